@@ -0,0 +1,99 @@
+/*
+ * 文件作用：凭证加密管理命令（./server credentials <子命令>）
+ * 负责功能：
+ *   - genkey：生成主密钥
+ *   - encrypt：一次性加密存量明文凭证
+ *   - rotate：主密钥轮换，用新主密钥重新包装全部凭证
+ * 重要程度：⭐⭐⭐ 一般（运维工具）
+ * 依赖模块：repository, encryption
+ */
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"cli-proxy/internal/repository"
+	"cli-proxy/pkg/encryption"
+)
+
+// runCredentialsCommand 执行凭证加密管理命令，返回进程退出码
+func runCredentialsCommand(args []string) int {
+	if len(args) == 0 {
+		printCredentialsUsage()
+		return 2
+	}
+
+	switch args[0] {
+	case "genkey":
+		key, err := encryption.GenerateKey()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "生成密钥失败: %v\n", err)
+			return 1
+		}
+		fmt.Println(key)
+		return 0
+
+	case "encrypt", "rotate":
+		rotate := args[0] == "rotate"
+		fs := flag.NewFlagSet("credentials "+args[0], flag.ContinueOnError)
+		dryRun := fs.Bool("dry-run", false, "只统计需要处理的数据，不写入数据库")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+
+		enabled, err := repository.InitCredentialEncryption()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "加载主密钥失败: %v\n", err)
+			return 1
+		}
+		if !enabled {
+			fmt.Fprintln(os.Stderr, "未配置主密钥，请设置 credentials.key_file 或环境变量 CREDENTIAL_KEY / CREDENTIAL_KEY_FILE")
+			return 1
+		}
+		if err := repository.InitMySQL(); err != nil {
+			fmt.Fprintf(os.Stderr, "MySQL 连接失败: %v\n", err)
+			return 1
+		}
+		defer repository.CloseMySQL()
+
+		// 先迁移表结构：加密后的值比明文长，需要放宽凭证列长度
+		if !*dryRun {
+			if err := repository.AutoMigrate(); err != nil {
+				fmt.Fprintf(os.Stderr, "数据库迁移失败: %v\n", err)
+				return 1
+			}
+		}
+
+		result, err := repository.RewrapCredentials(rotate, *dryRun)
+		if result != nil {
+			fmt.Printf("主密钥: %s | 扫描: %d 行 | 更新: %d 行 / %d 个字段 | 并发修改跳过: %d | 无法解密: %d | 试运行: %v\n",
+				encryption.Default().PrimaryKeyID(), result.Rows, result.Updated, result.Values, result.Skipped, result.Failed, *dryRun)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "处理失败: %v\n", err)
+			return 1
+		}
+		if result.Failed > 0 || result.Skipped > 0 {
+			fmt.Fprintln(os.Stderr, "部分数据未处理：无法解密的字段需要配置对应的旧主密钥，并发修改的行请重新执行")
+			return 1
+		}
+		if rotate && !*dryRun {
+			fmt.Println("轮换完成，可以移除旧主密钥配置")
+		}
+		return 0
+	}
+
+	printCredentialsUsage()
+	return 2
+}
+
+func printCredentialsUsage() {
+	fmt.Fprintln(os.Stderr, `用法: server credentials <子命令> [-dry-run]
+
+子命令:
+  genkey   生成新的主密钥（base64）
+  encrypt  加密数据库中的存量明文凭证
+  rotate   将全部凭证重新包装到当前主密钥（旧主密钥通过 previous_key_files 或 CREDENTIAL_PREVIOUS_KEYS 提供）`)
+}
@@ -5,6 +5,8 @@
  *   - 初始化MySQL数据库连接和自动迁移
  *   - 注册路由和中间件
  *   - 启动健康检查服务
+ *   - 初始化分布式追踪
+ *   - 凭证加密初始化与管理命令
  *   - 优雅关闭服务（信号处理）
  * 重要程度：⭐⭐⭐⭐⭐ 核心（程序启动入口）
  * 依赖模块：config, handler, middleware, repository, service
@@ -24,13 +26,17 @@ import (
 	"syscall"
 	"time"
 
+	"cli-proxy/internal/cache"
 	"cli-proxy/internal/config"
 	"cli-proxy/internal/handler"
 	"cli-proxy/internal/middleware"
 	"cli-proxy/internal/model"
+	"cli-proxy/internal/proxy/scheduler"
 	"cli-proxy/internal/repository"
 	"cli-proxy/internal/service"
+	"cli-proxy/pkg/encryption"
 	"cli-proxy/pkg/logger"
+	"cli-proxy/pkg/tracing"
 
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
@@ -63,6 +69,11 @@ func main() {
 		panic(fmt.Sprintf("加载配置失败: %v", err))
 	}
 
+	// 凭证加密管理命令（不启动服务）
+	if len(os.Args) > 1 && os.Args[1] == "credentials" {
+		os.Exit(runCredentialsCommand(os.Args[2:]))
+	}
+
 	// 初始化日志系统
 	logDir := config.Cfg.Log.Dir
 	if logDir == "" {
@@ -90,6 +101,16 @@ func main() {
 		config.Cfg.MySQL.Database, config.Cfg.MySQL.Charset,
 		config.Cfg.MySQL.MaxIdleConns, config.Cfg.MySQL.MaxOpenConns)
 
+	// 上游凭证加密（需在读取账户之前启用）
+	if enabled, err := repository.InitCredentialEncryption(); err != nil {
+		log.Error("凭证加密主密钥加载失败: %v", err)
+		panic(err)
+	} else if enabled {
+		log.Info("凭证加密已启用 | 主密钥: %s", encryption.Default().PrimaryKeyID())
+	} else {
+		log.Warn("凭证加密未启用，上游凭证以明文存储 | 请配置 credentials.key_file 或环境变量 CREDENTIAL_KEY")
+	}
+
 	mysqlStart := time.Now()
 	if err := repository.InitMySQL(); err != nil {
 		log.Error("MySQL 连接失败: %v | 请检查: 1.服务是否启动 2.地址端口是否正确 3.用户密码是否正确 4.数据库是否存在 5.防火墙设置", err)
@@ -97,6 +118,27 @@ func main() {
 	}
 	log.Info("MySQL 连接成功 | 耗时: %v", time.Since(mysqlStart))
 
+	// 初始化共享状态后端（会话粘性 / 并发计数 / 不可用标记）
+	if err := cache.InitSessionCache(&config.Cfg.Cache); err != nil {
+		log.Error("缓存后端初始化失败: %v | 后端: %s", err, config.Cfg.Cache.GetBackend())
+		panic(err)
+	}
+	log.Info("缓存后端: %s", cache.GetSessionCache().BackendName())
+
+	// 初始化分布式追踪（OTLP 导出）
+	if tracingCfg := config.Cfg.Tracing; tracingCfg.Enabled && tracingCfg.Endpoint != "" {
+		exporter := tracing.NewOTLPExporter(tracingCfg.Endpoint, tracingCfg.GetServiceName(), tracingCfg.Headers)
+		if err := tracing.Init(tracing.Options{
+			ServiceName: tracingCfg.GetServiceName(),
+			SampleRatio: tracingCfg.GetSampleRatio(),
+			Exporter:    exporter,
+		}); err != nil {
+			log.Warn("初始化追踪失败: %v", err)
+		} else {
+			log.Info("追踪已启用 | 上报: %s | 采样比例: %.2f", tracingCfg.Endpoint, tracingCfg.GetSampleRatio())
+		}
+	}
+
 	// 数据库迁移
 	migrateStart := time.Now()
 	if err := repository.AutoMigrate(); err != nil {
@@ -139,6 +181,29 @@ func main() {
 	configService := service.GetConfigService()
 	log.Info("会话粘性 TTL: %d分钟", config.Cfg.Cache.GetSessionTTL())
 
+	// 并发排队配置
+	applyConcurrencyQueueOptions(configService)
+
+	// 账户熔断配置
+	applyCircuitBreakerConfig(configService)
+
+	// 账户选择策略配置
+	applySchedulerStrategyConfig(configService)
+
+	// 会话绑定快照（内存后端重启后恢复会话粘性）
+	sessionPersistService := service.GetSessionPersistService()
+	if sessionPersistService.Enabled() {
+		if count, err := sessionPersistService.Restore(context.Background()); err != nil {
+			log.Warn("恢复会话绑定失败: %v", err)
+		} else {
+			log.Info("已恢复会话绑定: %d", count)
+		}
+		sessionPersistService.Start()
+	}
+
+	// 账户每日预算：调度器通过 UsageService 查询账户当日费用
+	scheduler.GetScheduler().SetDailyCostFunc(service.NewUsageService().GetAccountDailyCost)
+
 	// 启动账号健康检查服务
 	healthCheckService := service.GetAccountHealthCheckService()
 	if configService.GetAccountHealthCheckEnabled() {
@@ -155,6 +220,13 @@ func main() {
 			log.Info("会话 TTL 配置已更新: %s", value)
 		case model.ConfigAccountHealthCheckEnabled, model.ConfigAccountHealthCheckInterval:
 			healthCheckService.OnConfigChange(key, value)
+		case model.ConfigConcurrencyQueueEnabled, model.ConfigConcurrencyQueueMaxWait, model.ConfigConcurrencyQueueMaxDepth:
+			applyConcurrencyQueueOptions(configService)
+		case model.ConfigCircuitBreakerEnabled, model.ConfigCircuitBreakerWindow, model.ConfigCircuitBreakerMinRequests,
+			model.ConfigCircuitBreakerErrorRate, model.ConfigCircuitBreakerOpenDuration, model.ConfigCircuitBreakerHalfOpenProbes:
+			applyCircuitBreakerConfig(configService)
+		case model.ConfigSchedulerStrategy, model.ConfigSchedulerStrategyPlatforms, model.ConfigSchedulerStrategyGroups:
+			applySchedulerStrategyConfig(configService)
 		}
 	})
 
@@ -237,6 +309,12 @@ func main() {
 		log.Error("服务关闭出错: %v", err)
 	}
 
+	// 导出剩余追踪数据
+	tracing.Shutdown(ctx)
+
+	// 写入最终会话绑定快照（需在关闭数据库之前）
+	sessionPersistService.Stop()
+
 	// 关闭数据库连接
 	if err := repository.CloseMySQL(); err != nil {
 		log.Error("关闭 MySQL 连接出错: %v", err)
@@ -244,9 +322,74 @@ func main() {
 		log.Info("MySQL 连接已关闭")
 	}
 
+	// 关闭缓存后端连接
+	if err := cache.GetSessionCache().Close(); err != nil {
+		log.Error("关闭缓存后端出错: %v", err)
+	}
+
 	log.Info("=== 服务已正常关闭 ===")
 }
 
+// applyConcurrencyQueueOptions 将系统配置中的并发排队参数应用到会话缓存
+func applyConcurrencyQueueOptions(configService *service.ConfigService) {
+	opts := cache.QueueOptions{
+		Enabled:  configService.GetConcurrencyQueueEnabled(),
+		MaxWait:  configService.GetConcurrencyQueueMaxWait(),
+		MaxDepth: configService.GetConcurrencyQueueMaxDepth(),
+	}
+	cache.GetSessionCache().SetQueueOptions(opts)
+	logger.GetLogger("main").Info("并发排队配置 | 启用: %v | 最大等待: %v | 最大队列深度: %d", opts.Enabled, opts.MaxWait, opts.MaxDepth)
+}
+
+// applyCircuitBreakerConfig 将系统配置中的账户熔断参数应用到调度器
+func applyCircuitBreakerConfig(configService *service.ConfigService) {
+	cfg := scheduler.BreakerConfig{
+		Enabled:        configService.GetCircuitBreakerEnabled(),
+		Window:         configService.GetCircuitBreakerWindow(),
+		MinRequests:    configService.GetCircuitBreakerMinRequests(),
+		ErrorRate:      configService.GetCircuitBreakerErrorRate(),
+		OpenDuration:   configService.GetCircuitBreakerOpenDuration(),
+		HalfOpenProbes: configService.GetCircuitBreakerHalfOpenProbes(),
+	}
+	scheduler.GetScheduler().SetBreakerConfig(cfg)
+	logger.GetLogger("main").Info("账户熔断配置 | 启用: %v | 窗口: %v | 最少请求: %d | 错误率阈值: %.2f | 熔断时长: %v | 探测数: %d",
+		cfg.Enabled, cfg.Window, cfg.MinRequests, cfg.ErrorRate, cfg.OpenDuration, cfg.HalfOpenProbes)
+}
+
+// applySchedulerStrategyConfig 将系统配置中的账户选择策略应用到调度器，无效的策略名忽略
+func applySchedulerStrategyConfig(configService *service.ConfigService) {
+	log := logger.GetLogger("main")
+	parse := func(scope, name string) (scheduler.SelectionStrategy, bool) {
+		strategy, ok := scheduler.ParseSelectionStrategy(name)
+		if !ok {
+			log.Warn("未知的账户选择策略，已忽略 | %s: %s", scope, name)
+		}
+		return strategy, ok
+	}
+
+	cfg := scheduler.StrategyConfig{
+		Default:   scheduler.StrategyWeightedRandom,
+		Platforms: make(map[string]scheduler.SelectionStrategy),
+		Groups:    make(map[uint]scheduler.SelectionStrategy),
+	}
+	if strategy, ok := parse("默认", configService.GetSchedulerStrategy()); ok {
+		cfg.Default = strategy
+	}
+	for platform, name := range configService.GetSchedulerStrategyPlatforms() {
+		if strategy, ok := parse("平台 "+platform, name); ok {
+			cfg.Platforms[platform] = strategy
+		}
+	}
+	for groupID, name := range configService.GetSchedulerStrategyGroups() {
+		if strategy, ok := parse(fmt.Sprintf("分组 %d", groupID), name); ok {
+			cfg.Groups[groupID] = strategy
+		}
+	}
+
+	scheduler.GetScheduler().SetStrategyConfig(cfg)
+	log.Info("账户选择策略 | 默认: %s | 平台: %v | 分组: %v", cfg.Default, cfg.Platforms, cfg.Groups)
+}
+
 // getWorkDir 获取工作目录
 func getWorkDir() string {
 	dir, err := os.Getwd()
@@ -0,0 +1,303 @@
+/*
+ * 文件作用：共享状态后端抽象，SessionCache 通过该接口读写会话绑定、并发槽位和不可用标记
+ * 负责功能：
+ *   - Backend 接口定义
+ *   - 内存后端（单实例部署，包装 SessionStore / ConcurrencyManager / UnavailableMarker / MemoryCounters）
+ *   - 按配置创建后端（memory / redis）
+ * 重要程度：⭐⭐⭐⭐ 重要（多实例部署基础）
+ * 依赖模块：config, model
+ */
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cli-proxy/internal/config"
+	"cli-proxy/internal/model"
+)
+
+// SlotScope 并发槽位归属
+type SlotScope string
+
+const (
+	SlotScopeAccount SlotScope = "account"
+	SlotScopeUser    SlotScope = "user"
+)
+
+// BackendStats 后端统计
+type BackendStats struct {
+	SessionCount            int
+	UnavailableCount        int
+	AccountConcurrencyCount int
+	UserConcurrencyCount    int
+}
+
+// Backend 共享状态后端
+// 多个代理实例共用同一后端时，会话粘性、账户/用户并发限制和不可用标记在实例间保持一致
+type Backend interface {
+	// Name 后端名称（memory / redis）
+	Name() string
+
+	// 会话绑定
+	GetBinding(ctx context.Context, sessionID string) (*SessionBinding, error)
+	SetBinding(ctx context.Context, binding *SessionBinding) error
+	TouchBinding(ctx context.Context, sessionID string) error
+	RemoveBinding(ctx context.Context, sessionID string) error
+	AccountSessions(ctx context.Context, accountID uint) ([]string, error)
+	ClearAccountSessions(ctx context.Context, accountID uint) (int64, error)
+	ClearUserSessions(ctx context.Context, userID uint) (int64, error)
+	ListBindings(ctx context.Context, offset, limit int64) ([]SessionBinding, int64, error)
+	ClearAllSessions(ctx context.Context) (int64, error)
+
+	// 并发槽位（租约）
+	AcquireSlot(ctx context.Context, scope SlotScope, id uint, limit int, meta LeaseMeta) (string, bool, int64, error)
+	RenewSlot(ctx context.Context, scope SlotScope, id uint, leaseID string) (bool, error)
+	ReleaseSlot(ctx context.Context, scope SlotScope, id uint, leaseID string) error
+	SlotCount(ctx context.Context, scope SlotScope, id uint) (int64, error)
+	ResetSlots(ctx context.Context, scope SlotScope, id uint) error
+	ListLeases(ctx context.Context, scope SlotScope, id uint) ([]LeaseInfo, error)
+
+	// 临时不可用标记
+	MarkUnavailable(ctx context.Context, accountID uint, reason string, ttl time.Duration) error
+	IsUnavailable(ctx context.Context, accountID uint) (bool, string, error)
+	ClearUnavailable(ctx context.Context, accountID uint) error
+	ListUnavailable(ctx context.Context) ([]model.UnavailableAccount, error)
+	ClearAllUnavailable(ctx context.Context) (int64, error)
+
+	// 共享计数（套餐额度预留等）
+	Counters
+
+	// Stats 统计
+	Stats(ctx context.Context) (*BackendStats, error)
+	// Close 释放后端资源
+	Close() error
+}
+
+// 后端类型
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+)
+
+// defaultUserConcurrencyLimit 未指定用户并发上限时的默认值
+const defaultUserConcurrencyLimit = 10
+
+// NewBackend 按配置创建后端
+func NewBackend(cfg *config.CacheConfig) (Backend, error) {
+	switch cfg.GetBackend() {
+	case BackendMemory:
+		return newMemoryBackend(), nil
+	case BackendRedis:
+		backend, err := NewRedisBackendFromConfig(&cfg.Redis)
+		if err != nil {
+			return nil, err
+		}
+		return backend, nil
+	default:
+		return nil, fmt.Errorf("unknown cache backend: %s", cfg.Backend)
+	}
+}
+
+// ==================== 内存后端 ====================
+
+// memoryBackend 进程内存后端，仅适用于单实例部署
+type memoryBackend struct {
+	sessions    *SessionStore
+	concurrency *ConcurrencyManager
+	unavailable *UnavailableMarker
+	*MemoryCounters
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{
+		sessions:       GetSessionStore(),
+		concurrency:    GetConcurrencyManager(),
+		unavailable:    GetUnavailableMarker(),
+		MemoryCounters: GetMemoryCounters(),
+	}
+}
+
+func (m *memoryBackend) Name() string { return BackendMemory }
+
+func (m *memoryBackend) GetBinding(ctx context.Context, sessionID string) (*SessionBinding, error) {
+	binding := m.sessions.Get(sessionID)
+	if binding == nil {
+		return nil, nil
+	}
+	result := toSessionBinding(binding, time.Now())
+	return &result, nil
+}
+
+func (m *memoryBackend) SetBinding(ctx context.Context, binding *SessionBinding) error {
+	m.sessions.Set(&MemorySessionBinding{
+		SessionID:  binding.SessionID,
+		AccountID:  binding.AccountID,
+		Platform:   binding.Platform,
+		Model:      binding.Model,
+		UserID:     binding.UserID,
+		APIKeyID:   binding.APIKeyID,
+		ClientIP:   binding.ClientIP,
+		UserAgent:  binding.UserAgent,
+		BoundAt:    binding.BoundAt,
+		LastUsedAt: binding.LastUsedAt,
+		Pinned:     binding.Pinned,
+	})
+	return nil
+}
+
+func (m *memoryBackend) TouchBinding(ctx context.Context, sessionID string) error {
+	m.sessions.UpdateLastUsed(sessionID)
+	return nil
+}
+
+func (m *memoryBackend) RemoveBinding(ctx context.Context, sessionID string) error {
+	m.sessions.Remove(sessionID)
+	return nil
+}
+
+func (m *memoryBackend) AccountSessions(ctx context.Context, accountID uint) ([]string, error) {
+	bindings := m.sessions.GetByAccount(accountID)
+	sessionIDs := make([]string, len(bindings))
+	for i, b := range bindings {
+		sessionIDs[i] = b.SessionID
+	}
+	return sessionIDs, nil
+}
+
+func (m *memoryBackend) ClearAccountSessions(ctx context.Context, accountID uint) (int64, error) {
+	return int64(m.sessions.ClearByAccount(accountID)), nil
+}
+
+func (m *memoryBackend) ClearUserSessions(ctx context.Context, userID uint) (int64, error) {
+	return int64(m.sessions.ClearByUser(userID)), nil
+}
+
+func (m *memoryBackend) ListBindings(ctx context.Context, offset, limit int64) ([]SessionBinding, int64, error) {
+	bindings, total := m.sessions.ListAll(int(offset), int(limit))
+
+	now := time.Now()
+	result := make([]SessionBinding, len(bindings))
+	for i, b := range bindings {
+		result[i] = toSessionBinding(b, now)
+	}
+	return result, int64(total), nil
+}
+
+func (m *memoryBackend) ClearAllSessions(ctx context.Context) (int64, error) {
+	return int64(m.sessions.ClearAll()), nil
+}
+
+func (m *memoryBackend) AcquireSlot(ctx context.Context, scope SlotScope, id uint, limit int, meta LeaseMeta) (string, bool, int64, error) {
+	if scope == SlotScopeUser {
+		leaseID, acquired, current := m.concurrency.AcquireUser(ctx, id, limit, meta)
+		return leaseID, acquired, current, nil
+	}
+	leaseID, acquired, current := m.concurrency.AcquireAccountWithLimit(ctx, id, limit, meta)
+	return leaseID, acquired, current, nil
+}
+
+func (m *memoryBackend) RenewSlot(ctx context.Context, scope SlotScope, id uint, leaseID string) (bool, error) {
+	return m.concurrency.Renew(scope, id, leaseID), nil
+}
+
+func (m *memoryBackend) ReleaseSlot(ctx context.Context, scope SlotScope, id uint, leaseID string) error {
+	if scope == SlotScopeUser {
+		m.concurrency.ReleaseUser(ctx, id, leaseID)
+	} else {
+		m.concurrency.ReleaseAccount(ctx, id, leaseID)
+	}
+	return nil
+}
+
+func (m *memoryBackend) SlotCount(ctx context.Context, scope SlotScope, id uint) (int64, error) {
+	if scope == SlotScopeUser {
+		return m.concurrency.GetUserConcurrency(id), nil
+	}
+	return m.concurrency.GetAccountConcurrency(id), nil
+}
+
+func (m *memoryBackend) ResetSlots(ctx context.Context, scope SlotScope, id uint) error {
+	if scope == SlotScopeUser {
+		m.concurrency.ResetUserConcurrency(id)
+	} else {
+		m.concurrency.ResetAccountConcurrency(id)
+	}
+	return nil
+}
+
+func (m *memoryBackend) ListLeases(ctx context.Context, scope SlotScope, id uint) ([]LeaseInfo, error) {
+	return m.concurrency.ListLeases(scope, id), nil
+}
+
+func (m *memoryBackend) MarkUnavailable(ctx context.Context, accountID uint, reason string, ttl time.Duration) error {
+	m.unavailable.Mark(accountID, reason, ttl)
+	return nil
+}
+
+func (m *memoryBackend) IsUnavailable(ctx context.Context, accountID uint) (bool, string, error) {
+	unavailable, reason := m.unavailable.IsUnavailable(accountID)
+	return unavailable, reason, nil
+}
+
+func (m *memoryBackend) ClearUnavailable(ctx context.Context, accountID uint) error {
+	m.unavailable.Clear(accountID)
+	return nil
+}
+
+func (m *memoryBackend) ListUnavailable(ctx context.Context) ([]model.UnavailableAccount, error) {
+	all := m.unavailable.ListAll()
+	result := make([]model.UnavailableAccount, 0, len(all))
+	for accountID, info := range all {
+		result = append(result, model.UnavailableAccount{
+			AccountID:    accountID,
+			Reason:       info.Reason,
+			RemainingTTL: info.RemainingTTL,
+		})
+	}
+	return result, nil
+}
+
+func (m *memoryBackend) ClearAllUnavailable(ctx context.Context) (int64, error) {
+	return int64(m.unavailable.ClearAll()), nil
+}
+
+func (m *memoryBackend) Stats(ctx context.Context) (*BackendStats, error) {
+	accountConcurrency, userConcurrency := m.concurrency.Stats()
+	return &BackendStats{
+		SessionCount:            m.sessions.Count(),
+		UnavailableCount:        m.unavailable.Count(),
+		AccountConcurrencyCount: accountConcurrency,
+		UserConcurrencyCount:    userConcurrency,
+	}, nil
+}
+
+func (m *memoryBackend) Close() error {
+	return nil
+}
+
+// toSessionBinding 内存绑定转换为对外结构
+func toSessionBinding(b *MemorySessionBinding, now time.Time) SessionBinding {
+	remainingTTL := int64(0)
+	if b.Pinned {
+		remainingTTL = -1
+	} else if now.Before(b.ExpireAt) {
+		remainingTTL = int64(b.ExpireAt.Sub(now).Seconds())
+	}
+	return SessionBinding{
+		SessionID:    b.SessionID,
+		AccountID:    b.AccountID,
+		Platform:     b.Platform,
+		Model:        b.Model,
+		UserID:       b.UserID,
+		APIKeyID:     b.APIKeyID,
+		ClientIP:     b.ClientIP,
+		UserAgent:    b.UserAgent,
+		BoundAt:      b.BoundAt,
+		LastUsedAt:   b.LastUsedAt,
+		ExpireAt:     b.ExpireAt,
+		RemainingTTL: remainingTTL,
+		Pinned:       b.Pinned,
+	}
+}
@@ -0,0 +1,302 @@
+/*
+ * 文件作用：多实例共享的计数状态，供套餐额度预留、API Key 限流等在实例间保持一致
+ * 负责功能：
+ *   - Counters 接口定义（随 Backend 提供）
+ *   - 金额预留（带 TTL 的预留记录，进程异常退出时自动回收）
+ *   - 滑动窗口计数、带上限的计数器、短时共享数值缓存
+ *   - 内存实现（单实例部署）与过期数据清理
+ * 重要程度：⭐⭐⭐⭐ 重要（计费准入一致性）
+ * 依赖模块：无
+ */
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Counters 共享计数状态
+// 内存后端只在进程内有效；Redis 后端下多个代理实例共用同一份计数
+type Counters interface {
+	// ReserveAmount 在 key 下新增一笔带 TTL 的金额预留
+	// limit >= 0 且未过期预留总额加上 amount 超过 limit 时不预留；limit < 0 表示不限
+	// 返回是否预留成功与预留后的总额（失败时为当前总额）
+	ReserveAmount(ctx context.Context, key, reservationID string, amount, limit float64, ttl time.Duration) (bool, float64, error)
+	// ReleaseAmount 释放一笔预留（不存在时忽略）
+	ReleaseAmount(ctx context.Context, key, reservationID string) error
+	// ReservedAmount 未过期预留的总额
+	ReservedAmount(ctx context.Context, key string) (float64, error)
+
+	// HitWindow 在 key 的滑动窗口中记录一次命中（hitID 用于撤销），窗口内已有 limit 次命中时不记录
+	// 返回是否记录、记录后窗口内的命中数（拒绝时为当前命中数）与最早命中滑出窗口的剩余时间
+	HitWindow(ctx context.Context, key, hitID string, limit int, window time.Duration) (bool, int64, time.Duration, error)
+	// RemoveWindowHit 撤销一次窗口命中（不存在时忽略）
+	RemoveWindowHit(ctx context.Context, key, hitID string) error
+
+	// InitCounter 计数器不存在时设置初始值与 TTL，返回是否设置
+	InitCounter(ctx context.Context, key string, value int64, ttl time.Duration) (bool, error)
+	// IncrCounter 计数器小于 limit 时加一（不存在时从 0 开始并设置 TTL）
+	// 返回是否计数与计数后的值（拒绝时为当前值）
+	IncrCounter(ctx context.Context, key string, limit int64, ttl time.Duration) (bool, int64, error)
+
+	// CacheValue 缓存一个数值（多实例共用查询结果）
+	CacheValue(ctx context.Context, key string, value float64, ttl time.Duration) error
+	// CachedValue 读取缓存的数值，不存在或已过期时 found 为 false
+	CachedValue(ctx context.Context, key string) (value float64, found bool, err error)
+
+	// ResetCounters 删除 key 对应的滑动窗口、计数器和缓存数值
+	ResetCounters(ctx context.Context, keys ...string) error
+}
+
+// Counters 获取当前后端的共享计数状态
+func (s *SessionCache) Counters() Counters {
+	return s.backend
+}
+
+// ==================== 内存实现 ====================
+
+// amountReservation 单笔金额预留
+type amountReservation struct {
+	amount   float64
+	expireAt time.Time
+}
+
+// windowHit 滑动窗口中的一次命中
+type windowHit struct {
+	id string
+	at time.Time
+}
+
+// expiringValue 带过期时间的计数或数值
+type expiringValue struct {
+	value    float64
+	expireAt time.Time
+}
+
+// MemoryCounters 进程内计数状态
+type MemoryCounters struct {
+	mu           sync.Mutex
+	reservations map[string]map[string]amountReservation // key -> reservationID -> 预留
+	windows      map[string][]windowHit                  // key -> 按时间排序的命中
+	counters     map[string]*expiringValue               // key -> 计数器
+	values       map[string]*expiringValue               // key -> 缓存数值
+
+	now func() time.Time // 当前时间（测试可替换）
+}
+
+var (
+	globalMemoryCounters *MemoryCounters
+	memoryCountersOnce   sync.Once
+)
+
+// GetMemoryCounters 获取进程内计数状态单例（启动定期清理）
+func GetMemoryCounters() *MemoryCounters {
+	memoryCountersOnce.Do(func() {
+		globalMemoryCounters = NewMemoryCounters()
+		go globalMemoryCounters.cleanupLoop(time.Minute)
+	})
+	return globalMemoryCounters
+}
+
+// NewMemoryCounters 创建进程内计数状态（不启动定期清理，过期数据在访问时回收）
+func NewMemoryCounters() *MemoryCounters {
+	return &MemoryCounters{
+		reservations: make(map[string]map[string]amountReservation),
+		windows:      make(map[string][]windowHit),
+		counters:     make(map[string]*expiringValue),
+		values:       make(map[string]*expiringValue),
+		now:          time.Now,
+	}
+}
+
+// SetClock 替换当前时间来源（测试用）
+func (m *MemoryCounters) SetClock(now func() time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = now
+}
+
+func (m *MemoryCounters) ReserveAmount(ctx context.Context, key, reservationID string, amount, limit float64, ttl time.Duration) (bool, float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	total := m.reservedLocked(key, now)
+	if limit >= 0 && total+amount > limit {
+		return false, total, nil
+	}
+
+	entries := m.reservations[key]
+	if entries == nil {
+		entries = make(map[string]amountReservation)
+		m.reservations[key] = entries
+	}
+	entries[reservationID] = amountReservation{amount: amount, expireAt: now.Add(ttl)}
+	return true, total + amount, nil
+}
+
+func (m *MemoryCounters) ReleaseAmount(ctx context.Context, key, reservationID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.reservations[key], reservationID)
+	if len(m.reservations[key]) == 0 {
+		delete(m.reservations, key)
+	}
+	return nil
+}
+
+func (m *MemoryCounters) ReservedAmount(ctx context.Context, key string) (float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.reservedLocked(key, m.now()), nil
+}
+
+// reservedLocked 回收过期预留并返回总额（调用方需持有锁）
+func (m *MemoryCounters) reservedLocked(key string, now time.Time) float64 {
+	total := 0.0
+	for id, r := range m.reservations[key] {
+		if !now.Before(r.expireAt) {
+			delete(m.reservations[key], id)
+			continue
+		}
+		total += r.amount
+	}
+	if len(m.reservations[key]) == 0 {
+		delete(m.reservations, key)
+	}
+	return total
+}
+
+func (m *MemoryCounters) HitWindow(ctx context.Context, key, hitID string, limit int, window time.Duration) (bool, int64, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	hits := m.trimWindowLocked(key, now, window)
+	if len(hits) >= limit {
+		return false, int64(len(hits)), hits[0].at.Add(window).Sub(now), nil
+	}
+	hits = append(hits, windowHit{id: hitID, at: now})
+	m.windows[key] = hits
+	return true, int64(len(hits)), hits[0].at.Add(window).Sub(now), nil
+}
+
+func (m *MemoryCounters) RemoveWindowHit(ctx context.Context, key, hitID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hits := m.windows[key]
+	for i, hit := range hits {
+		if hit.id == hitID {
+			m.windows[key] = append(hits[:i:i], hits[i+1:]...)
+			break
+		}
+	}
+	if len(m.windows[key]) == 0 {
+		delete(m.windows, key)
+	}
+	return nil
+}
+
+// trimWindowLocked 移除滑出窗口的命中（调用方需持有锁）
+func (m *MemoryCounters) trimWindowLocked(key string, now time.Time, window time.Duration) []windowHit {
+	hits := m.windows[key]
+	cutoff := now.Add(-window)
+	i := 0
+	for i < len(hits) && !hits[i].at.After(cutoff) {
+		i++
+	}
+	hits = hits[i:]
+	if len(hits) == 0 {
+		delete(m.windows, key)
+	} else {
+		m.windows[key] = hits
+	}
+	return hits
+}
+
+func (m *MemoryCounters) InitCounter(ctx context.Context, key string, value int64, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if c := m.counters[key]; c != nil && now.Before(c.expireAt) {
+		return false, nil
+	}
+	m.counters[key] = &expiringValue{value: float64(value), expireAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (m *MemoryCounters) IncrCounter(ctx context.Context, key string, limit int64, ttl time.Duration) (bool, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	c := m.counters[key]
+	if c == nil || !now.Before(c.expireAt) {
+		c = &expiringValue{expireAt: now.Add(ttl)}
+		m.counters[key] = c
+	}
+	if int64(c.value) >= limit {
+		return false, int64(c.value), nil
+	}
+	c.value++
+	return true, int64(c.value), nil
+}
+
+func (m *MemoryCounters) CacheValue(ctx context.Context, key string, value float64, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[key] = &expiringValue{value: value, expireAt: m.now().Add(ttl)}
+	return nil
+}
+
+func (m *MemoryCounters) CachedValue(ctx context.Context, key string) (float64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v := m.values[key]
+	if v == nil || !m.now().Before(v.expireAt) {
+		return 0, false, nil
+	}
+	return v.value, true, nil
+}
+
+func (m *MemoryCounters) ResetCounters(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		delete(m.windows, key)
+		delete(m.counters, key)
+		delete(m.values, key)
+	}
+	return nil
+}
+
+// maxWindowRetention 清理时保留窗口命中的最长时间（窗口长度不超过该值）
+const maxWindowRetention = time.Hour
+
+// cleanupLoop 定期回收过期数据
+func (m *MemoryCounters) cleanupLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.mu.Lock()
+		now := m.now()
+		for key := range m.reservations {
+			m.reservedLocked(key, now)
+		}
+		for key := range m.windows {
+			m.trimWindowLocked(key, now, maxWindowRetention)
+		}
+		for _, entries := range []map[string]*expiringValue{m.counters, m.values} {
+			for key, v := range entries {
+				if !now.Before(v.expireAt) {
+					delete(entries, key)
+				}
+			}
+		}
+		m.mu.Unlock()
+	}
+}
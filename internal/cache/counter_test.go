@@ -0,0 +1,216 @@
+/*
+ * 文件作用：共享计数测试（内存实现与 miniredis）
+ * 负责功能：
+ *   - 金额预留：上限、不限额、释放、TTL 回收
+ *   - 滑动窗口：上限、撤销命中、滑出窗口
+ *   - 计数器：初始化只生效一次、上限、TTL；缓存数值；重置
+ * 重要程度：⭐⭐ 辅助（测试）
+ * 依赖模块：miniredis
+ */
+package cache
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+// counterBackend 测试用计数实现与时间推进函数
+type counterBackend struct {
+	name     string
+	counters Counters
+	advance  func(d time.Duration)
+}
+
+func newTestCounterBackends(t *testing.T) []counterBackend {
+	t.Helper()
+
+	memory := NewMemoryCounters()
+	now := time.Now()
+	memory.SetClock(func() time.Time { return now })
+
+	backend, mr := newTestRedisBackend(t)
+	return []counterBackend{
+		{name: "memory", counters: memory, advance: func(d time.Duration) { now = now.Add(d) }},
+		{name: "redis", counters: backend, advance: func(d time.Duration) { advance(mr, d) }},
+	}
+}
+
+func TestCountersReserveAmount(t *testing.T) {
+	ctx := context.Background()
+	const ttl = time.Minute
+
+	for _, cb := range newTestCounterBackends(t) {
+		t.Run(cb.name, func(t *testing.T) {
+			c := cb.counters
+			steps := []struct {
+				name      string
+				id        string
+				amount    float64
+				limit     float64
+				wantOK    bool
+				wantTotal float64
+			}{
+				{name: "first", id: "a", amount: 1.5, limit: 4, wantOK: true, wantTotal: 1.5},
+				{name: "second", id: "b", amount: 2.25, limit: 4, wantOK: true, wantTotal: 3.75},
+				{name: "over limit", id: "c", amount: 0.5, limit: 4, wantOK: false, wantTotal: 3.75},
+				{name: "unlimited", id: "d", amount: 10, limit: -1, wantOK: true, wantTotal: 13.75},
+			}
+			for _, step := range steps {
+				ok, total, err := c.ReserveAmount(ctx, "pkg:1", step.id, step.amount, step.limit, ttl)
+				if err != nil {
+					t.Fatalf("%s: %v", step.name, err)
+				}
+				if ok != step.wantOK || math.Abs(total-step.wantTotal) > 1e-9 {
+					t.Errorf("%s: ReserveAmount = (%v, %v), want (%v, %v)", step.name, ok, total, step.wantOK, step.wantTotal)
+				}
+			}
+
+			// 释放后腾出额度，重复释放无副作用
+			for i := 0; i < 2; i++ {
+				if err := c.ReleaseAmount(ctx, "pkg:1", "d"); err != nil {
+					t.Fatalf("ReleaseAmount: %v", err)
+				}
+			}
+			if total, err := c.ReservedAmount(ctx, "pkg:1"); err != nil || math.Abs(total-3.75) > 1e-9 {
+				t.Errorf("ReservedAmount after release = (%v, %v), want 3.75", total, err)
+			}
+
+			// 其他 key 互不影响
+			if total, err := c.ReservedAmount(ctx, "pkg:2"); err != nil || total != 0 {
+				t.Errorf("ReservedAmount(pkg:2) = (%v, %v), want 0", total, err)
+			}
+
+			// 未释放的预留在 TTL 后回收
+			cb.advance(ttl + time.Second)
+			if total, err := c.ReservedAmount(ctx, "pkg:1"); err != nil || total != 0 {
+				t.Errorf("ReservedAmount after ttl = (%v, %v), want 0", total, err)
+			}
+			if ok, total, err := c.ReserveAmount(ctx, "pkg:1", "e", 4, 4, ttl); err != nil || !ok || total != 4 {
+				t.Errorf("ReserveAmount after ttl = (%v, %v, %v), want (true, 4)", ok, total, err)
+			}
+		})
+	}
+}
+
+func TestCountersHitWindow(t *testing.T) {
+	ctx := context.Background()
+	const window = time.Minute
+
+	for _, cb := range newTestCounterBackends(t) {
+		t.Run(cb.name, func(t *testing.T) {
+			c := cb.counters
+			hit := func(id string) (bool, int64, time.Duration) {
+				t.Helper()
+				ok, count, resetAfter, err := c.HitWindow(ctx, "key:1", id, 3, window)
+				if err != nil {
+					t.Fatalf("HitWindow(%s): %v", id, err)
+				}
+				return ok, count, resetAfter
+			}
+
+			for i, id := range []string{"a", "b", "c"} {
+				if ok, count, resetAfter := hit(id); !ok || count != int64(i+1) || resetAfter <= 0 || resetAfter > window {
+					t.Errorf("hit %s = (%v, %d, %v), want (true, %d, (0, %v]]", id, ok, count, resetAfter, i+1, window)
+				}
+				cb.advance(10 * time.Second)
+			}
+
+			// 达到上限后拒绝，剩余时间为最早命中滑出窗口的时间
+			if ok, count, resetAfter := hit("d"); ok || count != 3 || resetAfter != 30*time.Second {
+				t.Errorf("hit over limit = (%v, %d, %v), want (false, 3, 30s)", ok, count, resetAfter)
+			}
+
+			// 撤销一次命中后腾出名额
+			if err := c.RemoveWindowHit(ctx, "key:1", "c"); err != nil {
+				t.Fatalf("RemoveWindowHit: %v", err)
+			}
+			if ok, count, _ := hit("e"); !ok || count != 3 {
+				t.Errorf("hit after remove = (%v, %d), want (true, 3)", ok, count)
+			}
+
+			// 最早的命中滑出窗口
+			cb.advance(30 * time.Second)
+			if ok, count, resetAfter := hit("f"); !ok || count != 3 || resetAfter != 10*time.Second {
+				t.Errorf("hit after slide = (%v, %d, %v), want (true, 3, 10s)", ok, count, resetAfter)
+			}
+
+			if err := c.ResetCounters(ctx, "key:1"); err != nil {
+				t.Fatalf("ResetCounters: %v", err)
+			}
+			if ok, count, _ := hit("g"); !ok || count != 1 {
+				t.Errorf("hit after reset = (%v, %d), want (true, 1)", ok, count)
+			}
+		})
+	}
+}
+
+func TestCountersIncrCounter(t *testing.T) {
+	ctx := context.Background()
+	const ttl = time.Hour
+
+	for _, cb := range newTestCounterBackends(t) {
+		t.Run(cb.name, func(t *testing.T) {
+			c := cb.counters
+
+			// 初始化只在计数器不存在时生效
+			if ok, err := c.InitCounter(ctx, "daily:1", 2, ttl); err != nil || !ok {
+				t.Fatalf("InitCounter = (%v, %v), want true", ok, err)
+			}
+			if ok, err := c.InitCounter(ctx, "daily:1", 0, ttl); err != nil || ok {
+				t.Fatalf("second InitCounter = (%v, %v), want false", ok, err)
+			}
+
+			steps := []struct {
+				wantOK    bool
+				wantValue int64
+			}{{true, 3}, {true, 4}, {false, 4}}
+			for i, step := range steps {
+				ok, value, err := c.IncrCounter(ctx, "daily:1", 4, ttl)
+				if err != nil || ok != step.wantOK || value != step.wantValue {
+					t.Errorf("incr %d = (%v, %d, %v), want (%v, %d)", i, ok, value, err, step.wantOK, step.wantValue)
+				}
+			}
+
+			// 不存在时从 0 开始；过期后重新计数
+			if ok, value, err := c.IncrCounter(ctx, "daily:2", 4, ttl); err != nil || !ok || value != 1 {
+				t.Errorf("incr new counter = (%v, %d, %v), want (true, 1)", ok, value, err)
+			}
+			cb.advance(ttl + time.Second)
+			if ok, value, err := c.IncrCounter(ctx, "daily:1", 4, ttl); err != nil || !ok || value != 1 {
+				t.Errorf("incr after ttl = (%v, %d, %v), want (true, 1)", ok, value, err)
+			}
+
+			if err := c.ResetCounters(ctx, "daily:1"); err != nil {
+				t.Fatalf("ResetCounters: %v", err)
+			}
+			if ok, err := c.InitCounter(ctx, "daily:1", 5, ttl); err != nil || !ok {
+				t.Errorf("InitCounter after reset = (%v, %v), want true", ok, err)
+			}
+		})
+	}
+}
+
+func TestCountersCachedValue(t *testing.T) {
+	ctx := context.Background()
+
+	for _, cb := range newTestCounterBackends(t) {
+		t.Run(cb.name, func(t *testing.T) {
+			c := cb.counters
+			if _, found, err := c.CachedValue(ctx, "cost:1"); err != nil || found {
+				t.Fatalf("CachedValue before set = (%v, %v), want not found", found, err)
+			}
+			if err := c.CacheValue(ctx, "cost:1", 12.375, 30*time.Second); err != nil {
+				t.Fatalf("CacheValue: %v", err)
+			}
+			if value, found, err := c.CachedValue(ctx, "cost:1"); err != nil || !found || value != 12.375 {
+				t.Errorf("CachedValue = (%v, %v, %v), want 12.375", value, found, err)
+			}
+			cb.advance(31 * time.Second)
+			if _, found, err := c.CachedValue(ctx, "cost:1"); err != nil || found {
+				t.Errorf("CachedValue after ttl = (%v, %v), want not found", found, err)
+			}
+		})
+	}
+}
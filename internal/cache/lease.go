@@ -0,0 +1,107 @@
+/*
+ * 文件作用：并发槽位租约，持有者以租约 ID 续约和释放自己的槽位
+ * 负责功能：
+ *   - 租约元信息（请求 ID、模型、获取/续约时间）
+ *   - 租约 ID 生成
+ *   - 后台心跳续约（长时间流式请求期间保持槽位）
+ * 重要程度：⭐⭐⭐⭐ 重要（并发计数准确性）
+ * 依赖模块：logger
+ */
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"cli-proxy/pkg/logger"
+)
+
+// LeaseMeta 获取槽位时记录的请求信息
+type LeaseMeta struct {
+	RequestID string
+	Model     string
+}
+
+// LeaseInfo 有效租约信息（管理端展示）
+type LeaseInfo struct {
+	LeaseID    string    `json:"lease_id"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Model      string    `json:"model,omitempty"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	RenewedAt  time.Time `json:"renewed_at"`
+	AgeSeconds int64     `json:"age_seconds"` // 已持有秒数
+}
+
+// minLeaseHeartbeatInterval 心跳续约最小间隔
+const minLeaseHeartbeatInterval = 5 * time.Second
+
+// newLeaseID 生成租约 ID
+func newLeaseID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// sortLeases 按获取时间升序排列
+func sortLeases(leases []LeaseInfo) {
+	sort.Slice(leases, func(i, j int) bool {
+		if !leases[i].AcquiredAt.Equal(leases[j].AcquiredAt) {
+			return leases[i].AcquiredAt.Before(leases[j].AcquiredAt)
+		}
+		return leases[i].LeaseID < leases[j].LeaseID
+	})
+}
+
+// leaseHeartbeatInterval 心跳间隔：租约 TTL 的三分之一，保证两次续约失败前不会过期
+func leaseHeartbeatInterval() time.Duration {
+	interval := getConcurrencyTTL() / 3
+	if interval < minLeaseHeartbeatInterval {
+		interval = minLeaseHeartbeatInterval
+	}
+	return interval
+}
+
+// StartLeaseHeartbeat 在后台定期续约，直到返回的 stop 被调用或 ctx 结束
+// 用于流式等长时间请求，避免持有中的槽位因超过 TTL 被回收而低估并发
+func (s *SessionCache) StartLeaseHeartbeat(ctx context.Context, scope SlotScope, id uint, leaseID string) (stop func()) {
+	if leaseID == "" {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		log := logger.GetLogger("cache")
+		ticker := time.NewTicker(leaseHeartbeatInterval())
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				renewed, err := s.backend.RenewSlot(ctx, scope, id, leaseID)
+				if err != nil {
+					log.Warn("并发租约续约失败: scope=%s, id=%d, lease=%s, error=%v", scope, id, leaseID, err)
+					continue
+				}
+				if !renewed {
+					log.Warn("并发租约已失效: scope=%s, id=%d, lease=%s", scope, id, leaseID)
+					return
+				}
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
@@ -34,11 +34,12 @@ type MemorySessionBinding struct {
 	BoundAt    time.Time
 	LastUsedAt time.Time
 	ExpireAt   time.Time
+	Pinned     bool // 固定会话不过期
 }
 
-// IsExpired 检查是否过期
+// IsExpired 检查是否过期（固定会话永不过期）
 func (b *MemorySessionBinding) IsExpired() bool {
-	return time.Now().After(b.ExpireAt)
+	return !b.Pinned && time.Now().After(b.ExpireAt)
 }
 
 // SessionStore 会话存储（替代 Redis 的会话功能）
@@ -150,7 +151,7 @@ func (s *SessionStore) cleanExpired() {
 
 	s.bindings.Range(func(key, value interface{}) bool {
 		binding := value.(*MemorySessionBinding)
-		if now.After(binding.ExpireAt) {
+		if !binding.Pinned && now.After(binding.ExpireAt) {
 			expiredSessions = append(expiredSessions, binding.SessionID)
 		}
 		return true
@@ -405,72 +406,119 @@ func (s *SessionStore) ClearAll() int {
 
 // ==================== 并发控制 ====================
 
-// ConcurrencyCounter 带TTL的并发计数器
+// slotLease 并发槽位租约
+type slotLease struct {
+	RequestID  string
+	Model      string
+	AcquiredAt time.Time
+	RenewedAt  time.Time
+}
+
+// ConcurrencyCounter 基于租约的并发计数器
+// 每个槽位以租约 ID 标识，持有者定期续约；超过 TTL 未续约的租约视为泄漏并被回收
 type ConcurrencyCounter struct {
-	mu    sync.Mutex
-	slots []time.Time // 每个槽位的获取时间
-	limit int
+	mu     sync.Mutex
+	leases map[string]*slotLease
 }
 
-// Count 获取当前有效并发数（排除过期槽位）
+// Count 获取当前有效并发数（排除过期租约）
 func (c *ConcurrencyCounter) Count(ttl time.Duration) int {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.cleanExpiredLocked(ttl)
-	return len(c.slots)
+	return len(c.leases)
 }
 
-// Acquire 获取并发槽位
-func (c *ConcurrencyCounter) Acquire(limit int, ttl time.Duration) (bool, int) {
+// Acquire 获取并发槽位，成功时返回租约 ID
+func (c *ConcurrencyCounter) Acquire(limit int, ttl time.Duration, meta LeaseMeta) (string, bool, int) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	// 先清理过期槽位
+	// 先清理过期租约
 	c.cleanExpiredLocked(ttl)
 
-	if len(c.slots) >= limit {
-		return false, len(c.slots)
+	if len(c.leases) >= limit {
+		return "", false, len(c.leases)
 	}
 
-	c.slots = append(c.slots, time.Now())
-	return true, len(c.slots)
+	leaseID, err := newLeaseID()
+	if err != nil {
+		return "", false, len(c.leases)
+	}
+	if c.leases == nil {
+		c.leases = make(map[string]*slotLease)
+	}
+	now := time.Now()
+	c.leases[leaseID] = &slotLease{
+		RequestID:  meta.RequestID,
+		Model:      meta.Model,
+		AcquiredAt: now,
+		RenewedAt:  now,
+	}
+	return leaseID, true, len(c.leases)
 }
 
-// Release 释放并发槽位（移除最老的一个）
-func (c *ConcurrencyCounter) Release() {
+// Renew 续约，租约已过期或不存在时返回 false
+func (c *ConcurrencyCounter) Renew(leaseID string, ttl time.Duration) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if len(c.slots) > 0 {
-		c.slots = c.slots[1:] // 移除最老的槽位
+	c.cleanExpiredLocked(ttl)
+	lease, ok := c.leases[leaseID]
+	if !ok {
+		return false
 	}
+	lease.RenewedAt = time.Now()
+	return true
+}
+
+// Release 按租约 ID 释放并发槽位
+func (c *ConcurrencyCounter) Release(leaseID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.leases, leaseID)
 }
 
 // Reset 重置计数器
 func (c *ConcurrencyCounter) Reset() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.slots = nil
+	c.leases = nil
+}
+
+// List 列出有效租约（按获取时间升序）
+func (c *ConcurrencyCounter) List(ttl time.Duration) []LeaseInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cleanExpiredLocked(ttl)
+
+	now := time.Now()
+	result := make([]LeaseInfo, 0, len(c.leases))
+	for leaseID, lease := range c.leases {
+		result = append(result, LeaseInfo{
+			LeaseID:    leaseID,
+			RequestID:  lease.RequestID,
+			Model:      lease.Model,
+			AcquiredAt: lease.AcquiredAt,
+			RenewedAt:  lease.RenewedAt,
+			AgeSeconds: int64(now.Sub(lease.AcquiredAt).Seconds()),
+		})
+	}
+	sortLeases(result)
+	return result
 }
 
-// cleanExpiredLocked 清理过期槽位（需要持有锁）
+// cleanExpiredLocked 回收超过 TTL 未续约的租约（需要持有锁）
 func (c *ConcurrencyCounter) cleanExpiredLocked(ttl time.Duration) {
-	if ttl <= 0 || len(c.slots) == 0 {
+	if ttl <= 0 || len(c.leases) == 0 {
 		return
 	}
 
 	now := time.Now()
-	validStart := 0
-	for i, t := range c.slots {
-		if now.Sub(t) < ttl {
-			validStart = i
-			break
+	for leaseID, lease := range c.leases {
+		if now.Sub(lease.RenewedAt) >= ttl {
+			delete(c.leases, leaseID)
 		}
-		validStart = i + 1
-	}
-
-	if validStart > 0 {
-		c.slots = c.slots[validStart:]
 	}
 }
 
@@ -587,24 +635,32 @@ func (m *ConcurrencyManager) getOrCreateUserCounter(userID uint) *ConcurrencyCou
 	return val.(*ConcurrencyCounter)
 }
 
+// getOrCreateCounter 按归属获取或创建计数器
+func (m *ConcurrencyManager) getOrCreateCounter(scope SlotScope, id uint) *ConcurrencyCounter {
+	if scope == SlotScopeUser {
+		return m.getOrCreateUserCounter(id)
+	}
+	return m.getOrCreateAccountCounter(id)
+}
+
 // AcquireAccount 获取账户并发槽位
-func (m *ConcurrencyManager) AcquireAccount(ctx context.Context, accountID uint) (bool, int64) {
+func (m *ConcurrencyManager) AcquireAccount(ctx context.Context, accountID uint, meta LeaseMeta) (string, bool, int64) {
 	limit := m.GetAccountLimit(accountID)
-	return m.AcquireAccountWithLimit(ctx, accountID, limit)
+	return m.AcquireAccountWithLimit(ctx, accountID, limit, meta)
 }
 
 // AcquireAccountWithLimit 获取账户并发槽位（指定限制）
-func (m *ConcurrencyManager) AcquireAccountWithLimit(ctx context.Context, accountID uint, limit int) (bool, int64) {
+func (m *ConcurrencyManager) AcquireAccountWithLimit(ctx context.Context, accountID uint, limit int, meta LeaseMeta) (string, bool, int64) {
 	counter := m.getOrCreateAccountCounter(accountID)
 	ttl := getConcurrencyTTL()
-	acquired, count := counter.Acquire(limit, ttl)
-	return acquired, int64(count)
+	leaseID, acquired, count := counter.Acquire(limit, ttl, meta)
+	return leaseID, acquired, int64(count)
 }
 
 // ReleaseAccount 释放账户并发槽位
-func (m *ConcurrencyManager) ReleaseAccount(ctx context.Context, accountID uint) {
+func (m *ConcurrencyManager) ReleaseAccount(ctx context.Context, accountID uint, leaseID string) {
 	counter := m.getOrCreateAccountCounter(accountID)
-	counter.Release()
+	counter.Release(leaseID)
 }
 
 // GetAccountConcurrency 获取账户当前并发数
@@ -622,21 +678,21 @@ func (m *ConcurrencyManager) ResetAccountConcurrency(accountID uint) {
 }
 
 // AcquireUser 获取用户并发槽位
-func (m *ConcurrencyManager) AcquireUser(ctx context.Context, userID uint, limit int) (bool, int64) {
+func (m *ConcurrencyManager) AcquireUser(ctx context.Context, userID uint, limit int, meta LeaseMeta) (string, bool, int64) {
 	if limit <= 0 {
-		limit = 10 // 默认用户并发限制
+		limit = defaultUserConcurrencyLimit
 	}
 
 	counter := m.getOrCreateUserCounter(userID)
 	ttl := getConcurrencyTTL()
-	acquired, count := counter.Acquire(limit, ttl)
-	return acquired, int64(count)
+	leaseID, acquired, count := counter.Acquire(limit, ttl, meta)
+	return leaseID, acquired, int64(count)
 }
 
 // ReleaseUser 释放用户并发槽位
-func (m *ConcurrencyManager) ReleaseUser(ctx context.Context, userID uint) {
+func (m *ConcurrencyManager) ReleaseUser(ctx context.Context, userID uint, leaseID string) {
 	counter := m.getOrCreateUserCounter(userID)
-	counter.Release()
+	counter.Release(leaseID)
 }
 
 // GetUserConcurrency 获取用户当前并发数
@@ -653,6 +709,16 @@ func (m *ConcurrencyManager) ResetUserConcurrency(userID uint) {
 	}
 }
 
+// Renew 续约
+func (m *ConcurrencyManager) Renew(scope SlotScope, id uint, leaseID string) bool {
+	return m.getOrCreateCounter(scope, id).Renew(leaseID, getConcurrencyTTL())
+}
+
+// ListLeases 列出有效租约
+func (m *ConcurrencyManager) ListLeases(scope SlotScope, id uint) []LeaseInfo {
+	return m.getOrCreateCounter(scope, id).List(getConcurrencyTTL())
+}
+
 // Stats 获取并发管理器统计
 func (m *ConcurrencyManager) Stats() (accountCount, userCount int) {
 	m.accountCounters.Range(func(_, _ interface{}) bool {
@@ -0,0 +1,354 @@
+/*
+ * 文件作用：并发槽位等待队列，槽位已满时请求按到达顺序排队等待而非立即拒绝
+ * 负责功能：
+ *   - 按（账户/用户）维度的 FIFO 等待队列，只有队首尝试获取槽位
+ *   - 最大等待时间、最大队列深度限制
+ *   - 槽位释放时唤醒队首；客户端断开时取消等待
+ *   - 排队统计（当前深度、等待时长、超时/取消/拒绝次数）
+ * 重要程度：⭐⭐⭐⭐ 重要（突发流量削峰）
+ * 依赖模块：无
+ */
+package cache
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrConcurrencyLimit 槽位已满且未启用排队
+	ErrConcurrencyLimit = errors.New("concurrency limit reached")
+	// ErrQueueFull 等待队列已满
+	ErrQueueFull = errors.New("concurrency queue is full")
+	// ErrQueueTimeout 排队超过最大等待时间
+	ErrQueueTimeout = errors.New("concurrency queue wait timeout")
+)
+
+// IsQueueRejected 是否为排队拒绝（槽位已满 / 队列已满 / 等待超时）
+func IsQueueRejected(err error) bool {
+	return errors.Is(err, ErrConcurrencyLimit) || errors.Is(err, ErrQueueFull) || errors.Is(err, ErrQueueTimeout)
+}
+
+// queuePollInterval 队首轮询间隔
+// 其他实例（共享 Redis 后端）释放槽位或租约过期回收时不会唤醒本实例，依赖轮询兜底
+const queuePollInterval = 500 * time.Millisecond
+
+// QueueOptions 排队配置
+type QueueOptions struct {
+	Enabled  bool
+	MaxWait  time.Duration // 最大等待时间
+	MaxDepth int           // 每个账户/用户的最大排队数
+}
+
+// DefaultQueueOptions 默认排队配置
+var DefaultQueueOptions = QueueOptions{
+	Enabled:  true,
+	MaxWait:  10 * time.Second,
+	MaxDepth: 20,
+}
+
+func (o QueueOptions) enabled() bool {
+	return o.Enabled && o.MaxWait > 0 && o.MaxDepth > 0
+}
+
+// QueueScopeStats 单个维度（账户/用户）的排队统计
+type QueueScopeStats struct {
+	Depth     int   `json:"depth"`       // 当前排队请求数
+	Enqueued  int64 `json:"enqueued"`    // 累计入队
+	Admitted  int64 `json:"admitted"`    // 排队后获得槽位
+	Timeouts  int64 `json:"timeouts"`    // 等待超时
+	Cancelled int64 `json:"cancelled"`   // 客户端断开取消
+	Rejected  int64 `json:"rejected"`    // 队列已满被拒绝
+	AvgWaitMs int64 `json:"avg_wait_ms"` // 获得槽位的平均等待时长
+	MaxWaitMs int64 `json:"max_wait_ms"` // 获得槽位的最长等待时长
+}
+
+// QueueEntry 非空队列快照
+type QueueEntry struct {
+	Scope        SlotScope `json:"scope"`
+	ID           uint      `json:"id"`
+	Depth        int       `json:"depth"`
+	OldestWaitMs int64     `json:"oldest_wait_ms"` // 队首已等待时长
+}
+
+// QueueStats 排队统计
+type QueueStats struct {
+	Enabled        bool            `json:"enabled"`
+	MaxWaitSeconds float64         `json:"max_wait_seconds"`
+	MaxDepth       int             `json:"max_depth"`
+	Account        QueueScopeStats `json:"account"`
+	User           QueueScopeStats `json:"user"`
+	Queues         []QueueEntry    `json:"queues"`
+}
+
+type queueKey struct {
+	scope SlotScope
+	id    uint
+}
+
+// queueWaiter 排队中的请求
+type queueWaiter struct {
+	wake       chan struct{} // 容量为 1，轮到或有槽位释放时唤醒
+	enqueuedAt time.Time
+}
+
+// queueOutcome 离队原因
+type queueOutcome int
+
+const (
+	queueAdmitted queueOutcome = iota
+	queueTimeout
+	queueCancelled
+	queueFailed // 后端错误
+)
+
+type queueCounters struct {
+	enqueued, admitted, timeouts, cancelled, rejected int64
+	totalWait, maxWait                                time.Duration
+}
+
+// slotQueues 进程内等待队列
+// 队列只在本实例内保证公平；多实例共享后端时各实例独立排队
+type slotQueues struct {
+	mu       sync.Mutex
+	options  QueueOptions
+	queues   map[queueKey][]*queueWaiter
+	counters map[SlotScope]*queueCounters
+}
+
+func newSlotQueues() *slotQueues {
+	return &slotQueues{
+		options: DefaultQueueOptions,
+		queues:  make(map[queueKey][]*queueWaiter),
+		counters: map[SlotScope]*queueCounters{
+			SlotScopeAccount: {},
+			SlotScopeUser:    {},
+		},
+	}
+}
+
+func (q *slotQueues) getOptions() QueueOptions {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.options
+}
+
+func (q *slotQueues) setOptions(opts QueueOptions) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.options = opts
+}
+
+// depth 当前排队数
+func (q *slotQueues) depth(key queueKey) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.queues[key])
+}
+
+// enqueue 入队，队列已满返回 false
+func (q *slotQueues) enqueue(key queueKey, maxDepth int) (*queueWaiter, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	counters := q.counters[key.scope]
+	if len(q.queues[key]) >= maxDepth {
+		counters.rejected++
+		return nil, false
+	}
+	w := &queueWaiter{wake: make(chan struct{}, 1), enqueuedAt: time.Now()}
+	q.queues[key] = append(q.queues[key], w)
+	counters.enqueued++
+	return w, true
+}
+
+// isHead 是否为队首
+func (q *slotQueues) isHead(key queueKey, w *queueWaiter) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	waiters := q.queues[key]
+	return len(waiters) > 0 && waiters[0] == w
+}
+
+// leave 离队并记录结果，原队首离开时唤醒新的队首
+func (q *slotQueues) leave(key queueKey, w *queueWaiter, outcome queueOutcome) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	waiters := q.queues[key]
+	for i, waiter := range waiters {
+		if waiter != w {
+			continue
+		}
+		waiters = append(waiters[:i], waiters[i+1:]...)
+		if len(waiters) == 0 {
+			delete(q.queues, key)
+		} else {
+			q.queues[key] = waiters
+			if i == 0 {
+				signal(waiters[0])
+			}
+		}
+		break
+	}
+
+	counters := q.counters[key.scope]
+	switch outcome {
+	case queueAdmitted:
+		wait := time.Since(w.enqueuedAt)
+		counters.admitted++
+		counters.totalWait += wait
+		if wait > counters.maxWait {
+			counters.maxWait = wait
+		}
+	case queueTimeout:
+		counters.timeouts++
+	case queueCancelled:
+		counters.cancelled++
+	}
+}
+
+// notify 槽位释放，唤醒队首
+func (q *slotQueues) notify(key queueKey) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if waiters := q.queues[key]; len(waiters) > 0 {
+		signal(waiters[0])
+	}
+}
+
+// signal 非阻塞唤醒（已有未处理的唤醒时忽略）
+func signal(w *queueWaiter) {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stats 排队统计快照
+func (q *slotQueues) stats() *QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	result := &QueueStats{
+		Enabled:        q.options.enabled(),
+		MaxWaitSeconds: q.options.MaxWait.Seconds(),
+		MaxDepth:       q.options.MaxDepth,
+		Queues:         make([]QueueEntry, 0, len(q.queues)),
+	}
+	for key, waiters := range q.queues {
+		result.Queues = append(result.Queues, QueueEntry{
+			Scope:        key.scope,
+			ID:           key.id,
+			Depth:        len(waiters),
+			OldestWaitMs: now.Sub(waiters[0].enqueuedAt).Milliseconds(),
+		})
+	}
+	sort.Slice(result.Queues, func(i, j int) bool {
+		return result.Queues[i].Depth > result.Queues[j].Depth
+	})
+
+	for scope, counters := range q.counters {
+		scopeStats := QueueScopeStats{
+			Enqueued:  counters.enqueued,
+			Admitted:  counters.admitted,
+			Timeouts:  counters.timeouts,
+			Cancelled: counters.cancelled,
+			Rejected:  counters.rejected,
+			MaxWaitMs: counters.maxWait.Milliseconds(),
+		}
+		if counters.admitted > 0 {
+			scopeStats.AvgWaitMs = (counters.totalWait / time.Duration(counters.admitted)).Milliseconds()
+		}
+		for _, entry := range result.Queues {
+			if entry.Scope == scope {
+				scopeStats.Depth += entry.Depth
+			}
+		}
+		if scope == SlotScopeUser {
+			result.User = scopeStats
+		} else {
+			result.Account = scopeStats
+		}
+	}
+	return result
+}
+
+// ==================== SessionCache 排队获取 ====================
+
+// SetQueueOptions 更新排队配置（对之后入队的请求生效）
+func (s *SessionCache) SetQueueOptions(opts QueueOptions) {
+	s.queues.setOptions(opts)
+}
+
+// QueueStats 排队统计
+func (s *SessionCache) QueueStats() *QueueStats {
+	return s.queues.stats()
+}
+
+// tryAcquireSlot 非阻塞获取槽位，已有请求排队时让出，保证排队请求优先
+func (s *SessionCache) tryAcquireSlot(ctx context.Context, scope SlotScope, id uint, limit int, meta LeaseMeta) (string, bool, int64, error) {
+	if s.queues.depth(queueKey{scope, id}) > 0 {
+		current, err := s.backend.SlotCount(ctx, scope, id)
+		return "", false, current, err
+	}
+	return s.backend.AcquireSlot(ctx, scope, id, limit, meta)
+}
+
+// acquireSlotWait 获取槽位，已满时排队等待
+// 返回 ErrConcurrencyLimit / ErrQueueFull / ErrQueueTimeout 表示被拒绝；ctx 取消时返回 ctx.Err()
+func (s *SessionCache) acquireSlotWait(ctx context.Context, scope SlotScope, id uint, limit int, meta LeaseMeta) (string, int64, error) {
+	key := queueKey{scope, id}
+	opts := s.queues.getOptions()
+
+	// 无人排队时直接尝试
+	if !opts.enabled() || s.queues.depth(key) == 0 {
+		leaseID, acquired, current, err := s.backend.AcquireSlot(ctx, scope, id, limit, meta)
+		if err != nil || acquired {
+			return leaseID, current, err
+		}
+		if !opts.enabled() {
+			return "", current, ErrConcurrencyLimit
+		}
+	}
+
+	w, ok := s.queues.enqueue(key, opts.MaxDepth)
+	if !ok {
+		return "", 0, ErrQueueFull
+	}
+
+	timer := time.NewTimer(opts.MaxWait)
+	defer timer.Stop()
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+
+	for {
+		// 只有队首尝试获取，保证先到先得
+		if s.queues.isHead(key, w) {
+			leaseID, acquired, current, err := s.backend.AcquireSlot(ctx, scope, id, limit, meta)
+			if err != nil {
+				s.queues.leave(key, w, queueFailed)
+				return "", current, err
+			}
+			if acquired {
+				s.queues.leave(key, w, queueAdmitted)
+				return leaseID, current, nil
+			}
+		}
+
+		select {
+		case <-w.wake:
+		case <-ticker.C:
+		case <-timer.C:
+			s.queues.leave(key, w, queueTimeout)
+			return "", 0, ErrQueueTimeout
+		case <-ctx.Done():
+			s.queues.leave(key, w, queueCancelled)
+			return "", 0, ctx.Err()
+		}
+	}
+}
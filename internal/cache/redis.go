@@ -0,0 +1,885 @@
+/*
+ * 文件作用：Redis 共享状态后端，供多个代理实例共享会话粘性、并发槽位和不可用标记
+ * 负责功能：
+ *   - 会话绑定（Hash + TTL，账户/用户/全局索引）
+ *   - 并发租约（ZSET + Lua 原子获取/续约/释放，按服务器时间回收）
+ *   - 账户临时不可用标记（String + TTL）
+ *   - 共享计数（金额预留 ZSET + Hash、滑动窗口 ZSET、计数器与缓存数值 String，按服务器时间回收）
+ * 重要程度：⭐⭐⭐⭐ 重要（多实例部署基础）
+ * 依赖模块：config, model, go-redis
+ */
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cli-proxy/internal/config"
+	"cli-proxy/internal/model"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis 键布局（均带 KeyPrefix 前缀）：
+//
+//	session:{id}                    会话绑定 Hash，TTL = 会话 TTL
+//	sessions                        全局会话索引 ZSET（score = 最后使用毫秒时间戳）
+//	account_sessions:{id}           账户会话索引 SET
+//	user_sessions:{id}              用户会话索引 SET
+//	concurrency:{scope}:{id}        并发租约 ZSET（member = 租约 ID，score = 最近续约毫秒时间戳）
+//	concurrency_lease:{scope}:{id}  租约元信息 Hash（租约 ID -> JSON）
+//	unavailable:{id}                临时不可用原因 String，TTL = 不可用时长
+//	reservation:{key}               金额预留 ZSET（member = 预留 ID，score = 过期毫秒时间戳）
+//	reservation_amount:{key}        预留金额 Hash（预留 ID -> 金额）
+//	rate_window:{key}               滑动窗口 ZSET（member = 命中 ID，score = 命中毫秒时间戳），TTL = 窗口长度
+//	counter:{key}                   计数器 String，TTL 由调用方指定
+//	value:{key}                     缓存数值 String，TTL 由调用方指定
+
+// reclaimExpiredLeases 回收超过 TTL 未续约的租约（脚本公共片段）
+// 依赖变量 now / ttl，KEYS[1] 租约 ZSET，KEYS[2] 租约元信息 Hash
+const reclaimExpiredLeases = `
+if ttl > 0 then
+	local expired = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', now - ttl)
+	for _, lease in ipairs(expired) do
+		redis.call('ZREM', KEYS[1], lease)
+		redis.call('HDEL', KEYS[2], lease)
+	end
+end
+`
+
+// redisNow 以 Redis 服务器时间计算毫秒时间戳，避免多实例时钟偏差
+const redisNow = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+`
+
+// acquireSlotScript 回收过期租约后在上限内新增租约
+// KEYS[1] 租约 ZSET，KEYS[2] 元信息 Hash；ARGV[1] 上限，ARGV[2] 租约 TTL（毫秒），ARGV[3] 租约 ID，ARGV[4] 元信息
+// 返回 {是否获取, 当前并发数}
+var acquireSlotScript = redis.NewScript(redisNow + `
+local ttl = tonumber(ARGV[2])
+` + reclaimExpiredLeases + `
+local count = redis.call('ZCARD', KEYS[1])
+if count >= tonumber(ARGV[1]) then
+	return {0, count}
+end
+redis.call('ZADD', KEYS[1], now, ARGV[3])
+redis.call('HSET', KEYS[2], ARGV[3], ARGV[4])
+if ttl > 0 then
+	redis.call('PEXPIRE', KEYS[1], ttl)
+	redis.call('PEXPIRE', KEYS[2], ttl)
+end
+return {1, count + 1}
+`)
+
+// renewSlotScript 续约，租约不存在时返回 0
+// KEYS[1] 租约 ZSET，KEYS[2] 元信息 Hash；ARGV[1] 租约 TTL（毫秒），ARGV[2] 租约 ID
+var renewSlotScript = redis.NewScript(redisNow + `
+local ttl = tonumber(ARGV[1])
+` + reclaimExpiredLeases + `
+if not redis.call('ZSCORE', KEYS[1], ARGV[2]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], now, ARGV[2])
+if ttl > 0 then
+	redis.call('PEXPIRE', KEYS[1], ttl)
+	redis.call('PEXPIRE', KEYS[2], ttl)
+end
+return 1
+`)
+
+// releaseSlotScript 按租约 ID 释放槽位
+// KEYS[1] 租约 ZSET，KEYS[2] 元信息 Hash；ARGV[1] 租约 ID；返回剩余并发数
+var releaseSlotScript = redis.NewScript(`
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+return redis.call('ZCARD', KEYS[1])
+`)
+
+// countSlotScript 回收过期租约后返回当前并发数
+// KEYS[1] 租约 ZSET，KEYS[2] 元信息 Hash；ARGV[1] 租约 TTL（毫秒）
+var countSlotScript = redis.NewScript(redisNow + `
+local ttl = tonumber(ARGV[1])
+` + reclaimExpiredLeases + `
+return redis.call('ZCARD', KEYS[1])
+`)
+
+// setBindingScript 写入会话绑定并维护索引
+// KEYS[1] 绑定 Hash，KEYS[2] 全局索引，KEYS[3] 账户索引，KEYS[4] 用户索引
+// ARGV[1] TTL（毫秒，0 表示不过期），ARGV[2] 最后使用时间，ARGV[3] 会话 ID，ARGV[4]/ARGV[5] 是否写账户/用户索引，其后为字段键值对
+var setBindingScript = redis.NewScript(`
+redis.call('DEL', KEYS[1])
+redis.call('HSET', KEYS[1], unpack(ARGV, 6))
+if tonumber(ARGV[1]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[3])
+if ARGV[4] == '1' then
+	redis.call('SADD', KEYS[3], ARGV[3])
+end
+if ARGV[5] == '1' then
+	redis.call('SADD', KEYS[4], ARGV[3])
+end
+return 1
+`)
+
+// touchBindingScript 更新最后使用时间并滑动续期（固定会话不设置过期）
+// KEYS[1] 绑定 Hash，KEYS[2] 全局索引；ARGV[1] TTL（毫秒），ARGV[2] 最后使用时间，ARGV[3] 会话 ID
+var touchBindingScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'last_used_at', ARGV[2])
+if redis.call('HGET', KEYS[1], 'pinned') ~= '1' then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[3])
+return 1
+`)
+
+// reclaimExpiredReservations 回收已过期的金额预留并累计剩余总额（脚本公共片段）
+// 依赖变量 now，KEYS[1] 预留 ZSET，KEYS[2] 预留金额 Hash；结果写入变量 total
+const reclaimExpiredReservations = `
+local expired = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', now)
+for _, id in ipairs(expired) do
+	redis.call('ZREM', KEYS[1], id)
+	redis.call('HDEL', KEYS[2], id)
+end
+local total = 0
+for _, v in ipairs(redis.call('HVALS', KEYS[2])) do
+	total = total + tonumber(v)
+end
+`
+
+// reserveAmountScript 回收过期预留后在上限内新增预留
+// KEYS[1] 预留 ZSET，KEYS[2] 预留金额 Hash；ARGV[1] 金额，ARGV[2] 上限（负数不限），ARGV[3] TTL（毫秒），ARGV[4] 预留 ID
+// 返回 {是否预留, 总额字符串}（Lua 数字返回时会被截断为整数）
+var reserveAmountScript = redis.NewScript(redisNow + reclaimExpiredReservations + `
+local amount = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+if limit >= 0 and total + amount > limit then
+	return {0, tostring(total)}
+end
+local ttl = tonumber(ARGV[3])
+redis.call('ZADD', KEYS[1], now + ttl, ARGV[4])
+redis.call('HSET', KEYS[2], ARGV[4], ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ttl)
+redis.call('PEXPIRE', KEYS[2], ttl)
+return {1, tostring(total + amount)}
+`)
+
+// reservedAmountScript 回收过期预留后返回总额字符串
+// KEYS[1] 预留 ZSET，KEYS[2] 预留金额 Hash
+var reservedAmountScript = redis.NewScript(redisNow + reclaimExpiredReservations + `
+return tostring(total)
+`)
+
+// hitWindowScript 移除滑出窗口的命中后在上限内记录一次命中
+// KEYS[1] 窗口 ZSET；ARGV[1] 上限，ARGV[2] 窗口长度（毫秒），ARGV[3] 命中 ID
+// 返回 {是否记录, 窗口内命中数, 最早命中滑出窗口的剩余毫秒}
+var hitWindowScript = redis.NewScript(redisNow + `
+local window = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local recorded = 0
+if count < tonumber(ARGV[1]) then
+	redis.call('ZADD', KEYS[1], now, ARGV[3])
+	redis.call('PEXPIRE', KEYS[1], window)
+	count = count + 1
+	recorded = 1
+end
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+local resetAfter = 0
+if oldest[2] then
+	resetAfter = tonumber(oldest[2]) + window - now
+end
+return {recorded, count, resetAfter}
+`)
+
+// incrCounterScript 计数器小于上限时加一，新建时设置 TTL
+// KEYS[1] 计数器；ARGV[1] 上限，ARGV[2] TTL（毫秒）；返回 {是否计数, 计数后的值}
+var incrCounterScript = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+if current >= tonumber(ARGV[1]) then
+	return {0, current}
+end
+current = redis.call('INCR', KEYS[1])
+if redis.call('PTTL', KEYS[1]) < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return {1, current}
+`)
+
+// RedisBackend Redis 共享状态后端
+type RedisBackend struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisBackend 使用已有客户端创建 Redis 后端
+func NewRedisBackend(client *redis.Client, keyPrefix string) *RedisBackend {
+	return &RedisBackend{client: client, prefix: keyPrefix}
+}
+
+// NewRedisBackendFromConfig 按配置连接 Redis 并创建后端
+func NewRedisBackendFromConfig(cfg *config.RedisConfig) (*RedisBackend, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.GetAddr(),
+		Password: cfg.Password,
+		DB:       cfg.DB,
+		PoolSize: cfg.PoolSize,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("connect redis %s: %w", cfg.GetAddr(), err)
+	}
+
+	return NewRedisBackend(client, cfg.GetKeyPrefix()), nil
+}
+
+func (r *RedisBackend) Name() string { return BackendRedis }
+
+func (r *RedisBackend) Close() error {
+	return r.client.Close()
+}
+
+// ==================== 键名 ====================
+
+func (r *RedisBackend) sessionKey(sessionID string) string {
+	return r.prefix + "session:" + sessionID
+}
+
+func (r *RedisBackend) sessionsIndexKey() string {
+	return r.prefix + "sessions"
+}
+
+func (r *RedisBackend) accountSessionsKey(accountID uint) string {
+	return r.prefix + "account_sessions:" + strconv.FormatUint(uint64(accountID), 10)
+}
+
+func (r *RedisBackend) userSessionsKey(userID uint) string {
+	return r.prefix + "user_sessions:" + strconv.FormatUint(uint64(userID), 10)
+}
+
+func (r *RedisBackend) slotKey(scope SlotScope, id uint) string {
+	return r.prefix + "concurrency:" + string(scope) + ":" + strconv.FormatUint(uint64(id), 10)
+}
+
+func (r *RedisBackend) leaseMetaKey(scope SlotScope, id uint) string {
+	return r.prefix + "concurrency_lease:" + string(scope) + ":" + strconv.FormatUint(uint64(id), 10)
+}
+
+func (r *RedisBackend) unavailableKey(accountID uint) string {
+	return r.prefix + "unavailable:" + strconv.FormatUint(uint64(accountID), 10)
+}
+
+// ==================== 会话绑定 ====================
+
+func (r *RedisBackend) GetBinding(ctx context.Context, sessionID string) (*SessionBinding, error) {
+	key := r.sessionKey(sessionID)
+
+	pipe := r.client.Pipeline()
+	fieldsCmd := pipe.HGetAll(ctx, key)
+	ttlCmd := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	fields := fieldsCmd.Val()
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	remaining := ttlCmd.Val()
+
+	// 智能续期：剩余时间不足阈值时续期（与内存实现一致）
+	if remaining > 0 && remaining < getSessionRenewalThreshold() {
+		if err := r.client.PExpire(ctx, key, getSessionTTL()).Err(); err != nil {
+			return nil, err
+		}
+		remaining = getSessionTTL()
+	}
+
+	binding := parseRedisBinding(sessionID, fields, remaining, time.Now())
+	return &binding, nil
+}
+
+func (r *RedisBackend) SetBinding(ctx context.Context, binding *SessionBinding) error {
+	now := time.Now()
+	boundAt := binding.BoundAt
+	if boundAt.IsZero() {
+		boundAt = now
+	}
+
+	flag := func(ok bool) string {
+		if ok {
+			return "1"
+		}
+		return "0"
+	}
+
+	ttl := getSessionTTL().Milliseconds()
+	if binding.Pinned {
+		ttl = 0
+	}
+	args := []interface{}{
+		ttl,
+		now.UnixMilli(),
+		binding.SessionID,
+		flag(binding.AccountID > 0),
+		flag(binding.UserID > 0),
+		"account_id", binding.AccountID,
+		"platform", binding.Platform,
+		"model", binding.Model,
+		"user_id", binding.UserID,
+		"api_key_id", binding.APIKeyID,
+		"client_ip", binding.ClientIP,
+		"user_agent", binding.UserAgent,
+		"bound_at", boundAt.UnixMilli(),
+		"last_used_at", now.UnixMilli(),
+		"pinned", flag(binding.Pinned),
+	}
+	keys := []string{
+		r.sessionKey(binding.SessionID),
+		r.sessionsIndexKey(),
+		r.accountSessionsKey(binding.AccountID),
+		r.userSessionsKey(binding.UserID),
+	}
+	return setBindingScript.Run(ctx, r.client, keys, args...).Err()
+}
+
+func (r *RedisBackend) TouchBinding(ctx context.Context, sessionID string) error {
+	keys := []string{r.sessionKey(sessionID), r.sessionsIndexKey()}
+	return touchBindingScript.Run(ctx, r.client, keys,
+		getSessionTTL().Milliseconds(), time.Now().UnixMilli(), sessionID).Err()
+}
+
+func (r *RedisBackend) RemoveBinding(ctx context.Context, sessionID string) error {
+	key := r.sessionKey(sessionID)
+	owners, err := r.client.HMGet(ctx, key, "account_id", "user_id").Result()
+	if err != nil {
+		return err
+	}
+
+	pipe := r.client.TxPipeline()
+	pipe.Del(ctx, key)
+	pipe.ZRem(ctx, r.sessionsIndexKey(), sessionID)
+	if accountID := parseUintField(owners[0]); accountID > 0 {
+		pipe.SRem(ctx, r.accountSessionsKey(accountID), sessionID)
+	}
+	if userID := parseUintField(owners[1]); userID > 0 {
+		pipe.SRem(ctx, r.userSessionsKey(userID), sessionID)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// indexedSessions 读取索引中仍然有效且归属匹配的会话，顺带清理过期或已迁移的成员
+func (r *RedisBackend) indexedSessions(ctx context.Context, indexKey, ownerField string, ownerID uint) ([]string, error) {
+	members, err := r.client.SMembers(ctx, indexKey).Result()
+	if err != nil || len(members) == 0 {
+		return nil, err
+	}
+
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(members))
+	for i, sessionID := range members {
+		cmds[i] = pipe.HGet(ctx, r.sessionKey(sessionID), ownerField)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	var stale []interface{}
+	result := make([]string, 0, len(members))
+	for i, sessionID := range members {
+		if parseUintField(cmds[i].Val()) == ownerID {
+			result = append(result, sessionID)
+		} else {
+			stale = append(stale, sessionID)
+		}
+	}
+	if len(stale) > 0 {
+		r.client.SRem(ctx, indexKey, stale...)
+	}
+	return result, nil
+}
+
+// clearIndexedSessions 删除索引中的全部有效会话
+func (r *RedisBackend) clearIndexedSessions(ctx context.Context, indexKey, ownerField string, ownerID uint) (int64, error) {
+	sessionIDs, err := r.indexedSessions(ctx, indexKey, ownerField, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	for _, sessionID := range sessionIDs {
+		if err := r.RemoveBinding(ctx, sessionID); err != nil {
+			return 0, err
+		}
+	}
+	if err := r.client.Del(ctx, indexKey).Err(); err != nil {
+		return 0, err
+	}
+	return int64(len(sessionIDs)), nil
+}
+
+func (r *RedisBackend) AccountSessions(ctx context.Context, accountID uint) ([]string, error) {
+	return r.indexedSessions(ctx, r.accountSessionsKey(accountID), "account_id", accountID)
+}
+
+func (r *RedisBackend) ClearAccountSessions(ctx context.Context, accountID uint) (int64, error) {
+	return r.clearIndexedSessions(ctx, r.accountSessionsKey(accountID), "account_id", accountID)
+}
+
+func (r *RedisBackend) ClearUserSessions(ctx context.Context, userID uint) (int64, error) {
+	return r.clearIndexedSessions(ctx, r.userSessionsKey(userID), "user_id", userID)
+}
+
+func (r *RedisBackend) ListBindings(ctx context.Context, offset, limit int64) ([]SessionBinding, int64, error) {
+	// 全局索引按最后使用时间倒序，已过期的绑定在此处从索引中清理
+	sessionIDs, err := r.client.ZRevRange(ctx, r.sessionsIndexKey(), 0, -1).Result()
+	if err != nil || len(sessionIDs) == 0 {
+		return nil, 0, err
+	}
+
+	pipe := r.client.Pipeline()
+	fieldCmds := make([]*redis.MapStringStringCmd, len(sessionIDs))
+	ttlCmds := make([]*redis.DurationCmd, len(sessionIDs))
+	for i, sessionID := range sessionIDs {
+		key := r.sessionKey(sessionID)
+		fieldCmds[i] = pipe.HGetAll(ctx, key)
+		ttlCmds[i] = pipe.PTTL(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, 0, err
+	}
+
+	now := time.Now()
+	all := make([]SessionBinding, 0, len(sessionIDs))
+	var stale []interface{}
+	for i, sessionID := range sessionIDs {
+		fields := fieldCmds[i].Val()
+		if len(fields) == 0 {
+			stale = append(stale, sessionID)
+			continue
+		}
+		all = append(all, parseRedisBinding(sessionID, fields, ttlCmds[i].Val(), now))
+	}
+	if len(stale) > 0 {
+		r.client.ZRem(ctx, r.sessionsIndexKey(), stale...)
+	}
+
+	total := int64(len(all))
+	if offset >= total {
+		return nil, total, nil
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	return all[offset:end], total, nil
+}
+
+func (r *RedisBackend) ClearAllSessions(ctx context.Context) (int64, error) {
+	sessionKeys, err := r.scanKeys(ctx, r.prefix+"session:*")
+	if err != nil {
+		return 0, err
+	}
+	indexKeys, err := r.scanKeys(ctx, r.prefix+"account_sessions:*")
+	if err != nil {
+		return 0, err
+	}
+	userIndexKeys, err := r.scanKeys(ctx, r.prefix+"user_sessions:*")
+	if err != nil {
+		return 0, err
+	}
+
+	keys := append(append(append(sessionKeys, indexKeys...), userIndexKeys...), r.sessionsIndexKey())
+	if err := r.deleteKeys(ctx, keys); err != nil {
+		return 0, err
+	}
+	return int64(len(sessionKeys)), nil
+}
+
+// ==================== 并发槽位 ====================
+
+// redisLeaseMeta 租约元信息
+type redisLeaseMeta struct {
+	RequestID  string `json:"request_id,omitempty"`
+	Model      string `json:"model,omitempty"`
+	AcquiredAt int64  `json:"acquired_at"` // 毫秒时间戳
+}
+
+func (r *RedisBackend) slotKeys(scope SlotScope, id uint) []string {
+	return []string{r.slotKey(scope, id), r.leaseMetaKey(scope, id)}
+}
+
+func (r *RedisBackend) AcquireSlot(ctx context.Context, scope SlotScope, id uint, limit int, meta LeaseMeta) (string, bool, int64, error) {
+	if scope == SlotScopeUser && limit <= 0 {
+		limit = defaultUserConcurrencyLimit
+	}
+
+	leaseID, err := newLeaseID()
+	if err != nil {
+		return "", false, 0, err
+	}
+	metaJSON, err := json.Marshal(redisLeaseMeta{
+		RequestID:  meta.RequestID,
+		Model:      meta.Model,
+		AcquiredAt: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		return "", false, 0, err
+	}
+
+	res, err := acquireSlotScript.Run(ctx, r.client, r.slotKeys(scope, id),
+		limit, getConcurrencyTTL().Milliseconds(), leaseID, string(metaJSON)).Int64Slice()
+	if err != nil {
+		return "", false, 0, err
+	}
+	if res[0] != 1 {
+		return "", false, res[1], nil
+	}
+	return leaseID, true, res[1], nil
+}
+
+func (r *RedisBackend) RenewSlot(ctx context.Context, scope SlotScope, id uint, leaseID string) (bool, error) {
+	renewed, err := renewSlotScript.Run(ctx, r.client, r.slotKeys(scope, id),
+		getConcurrencyTTL().Milliseconds(), leaseID).Int64()
+	return renewed == 1, err
+}
+
+func (r *RedisBackend) ReleaseSlot(ctx context.Context, scope SlotScope, id uint, leaseID string) error {
+	return releaseSlotScript.Run(ctx, r.client, r.slotKeys(scope, id), leaseID).Err()
+}
+
+func (r *RedisBackend) SlotCount(ctx context.Context, scope SlotScope, id uint) (int64, error) {
+	return countSlotScript.Run(ctx, r.client, r.slotKeys(scope, id),
+		getConcurrencyTTL().Milliseconds()).Int64()
+}
+
+func (r *RedisBackend) ResetSlots(ctx context.Context, scope SlotScope, id uint) error {
+	return r.client.Del(ctx, r.slotKeys(scope, id)...).Err()
+}
+
+func (r *RedisBackend) ListLeases(ctx context.Context, scope SlotScope, id uint) ([]LeaseInfo, error) {
+	// 先回收过期租约，保证列表与计数一致
+	if _, err := r.SlotCount(ctx, scope, id); err != nil {
+		return nil, err
+	}
+
+	pipe := r.client.Pipeline()
+	leasesCmd := pipe.ZRangeWithScores(ctx, r.slotKey(scope, id), 0, -1)
+	metaCmd := pipe.HGetAll(ctx, r.leaseMetaKey(scope, id))
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	now := time.Now()
+	metas := metaCmd.Val()
+	result := make([]LeaseInfo, 0, len(leasesCmd.Val()))
+	for _, z := range leasesCmd.Val() {
+		leaseID, _ := z.Member.(string)
+		renewedAt := time.UnixMilli(int64(z.Score))
+
+		var meta redisLeaseMeta
+		json.Unmarshal([]byte(metas[leaseID]), &meta)
+		acquiredAt := renewedAt
+		if meta.AcquiredAt > 0 {
+			acquiredAt = time.UnixMilli(meta.AcquiredAt)
+		}
+
+		result = append(result, LeaseInfo{
+			LeaseID:    leaseID,
+			RequestID:  meta.RequestID,
+			Model:      meta.Model,
+			AcquiredAt: acquiredAt,
+			RenewedAt:  renewedAt,
+			AgeSeconds: int64(now.Sub(acquiredAt).Seconds()),
+		})
+	}
+	sortLeases(result)
+	return result, nil
+}
+
+// ==================== 临时不可用标记 ====================
+
+func (r *RedisBackend) MarkUnavailable(ctx context.Context, accountID uint, reason string, ttl time.Duration) error {
+	if ttl == 0 {
+		ttl = getUnavailableTTL()
+	}
+	return r.client.Set(ctx, r.unavailableKey(accountID), reason, ttl).Err()
+}
+
+func (r *RedisBackend) IsUnavailable(ctx context.Context, accountID uint) (bool, string, error) {
+	reason, err := r.client.Get(ctx, r.unavailableKey(accountID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	return true, reason, nil
+}
+
+func (r *RedisBackend) ClearUnavailable(ctx context.Context, accountID uint) error {
+	return r.client.Del(ctx, r.unavailableKey(accountID)).Err()
+}
+
+func (r *RedisBackend) ListUnavailable(ctx context.Context) ([]model.UnavailableAccount, error) {
+	keyPrefix := r.prefix + "unavailable:"
+	keys, err := r.scanKeys(ctx, keyPrefix+"*")
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+
+	pipe := r.client.Pipeline()
+	reasonCmds := make([]*redis.StringCmd, len(keys))
+	ttlCmds := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		reasonCmds[i] = pipe.Get(ctx, key)
+		ttlCmds[i] = pipe.PTTL(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	result := make([]model.UnavailableAccount, 0, len(keys))
+	for i, key := range keys {
+		if reasonCmds[i].Err() != nil {
+			continue // 扫描后已过期
+		}
+		accountID, err := strconv.ParseUint(strings.TrimPrefix(key, keyPrefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		result = append(result, model.UnavailableAccount{
+			AccountID:    uint(accountID),
+			Reason:       reasonCmds[i].Val(),
+			RemainingTTL: int64(ttlCmds[i].Val().Seconds()),
+		})
+	}
+	return result, nil
+}
+
+func (r *RedisBackend) ClearAllUnavailable(ctx context.Context) (int64, error) {
+	keys, err := r.scanKeys(ctx, r.prefix+"unavailable:*")
+	if err != nil {
+		return 0, err
+	}
+	if err := r.deleteKeys(ctx, keys); err != nil {
+		return 0, err
+	}
+	return int64(len(keys)), nil
+}
+
+// ==================== 共享计数 ====================
+
+func (r *RedisBackend) reservationKeys(key string) []string {
+	return []string{r.prefix + "reservation:" + key, r.prefix + "reservation_amount:" + key}
+}
+
+func (r *RedisBackend) ReserveAmount(ctx context.Context, key, reservationID string, amount, limit float64, ttl time.Duration) (bool, float64, error) {
+	res, err := reserveAmountScript.Run(ctx, r.client, r.reservationKeys(key),
+		strconv.FormatFloat(amount, 'f', -1, 64), strconv.FormatFloat(limit, 'f', -1, 64),
+		ttl.Milliseconds(), reservationID).Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(res) != 2 {
+		return false, 0, fmt.Errorf("unexpected reserve result: %v", res)
+	}
+	reserved, _ := res[0].(int64)
+	totalStr, _ := res[1].(string)
+	total, err := strconv.ParseFloat(totalStr, 64)
+	if err != nil {
+		return false, 0, fmt.Errorf("parse reserved total %q: %w", totalStr, err)
+	}
+	return reserved == 1, total, nil
+}
+
+func (r *RedisBackend) ReleaseAmount(ctx context.Context, key, reservationID string) error {
+	keys := r.reservationKeys(key)
+	pipe := r.client.TxPipeline()
+	pipe.ZRem(ctx, keys[0], reservationID)
+	pipe.HDel(ctx, keys[1], reservationID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *RedisBackend) ReservedAmount(ctx context.Context, key string) (float64, error) {
+	totalStr, err := reservedAmountScript.Run(ctx, r.client, r.reservationKeys(key)).Text()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(totalStr, 64)
+}
+
+func (r *RedisBackend) windowKey(key string) string {
+	return r.prefix + "rate_window:" + key
+}
+
+func (r *RedisBackend) counterKey(key string) string {
+	return r.prefix + "counter:" + key
+}
+
+func (r *RedisBackend) valueKey(key string) string {
+	return r.prefix + "value:" + key
+}
+
+func (r *RedisBackend) HitWindow(ctx context.Context, key, hitID string, limit int, window time.Duration) (bool, int64, time.Duration, error) {
+	res, err := hitWindowScript.Run(ctx, r.client, []string{r.windowKey(key)},
+		limit, window.Milliseconds(), hitID).Int64Slice()
+	if err != nil {
+		return false, 0, 0, err
+	}
+	return res[0] == 1, res[1], time.Duration(res[2]) * time.Millisecond, nil
+}
+
+func (r *RedisBackend) RemoveWindowHit(ctx context.Context, key, hitID string) error {
+	return r.client.ZRem(ctx, r.windowKey(key), hitID).Err()
+}
+
+func (r *RedisBackend) InitCounter(ctx context.Context, key string, value int64, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, r.counterKey(key), value, ttl).Result()
+}
+
+func (r *RedisBackend) IncrCounter(ctx context.Context, key string, limit int64, ttl time.Duration) (bool, int64, error) {
+	res, err := incrCounterScript.Run(ctx, r.client, []string{r.counterKey(key)},
+		limit, ttl.Milliseconds()).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return res[0] == 1, res[1], nil
+}
+
+func (r *RedisBackend) CacheValue(ctx context.Context, key string, value float64, ttl time.Duration) error {
+	return r.client.Set(ctx, r.valueKey(key), strconv.FormatFloat(value, 'f', -1, 64), ttl).Err()
+}
+
+func (r *RedisBackend) CachedValue(ctx context.Context, key string) (float64, bool, error) {
+	value, err := r.client.Get(ctx, r.valueKey(key)).Float64()
+	if errors.Is(err, redis.Nil) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return value, true, nil
+}
+
+func (r *RedisBackend) ResetCounters(ctx context.Context, keys ...string) error {
+	redisKeys := make([]string, 0, len(keys)*3)
+	for _, key := range keys {
+		redisKeys = append(redisKeys, r.windowKey(key), r.counterKey(key), r.valueKey(key))
+	}
+	if len(redisKeys) == 0 {
+		return nil
+	}
+	return r.client.Del(ctx, redisKeys...).Err()
+}
+
+// ==================== 统计 ====================
+
+func (r *RedisBackend) Stats(ctx context.Context) (*BackendStats, error) {
+	stats := &BackendStats{}
+	counts := []struct {
+		pattern string
+		target  *int
+	}{
+		{r.prefix + "session:*", &stats.SessionCount},
+		{r.prefix + "unavailable:*", &stats.UnavailableCount},
+		{r.prefix + "concurrency:" + string(SlotScopeAccount) + ":*", &stats.AccountConcurrencyCount},
+		{r.prefix + "concurrency:" + string(SlotScopeUser) + ":*", &stats.UserConcurrencyCount},
+	}
+	for _, c := range counts {
+		keys, err := r.scanKeys(ctx, c.pattern)
+		if err != nil {
+			return nil, err
+		}
+		*c.target = len(keys)
+	}
+	return stats, nil
+}
+
+// ==================== 工具函数 ====================
+
+// scanKeys 以 SCAN 遍历匹配的键（不阻塞 Redis）
+func (r *RedisBackend) scanKeys(ctx context.Context, pattern string) ([]string, error) {
+	var keys []string
+	iter := r.client.Scan(ctx, 0, pattern, 500).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+// deleteKeys 分批删除键
+func (r *RedisBackend) deleteKeys(ctx context.Context, keys []string) error {
+	const batch = 500
+	for start := 0; start < len(keys); start += batch {
+		end := start + batch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := r.client.Del(ctx, keys[start:end]...).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseRedisBinding 将绑定 Hash 转换为对外结构
+func parseRedisBinding(sessionID string, fields map[string]string, remaining time.Duration, now time.Time) SessionBinding {
+	// 固定会话没有过期时间（PTTL 为 -1），剩余秒数按 -1 返回
+	pinned := fields["pinned"] == "1"
+	remainingTTL := int64(remaining.Seconds())
+	if pinned {
+		remaining, remainingTTL = 0, -1
+	} else if remaining < 0 {
+		remaining, remainingTTL = 0, 0
+	}
+	return SessionBinding{
+		SessionID:    sessionID,
+		AccountID:    parseUintField(fields["account_id"]),
+		Platform:     fields["platform"],
+		Model:        fields["model"],
+		UserID:       parseUintField(fields["user_id"]),
+		APIKeyID:     parseUintField(fields["api_key_id"]),
+		ClientIP:     fields["client_ip"],
+		UserAgent:    fields["user_agent"],
+		BoundAt:      parseMilliField(fields["bound_at"]),
+		LastUsedAt:   parseMilliField(fields["last_used_at"]),
+		ExpireAt:     now.Add(remaining),
+		RemainingTTL: remainingTTL,
+		Pinned:       pinned,
+	}
+}
+
+// parseUintField 解析 Hash 中的无符号整数字段（HMGET 可能返回 nil）
+func parseUintField(v interface{}) uint {
+	s, ok := v.(string)
+	if !ok || s == "" {
+		return 0
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return uint(n)
+}
+
+// parseMilliField 解析毫秒时间戳字段
+func parseMilliField(s string) time.Time {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || ms <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
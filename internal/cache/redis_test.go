@@ -0,0 +1,365 @@
+/*
+ * 文件作用：Redis 共享状态后端测试（使用进程内 miniredis）
+ * 负责功能：
+ *   - 并发租约 Lua 脚本：获取/续约/释放/计数、上限、TTL 回收
+ *   - 会话绑定写入/读取/移除与账户索引、固定会话不过期
+ *   - ctx 取消后释放并发槽位
+ * 重要程度：⭐⭐ 辅助（测试）
+ * 依赖模块：miniredis
+ */
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cli-proxy/internal/config"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// testRedis 进程内 Redis，服务器时间固定并手动推进
+type testRedis struct {
+	*miniredis.Miniredis
+	now time.Time
+}
+
+// newTestRedisBackend 启动 miniredis 并创建后端（使用默认缓存配置）
+func newTestRedisBackend(t *testing.T) (*RedisBackend, *testRedis) {
+	t.Helper()
+	if config.Cfg == nil {
+		config.Cfg = &config.Config{}
+	}
+	mr := &testRedis{Miniredis: miniredis.RunT(t), now: time.Now()}
+	// Lua 脚本通过 TIME 取服务器时间，固定后可以精确推进
+	mr.SetTime(mr.now)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	backend := NewRedisBackend(client, "test:")
+	t.Cleanup(func() { backend.Close() })
+	return backend, mr
+}
+
+// advance 推进 miniredis 的服务器时间与键过期时间
+func advance(mr *testRedis, d time.Duration) {
+	mr.now = mr.now.Add(d)
+	mr.SetTime(mr.now)
+	mr.FastForward(d)
+}
+
+func TestRedisBackendSlotLifecycle(t *testing.T) {
+	ctx := context.Background()
+	backend, _ := newTestRedisBackend(t)
+	meta := LeaseMeta{RequestID: "req-1", Model: "claude-sonnet-4"}
+
+	tests := []struct {
+		name  string
+		scope SlotScope
+		id    uint
+		limit int
+	}{
+		{name: "account", scope: SlotScopeAccount, id: 1, limit: 2},
+		{name: "user", scope: SlotScopeUser, id: 7, limit: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var leases []string
+			for i := 0; i < tt.limit; i++ {
+				leaseID, ok, current, err := backend.AcquireSlot(ctx, tt.scope, tt.id, tt.limit, meta)
+				if err != nil || !ok || leaseID == "" {
+					t.Fatalf("acquire %d: lease=%q ok=%v err=%v", i, leaseID, ok, err)
+				}
+				if current != int64(i+1) {
+					t.Errorf("acquire %d: current = %d, want %d", i, current, i+1)
+				}
+				leases = append(leases, leaseID)
+			}
+
+			// 达到上限后拒绝
+			leaseID, ok, current, err := backend.AcquireSlot(ctx, tt.scope, tt.id, tt.limit, meta)
+			if err != nil || ok || leaseID != "" {
+				t.Fatalf("acquire over limit: lease=%q ok=%v err=%v", leaseID, ok, err)
+			}
+			if current != int64(tt.limit) {
+				t.Errorf("acquire over limit: current = %d, want %d", current, tt.limit)
+			}
+
+			if renewed, err := backend.RenewSlot(ctx, tt.scope, tt.id, leases[0]); err != nil || !renewed {
+				t.Errorf("renew held lease: renewed=%v err=%v", renewed, err)
+			}
+			if renewed, err := backend.RenewSlot(ctx, tt.scope, tt.id, "unknown"); err != nil || renewed {
+				t.Errorf("renew unknown lease: renewed=%v err=%v", renewed, err)
+			}
+
+			infos, err := backend.ListLeases(ctx, tt.scope, tt.id)
+			if err != nil || len(infos) != tt.limit {
+				t.Fatalf("list leases: %d leases, err=%v", len(infos), err)
+			}
+			if infos[0].RequestID != meta.RequestID || infos[0].Model != meta.Model {
+				t.Errorf("lease meta = %+v, want %+v", infos[0], meta)
+			}
+
+			// 释放一个后可以再次获取
+			if err := backend.ReleaseSlot(ctx, tt.scope, tt.id, leases[0]); err != nil {
+				t.Fatalf("release: %v", err)
+			}
+			// 重复释放不影响其他租约
+			if err := backend.ReleaseSlot(ctx, tt.scope, tt.id, leases[0]); err != nil {
+				t.Fatalf("release twice: %v", err)
+			}
+			if count, err := backend.SlotCount(ctx, tt.scope, tt.id); err != nil || count != int64(tt.limit-1) {
+				t.Errorf("count after release = %d (err=%v), want %d", count, err, tt.limit-1)
+			}
+			if _, ok, _, err := backend.AcquireSlot(ctx, tt.scope, tt.id, tt.limit, meta); err != nil || !ok {
+				t.Errorf("acquire after release: ok=%v err=%v", ok, err)
+			}
+
+			if err := backend.ResetSlots(ctx, tt.scope, tt.id); err != nil {
+				t.Fatalf("reset: %v", err)
+			}
+			if count, _ := backend.SlotCount(ctx, tt.scope, tt.id); count != 0 {
+				t.Errorf("count after reset = %d, want 0", count)
+			}
+		})
+	}
+}
+
+func TestRedisBackendSlotScopesAreIndependent(t *testing.T) {
+	ctx := context.Background()
+	backend, _ := newTestRedisBackend(t)
+
+	if _, ok, _, _ := backend.AcquireSlot(ctx, SlotScopeAccount, 1, 1, LeaseMeta{}); !ok {
+		t.Fatal("acquire account slot failed")
+	}
+	if _, ok, _, _ := backend.AcquireSlot(ctx, SlotScopeUser, 1, 1, LeaseMeta{}); !ok {
+		t.Error("user slot with same id should not share the account limit")
+	}
+	if _, ok, _, _ := backend.AcquireSlot(ctx, SlotScopeAccount, 2, 1, LeaseMeta{}); !ok {
+		t.Error("another account should not share the limit")
+	}
+}
+
+func TestRedisBackendSlotTTLExpiry(t *testing.T) {
+	ctx := context.Background()
+	backend, mr := newTestRedisBackend(t)
+	ttl := getConcurrencyTTL()
+
+	stale, ok, _, err := backend.AcquireSlot(ctx, SlotScopeAccount, 1, 2, LeaseMeta{})
+	if err != nil || !ok {
+		t.Fatalf("acquire stale: ok=%v err=%v", ok, err)
+	}
+	advance(mr, ttl/2)
+	held, ok, _, err := backend.AcquireSlot(ctx, SlotScopeAccount, 1, 2, LeaseMeta{})
+	if err != nil || !ok {
+		t.Fatalf("acquire held: ok=%v err=%v", ok, err)
+	}
+	if _, ok, _, _ := backend.AcquireSlot(ctx, SlotScopeAccount, 1, 2, LeaseMeta{}); ok {
+		t.Fatal("limit should be reached")
+	}
+
+	// 续约持有中的租约后越过第一个租约的 TTL：只回收未续约的租约
+	advance(mr, ttl/4)
+	if renewed, err := backend.RenewSlot(ctx, SlotScopeAccount, 1, held); err != nil || !renewed {
+		t.Fatalf("renew: renewed=%v err=%v", renewed, err)
+	}
+	advance(mr, ttl/2)
+
+	if count, err := backend.SlotCount(ctx, SlotScopeAccount, 1); err != nil || count != 1 {
+		t.Fatalf("count after expiry = %d (err=%v), want 1", count, err)
+	}
+	if renewed, _ := backend.RenewSlot(ctx, SlotScopeAccount, 1, stale); renewed {
+		t.Error("expired lease should not be renewable")
+	}
+	if _, ok, current, _ := backend.AcquireSlot(ctx, SlotScopeAccount, 1, 2, LeaseMeta{}); !ok || current != 2 {
+		t.Errorf("acquire after expiry: ok=%v current=%d, want ok and 2", ok, current)
+	}
+
+	// 全部租约停止续约后键整体过期
+	advance(mr, ttl+time.Second)
+	if count, _ := backend.SlotCount(ctx, SlotScopeAccount, 1); count != 0 {
+		t.Errorf("count after all leases expired = %d, want 0", count)
+	}
+	if mr.Exists(backend.slotKey(SlotScopeAccount, 1)) {
+		t.Error("slot key should expire with its leases")
+	}
+}
+
+func TestRedisBackendSessionBinding(t *testing.T) {
+	ctx := context.Background()
+	backend, mr := newTestRedisBackend(t)
+
+	binding := &SessionBinding{
+		SessionID: "sess-1",
+		AccountID: 10,
+		Platform:  "claude",
+		Model:     "claude-sonnet-4",
+		UserID:    3,
+		APIKeyID:  5,
+		ClientIP:  "10.0.0.1",
+		UserAgent: "claude-cli/1.0",
+	}
+	if err := backend.SetBinding(ctx, binding); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := backend.SetBinding(ctx, &SessionBinding{SessionID: "sess-2", AccountID: 10, Platform: "claude", UserID: 4}); err != nil {
+		t.Fatalf("set sess-2: %v", err)
+	}
+
+	got, err := backend.GetBinding(ctx, "sess-1")
+	if err != nil || got == nil {
+		t.Fatalf("get: binding=%v err=%v", got, err)
+	}
+	if got.AccountID != 10 || got.Platform != "claude" || got.Model != "claude-sonnet-4" ||
+		got.UserID != 3 || got.APIKeyID != 5 || got.ClientIP != "10.0.0.1" || got.UserAgent != "claude-cli/1.0" {
+		t.Errorf("get = %+v, want fields of %+v", got, binding)
+	}
+	if got.BoundAt.IsZero() || got.RemainingTTL <= 0 {
+		t.Errorf("get: bound_at=%v remaining=%d, want set", got.BoundAt, got.RemainingTTL)
+	}
+	if missing, err := backend.GetBinding(ctx, "missing"); err != nil || missing != nil {
+		t.Errorf("get missing: binding=%v err=%v", missing, err)
+	}
+
+	sessions, err := backend.AccountSessions(ctx, 10)
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("account sessions = %v (err=%v), want 2", sessions, err)
+	}
+
+	// 迁移到其他账户后，旧账户索引中的成员被惰性清理
+	moved := *binding
+	moved.AccountID = 11
+	if err := backend.SetBinding(ctx, &moved); err != nil {
+		t.Fatalf("rebind: %v", err)
+	}
+	if sessions, _ := backend.AccountSessions(ctx, 10); len(sessions) != 1 || sessions[0] != "sess-2" {
+		t.Errorf("old account sessions = %v, want [sess-2]", sessions)
+	}
+	if sessions, _ := backend.AccountSessions(ctx, 11); len(sessions) != 1 || sessions[0] != "sess-1" {
+		t.Errorf("new account sessions = %v, want [sess-1]", sessions)
+	}
+
+	if err := backend.RemoveBinding(ctx, "sess-1"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if got, _ := backend.GetBinding(ctx, "sess-1"); got != nil {
+		t.Errorf("get after remove = %+v, want nil", got)
+	}
+	if sessions, _ := backend.AccountSessions(ctx, 11); len(sessions) != 0 {
+		t.Errorf("account sessions after remove = %v, want none", sessions)
+	}
+	if mr.Exists(backend.accountSessionsKey(11)) {
+		members, _ := mr.Members(backend.accountSessionsKey(11))
+		if len(members) != 0 {
+			t.Errorf("account index still has members %v", members)
+		}
+	}
+
+	if n, err := backend.ClearAccountSessions(ctx, 10); err != nil || n != 1 {
+		t.Errorf("clear account sessions = %d (err=%v), want 1", n, err)
+	}
+	if _, total, _ := backend.ListBindings(ctx, 0, 10); total != 0 {
+		t.Errorf("bindings after clear = %d, want 0", total)
+	}
+}
+
+func TestRedisBackendSessionBindingTTL(t *testing.T) {
+	ctx := context.Background()
+	backend, mr := newTestRedisBackend(t)
+	ttl := getSessionTTL()
+
+	if err := backend.SetBinding(ctx, &SessionBinding{SessionID: "sess-1", AccountID: 1, Platform: "claude"}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	// 使用会滑动续期
+	advance(mr, ttl-time.Minute)
+	if err := backend.TouchBinding(ctx, "sess-1"); err != nil {
+		t.Fatalf("touch: %v", err)
+	}
+	advance(mr, 2*time.Minute)
+	if got, _ := backend.GetBinding(ctx, "sess-1"); got == nil {
+		t.Fatal("binding expired although it was touched")
+	}
+
+	advance(mr, ttl+time.Second)
+	if got, _ := backend.GetBinding(ctx, "sess-1"); got != nil {
+		t.Errorf("binding = %+v after TTL, want nil", got)
+	}
+	// 过期绑定在列表时从全局索引和账户索引中清理
+	if _, total, _ := backend.ListBindings(ctx, 0, 10); total != 0 {
+		t.Errorf("list after expiry total = %d, want 0", total)
+	}
+	if sessions, _ := backend.AccountSessions(ctx, 1); len(sessions) != 0 {
+		t.Errorf("account sessions after expiry = %v, want none", sessions)
+	}
+}
+
+func TestRedisBackendPinnedBinding(t *testing.T) {
+	ctx := context.Background()
+	backend, mr := newTestRedisBackend(t)
+	ttl := getSessionTTL()
+
+	if err := backend.SetBinding(ctx, &SessionBinding{SessionID: "sess-1", AccountID: 1, Platform: "claude", Pinned: true}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	// 固定会话不设置过期时间，使用后也不会加上 TTL
+	advance(mr, 2*ttl)
+	if err := backend.TouchBinding(ctx, "sess-1"); err != nil {
+		t.Fatalf("touch: %v", err)
+	}
+	advance(mr, 2*ttl)
+	got, err := backend.GetBinding(ctx, "sess-1")
+	if err != nil || got == nil {
+		t.Fatalf("get pinned binding = %v, %v; want binding", got, err)
+	}
+	if !got.Pinned || got.RemainingTTL != -1 {
+		t.Errorf("pinned binding = %+v, want Pinned with RemainingTTL -1", got)
+	}
+	if list, _, _ := backend.ListBindings(ctx, 0, 10); len(list) != 1 || !list[0].Pinned {
+		t.Errorf("list = %+v, want the pinned binding", list)
+	}
+
+	// 取消固定后恢复按 TTL 过期
+	got.Pinned = false
+	if err := backend.SetBinding(ctx, got); err != nil {
+		t.Fatalf("unpin: %v", err)
+	}
+	advance(mr, ttl+time.Second)
+	if got, _ := backend.GetBinding(ctx, "sess-1"); got != nil {
+		t.Errorf("binding = %+v after TTL, want nil", got)
+	}
+}
+
+func TestSessionCacheReleaseAfterCancel(t *testing.T) {
+	backend, _ := newTestRedisBackend(t)
+	sessionCache := newSessionCache(backend)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leaseID, ok, _, err := sessionCache.AcquireConcurrencyWithLimit(ctx, 1, 1, LeaseMeta{})
+	if err != nil || !ok {
+		t.Fatalf("acquire: ok=%v err=%v", ok, err)
+	}
+	userLease, ok, _, err := sessionCache.AcquireUserConcurrency(ctx, 2, 1, LeaseMeta{})
+	if err != nil || !ok {
+		t.Fatalf("acquire user: ok=%v err=%v", ok, err)
+	}
+
+	// 客户端断开后请求 ctx 已取消，释放仍然必须生效
+	cancel()
+	if err := sessionCache.ReleaseConcurrency(ctx, 1, leaseID); err != nil {
+		t.Fatalf("release with cancelled ctx: %v", err)
+	}
+	if err := sessionCache.ReleaseUserConcurrency(ctx, 2, userLease); err != nil {
+		t.Fatalf("release user with cancelled ctx: %v", err)
+	}
+
+	bg := context.Background()
+	if count, _ := sessionCache.GetAccountConcurrency(bg, 1); count != 0 {
+		t.Errorf("account concurrency = %d, want 0", count)
+	}
+	if count, _ := sessionCache.GetUserConcurrency(bg, 2); count != 0 {
+		t.Errorf("user concurrency = %d, want 0", count)
+	}
+}
@@ -4,26 +4,29 @@
  *   - 会话-账户绑定（实现会话粘性）
  *   - 账户并发计数管理
  *   - 账户不可用标记管理
- *   - 用户并发计数管理
+ *   - 用户并发计数管理（槽位已满时排队等待）
  *   - API Key使用量计数
+ *   - 共享状态后端选择（内存 / Redis）
  * 重要程度：⭐⭐⭐⭐ 重要（会话管理核心）
- * 依赖模块：model
+ * 依赖模块：model, config
  */
 package cache
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
+	"cli-proxy/internal/config"
 	"cli-proxy/internal/model"
 )
 
-// SessionCache 会话缓存服务（使用内存缓存）
+// SessionCache 会话缓存服务（状态存放在 Backend 中，默认内存后端）
 type SessionCache struct {
-	sessionStore       *SessionStore
-	concurrencyManager *ConcurrencyManager
-	unavailableMarker  *UnavailableMarker
+	backend       Backend
+	accountLimits sync.Map // accountID -> int（自定义限制，来自数据库配置，不需要跨实例共享）
+	queues        *slotQueues
 }
 
 var (
@@ -31,18 +34,48 @@ var (
 	sessionCacheOnce    sync.Once
 )
 
+// InitSessionCache 按缓存配置初始化会话缓存后端
+// 需在首次调用 GetSessionCache 之前执行；未调用时使用内存后端
+func InitSessionCache(cfg *config.CacheConfig) error {
+	backend, err := NewBackend(cfg)
+	if err != nil {
+		return err
+	}
+
+	initialized := false
+	sessionCacheOnce.Do(func() {
+		defaultSessionCache = newSessionCache(backend)
+		initialized = true
+	})
+	if !initialized {
+		backend.Close()
+		return fmt.Errorf("session cache already initialized with %s backend", defaultSessionCache.backend.Name())
+	}
+	return nil
+}
+
 // GetSessionCache 获取会话缓存单例
 func GetSessionCache() *SessionCache {
 	sessionCacheOnce.Do(func() {
-		defaultSessionCache = &SessionCache{
-			sessionStore:       GetSessionStore(),
-			concurrencyManager: GetConcurrencyManager(),
-			unavailableMarker:  GetUnavailableMarker(),
-		}
+		defaultSessionCache = newSessionCache(newMemoryBackend())
 	})
 	return defaultSessionCache
 }
 
+func newSessionCache(backend Backend) *SessionCache {
+	return &SessionCache{backend: backend, queues: newSlotQueues()}
+}
+
+// BackendName 当前后端名称
+func (s *SessionCache) BackendName() string {
+	return s.backend.Name()
+}
+
+// Close 关闭后端连接
+func (s *SessionCache) Close() error {
+	return s.backend.Close()
+}
+
 // 导出的默认配置
 var (
 	DefaultTempUnavailableTTL = func() time.Duration { return getUnavailableTTL() }
@@ -65,208 +98,187 @@ type SessionBinding struct {
 	BoundAt      time.Time `json:"bound_at"`
 	LastUsedAt   time.Time `json:"last_used_at"`
 	ExpireAt     time.Time `json:"expire_at"`
-	RemainingTTL int64     `json:"remaining_ttl"` // 剩余秒数
+	RemainingTTL int64     `json:"remaining_ttl"` // 剩余秒数（固定会话为 -1）
+	Pinned       bool      `json:"pinned"`        // 管理员固定：不过期，调度时不会改绑到其他账户
 }
 
 // GetSessionBinding 获取会话绑定
 func (s *SessionCache) GetSessionBinding(ctx context.Context, sessionID string) (*SessionBinding, error) {
-	binding := s.sessionStore.Get(sessionID)
-	if binding == nil {
-		return nil, nil
-	}
-
-	remainingTTL := int64(0)
-	if time.Now().Before(binding.ExpireAt) {
-		remainingTTL = int64(time.Until(binding.ExpireAt).Seconds())
-	}
-
-	return &SessionBinding{
-		SessionID:    binding.SessionID,
-		AccountID:    binding.AccountID,
-		Platform:     binding.Platform,
-		Model:        binding.Model,
-		UserID:       binding.UserID,
-		APIKeyID:     binding.APIKeyID,
-		ClientIP:     binding.ClientIP,
-		UserAgent:    binding.UserAgent,
-		BoundAt:      binding.BoundAt,
-		LastUsedAt:   binding.LastUsedAt,
-		ExpireAt:     binding.ExpireAt,
-		RemainingTTL: remainingTTL,
-	}, nil
+	return s.backend.GetBinding(ctx, sessionID)
 }
 
 // SetSessionBinding 设置会话绑定
 func (s *SessionCache) SetSessionBinding(ctx context.Context, binding *SessionBinding) error {
-	memBinding := &MemorySessionBinding{
-		SessionID:  binding.SessionID,
-		AccountID:  binding.AccountID,
-		Platform:   binding.Platform,
-		Model:      binding.Model,
-		UserID:     binding.UserID,
-		APIKeyID:   binding.APIKeyID,
-		ClientIP:   binding.ClientIP,
-		UserAgent:  binding.UserAgent,
-		BoundAt:    binding.BoundAt,
-		LastUsedAt: binding.LastUsedAt,
-	}
-	s.sessionStore.Set(memBinding)
-	return nil
+	return s.backend.SetBinding(ctx, binding)
 }
 
 // UpdateSessionLastUsed 更新会话最后使用时间
 func (s *SessionCache) UpdateSessionLastUsed(ctx context.Context, sessionID string) error {
-	s.sessionStore.UpdateLastUsed(sessionID)
-	return nil
+	return s.backend.TouchBinding(ctx, sessionID)
 }
 
 // RemoveSessionBinding 移除会话绑定
 func (s *SessionCache) RemoveSessionBinding(ctx context.Context, sessionID string) error {
-	s.sessionStore.Remove(sessionID)
-	return nil
+	return s.backend.RemoveBinding(ctx, sessionID)
 }
 
 // GetAccountSessions 获取账户的所有会话
 func (s *SessionCache) GetAccountSessions(ctx context.Context, accountID uint) ([]string, error) {
-	bindings := s.sessionStore.GetByAccount(accountID)
-	sessionIDs := make([]string, len(bindings))
-	for i, b := range bindings {
-		sessionIDs[i] = b.SessionID
-	}
-	return sessionIDs, nil
+	return s.backend.AccountSessions(ctx, accountID)
 }
 
 // ClearAccountSessions 清除账户的所有会话
 func (s *SessionCache) ClearAccountSessions(ctx context.Context, accountID uint) (int64, error) {
-	count := s.sessionStore.ClearByAccount(accountID)
-	return int64(count), nil
+	return s.backend.ClearAccountSessions(ctx, accountID)
+}
+
+// ClearUserSessions 清除用户的所有会话
+func (s *SessionCache) ClearUserSessions(ctx context.Context, userID uint) (int64, error) {
+	return s.backend.ClearUserSessions(ctx, userID)
 }
 
 // ListAllSessions 列出所有会话绑定
 func (s *SessionCache) ListAllSessions(ctx context.Context, offset, limit int64) ([]SessionBinding, int64, error) {
-	bindings, total := s.sessionStore.ListAll(int(offset), int(limit))
-
-	now := time.Now()
-	result := make([]SessionBinding, len(bindings))
-	for i, b := range bindings {
-		remainingTTL := int64(0)
-		if now.Before(b.ExpireAt) {
-			remainingTTL = int64(b.ExpireAt.Sub(now).Seconds())
-		}
-		result[i] = SessionBinding{
-			SessionID:    b.SessionID,
-			AccountID:    b.AccountID,
-			Platform:     b.Platform,
-			Model:        b.Model,
-			UserID:       b.UserID,
-			APIKeyID:     b.APIKeyID,
-			ClientIP:     b.ClientIP,
-			UserAgent:    b.UserAgent,
-			BoundAt:      b.BoundAt,
-			LastUsedAt:   b.LastUsedAt,
-			ExpireAt:     b.ExpireAt,
-			RemainingTTL: remainingTTL,
-		}
-	}
-	return result, int64(total), nil
+	return s.backend.ListBindings(ctx, offset, limit)
+}
+
+// ClearAllSessions 清除所有会话绑定
+func (s *SessionCache) ClearAllSessions(ctx context.Context) (int64, error) {
+	return s.backend.ClearAllSessions(ctx)
 }
 
 // ==================== 临时不可用标记 ====================
 
 // MarkAccountUnavailable 标记账户临时不可用
 func (s *SessionCache) MarkAccountUnavailable(ctx context.Context, accountID uint, reason string, ttl time.Duration) error {
-	s.unavailableMarker.Mark(accountID, reason, ttl)
-	return nil
+	return s.backend.MarkUnavailable(ctx, accountID, reason, ttl)
 }
 
 // IsAccountUnavailable 检查账户是否临时不可用
 func (s *SessionCache) IsAccountUnavailable(ctx context.Context, accountID uint) (bool, string, error) {
-	unavailable, reason := s.unavailableMarker.IsUnavailable(accountID)
-	return unavailable, reason, nil
+	return s.backend.IsUnavailable(ctx, accountID)
 }
 
 // ClearAccountUnavailable 清除账户不可用标记
 func (s *SessionCache) ClearAccountUnavailable(ctx context.Context, accountID uint) error {
-	s.unavailableMarker.Clear(accountID)
-	return nil
+	return s.backend.ClearUnavailable(ctx, accountID)
 }
 
 // GetAllUnavailableAccounts 获取所有不可用账户
 func (s *SessionCache) GetAllUnavailableAccounts(ctx context.Context) ([]model.UnavailableAccount, error) {
-	all := s.unavailableMarker.ListAll()
-	result := make([]model.UnavailableAccount, 0, len(all))
-	for accountID, info := range all {
-		result = append(result, model.UnavailableAccount{
-			AccountID:    accountID,
-			Reason:       info.Reason,
-			RemainingTTL: info.RemainingTTL,
-		})
-	}
-	return result, nil
+	return s.backend.ListUnavailable(ctx)
+}
+
+// ClearAllUnavailable 清除所有不可用标记
+func (s *SessionCache) ClearAllUnavailable(ctx context.Context) (int64, error) {
+	return s.backend.ClearAllUnavailable(ctx)
 }
 
 // ==================== 并发控制 ====================
 
 // SetAccountConcurrencyLimit 设置账户并发限制
 func (s *SessionCache) SetAccountConcurrencyLimit(accountID uint, limit int) {
-	s.concurrencyManager.SetAccountLimit(accountID, limit)
+	s.accountLimits.Store(accountID, limit)
 }
 
 // GetAccountConcurrencyLimit 获取账户并发限制
 func (s *SessionCache) GetAccountConcurrencyLimit(accountID uint) int {
-	return s.concurrencyManager.GetAccountLimit(accountID)
+	if val, ok := s.accountLimits.Load(accountID); ok {
+		return val.(int)
+	}
+	return getDefaultConcurrencyMax()
 }
 
-// AcquireConcurrency 获取并发槽位（使用默认限制）
-func (s *SessionCache) AcquireConcurrency(ctx context.Context, accountID uint) (bool, int64, error) {
-	acquired, current := s.concurrencyManager.AcquireAccount(ctx, accountID)
-	return acquired, current, nil
+// AcquireConcurrency 获取并发槽位（使用默认限制），成功时返回租约 ID
+// 该账户有请求排队时直接返回未获取，不插队
+func (s *SessionCache) AcquireConcurrency(ctx context.Context, accountID uint, meta LeaseMeta) (string, bool, int64, error) {
+	return s.tryAcquireSlot(ctx, SlotScopeAccount, accountID, s.GetAccountConcurrencyLimit(accountID), meta)
 }
 
-// AcquireConcurrencyWithLimit 获取并发槽位（指定限制）
-func (s *SessionCache) AcquireConcurrencyWithLimit(ctx context.Context, accountID uint, limit int) (bool, int64, error) {
-	acquired, current := s.concurrencyManager.AcquireAccountWithLimit(ctx, accountID, limit)
-	return acquired, current, nil
+// AcquireConcurrencyWithLimit 获取并发槽位（指定限制），成功时返回租约 ID
+// 该账户有请求排队时直接返回未获取，不插队
+func (s *SessionCache) AcquireConcurrencyWithLimit(ctx context.Context, accountID uint, limit int, meta LeaseMeta) (string, bool, int64, error) {
+	return s.tryAcquireSlot(ctx, SlotScopeAccount, accountID, limit, meta)
 }
 
-// ReleaseConcurrency 释放并发槽位
-func (s *SessionCache) ReleaseConcurrency(ctx context.Context, accountID uint) error {
-	s.concurrencyManager.ReleaseAccount(ctx, accountID)
-	return nil
+// AcquireConcurrencyWait 获取并发槽位，已满时排队等待空闲槽位
+// 被拒绝时返回 ErrConcurrencyLimit / ErrQueueFull / ErrQueueTimeout，ctx 取消时返回 ctx.Err()
+func (s *SessionCache) AcquireConcurrencyWait(ctx context.Context, accountID uint, limit int, meta LeaseMeta) (string, int64, error) {
+	return s.acquireSlotWait(ctx, SlotScopeAccount, accountID, limit, meta)
+}
+
+// RenewConcurrency 续约账户并发槽位，租约已失效时返回 false
+func (s *SessionCache) RenewConcurrency(ctx context.Context, accountID uint, leaseID string) (bool, error) {
+	return s.backend.RenewSlot(ctx, SlotScopeAccount, accountID, leaseID)
+}
+
+// ReleaseConcurrency 按租约 ID 释放并发槽位
+// 请求结束时 ctx 往往已取消（客户端断开），释放不应随之失败
+func (s *SessionCache) ReleaseConcurrency(ctx context.Context, accountID uint, leaseID string) error {
+	defer s.queues.notify(queueKey{SlotScopeAccount, accountID})
+	return s.backend.ReleaseSlot(context.WithoutCancel(ctx), SlotScopeAccount, accountID, leaseID)
 }
 
 // GetAccountConcurrency 获取账户当前并发数
 func (s *SessionCache) GetAccountConcurrency(ctx context.Context, accountID uint) (int64, error) {
-	return s.concurrencyManager.GetAccountConcurrency(accountID), nil
+	return s.backend.SlotCount(ctx, SlotScopeAccount, accountID)
 }
 
 // ResetAccountConcurrency 重置账户并发计数
 func (s *SessionCache) ResetAccountConcurrency(ctx context.Context, accountID uint) error {
-	s.concurrencyManager.ResetAccountConcurrency(accountID)
-	return nil
+	defer s.queues.notify(queueKey{SlotScopeAccount, accountID})
+	return s.backend.ResetSlots(ctx, SlotScopeAccount, accountID)
+}
+
+// ListAccountLeases 列出账户的有效并发租约
+func (s *SessionCache) ListAccountLeases(ctx context.Context, accountID uint) ([]LeaseInfo, error) {
+	return s.backend.ListLeases(ctx, SlotScopeAccount, accountID)
 }
 
 // ==================== 用户并发控制 ====================
 
-// AcquireUserConcurrency 获取用户并发槽位
-func (s *SessionCache) AcquireUserConcurrency(ctx context.Context, userID uint, limit int) (bool, int64, error) {
-	acquired, current := s.concurrencyManager.AcquireUser(ctx, userID, limit)
-	return acquired, current, nil
+// AcquireUserConcurrency 获取用户并发槽位，成功时返回租约 ID
+// 该用户有请求排队时直接返回未获取，不插队
+func (s *SessionCache) AcquireUserConcurrency(ctx context.Context, userID uint, limit int, meta LeaseMeta) (string, bool, int64, error) {
+	return s.tryAcquireSlot(ctx, SlotScopeUser, userID, limit, meta)
 }
 
-// ReleaseUserConcurrency 释放用户并发槽位
-func (s *SessionCache) ReleaseUserConcurrency(ctx context.Context, userID uint) error {
-	s.concurrencyManager.ReleaseUser(ctx, userID)
-	return nil
+// AcquireUserConcurrencyWait 获取用户并发槽位，已满时排队等待空闲槽位
+// 被拒绝时返回 ErrConcurrencyLimit / ErrQueueFull / ErrQueueTimeout，ctx 取消时返回 ctx.Err()
+func (s *SessionCache) AcquireUserConcurrencyWait(ctx context.Context, userID uint, limit int, meta LeaseMeta) (string, int64, error) {
+	return s.acquireSlotWait(ctx, SlotScopeUser, userID, limit, meta)
+}
+
+// RenewUserConcurrency 续约用户并发槽位，租约已失效时返回 false
+func (s *SessionCache) RenewUserConcurrency(ctx context.Context, userID uint, leaseID string) (bool, error) {
+	return s.backend.RenewSlot(ctx, SlotScopeUser, userID, leaseID)
+}
+
+// ReleaseUserConcurrency 按租约 ID 释放用户并发槽位
+func (s *SessionCache) ReleaseUserConcurrency(ctx context.Context, userID uint, leaseID string) error {
+	defer s.queues.notify(queueKey{SlotScopeUser, userID})
+	return s.backend.ReleaseSlot(context.WithoutCancel(ctx), SlotScopeUser, userID, leaseID)
 }
 
 // GetUserConcurrency 获取用户当前并发数
 func (s *SessionCache) GetUserConcurrency(ctx context.Context, userID uint) (int64, error) {
-	return s.concurrencyManager.GetUserConcurrency(userID), nil
+	return s.backend.SlotCount(ctx, SlotScopeUser, userID)
 }
 
 // ResetUserConcurrency 重置用户并发计数
 func (s *SessionCache) ResetUserConcurrency(ctx context.Context, userID uint) error {
-	s.concurrencyManager.ResetUserConcurrency(userID)
-	return nil
+	defer s.queues.notify(queueKey{SlotScopeUser, userID})
+	return s.backend.ResetSlots(ctx, SlotScopeUser, userID)
+}
+
+// ListUserLeases 列出用户的有效并发租约
+func (s *SessionCache) ListUserLeases(ctx context.Context, userID uint) ([]LeaseInfo, error) {
+	return s.backend.ListLeases(ctx, SlotScopeUser, userID)
+}
+
+// ==================== 统计 ====================
+
+// Stats 获取后端统计
+func (s *SessionCache) Stats(ctx context.Context) (*BackendStats, error) {
+	return s.backend.Stats(ctx)
 }
@@ -2,7 +2,7 @@
  * 文件作用：应用配置加载，从YAML文件读取系统配置
  * 负责功能：
  *   - 配置文件解析（YAML格式）
- *   - 服务器/数据库/JWT/缓存配置
+ *   - 服务器/数据库/JWT/缓存/指标/追踪/凭证加密配置
  *   - 配置默认值处理
  *   - 全局配置实例管理
  * 重要程度：⭐⭐⭐⭐ 重要（系统配置核心）
@@ -13,16 +13,21 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Server ServerConfig `yaml:"server"`
-	MySQL  MySQLConfig  `yaml:"mysql"`
-	JWT    JWTConfig    `yaml:"jwt"`
-	Log    LogConfig    `yaml:"log"`
-	Cache  CacheConfig  `yaml:"cache"`
+	Server  ServerConfig  `yaml:"server"`
+	MySQL   MySQLConfig   `yaml:"mysql"`
+	JWT     JWTConfig     `yaml:"jwt"`
+	Log     LogConfig     `yaml:"log"`
+	Cache   CacheConfig   `yaml:"cache"`
+	Metrics MetricsConfig `yaml:"metrics"`
+	Tracing TracingConfig `yaml:"tracing"`
+
+	Credentials CredentialsConfig `yaml:"credentials"`
 }
 
 type ServerConfig struct {
@@ -56,13 +61,96 @@ type JWTConfig struct {
 	ExpireHours int    `yaml:"expire_hours"`
 }
 
+// MetricsConfig Prometheus 指标配置
+type MetricsConfig struct {
+	// 抓取令牌，Prometheus 以 Authorization: Bearer <token> 访问 /metrics；为空时关闭 /metrics
+	Token string `yaml:"token"`
+}
+
+// Enabled 是否开启 /metrics
+func (c *MetricsConfig) Enabled() bool {
+	return c.Token != ""
+}
+
+// TracingConfig OpenTelemetry 追踪配置（OTLP/HTTP 导出）
+type TracingConfig struct {
+	Enabled     bool              `yaml:"enabled"`
+	Endpoint    string            `yaml:"endpoint"`     // Collector 地址，如 http://otel-collector:4318
+	Headers     map[string]string `yaml:"headers"`      // 上报附加请求头（如认证）
+	ServiceName string            `yaml:"service_name"` // 服务名，默认 cli-proxy
+	SampleRatio *float64          `yaml:"sample_ratio"` // 新建追踪采样比例（0~1），默认 1；客户端传入 traceparent 时沿用其采样标记
+}
+
+// GetServiceName 获取服务名
+func (c *TracingConfig) GetServiceName() string {
+	if c.ServiceName == "" {
+		return "cli-proxy"
+	}
+	return c.ServiceName
+}
+
+// GetSampleRatio 获取采样比例
+func (c *TracingConfig) GetSampleRatio() float64 {
+	if c.SampleRatio == nil {
+		return 1
+	}
+	return *c.SampleRatio
+}
+
+// CredentialsConfig 上游凭证加密配置（主密钥为 32 字节，base64 或 hex 编码）
+type CredentialsConfig struct {
+	KeyFile          string   `yaml:"key_file"`           // 主密钥文件
+	PreviousKeyFiles []string `yaml:"previous_key_files"` // 轮换前的旧主密钥文件（仅用于解密）
+
+	// 以下仅从环境变量读取，避免密钥写入配置文件
+	Key          string   `yaml:"-"` // CREDENTIAL_KEY
+	PreviousKeys []string `yaml:"-"` // CREDENTIAL_PREVIOUS_KEYS（逗号分隔）
+}
+
 // CacheConfig 缓存配置
 type CacheConfig struct {
-	SessionTTL            int `yaml:"session_ttl"`             // 会话绑定 TTL（分钟），默认 60
-	SessionRenewalTTL     int `yaml:"session_renewal_ttl"`     // 会话续期阈值（分钟），默认 14
-	UnavailableTTL        int `yaml:"unavailable_ttl"`         // 临时不可用 TTL（分钟），默认 5
-	ConcurrencyTTL        int `yaml:"concurrency_ttl"`         // 并发计数 TTL（分钟），默认 5
-	DefaultConcurrencyMax int `yaml:"default_concurrency_max"` // 默认并发上限，默认 5
+	Backend               string      `yaml:"backend"`                 // 共享状态后端：memory（默认，单实例）/ redis（多实例）
+	Redis                 RedisConfig `yaml:"redis"`                   // backend=redis 时的连接配置
+	SessionTTL            int         `yaml:"session_ttl"`             // 会话绑定 TTL（分钟），默认 60
+	SessionRenewalTTL     int         `yaml:"session_renewal_ttl"`     // 会话续期阈值（分钟），默认 14
+	UnavailableTTL        int         `yaml:"unavailable_ttl"`         // 临时不可用 TTL（分钟），默认 5
+	ConcurrencyTTL        int         `yaml:"concurrency_ttl"`         // 并发租约 TTL（分钟），超过该时间未续约的槽位被回收，默认 5
+	DefaultConcurrencyMax int         `yaml:"default_concurrency_max"` // 默认并发上限，默认 5
+	// 内存后端会话绑定快照间隔（秒），默认 60，-1 关闭；快照写入数据库，重启后恢复
+	SessionPersistInterval int `yaml:"session_persist_interval"`
+}
+
+// GetBackend 获取共享状态后端类型
+func (c *CacheConfig) GetBackend() string {
+	if c.Backend == "" {
+		return "memory"
+	}
+	return c.Backend
+}
+
+// RedisConfig Redis 连接配置
+type RedisConfig struct {
+	Addr      string `yaml:"addr"`       // 地址，默认 127.0.0.1:6379
+	Password  string `yaml:"password"`   // 密码
+	DB        int    `yaml:"db"`         // 数据库编号
+	PoolSize  int    `yaml:"pool_size"`  // 连接池大小，0 使用客户端默认值
+	KeyPrefix string `yaml:"key_prefix"` // 键前缀，默认 cli-proxy:
+}
+
+// GetAddr 获取 Redis 地址
+func (c *RedisConfig) GetAddr() string {
+	if c.Addr == "" {
+		return "127.0.0.1:6379"
+	}
+	return c.Addr
+}
+
+// GetKeyPrefix 获取键前缀
+func (c *RedisConfig) GetKeyPrefix() string {
+	if c.KeyPrefix == "" {
+		return "cli-proxy:"
+	}
+	return c.KeyPrefix
 }
 
 // GetSessionTTL 获取会话 TTL（分钟）
@@ -97,6 +185,17 @@ func (c *CacheConfig) GetConcurrencyTTL() int {
 	return c.ConcurrencyTTL
 }
 
+// GetSessionPersistInterval 获取会话绑定快照间隔（秒），返回 0 表示关闭
+func (c *CacheConfig) GetSessionPersistInterval() int {
+	if c.SessionPersistInterval < 0 {
+		return 0
+	}
+	if c.SessionPersistInterval == 0 {
+		return 60
+	}
+	return c.SessionPersistInterval
+}
+
 // GetDefaultConcurrencyMax 获取默认并发上限
 func (c *CacheConfig) GetDefaultConcurrencyMax() int {
 	if c.DefaultConcurrencyMax <= 0 {
@@ -144,4 +243,37 @@ func overrideFromEnv() {
 	if database := os.Getenv("DB_NAME"); database != "" {
 		Cfg.MySQL.Database = database
 	}
+
+	// 指标抓取令牌
+	if token := os.Getenv("METRICS_TOKEN"); token != "" {
+		Cfg.Metrics.Token = token
+	}
+
+	// 追踪上报地址（沿用 OpenTelemetry 标准环境变量）
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		Cfg.Tracing.Endpoint = endpoint
+	}
+
+	// 凭证加密主密钥
+	if key := os.Getenv("CREDENTIAL_KEY"); key != "" {
+		Cfg.Credentials.Key = key
+	}
+	if keyFile := os.Getenv("CREDENTIAL_KEY_FILE"); keyFile != "" {
+		Cfg.Credentials.KeyFile = keyFile
+	}
+	if keys := os.Getenv("CREDENTIAL_PREVIOUS_KEYS"); keys != "" {
+		for _, key := range strings.Split(keys, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				Cfg.Credentials.PreviousKeys = append(Cfg.Credentials.PreviousKeys, key)
+			}
+		}
+	}
+
+	// Redis 配置
+	if addr := os.Getenv("REDIS_ADDR"); addr != "" {
+		Cfg.Cache.Redis.Addr = addr
+	}
+	if password := os.Getenv("REDIS_PASSWORD"); password != "" {
+		Cfg.Cache.Redis.Password = password
+	}
 }
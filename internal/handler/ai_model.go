@@ -125,6 +125,7 @@ func (h *AIModelHandler) Update(c *gin.Context) {
 	existing.IsDefault = updates.IsDefault
 	existing.SortOrder = updates.SortOrder
 	existing.Aliases = updates.Aliases
+	existing.FallbackModels = updates.FallbackModels
 	existing.Capabilities = updates.Capabilities
 
 	if err := h.repo.Update(existing); err != nil {
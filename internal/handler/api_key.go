@@ -14,6 +14,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"cli-proxy/internal/service"
 	"cli-proxy/pkg/logger"
@@ -210,6 +211,7 @@ func (h *APIKeyHandler) Validate(c *gin.Context) {
 		"key_id":            key.ID,
 		"allowed_platforms": key.AllowedPlatforms,
 		"allowed_models":    key.AllowedModels,
+		"blocked_models":    key.BlockedModels,
 		"rate_limit":        key.RateLimit,
 	})
 }
@@ -289,6 +291,81 @@ func (h *APIKeyHandler) AdminToggleStatus(c *gin.Context) {
 	response.Success(c, gin.H{"status": key.Status})
 }
 
+// AdminUpdateAccountGroups 管理员设置 API Key 绑定的账户分组
+func (h *APIKeyHandler) AdminUpdateAccountGroups(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "无效的 API Key ID")
+		return
+	}
+
+	var req struct {
+		AccountGroupIDs string `json:"account_group_ids"` // 逗号分隔，空字符串表示解除绑定
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "无效的请求数据")
+		return
+	}
+
+	key, err := h.service.AdminUpdateAccountGroups(uint(id), req.AccountGroupIDs)
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	response.Success(c, gin.H{"account_group_ids": key.AccountGroupIDs})
+}
+
+// AdminUpdateHedge 管理员设置 API Key 是否启用非流式请求对冲
+func (h *APIKeyHandler) AdminUpdateHedge(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "无效的 API Key ID")
+		return
+	}
+
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "无效的请求数据")
+		return
+	}
+
+	key, err := h.service.AdminUpdateHedge(uint(id), req.Enabled)
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	response.Success(c, gin.H{"hedge_enabled": key.HedgeEnabled})
+}
+
+// AdminUpdateBodyCapture 管理员设置 API Key 调试记录请求体/响应体（有效期内不受记录策略限制）
+func (h *APIKeyHandler) AdminUpdateBodyCapture(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "无效的 API Key ID")
+		return
+	}
+
+	var req struct {
+		Minutes int `json:"minutes"` // 有效期（分钟），0 表示关闭
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "无效的请求数据")
+		return
+	}
+
+	key, err := h.service.AdminUpdateBodyCapture(uint(id), time.Duration(req.Minutes)*time.Minute)
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	response.Success(c, gin.H{"body_capture_until": key.BodyCaptureUntil})
+}
+
 // AdminListAll 管理员获取所有 API Key（带用户信息）
 func (h *APIKeyHandler) AdminListAll(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
@@ -2,10 +2,11 @@
  * 文件作用：缓存管理处理器，提供Redis缓存的管理接口
  * 负责功能：
  *   - 缓存统计信息查询
- *   - 会话缓存管理（列表、删除）
+ *   - 会话缓存管理（列表、删除、批量固定/迁移/过期）
  *   - 账户/用户缓存管理
  *   - 并发计数管理
  *   - 不可用账户标记管理
+ *   - 账户熔断器状态查询/重置
  *   - 缓存配置管理
  * 重要程度：⭐⭐⭐ 一般（管理后台功能）
  * 依赖模块：service, config
@@ -124,6 +125,71 @@ func (h *CacheHandler) RemoveSession(c *gin.Context) {
 	response.Success(c, gin.H{"message": "session removed"})
 }
 
+// PinSessions 批量将会话固定到指定账户
+func (h *CacheHandler) PinSessions(c *gin.Context) {
+	var req struct {
+		SessionIDs []string `json:"session_ids" binding:"required,min=1"`
+		AccountID  uint     `json:"account_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	pinned, skipped, err := h.cacheService.PinSessions(c.Request.Context(), req.SessionIDs, req.AccountID)
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	response.Success(c, gin.H{
+		"pinned_count":  pinned,
+		"skipped_count": skipped,
+	})
+}
+
+// MigrateSessions 批量将匹配的会话迁移到目标账户
+func (h *CacheHandler) MigrateSessions(c *gin.Context) {
+	var req struct {
+		service.SessionFilter
+		ToAccountID uint `json:"to_account_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	migrated, skipped, err := h.cacheService.MigrateSessions(c.Request.Context(), &req.SessionFilter, req.ToAccountID)
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	response.Success(c, gin.H{
+		"migrated_count": migrated,
+		"skipped_count":  skipped,
+	})
+}
+
+// ExpireSessions 批量使匹配的会话立即过期
+func (h *CacheHandler) ExpireSessions(c *gin.Context) {
+	var filter service.SessionFilter
+	if err := c.ShouldBindJSON(&filter); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	count, err := h.cacheService.ExpireSessions(c.Request.Context(), &filter)
+	if err != nil {
+		response.InternalError(c, err.Error())
+		return
+	}
+
+	response.Success(c, gin.H{
+		"expired_count": count,
+	})
+}
+
 // ClearAccountSessions 清除账户的所有会话
 func (h *CacheHandler) ClearAccountSessions(c *gin.Context) {
 	accountIDStr := c.Param("id")
@@ -215,6 +281,25 @@ func (h *CacheHandler) ClearAccountUnavailable(c *gin.Context) {
 	response.Success(c, gin.H{"message": "unavailable mark cleared"})
 }
 
+// ListCircuitBreakers 列出账户熔断器状态及最近的状态变更
+func (h *CacheHandler) ListCircuitBreakers(c *gin.Context) {
+	response.Success(c, h.cacheService.GetCircuitBreakers())
+}
+
+// ResetCircuitBreaker 重置账户熔断器
+func (h *CacheHandler) ResetCircuitBreaker(c *gin.Context) {
+	accountIDStr := c.Param("id")
+	accountID, err := strconv.ParseUint(accountIDStr, 10, 32)
+	if err != nil {
+		response.BadRequest(c, "invalid account_id")
+		return
+	}
+
+	h.cacheService.ResetCircuitBreaker(uint(accountID))
+
+	response.Success(c, gin.H{"message": "circuit breaker reset"})
+}
+
 // GetAccountConcurrency 获取账户并发信息
 func (h *CacheHandler) GetAccountConcurrency(c *gin.Context) {
 	accountIDStr := c.Param("id")
@@ -234,10 +319,17 @@ func (h *CacheHandler) GetAccountConcurrency(c *gin.Context) {
 
 	limit := h.cacheService.GetAccountConcurrencyLimit(uint(accountID))
 
+	leases, err := h.cacheService.ListAccountLeases(ctx, uint(accountID))
+	if err != nil {
+		response.InternalError(c, err.Error())
+		return
+	}
+
 	response.Success(c, gin.H{
 		"account_id": accountID,
 		"current":    current,
 		"limit":      limit,
+		"leases":     leases,
 	})
 }
 
@@ -344,9 +436,16 @@ func (h *CacheHandler) GetUserConcurrency(c *gin.Context) {
 		return
 	}
 
+	leases, err := h.cacheService.ListUserLeases(ctx, uint(userID))
+	if err != nil {
+		response.InternalError(c, err.Error())
+		return
+	}
+
 	response.Success(c, gin.H{
 		"user_id": userID,
 		"current": current,
+		"leases":  leases,
 	})
 }
 
@@ -106,6 +106,36 @@ func (h *ConfigHandler) notifyConfigChange(configs map[string]string) {
 			configChangeCallback(model.ConfigAccountHealthCheckInterval, configs[model.ConfigAccountHealthCheckInterval])
 		}
 	}
+
+	// 检查是否更新了并发排队配置
+	for _, key := range []string{model.ConfigConcurrencyQueueEnabled, model.ConfigConcurrencyQueueMaxWait, model.ConfigConcurrencyQueueMaxDepth} {
+		if _, ok := configs[key]; ok {
+			if configChangeCallback != nil {
+				configChangeCallback(key, configs[key])
+			}
+		}
+	}
+
+	// 检查是否更新了账户选择策略配置
+	for _, key := range []string{model.ConfigSchedulerStrategy, model.ConfigSchedulerStrategyPlatforms, model.ConfigSchedulerStrategyGroups} {
+		if _, ok := configs[key]; ok {
+			if configChangeCallback != nil {
+				configChangeCallback(key, configs[key])
+			}
+		}
+	}
+
+	// 检查是否更新了账户熔断配置
+	for _, key := range []string{
+		model.ConfigCircuitBreakerEnabled, model.ConfigCircuitBreakerWindow, model.ConfigCircuitBreakerMinRequests,
+		model.ConfigCircuitBreakerErrorRate, model.ConfigCircuitBreakerOpenDuration, model.ConfigCircuitBreakerHalfOpenProbes,
+	} {
+		if _, ok := configs[key]; ok {
+			if configChangeCallback != nil {
+				configChangeCallback(key, configs[key])
+			}
+		}
+	}
 }
 
 // ConfigChangeCallback 配置变更回调函数类型
@@ -0,0 +1,255 @@
+/*
+ * 文件作用：Gemini 原生 API 处理器，兼容 Gemini CLI / Google SDK 的 v1beta 接口
+ * 负责功能：
+ *   - models/{model}:generateContent 非流式透传
+ *   - models/{model}:streamGenerateContent 流式透传（支持 alt=sse，中途失败时追加错误并保持 JSON 数组完整）
+ *   - models/{model}:countTokens 透传（不计费）
+ *   - GET models 模型列表
+ * 重要程度：⭐⭐⭐⭐ 重要（Gemini 原生客户端接入）
+ * 依赖模块：adapter, scheduler, repository, middleware
+ */
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"cli-proxy/internal/middleware"
+	"cli-proxy/internal/model"
+	"cli-proxy/internal/proxy/adapter"
+	"cli-proxy/internal/repository"
+	"cli-proxy/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GeminiNative 处理 POST /gemini/v1beta/models/{model}:{action}
+func (h *ProxyHandler) GeminiNative(c *gin.Context) {
+	modelName, action, ok := strings.Cut(c.Param("modelAction"), ":")
+	if !ok || modelName == "" {
+		writePlatformError(c, "gemini", http.StatusNotFound, model.ErrorTypeInvalidRequest,
+			"invalid path, expected models/{model}:{action}")
+		return
+	}
+	switch action {
+	case adapter.GeminiActionGenerateContent, adapter.GeminiActionStreamGenerateContent, adapter.GeminiActionCountTokens:
+	default:
+		writePlatformError(c, "gemini", http.StatusNotFound, model.ErrorTypeInvalidRequest,
+			"unsupported action: "+action)
+		return
+	}
+
+	rawBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		writePlatformError(c, "gemini", http.StatusBadRequest, model.ErrorTypeInvalidRequest,
+			"failed to read request body")
+		return
+	}
+	c.Set("request_body", rawBody)
+
+	if !checkAPIKeyAccess(c, "gemini", "gemini", modelName) {
+		return
+	}
+	if !h.checkModelEnabled(c, modelName) {
+		return
+	}
+
+	req := &adapter.Request{
+		Model:   modelName,
+		RawBody: rawBody,
+		Stream:  action == adapter.GeminiActionStreamGenerateContent,
+	}
+
+	// countTokens 不产生用量，不做套餐准入和用量记录
+	if action == adapter.GeminiActionCountTokens {
+		h.handleGeminiNativeCountTokens(c, req)
+		return
+	}
+
+	if !admitPackageQuota(c, "gemini", modelName, rawBody) {
+		return
+	}
+	defer releasePackageReservation(c)
+
+	if req.Stream {
+		h.handleGeminiNativeStream(c, req, c.Query("alt") == "sse")
+	} else {
+		h.handleGeminiNativeNonStream(c, req)
+	}
+}
+
+// sendGeminiNative 选择 Gemini 账户并转发原生非流式请求
+func (h *ProxyHandler) sendGeminiNative(c *gin.Context, req *adapter.Request, action string) (*adapter.Response, uint, error) {
+	retryReq := h.createRetryRequest(c, model.PlatformGemini)
+
+	result, err := retryReq.ExecuteWithRetry(
+		c.Request.Context(),
+		req.Model,
+		func(ctx context.Context, account *model.Account) (*adapter.Response, error) {
+			adp, ok := adapter.Get(account.Type).(*adapter.GeminiAdapter)
+			if !ok {
+				return nil, adapter.ErrNoAdapter
+			}
+			return adp.SendNative(ctx, account, req, action)
+		},
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	return result.Response, result.AccountID, nil
+}
+
+func (h *ProxyHandler) handleGeminiNativeCountTokens(c *gin.Context, req *adapter.Request) {
+	resp, _, err := h.sendGeminiNative(c, req, adapter.GeminiActionCountTokens)
+	if err != nil {
+		errorType, statusCode := getProxyErrorTypeAndCode(err)
+		recordFailure(c, req.Model, statusCode, err)
+		writePlatformError(c, "gemini", statusCode, errorType, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, "application/json", resp.Body)
+}
+
+func (h *ProxyHandler) handleGeminiNativeNonStream(c *gin.Context, req *adapter.Request) {
+	resp, accountID, err := h.sendGeminiNative(c, req, adapter.GeminiActionGenerateContent)
+	if err != nil {
+		errorType, statusCode := getProxyErrorTypeAndCode(err)
+		recordFailure(c, req.Model, statusCode, err)
+		writePlatformError(c, "gemini", statusCode, errorType, err.Error())
+		return
+	}
+
+	// 应用倍率到返回给用户的 usageMetadata
+	responseBody := resp.Body
+	if rate := c.GetFloat64("api_key_price_rate"); rate > 0 && rate != 1.0 {
+		responseBody = rewriteUsage(responseBody, rate)
+	}
+
+	h.recordNonStreamUsage(c, req.Model, resp, req.RawBody, responseBody, 200, accountID)
+
+	c.Data(http.StatusOK, "application/json", responseBody)
+}
+
+func (h *ProxyHandler) handleGeminiNativeStream(c *gin.Context, req *adapter.Request, sse bool) {
+	if sse {
+		c.Header("Content-Type", "text/event-stream")
+	} else {
+		c.Header("Content-Type", "application/json")
+	}
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // 禁用 Nginx 缓冲
+
+	writer := c.Writer
+
+	// 获取倍率（由中间件设置）
+	priceRate := 1.0
+	if rate, ok := c.Get("api_key_price_rate"); ok {
+		if r, ok := rate.(float64); ok {
+			priceRate = r
+		}
+	}
+
+	rateWriter := NewUsageRewriter(writer, priceRate, sse)
+	tailWriter := adapter.NewTailWriter(rateWriter, 2048)
+
+	retryReq := h.createRetryRequest(c, model.PlatformGemini)
+
+	result, err := retryReq.ExecuteStreamWithRetry(
+		c.Request.Context(),
+		req.Model,
+		func(ctx context.Context, account *model.Account, w io.Writer) (*adapter.StreamResult, error) {
+			adp, ok := adapter.Get(account.Type).(*adapter.GeminiAdapter)
+			if !ok {
+				return nil, adapter.ErrNoAdapter
+			}
+			return adp.SendNativeStream(ctx, account, req, w, sse)
+		},
+		tailWriter,
+	)
+	rateWriter.Close()
+
+	if err != nil {
+		errorType, statusCode := getProxyErrorTypeAndCode(err)
+		recordFailure(c, req.Model, statusCode, err)
+		if !writer.Written() {
+			writePlatformError(c, "gemini", statusCode, errorType, err.Error())
+			return
+		}
+		// 已开始输出，只能在流末尾追加错误
+		logger.GetLogger("proxy").Warn("Gemini Native Stream 中途失败: %v", err)
+		message, _ := getCustomErrorMessage(errorType, err.Error())
+		writeGeminiStreamError(writer, sse, statusCode, message)
+		writer.Flush()
+		return
+	}
+
+	if result != nil && result.Result != nil {
+		h.recordUsage(c, req.Model, result.Result, true, req.RawBody, tailWriter.Tail(), 200, result.AccountID)
+	}
+}
+
+// writeGeminiStreamError 在已开始输出的流末尾追加错误
+// SSE 模式追加一个错误事件；JSON 数组模式追加错误元素并补上数组结尾的 ]，保证响应仍是合法 JSON
+func writeGeminiStreamError(w io.Writer, sse bool, statusCode int, message string) {
+	errData, _ := json.Marshal(gin.H{
+		"error": gin.H{
+			"code":    statusCode,
+			"message": message,
+			"status":  geminiErrorStatus(statusCode),
+		},
+	})
+	if sse {
+		w.Write([]byte("data: " + string(errData) + "\r\n\r\n"))
+		return
+	}
+	w.Write([]byte(",\r\n" + string(errData) + "]"))
+}
+
+// GeminiListModels 处理 GET /gemini/v1beta/models，返回 API Key 可访问的已启用 Gemini 模型
+func (h *ProxyHandler) GeminiListModels(c *gin.Context) {
+	if !middleware.CheckPlatformAccess(c, model.PlatformGemini) {
+		c.JSON(http.StatusOK, gin.H{"models": []gin.H{}})
+		return
+	}
+
+	enabled := true
+	models, err := repository.NewAIModelRepository(repository.GetDB()).List(model.PlatformGemini, &enabled)
+	if err != nil {
+		writePlatformError(c, "gemini", http.StatusInternalServerError, model.ErrorTypeInternalError, err.Error())
+		return
+	}
+
+	list := make([]gin.H, 0, len(models))
+	for _, m := range models {
+		if !middleware.CheckModelAccess(c, m.Name) {
+			continue
+		}
+		displayName := m.DisplayName
+		if displayName == "" {
+			displayName = m.Name
+		}
+		item := gin.H{
+			"name":        "models/" + m.Name,
+			"displayName": displayName,
+			"description": m.Description,
+			"supportedGenerationMethods": []string{
+				adapter.GeminiActionGenerateContent,
+				adapter.GeminiActionStreamGenerateContent,
+				adapter.GeminiActionCountTokens,
+			},
+		}
+		if m.ContextSize > 0 {
+			item["inputTokenLimit"] = m.ContextSize
+		}
+		if m.MaxOutput > 0 {
+			item["outputTokenLimit"] = m.MaxOutput
+		}
+		list = append(list, item)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"models": list})
+}
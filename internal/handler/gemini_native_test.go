@@ -0,0 +1,73 @@
+/*
+ * 文件作用：Gemini 原生流式接口测试
+ * 负责功能：
+ *   - 流中途失败时追加的错误：JSON 数组模式仍是合法 JSON 数组，SSE 模式追加错误事件
+ * 重要程度：⭐⭐ 辅助（测试）
+ * 依赖模块：无
+ */
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWriteGeminiStreamError(t *testing.T) {
+	chunk := `{"candidates":[{"content":{"parts":[{"text":"hi"}]}}],"usageMetadata":{"promptTokenCount":10}}`
+
+	tests := []struct {
+		name     string
+		sse      bool
+		upstream []string // 失败前已写出的内容（与 SendNativeStream 的输出格式一致）
+		wantLen  int      // 解析出的元素/事件数（含错误）
+	}{
+		{name: "json array after first chunk", upstream: []string{"[" + chunk}, wantLen: 2},
+		{name: "json array after several chunks", upstream: []string{"[" + chunk, ",\r\n" + chunk, ",\r\n" + chunk}, wantLen: 4},
+		{name: "sse", sse: true, upstream: []string{"data: " + chunk + "\r\n\r\n"}, wantLen: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			rw := NewUsageRewriter(&buf, 1.5, tt.sse)
+			for _, part := range tt.upstream {
+				if _, err := rw.Write([]byte(part)); err != nil {
+					t.Fatalf("Write: %v", err)
+				}
+			}
+			rw.Close()
+			writeGeminiStreamError(&buf, tt.sse, http.StatusServiceUnavailable, "upstream unavailable")
+
+			var elements []map[string]json.RawMessage
+			if tt.sse {
+				for _, event := range strings.Split(strings.TrimSpace(buf.String()), "\r\n\r\n") {
+					var element map[string]json.RawMessage
+					if err := json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &element); err != nil {
+						t.Fatalf("invalid SSE event %q: %v", event, err)
+					}
+					elements = append(elements, element)
+				}
+			} else if err := json.Unmarshal(buf.Bytes(), &elements); err != nil {
+				t.Fatalf("response is not a valid JSON array: %v\n%s", err, buf.String())
+			}
+
+			if len(elements) != tt.wantLen {
+				t.Fatalf("got %d elements, want %d", len(elements), tt.wantLen)
+			}
+			var apiErr struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+				Status  string `json:"status"`
+			}
+			if err := json.Unmarshal(elements[len(elements)-1]["error"], &apiErr); err != nil {
+				t.Fatalf("last element has no error: %v", err)
+			}
+			if apiErr.Code != http.StatusServiceUnavailable || apiErr.Status != "UNAVAILABLE" || apiErr.Message != "upstream unavailable" {
+				t.Errorf("error element = %+v", apiErr)
+			}
+		})
+	}
+}
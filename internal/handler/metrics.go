@@ -0,0 +1,26 @@
+/*
+ * 文件作用：Prometheus 指标采集接口
+ * 负责功能：
+ *   - 以 Prometheus 文本格式输出代理、调度器、缓存和健康检测指标
+ * 重要程度：⭐⭐⭐ 一般（可观测性）
+ * 依赖模块：metrics
+ */
+package handler
+
+import (
+	"net/http"
+
+	"cli-proxy/pkg/logger"
+	"cli-proxy/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Metrics 输出全部已注册指标（认证由 MetricsAuth 中间件完成）
+func Metrics(c *gin.Context) {
+	c.Header("Content-Type", metrics.ContentType)
+	c.Status(http.StatusOK)
+	if err := metrics.Write(c.Writer); err != nil {
+		logger.GetLogger("metrics").Warn("输出指标失败: %v", err)
+	}
+}
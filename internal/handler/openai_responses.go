@@ -4,6 +4,7 @@
  *   - OpenAI Responses API 转发
  *   - Codex CLI 专用接口处理
  *   - 流式/非流式响应转换
+ *   - 账户重试切换（429/5xx/连接错误，首字节前）
  *   - 模型映射和费用统计
  * 重要程度：⭐⭐⭐⭐ 重要（Codex CLI专用接口）
  * 依赖模块：scheduler, adapter, service, repository
@@ -16,9 +17,9 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -30,6 +31,7 @@ import (
 	"cli-proxy/internal/service"
 	"cli-proxy/pkg/logger"
 	"cli-proxy/pkg/response"
+	"cli-proxy/pkg/tracing"
 
 	"github.com/gin-gonic/gin"
 )
@@ -122,6 +124,11 @@ func (h *OpenAIResponsesHandler) HandleResponses(c *gin.Context) {
 		modelName = m
 	}
 
+	// 检查 API Key 平台/模型权限（使用映射前的原始模型名）
+	if !checkAPIKeyAccess(c, "openai", "openai", modelName) {
+		return
+	}
+
 	// 应用模型映射
 	originalModel := modelName
 	modelName = h.modelMappingService.MapModel(modelName)
@@ -138,6 +145,12 @@ func (h *OpenAIResponsesHandler) HandleResponses(c *gin.Context) {
 		return
 	}
 
+	// 套餐额度准入（账户选择之前）
+	if !admitPackageQuota(c, "openai", modelName, rawBody) {
+		return
+	}
+	defer releasePackageReservation(c)
+
 	isStream := true // 默认流式
 	if s, ok := reqBody["stream"].(bool); ok {
 		isStream = s
@@ -172,6 +185,9 @@ func (h *OpenAIResponsesHandler) HandleResponses(c *gin.Context) {
 		}
 	}
 
+	// 保存实际转发的请求体，供失败日志按记录策略记录
+	c.Set("request_body", rawBody)
+
 	// 获取请求路径
 	requestPath := c.Request.URL.Path
 	log.Info("OpenAI Responses 请求 - Model: %s, Stream: %v, Path: %s, IsCodexCLI: %v", modelName, isStream, requestPath, isCodexCLI)
@@ -181,44 +197,51 @@ func (h *OpenAIResponsesHandler) HandleResponses(c *gin.Context) {
 
 	// 构建 sessionID 用于会话粘性
 	// 参考 claude-relay 的 sessionHelper.js 实现，基于请求内容生成会话哈希
-	sessionID := h.generateSessionHash(c, reqBody)
+	sessionID := h.generateSessionHash(c, rawBody, reqBody)
 	log.Info("会话哈希 - SessionID: %s", sessionID)
 
-	// 选择账户（支持 openai-responses 和 openai 两种类型，支持会话粘性）
-	ctx := context.Background()
-	accountTypes := []string{model.AccountTypeOpenAIResponses, model.AccountTypeOpenAI}
-	account, err := h.scheduler.SelectAccountByTypesWithSession(ctx, accountTypes, modelName, sessionID, userID, apiKeyID)
-	if err != nil {
-		log.Error("选择账户失败: %v", err)
-		response.CustomError(c, http.StatusServiceUnavailable, "no_available_account", err.Error())
-		return
+	// 处理平台前缀路由：去掉 /openai 前缀
+	forwardPath := requestPath
+	if strings.HasPrefix(requestPath, "/openai/") {
+		forwardPath = strings.TrimPrefix(requestPath, "/openai")
 	}
 
-	log.Info("选中账户 - ID: %d, Name: %s, BaseURL: %s", account.ID, account.Name, account.BaseURL)
+	// 通过重试机制选择账户（openai 前缀匹配 openai-responses 和 openai 两种类型）
+	// 支持会话粘性、账户并发槽位、429/5xx/连接错误时切换账户
+	retryReq := withPromptAffinity(scheduler.NewRetryableRequest(h.scheduler, currentRetryConfig()).
+		WithSessionID(sessionID).
+		WithUserInfo(userID, apiKeyID, c.ClientIP(), userAgent), rawBody)
+	scheduleModel := model.PlatformOpenAI + "," + modelName
+
+	// 记录开始时间
+	startTime := time.Now()
+
+	// 处理响应
+	if isStream {
+		h.handleStreamResponse(c, retryReq, scheduleModel, forwardPath, rawBody, userID, apiKeyID, modelName, log)
+	} else {
+		h.handleNormalResponse(c, retryReq, scheduleModel, forwardPath, rawBody, userID, apiKeyID, modelName, log)
+	}
 
+	log.Info("请求完成 - 耗时: %v", time.Since(startTime))
+}
+
+// sendUpstream 向选中账户转发请求
+// 非 200 响应转换为 UpstreamError，由重试机制决定是否切换账户并交给错误规则匹配器
+func (h *OpenAIResponsesHandler) sendUpstream(ctx context.Context, c *gin.Context, account *model.Account, forwardPath string, rawBody []byte, isStream bool, log *logger.Logger) (*http.Response, error) {
 	// 构建目标 URL: baseURL + path
 	// 参考 claude-relay: const targetUrl = `${fullAccount.baseApi}${req.path}`
 	baseURL := account.BaseURL
 	if baseURL == "" {
-		baseURL = "https://chatgpt.com/backend-api/codex"
-	}
-	baseURL = strings.TrimSuffix(baseURL, "/")
-
-	// 处理平台前缀路由：去掉 /openai 前缀
-	forwardPath := requestPath
-	if strings.HasPrefix(requestPath, "/openai/") {
-		forwardPath = strings.TrimPrefix(requestPath, "/openai")
+		baseURL = adapter.DefaultOpenAIResponsesBaseURL
 	}
-	targetURL := baseURL + forwardPath
+	targetURL := strings.TrimSuffix(baseURL, "/") + forwardPath
 
-	log.Info("转发目标 - TargetURL: %s", targetURL)
+	log.Info("转发目标 - AccountID: %d, Name: %s, TargetURL: %s", account.ID, account.Name, targetURL)
 
-	// 创建 HTTP 请求
 	httpReq, err := http.NewRequestWithContext(ctx, "POST", targetURL, bytes.NewReader(rawBody))
 	if err != nil {
-		log.Error("创建请求失败: %v", err)
-		response.CustomError(c, http.StatusInternalServerError, "internal_error", err.Error())
-		return
+		return nil, err
 	}
 
 	// 设置请求头
@@ -234,28 +257,85 @@ func (h *OpenAIResponsesHandler) HandleResponses(c *gin.Context) {
 	resp, err := client.Do(httpReq)
 	if err != nil {
 		log.Error("请求失败 - 网络错误: %v", err)
-		response.CustomError(c, http.StatusBadGateway, "upstream_error", err.Error())
-		return
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	// 处理错误响应
 	if resp.StatusCode != http.StatusOK {
-		h.handleErrorResponse(c, resp, account, log)
-		return
+		defer resp.Body.Close()
+		return nil, h.upstreamError(resp, log)
 	}
+	return resp, nil
+}
 
-	// 记录开始时间
-	startTime := time.Now()
+// upstreamError 读取错误响应并构建上游错误（429 时解析限流恢复时间）
+func (h *OpenAIResponsesHandler) upstreamError(resp *http.Response, log *logger.Logger) error {
+	respBody, _ := io.ReadAll(resp.Body)
+	log.Error("API 错误 - StatusCode: %d, Body: %s", resp.StatusCode, string(respBody))
 
-	// 处理响应
-	if isStream {
-		h.handleStreamResponse(c, resp, account, userID, apiKeyID, modelName, log)
-	} else {
-		h.handleNormalResponse(c, resp, account, userID, apiKeyID, modelName, log)
+	upstreamErr := adapter.NewUpstreamErrorFromResponse(resp, string(respBody))
+	if resp.StatusCode == http.StatusTooManyRequests {
+		upstreamErr.ResetAt = codexResetAt(resp.Header, respBody)
 	}
+	return upstreamErr
+}
 
-	log.Info("请求完成 - 耗时: %v", time.Since(startTime))
+// codexResetAt 解析 Codex 限流恢复时间
+// 优先使用响应体中的 resets_at / resets_in_seconds，其次使用 x-codex-*-reset-after-seconds 响应头
+func codexResetAt(header http.Header, body []byte) *time.Time {
+	var payload struct {
+		Error struct {
+			ResetsAt        int64 `json:"resets_at"`
+			ResetsInSeconds int64 `json:"resets_in_seconds"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &payload); err == nil {
+		if payload.Error.ResetsAt > 0 {
+			resetAt := time.Unix(payload.Error.ResetsAt, 0)
+			return &resetAt
+		}
+		if payload.Error.ResetsInSeconds > 0 {
+			resetAt := time.Now().Add(time.Duration(payload.Error.ResetsInSeconds) * time.Second)
+			return &resetAt
+		}
+	}
+
+	// 次级窗口（周限额）已用尽时使用次级窗口的恢复时间，否则使用主窗口
+	window := "primary"
+	if used, err := strconv.ParseFloat(header.Get("x-codex-secondary-used-percent"), 64); err == nil && used >= 100 {
+		window = "secondary"
+	}
+	if seconds, err := strconv.Atoi(header.Get("x-codex-" + window + "-reset-after-seconds")); err == nil && seconds > 0 {
+		resetAt := time.Now().Add(time.Duration(seconds) * time.Second)
+		return &resetAt
+	}
+	return nil
+}
+
+// writeRetryError 重试失败后返回错误（上游错误原样返回上游状态码和响应体）
+func (h *OpenAIResponsesHandler) writeRetryError(c *gin.Context, err error) {
+	var upstreamErr *adapter.UpstreamError
+	if errors.As(err, &upstreamErr) {
+		var errorResp map[string]interface{}
+		if json.Unmarshal([]byte(upstreamErr.Message), &errorResp) == nil {
+			c.JSON(upstreamErr.StatusCode, errorResp)
+			return
+		}
+		c.Data(upstreamErr.StatusCode, "application/json", []byte(upstreamErr.Message))
+		return
+	}
+
+	errorType, statusCode := getProxyErrorTypeAndCode(err)
+	response.CustomError(c, statusCode, errorType, err.Error())
+}
+
+// retryErrorStatus 重试失败时返回给客户端的状态码（与 writeRetryError 一致）
+func retryErrorStatus(err error) int {
+	var upstreamErr *adapter.UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.StatusCode
+	}
+	_, statusCode := getProxyErrorTypeAndCode(err)
+	return statusCode
 }
 
 // setRequestHeaders 设置请求头
@@ -301,50 +381,86 @@ func (h *OpenAIResponsesHandler) setRequestHeaders(httpReq *http.Request, c *gin
 	}
 }
 
-// handleErrorResponse 处理错误响应
-func (h *OpenAIResponsesHandler) handleErrorResponse(c *gin.Context, resp *http.Response, account *model.Account, log *logger.Logger) {
-	respBody, _ := io.ReadAll(resp.Body)
-	log.Error("API 错误 - StatusCode: %d, Body: %s", resp.StatusCode, string(respBody))
+// handleStreamResponse 处理流式响应
+// 参考 claude-relay: openaiResponsesRelayService._handleStreamResponse
+// 直接转发原始字节流，同时解析 usage 数据
+func (h *OpenAIResponsesHandler) handleStreamResponse(c *gin.Context, retryReq *scheduler.RetryableRequest, scheduleModel, forwardPath string, rawBody []byte, userID, apiKeyID uint, modelName string, log *logger.Logger) {
+	// 获取倍率
+	priceRate := 1.0
+	if rate, ok := c.Get("api_key_price_rate"); ok {
+		if r, ok := rate.(float64); ok {
+			priceRate = r
+		}
+	}
 
-	// 尝试解析错误响应
-	var errorResp map[string]interface{}
-	if err := json.Unmarshal(respBody, &errorResp); err == nil {
-		c.JSON(resp.StatusCode, errorResp)
+	var actualModel string
+
+	result, err := retryReq.ExecuteStreamWithRetry(
+		c.Request.Context(),
+		scheduleModel,
+		func(ctx context.Context, account *model.Account, w io.Writer) (*adapter.StreamResult, error) {
+			resp, err := h.sendUpstream(ctx, c, account, forwardPath, rawBody, true, log)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+
+			actualModel = ""
+			return h.relayStream(ctx, c, resp, w, priceRate, &actualModel, log)
+		},
+		c.Writer,
+	)
+
+	if err != nil {
+		recordFailure(c, modelName, retryErrorStatus(err), err)
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Type")
+			h.writeRetryError(c, err)
+			return
+		}
+		log.Error("OpenAI Responses Stream 失败: %v", err)
 		return
 	}
 
-	// 返回原始错误
-	c.Data(resp.StatusCode, "application/json", respBody)
+	// 记录使用量
+	if actualModel == "" {
+		actualModel = modelName
+	}
+	usage := result.Result
+
+	// 应用倍率到 token（用于日志记录和费用计算）
+	ratedInputTokens := int(float64(usage.InputTokens) * priceRate)
+	ratedOutputTokens := int(float64(usage.OutputTokens) * priceRate)
+	ratedCacheReadTokens := int(float64(usage.CacheReadInputTokens) * priceRate)
+	ratedCacheCreationTokens := int(float64(usage.CacheCreationInputTokens) * priceRate)
+
+	log.Info("Stream 完成 - Model: %s, 原始Token(in:%d/out:%d), 倍率:%.2f, 计费Token(in:%d/out:%d)",
+		actualModel, usage.InputTokens, usage.OutputTokens, priceRate, ratedInputTokens, ratedOutputTokens)
+
+	// 记录使用统计（使用倍率后的 token）
+	if ratedInputTokens > 0 || ratedOutputTokens > 0 {
+		h.recordUsage(c, userID, apiKeyID, result.AccountID, actualModel, ratedInputTokens, ratedOutputTokens, ratedCacheReadTokens, ratedCacheCreationTokens)
+	}
 }
 
-// handleStreamResponse 处理流式响应
-// 参考 claude-relay: openaiResponsesRelayService._handleStreamResponse
-// 直接转发原始字节流，同时解析 usage 数据
-func (h *OpenAIResponsesHandler) handleStreamResponse(c *gin.Context, resp *http.Response, account *model.Account, userID, apiKeyID uint, modelName string, log *logger.Logger) {
-	// 设置 SSE 响应头
+// relayStream 转发上游 SSE 字节流并解析 usage
+func (h *OpenAIResponsesHandler) relayStream(ctx context.Context, c *gin.Context, resp *http.Response, writer io.Writer, priceRate float64, actualModel *string, log *logger.Logger) (*adapter.StreamResult, error) {
+	// 设置 SSE 响应头（上游已返回 200，首次写入时发送）
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
 	c.Header("Connection", "keep-alive")
 	c.Header("X-Accel-Buffering", "no")
 
-	// 立即刷新头部
-	c.Writer.Flush()
+	flusher, hasFlusher := writer.(http.Flusher)
 
-	// 获取倍率
-	priceRate := 1.0
-	if rate, ok := c.Get("api_key_price_rate"); ok {
-		if r, ok := rate.(float64); ok {
-			priceRate = r
-		}
-	}
+	// 倍率不为1时按事件改写 usage 中的 token 数量后再转发
+	out := NewUsageRewriter(writer, priceRate, true)
+	defer out.Close()
 
 	var inputTokens, outputTokens int
 	var cacheReadTokens, cacheCreationTokens int
-	var actualModel string
 	var buffer strings.Builder
 
-	ctx := c.Request.Context()
-
 	// 监控 context 取消（客户端断开）
 	streamDone := make(chan struct{})
 	defer close(streamDone)
@@ -360,79 +476,51 @@ func (h *OpenAIResponsesHandler) handleStreamResponse(c *gin.Context, resp *http
 		}
 	}()
 
+	result := func() *adapter.StreamResult {
+		// 处理剩余 buffer
+		if buffer.Len() > 0 {
+			h.parseSSEForUsage(&buffer, actualModel, &inputTokens, &outputTokens, &cacheReadTokens, &cacheCreationTokens, log)
+		}
+		return &adapter.StreamResult{
+			InputTokens:              inputTokens,
+			OutputTokens:             outputTokens,
+			CacheReadInputTokens:     cacheReadTokens,
+			CacheCreationInputTokens: cacheCreationTokens,
+		}
+	}
+
 	// 直接转发原始字节，同时解析 usage
 	buf := make([]byte, 32*1024)
 	for {
-		// 检查 context 是否已取消
-		select {
-		case <-ctx.Done():
-			log.Info("OpenAI Responses Stream context 已取消，停止转发")
-			goto done
-		default:
-		}
-
 		n, err := resp.Body.Read(buf)
 		if n > 0 {
-			chunk := buf[:n]
-
-			// 如果倍率不为1，需要修改 token 数量后再转发
-			if priceRate != 1.0 {
-				chunk = h.applyRateToSSEChunk(chunk, priceRate)
-			}
-
 			// 转发给客户端
-			_, writeErr := c.Writer.Write(chunk)
-			if writeErr != nil {
+			if _, writeErr := out.Write(buf[:n]); writeErr != nil {
 				log.Warn("OpenAI Responses Stream 写入客户端失败: %v", writeErr)
-				goto done
+				return result(), writeErr
+			}
+			if hasFlusher {
+				flusher.Flush()
 			}
-			c.Writer.Flush()
 
 			// 同时解析 usage 数据（解析原始数据，不是修改后的）
 			buffer.Write(buf[:n])
-			h.parseSSEForUsage(&buffer, &actualModel, &inputTokens, &outputTokens, &cacheReadTokens, &cacheCreationTokens, log)
+			h.parseSSEForUsage(&buffer, actualModel, &inputTokens, &outputTokens, &cacheReadTokens, &cacheCreationTokens, log)
 		}
 
+		if err == io.EOF {
+			return result(), nil
+		}
 		if err != nil {
-			if err != io.EOF {
-				// 检查是否是因为 context 取消导致的错误
-				if ctx.Err() != nil {
-					log.Info("OpenAI Responses Stream 因 context 取消而结束: %v", ctx.Err())
-				} else {
-					log.Error("Stream 读取错误: %v", err)
-				}
+			// 检查是否是因为 context 取消导致的错误
+			if ctx.Err() != nil {
+				log.Info("OpenAI Responses Stream 因 context 取消而结束: %v", ctx.Err())
+				return result(), ctx.Err()
 			}
-			break
+			log.Error("Stream 读取错误: %v", err)
+			return result(), err
 		}
 	}
-
-done:
-	// 处理剩余 buffer
-	if buffer.Len() > 0 {
-		h.parseSSEForUsage(&buffer, &actualModel, &inputTokens, &outputTokens, &cacheReadTokens, &cacheCreationTokens, log)
-	}
-
-	// 记录使用量
-	if actualModel == "" {
-		actualModel = modelName
-	}
-
-	// 应用倍率到 token（用于日志记录和费用计算）
-	ratedInputTokens := int(float64(inputTokens) * priceRate)
-	ratedOutputTokens := int(float64(outputTokens) * priceRate)
-	ratedCacheReadTokens := int(float64(cacheReadTokens) * priceRate)
-	ratedCacheCreationTokens := int(float64(cacheCreationTokens) * priceRate)
-
-	log.Info("Stream 完成 - Model: %s, 原始Token(in:%d/out:%d), 倍率:%.2f, 计费Token(in:%d/out:%d)",
-		actualModel, inputTokens, outputTokens, priceRate, ratedInputTokens, ratedOutputTokens)
-
-	// 标记账户成功（更新 last_used_at 和 request_count）
-	h.scheduler.MarkAccountSuccess(account.ID)
-
-	// 记录使用统计（使用倍率后的 token）
-	if ratedInputTokens > 0 || ratedOutputTokens > 0 {
-		h.recordUsage(c, userID, apiKeyID, account.ID, actualModel, ratedInputTokens, ratedOutputTokens, ratedCacheReadTokens, ratedCacheCreationTokens)
-	}
 }
 
 // parseSSEForUsage 从 SSE 数据中解析 usage 信息
@@ -516,13 +604,31 @@ func (h *OpenAIResponsesHandler) parseSSEForUsage(buffer *strings.Builder, actua
 }
 
 // handleNormalResponse 处理非流式响应
-func (h *OpenAIResponsesHandler) handleNormalResponse(c *gin.Context, resp *http.Response, account *model.Account, userID, apiKeyID uint, modelName string, log *logger.Logger) {
-	respBody, err := io.ReadAll(resp.Body)
+func (h *OpenAIResponsesHandler) handleNormalResponse(c *gin.Context, retryReq *scheduler.RetryableRequest, scheduleModel, forwardPath string, rawBody []byte, userID, apiKeyID uint, modelName string, log *logger.Logger) {
+	result, err := retryReq.ExecuteWithRetry(
+		c.Request.Context(),
+		scheduleModel,
+		func(ctx context.Context, account *model.Account) (*adapter.Response, error) {
+			resp, err := h.sendUpstream(ctx, c, account, forwardPath, rawBody, false, log)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+
+			respBody, err := io.ReadAll(resp.Body)
+			if err != nil {
+				log.Error("读取响应失败: %v", err)
+				return nil, err
+			}
+			return &adapter.Response{Body: respBody}, nil
+		},
+	)
 	if err != nil {
-		log.Error("读取响应失败: %v", err)
-		response.CustomError(c, http.StatusBadGateway, "upstream_error", err.Error())
+		recordFailure(c, modelName, retryErrorStatus(err), err)
+		h.writeRetryError(c, err)
 		return
 	}
+	respBody := result.Response.Body
 
 	// 获取倍率
 	priceRate := 1.0
@@ -588,16 +694,13 @@ func (h *OpenAIResponsesHandler) handleNormalResponse(c *gin.Context, resp *http
 	log.Info("非流式响应 - Model: %s, 原始Token(in:%d/out:%d), 倍率:%.2f, 计费Token(in:%d/out:%d)",
 		actualModel, inputTokens, outputTokens, priceRate, ratedInputTokens, ratedOutputTokens)
 
-	// 标记账户成功（更新 last_used_at 和 request_count）
-	h.scheduler.MarkAccountSuccess(account.ID)
-
 	// 记录使用统计（使用倍率后的 token）
 	if ratedInputTokens > 0 || ratedOutputTokens > 0 {
-		h.recordUsage(c, userID, apiKeyID, account.ID, actualModel, ratedInputTokens, ratedOutputTokens, ratedCacheReadTokens, ratedCacheCreationTokens)
+		h.recordUsage(c, userID, apiKeyID, result.AccountID, actualModel, ratedInputTokens, ratedOutputTokens, ratedCacheReadTokens, ratedCacheCreationTokens)
 	}
 
 	// 返回响应（已应用倍率）
-	c.Data(resp.StatusCode, "application/json", respBody)
+	c.Data(http.StatusOK, "application/json", respBody)
 }
 
 // applyRateToUsageMap 将倍率应用到 usage map 中的 token 字段
@@ -618,45 +721,18 @@ func (h *OpenAIResponsesHandler) applyRateToUsageMap(usage map[string]interface{
 	}
 }
 
-// applyRateToSSEChunk 将倍率应用到 SSE 数据块中的 token 字段
-func (h *OpenAIResponsesHandler) applyRateToSSEChunk(chunk []byte, rate float64) []byte {
-	content := string(chunk)
-
-	// 匹配所有 token 相关的字段并乘以倍率
-	tokenFields := []string{
-		"input_tokens",
-		"output_tokens",
-		"cached_tokens",
-		"cache_creation_input_tokens",
-		"cache_read_input_tokens",
-		"total_tokens",
-	}
-
-	for _, field := range tokenFields {
-		// 匹配 "field": 数字 或 "field":数字
-		pattern := regexp.MustCompile(`"` + field + `"\s*:\s*(\d+)`)
-		content = pattern.ReplaceAllStringFunc(content, func(match string) string {
-			// 提取数字
-			numPattern := regexp.MustCompile(`(\d+)`)
-			numStr := numPattern.FindString(match)
-			if num, err := strconv.Atoi(numStr); err == nil {
-				newNum := int(float64(num) * rate)
-				return strings.Replace(match, numStr, strconv.Itoa(newNum), 1)
-			}
-			return match
-		})
-	}
-
-	return []byte(content)
-}
-
 // recordUsage 记录使用量到 Redis 和 MySQL
 func (h *OpenAIResponsesHandler) recordUsage(c *gin.Context, userID, apiKeyID, accountID uint, modelName string, inputTokens, outputTokens, cacheReadTokens, cacheCreationTokens int) {
 	log := logger.GetLogger("openai-responses")
 	log.Info("Usage - User: %d, APIKey: %d, Account: %d, Model: %s, Input: %d, Output: %d, CacheRead: %d, CacheCreation: %d",
 		userID, apiKeyID, accountID, modelName, inputTokens, outputTokens, cacheReadTokens, cacheCreationTokens)
 
-	ctx := context.Background()
+	ctx, span := tracing.Start(context.WithoutCancel(c.Request.Context()), "usage.record", tracing.WithAttributes(
+		tracing.String("model", modelName),
+		tracing.Uint("account.id", accountID),
+		tracing.Uint("user.id", userID),
+	))
+	defer span.End()
 
 	// 获取价格倍率
 	priceRate := 1.0
@@ -712,6 +788,7 @@ func (h *OpenAIResponsesHandler) recordUsage(c *gin.Context, userID, apiKeyID, a
 
 	// 记录到 Redis（倍率已应用，这里用 1.0）
 	if err := h.usageService.RecordRequest(ctx, userID, apiKeyID, requestLog, 1.0); err != nil {
+		span.RecordError(err)
 		log.Error("记录使用统计失败: %v", err)
 	}
 
@@ -746,6 +823,13 @@ func (h *OpenAIResponsesHandler) recordUsage(c *gin.Context, userID, apiKeyID, a
 		log.Error("更新每日汇总失败: %v", err)
 	}
 
+	// 结算绑定的套餐使用量
+	if reservation := takePackageReservation(c); reservation != nil {
+		if err := reservation.Commit(costBreakdown.TotalCost); err != nil {
+			log.Error("更新用户套餐使用量失败: packageID=%d, error=%v", reservation.PackageID, err)
+		}
+	}
+
 	log.Info("使用记录已保存 - Cost: %.6f", costBreakdown.TotalCost)
 }
 
@@ -767,26 +851,16 @@ func (h *OpenAIResponsesHandler) getUserInfo(c *gin.Context) (userID, apiKeyID u
 // generateSessionHash 生成会话哈希，用于粘性会话保持
 // 参考 claude-relay 的 sessionHelper.js 实现
 // 优先级：
-//  1. 客户端提供的 Session_id 请求头
+//  1. 客户端会话标识（Codex session_id 请求头、prompt_cache_key 等，见 sessionExtractors）
 //  2. 请求体中的 instructions 字段（类似 system prompt）
 //  3. 第一条 input 消息内容
-func (h *OpenAIResponsesHandler) generateSessionHash(c *gin.Context, reqBody map[string]interface{}) string {
+func (h *OpenAIResponsesHandler) generateSessionHash(c *gin.Context, rawBody []byte, reqBody map[string]interface{}) string {
 	log := logger.GetLogger("openai-responses")
 
-	// 1. 最高优先级：使用客户端提供的 Session_id 请求头
-	// 注意：Gin 的 GetHeader 不区分大小写，但请求头名称可能被规范化
-	sessionHeader := c.GetHeader("Session_id")
-	if sessionHeader == "" {
-		sessionHeader = c.GetHeader("Session-Id")
-	}
-	if sessionHeader == "" {
-		sessionHeader = c.Request.Header.Get("Session_id")
-	}
-
-	if sessionHeader != "" {
-		log.Debug("使用 Session_id 请求头生成哈希: %s", sessionHeader)
-		hash := sha256.Sum256([]byte(sessionHeader))
-		return hex.EncodeToString(hash[:])[:32]
+	// 1. 最高优先级：使用客户端提供的会话标识
+	if token := clientSessionID(c, model.PlatformOpenAI, rawBody); token != "" {
+		log.Debug("使用客户端会话标识: %s", token)
+		return scopedSessionID(c, token)
 	}
 
 	// 2. 使用 instructions 字段（类似 claude-relay 的 system prompt）
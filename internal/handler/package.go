@@ -13,6 +13,7 @@ package handler
 import (
 	"cli-proxy/internal/model"
 	"cli-proxy/internal/repository"
+	"cli-proxy/internal/service"
 	"cli-proxy/pkg/response"
 	"strconv"
 	"time"
@@ -59,6 +60,8 @@ func (h *PackageHandler) CreatePackage(c *gin.Context) {
 		QuotaAmount   float64 `json:"quota_amount"`   // 额度类型：总额度
 		AllowedModels string  `json:"allowed_models"` // 允许的模型
 		Description   string  `json:"description"`
+		// 绑定的账户分组ID（逗号分隔）
+		AccountGroupIDs string `json:"account_group_ids"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -66,6 +69,12 @@ func (h *PackageHandler) CreatePackage(c *gin.Context) {
 		return
 	}
 
+	accountGroupIDs, err := service.NewAccountService().NormalizeAccountGroupIDs(req.AccountGroupIDs)
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
 	pkg := &model.Package{
 		Name:          req.Name,
 		Type:          req.Type,
@@ -78,6 +87,8 @@ func (h *PackageHandler) CreatePackage(c *gin.Context) {
 		AllowedModels: req.AllowedModels,
 		Description:   req.Description,
 		Status:        "active",
+
+		AccountGroupIDs: accountGroupIDs,
 	}
 
 	if err := h.packageRepo.Create(pkg); err != nil {
@@ -109,6 +120,8 @@ func (h *PackageHandler) UpdatePackage(c *gin.Context) {
 		AllowedModels *string  `json:"allowed_models"`
 		Description   string   `json:"description"`
 		Status        string   `json:"status"`
+		// 绑定的账户分组ID（逗号分隔，空字符串表示解除绑定）
+		AccountGroupIDs *string `json:"account_group_ids"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -119,6 +132,14 @@ func (h *PackageHandler) UpdatePackage(c *gin.Context) {
 	if req.Name != "" {
 		pkg.Name = req.Name
 	}
+	if req.AccountGroupIDs != nil {
+		accountGroupIDs, err := service.NewAccountService().NormalizeAccountGroupIDs(*req.AccountGroupIDs)
+		if err != nil {
+			response.BadRequest(c, err.Error())
+			return
+		}
+		pkg.AccountGroupIDs = accountGroupIDs
+	}
 	if req.Price != nil {
 		pkg.Price = *req.Price
 	}
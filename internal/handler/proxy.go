@@ -7,6 +7,7 @@
  *   - 流式/非流式响应处理
  *   - 请求重试和账户切换
  *   - 使用量记录和费用统计
+ *   - 失败请求日志记录
  *   - 限流头解析和账户状态更新
  * 重要程度：⭐⭐⭐⭐⭐ 核心（代理转发的主要入口）
  * 依赖模块：scheduler, adapter, service, model
@@ -20,7 +21,6 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -32,13 +32,13 @@ import (
 	"cli-proxy/internal/service"
 	"cli-proxy/pkg/logger"
 	"cli-proxy/pkg/response"
+	"cli-proxy/pkg/tracing"
 
 	"github.com/gin-gonic/gin"
 )
 
 type ProxyHandler struct {
 	scheduler       *scheduler.Scheduler
-	retryConfig     *scheduler.RetryConfig
 	usageService    *service.UsageService
 	pricingService  *service.PricingService
 	userRepo        *repository.UserRepository
@@ -51,7 +51,6 @@ type ProxyHandler struct {
 func NewProxyHandler() *ProxyHandler {
 	return &ProxyHandler{
 		scheduler:       scheduler.GetScheduler(),
-		retryConfig:     &scheduler.DefaultRetryConfig,
 		usageService:    service.NewUsageService(),
 		pricingService:  service.NewPricingService(),
 		userRepo:        repository.NewUserRepository(),
@@ -62,89 +61,12 @@ func NewProxyHandler() *ProxyHandler {
 	}
 }
 
-// RateWriter 倍率写入器，包装 io.Writer 并在写入时修改 token 值
-type RateWriter struct {
-	writer io.Writer
-	rate   float64
-}
-
-// NewRateWriter 创建倍率写入器
-func NewRateWriter(w io.Writer, rate float64) *RateWriter {
-	return &RateWriter{writer: w, rate: rate}
-}
-
-// Write 实现 io.Writer 接口，写入时修改 token 值
-func (rw *RateWriter) Write(p []byte) (n int, err error) {
-	if rw.rate == 1.0 {
-		return rw.writer.Write(p)
-	}
-	modified := applyRateToSSEChunk(p, rw.rate)
-	// 返回原始长度，避免调用者认为写入不完整
-	_, err = rw.writer.Write(modified)
-	return len(p), err
-}
-
-// Flush 实现 http.Flusher 接口（如果底层 writer 支持）
-func (rw *RateWriter) Flush() {
-	if f, ok := rw.writer.(interface{ Flush() }); ok {
-		f.Flush()
-	}
-}
-
-// applyRateToSSEChunk 将倍率应用到 SSE 数据块中的 token 字段
-func applyRateToSSEChunk(chunk []byte, rate float64) []byte {
-	content := string(chunk)
-
-	// 匹配所有 token 相关的字段并乘以倍率
-	// 包括 OpenAI、Claude 和 Gemini 的字段名
-	tokenFields := []string{
-		// OpenAI 格式
-		"prompt_tokens",
-		"completion_tokens",
-		"total_tokens",
-		"cached_tokens",
-		// Claude 格式
-		"input_tokens",
-		"output_tokens",
-		"cache_creation_input_tokens",
-		"cache_read_input_tokens",
-		// Gemini 格式
-		"promptTokenCount",
-		"candidatesTokenCount",
-		"totalTokenCount",
-	}
-
-	for _, field := range tokenFields {
-		// 匹配 "field": 数字 或 "field":数字
-		pattern := regexp.MustCompile(`"` + field + `"\s*:\s*(\d+)`)
-		content = pattern.ReplaceAllStringFunc(content, func(match string) string {
-			// 提取数字
-			numPattern := regexp.MustCompile(`(\d+)`)
-			numStr := numPattern.FindString(match)
-			if num, err := strconv.Atoi(numStr); err == nil {
-				newNum := int(float64(num) * rate)
-				return strings.Replace(match, numStr, strconv.Itoa(newNum), 1)
-			}
-			return match
-		})
-	}
-
-	return []byte(content)
-}
-
 // getSessionID 获取会话ID
-// 优先使用请求头中的 x-session-id（Claude Code 每个窗口会发不同的 session）
+// 优先使用客户端会话标识（按入口格式提取，如 Claude Code 每个窗口的会话、Codex session_id）
 // 如果没有则使用 API Key ID
-func (h *ProxyHandler) getSessionID(c *gin.Context) string {
-	// 优先使用 Claude Code 的 x-session-id
-	if sessionID := c.GetHeader("x-session-id"); sessionID != "" {
-		// 加上 API Key ID 前缀，避免不同用户的 session 冲突
-		if apiKeyID, ok := c.Get("api_key_id"); ok {
-			if id, ok := apiKeyID.(uint); ok {
-				return fmt.Sprintf("apikey:%d:%s", id, sessionID)
-			}
-		}
-		return sessionID
+func (h *ProxyHandler) getSessionID(c *gin.Context, format string) string {
+	if token := clientSessionID(c, format, contextRequestBody(c)); token != "" {
+		return scopedSessionID(c, token)
 	}
 	// 回退到 API Key ID
 	if apiKeyID, ok := c.Get("api_key_id"); ok {
@@ -172,12 +94,53 @@ func (h *ProxyHandler) getUserInfo(c *gin.Context) (userID, apiKeyID uint, clien
 	return
 }
 
-// createRetryRequest 创建带用户信息的重试请求
-func (h *ProxyHandler) createRetryRequest(c *gin.Context) *scheduler.RetryableRequest {
+// createRetryRequest 创建带用户信息的重试请求（format 为入口格式，用于提取客户端会话标识）
+func (h *ProxyHandler) createRetryRequest(c *gin.Context, format string) *scheduler.RetryableRequest {
 	userID, apiKeyID, clientIP, userAgent := h.getUserInfo(c)
-	return scheduler.NewRetryableRequest(h.scheduler, h.retryConfig).
-		WithSessionID(h.getSessionID(c)).
+	retryReq := scheduler.NewRetryableRequest(h.scheduler, currentRetryConfig()).
+		WithSessionID(h.getSessionID(c, format)).
 		WithUserInfo(userID, apiKeyID, clientIP, userAgent)
+	return withPromptAffinity(retryReq, contextRequestBody(c))
+}
+
+// currentRetryConfig 按系统配置构建重试配置（每个请求读取，修改后立即生效）
+func currentRetryConfig() *scheduler.RetryConfig {
+	configService := service.GetConfigService()
+	cfg := scheduler.DefaultRetryConfig
+	cfg.MaxRetries = configService.GetRetryMaxRetries()
+	cfg.RetryDelay = configService.GetRetryDelay()
+	cfg.RetryBackoff = configService.GetRetryBackoff()
+	cfg.MaxRetryDelay = configService.GetRetryMaxDelay()
+	cfg.Jitter = configService.GetRetryJitter()
+	cfg.TotalTimeout = configService.GetRetryTotalTimeout()
+	cfg.FirstByteTimeout = configService.GetRetryFirstByteTimeout()
+	cfg.NonStreamFirstByteTimeout = configService.GetRetryNonStreamFirstByteTimeout()
+	return &cfg
+}
+
+// applyHedge 非流式请求对冲：系统配置开启，且 API Key 启用对冲或模型在对冲列表中时生效
+func (h *ProxyHandler) applyHedge(c *gin.Context, retryReq *scheduler.RetryableRequest, modelName string) *scheduler.RetryableRequest {
+	configService := service.GetConfigService()
+	if !configService.GetHedgeEnabled() {
+		return retryReq
+	}
+	enabled := c.GetBool("api_key_hedge_enabled")
+	if !enabled {
+		lowerModel := strings.ToLower(modelName)
+		for _, prefix := range configService.GetHedgeModels() {
+			if strings.HasPrefix(lowerModel, strings.ToLower(prefix)) {
+				enabled = true
+				break
+			}
+		}
+	}
+	if !enabled {
+		return retryReq
+	}
+	return retryReq.WithHedge(scheduler.HedgeOptions{
+		Percentile: configService.GetHedgePercentile(),
+		MinDelay:   configService.GetHedgeMinDelay(),
+	})
 }
 
 // checkModelEnabled 检查模型是否启用
@@ -201,7 +164,7 @@ func (h *ProxyHandler) checkModelEnabled(c *gin.Context, modelName string) bool
 // OpenAI 非流式响应（带重试）
 // originalModel: 客户端请求的原始模型名（映射前），用于账户 ModelMapping 检查
 func (h *ProxyHandler) handleOpenAINonStreamWithRetry(c *gin.Context, req *adapter.Request, accountType string, originalModel string) {
-	retryReq := h.createRetryRequest(c).WithOriginalModel(originalModel)
+	retryReq := h.applyHedge(c, h.createRetryRequest(c, model.PlatformOpenAI).WithOriginalModel(originalModel), originalModel)
 
 	modelName := req.Model
 	if accountType != "" {
@@ -221,8 +184,12 @@ func (h *ProxyHandler) handleOpenAINonStreamWithRetry(c *gin.Context, req *adapt
 	)
 
 	if err != nil {
+		if h.fallbackToNextModel(c, err) {
+			return
+		}
 		// 根据错误类型返回自定义错误
 		errorType, statusCode := getProxyErrorTypeAndCode(err)
+		recordFailure(c, originalModel, statusCode, err)
 		response.CustomError(c, statusCode, errorType, err.Error())
 		return
 	}
@@ -252,11 +219,8 @@ func (h *ProxyHandler) handleOpenAINonStreamWithRetry(c *gin.Context, req *adapt
 		"model":  resp.Model,
 		"choices": []gin.H{
 			{
-				"index": 0,
-				"message": gin.H{
-					"role":    "assistant",
-					"content": resp.Content,
-				},
+				"index":         0,
+				"message":       buildOpenAIMessage(resp),
 				"finish_reason": convertStopReason(resp.StopReason),
 			},
 		},
@@ -283,11 +247,8 @@ func (h *ProxyHandler) handleOpenAINonStreamWithRetry(c *gin.Context, req *adapt
 		"model":  resp.Model,
 		"choices": []gin.H{
 			{
-				"index": 0,
-				"message": gin.H{
-					"role":    "assistant",
-					"content": resp.Content,
-				},
+				"index":         0,
+				"message":       buildOpenAIMessage(resp),
 				"finish_reason": convertStopReason(resp.StopReason),
 			},
 		},
@@ -311,7 +272,10 @@ func (h *ProxyHandler) handleOpenAIStreamWithRetry(c *gin.Context, req *adapter.
 	writer := c.Writer
 
 	// 立即刷新头部，确保客户端知道这是流式响应
-	writer.Flush()
+	// 配置了模型回退时延迟到首次写入，以便回退后仍能设置 X-Model-Fallback 响应头
+	if !hasModelFallback(c) {
+		writer.Flush()
+	}
 
 	// 获取倍率（由中间件设置）
 	priceRate := 1.0
@@ -321,13 +285,13 @@ func (h *ProxyHandler) handleOpenAIStreamWithRetry(c *gin.Context, req *adapter.
 		}
 	}
 
-	// 使用 RateWriter 包装 writer，在写入时修改 token 值
-	rateWriter := NewRateWriter(writer, priceRate)
+	// 使用 UsageRewriter 包装 writer，按事件改写 usage 中的 token 值
+	rateWriter := NewUsageRewriter(writer, priceRate, true)
 
-	// 使用 TailWriter 捕获末尾 2KB 响应（包装 RateWriter）
+	// 使用 TailWriter 捕获末尾 2KB 响应（包装 UsageRewriter）
 	tailWriter := adapter.NewTailWriter(rateWriter, 2048)
 
-	retryReq := h.createRetryRequest(c).WithOriginalModel(originalModel)
+	retryReq := h.createRetryRequest(c, model.PlatformOpenAI).WithOriginalModel(originalModel)
 
 	modelName := req.Model
 	if accountType != "" {
@@ -342,12 +306,19 @@ func (h *ProxyHandler) handleOpenAIStreamWithRetry(c *gin.Context, req *adapter.
 			if adp == nil {
 				return nil, adapter.ErrNoAdapter
 			}
-			return adp.SendStream(ctx, account, req, w)
+			return adapter.SendStreamAs(ctx, adp, account, req, w, adapter.FormatOpenAI)
 		},
 		tailWriter,
 	)
+	// 输出缓冲的剩余数据，之后才能直接向 writer 追加结束/错误事件
+	rateWriter.Close()
 
 	if err != nil {
+		if h.fallbackToNextModel(c, err) {
+			return
+		}
+		_, statusCode := getProxyErrorTypeAndCode(err)
+		recordFailure(c, originalModel, statusCode, err)
 		errEvent := map[string]interface{}{
 			"error": map[string]string{
 				"message": err.Error(),
@@ -379,7 +350,7 @@ func (h *ProxyHandler) handleOpenAIStreamWithRetry(c *gin.Context, req *adapter.
 // Claude 非流式响应（带重试）
 // originalModel: 客户端请求的原始模型名（映射前），用于账户 ModelMapping 检查
 func (h *ProxyHandler) handleClaudeNonStreamWithRetry(c *gin.Context, req *adapter.Request, accountType string, originalModel string) {
-	retryReq := h.createRetryRequest(c).WithOriginalModel(originalModel)
+	retryReq := h.applyHedge(c, h.createRetryRequest(c, model.PlatformClaude).WithOriginalModel(originalModel), originalModel)
 
 	modelName := req.Model
 	if accountType != "" {
@@ -399,8 +370,12 @@ func (h *ProxyHandler) handleClaudeNonStreamWithRetry(c *gin.Context, req *adapt
 	)
 
 	if err != nil {
+		if h.fallbackToNextModel(c, err) {
+			return
+		}
 		// 使用自定义错误消息
 		errorType, statusCode := getProxyErrorTypeAndCode(err)
+		recordFailure(c, originalModel, statusCode, err)
 		customMsg, _ := getCustomErrorMessage(errorType, err.Error())
 		c.JSON(statusCode, gin.H{
 			"type": "error",
@@ -443,8 +418,8 @@ func (h *ProxyHandler) handleClaudeNonStreamWithRetry(c *gin.Context, req *adapt
 		"type":        "message",
 		"role":        "assistant",
 		"model":       resp.Model,
-		"content":     []gin.H{{"type": "text", "text": resp.Content}},
-		"stop_reason": resp.StopReason,
+		"content":     buildClaudeContent(resp),
+		"stop_reason": convertStopReasonToClaude(resp.StopReason),
 		"usage": gin.H{
 			"input_tokens":  ratedInputTokens,
 			"output_tokens": ratedOutputTokens,
@@ -469,8 +444,8 @@ func (h *ProxyHandler) handleClaudeNonStreamWithRetry(c *gin.Context, req *adapt
 		"type":        "message",
 		"role":        "assistant",
 		"model":       resp.Model,
-		"content":     []gin.H{{"type": "text", "text": resp.Content}},
-		"stop_reason": resp.StopReason,
+		"content":     buildClaudeContent(resp),
+		"stop_reason": convertStopReasonToClaude(resp.StopReason),
 		"usage": gin.H{
 			"input_tokens":  ratedInputTokens,
 			"output_tokens": ratedOutputTokens,
@@ -490,7 +465,10 @@ func (h *ProxyHandler) handleClaudeStreamWithRetry(c *gin.Context, req *adapter.
 	writer := c.Writer
 
 	// 立即刷新头部，确保客户端知道这是流式响应
-	writer.Flush()
+	// 配置了模型回退时延迟到首次写入，以便回退后仍能设置 X-Model-Fallback 响应头
+	if !hasModelFallback(c) {
+		writer.Flush()
+	}
 
 	// 获取倍率（由中间件设置）
 	priceRate := 1.0
@@ -503,13 +481,13 @@ func (h *ProxyHandler) handleClaudeStreamWithRetry(c *gin.Context, req *adapter.
 	log := logger.GetLogger("proxy")
 	log.Debug("Claude Stream 倍率 | Rate: %.2f | Model: %s", priceRate, req.Model)
 
-	// 使用 RateWriter 包装 writer，在写入时修改 token 值
-	rateWriter := NewRateWriter(writer, priceRate)
+	// 使用 UsageRewriter 包装 writer，按事件改写 usage 中的 token 值
+	rateWriter := NewUsageRewriter(writer, priceRate, true)
 
-	// 使用 TailWriter 捕获末尾 2KB 响应（包装 RateWriter）
+	// 使用 TailWriter 捕获末尾 2KB 响应（包装 UsageRewriter）
 	tailWriter := adapter.NewTailWriter(rateWriter, 2048)
 
-	retryReq := h.createRetryRequest(c).WithOriginalModel(originalModel)
+	retryReq := h.createRetryRequest(c, model.PlatformClaude).WithOriginalModel(originalModel)
 
 	modelName := req.Model
 	if accountType != "" {
@@ -524,12 +502,19 @@ func (h *ProxyHandler) handleClaudeStreamWithRetry(c *gin.Context, req *adapter.
 			if adp == nil {
 				return nil, adapter.ErrNoAdapter
 			}
-			return adp.SendStream(ctx, account, req, w)
+			return adapter.SendStreamAs(ctx, adp, account, req, w, adapter.FormatClaude)
 		},
 		tailWriter,
 	)
+	// 输出缓冲的剩余数据，之后才能直接向 writer 追加结束/错误事件
+	rateWriter.Close()
 
 	if err != nil {
+		if h.fallbackToNextModel(c, err) {
+			return
+		}
+		_, statusCode := getProxyErrorTypeAndCode(err)
+		recordFailure(c, originalModel, statusCode, err)
 		writer.Write([]byte("event: error\n"))
 		errData, _ := json.Marshal(gin.H{
 			"type": "error",
@@ -561,8 +546,8 @@ func (h *ProxyHandler) handleClaudeStreamWithRetry(c *gin.Context, req *adapter.
 
 // ========== 平台特定路由处理器 ==========
 
-// ClaudeMessages Claude 平台专用接口 POST /claude/v1/messages
-// 强制只从 Claude 平台账户中选择，不做平台自动检测
+// ClaudeMessages Claude 格式接口 POST /claude/v1/messages
+// 默认从 Claude 平台账户中选择；模型属于 OpenAI/Gemini 时转换格式后由对应平台账户处理
 func (h *ProxyHandler) ClaudeMessages(c *gin.Context) {
 	// 1. 读取原始请求体（不做任何解析）
 	rawBody, err := io.ReadAll(c.Request.Body)
@@ -607,32 +592,81 @@ func (h *ProxyHandler) ClaudeMessages(c *gin.Context) {
 		}
 	}
 
-	// 4. 强制使用 Claude 平台（不自动检测）
-	accountType := "claude"
+	// 4. 确定服务平台：模型属于 OpenAI/Gemini 时跨格式路由，否则使用 Claude 平台
 	actualModel := scheduler.GetActualModel(basic.Model) // 去掉可能的 "type," 前缀
+	accountType := resolveTargetPlatform(actualModel, model.PlatformClaude)
+
+	// 5. 检查 API Key 平台/模型权限（使用映射前的原始模型名）
+	if !checkAPIKeyAccess(c, model.PlatformClaude, accountType, actualModel) {
+		return
+	}
 
-	// 5. 检查模型是否启用（不再做全局模型映射，只在账号级别映射）
+	// 6. 检查模型是否启用（不再做全局模型映射，只在账号级别映射）
 	if !h.checkModelEnabled(c, actualModel) {
 		return
 	}
 
-	// 6. 构建透传请求（模型映射由调度器在账号级别处理）
-	req := &adapter.Request{
-		Model:   actualModel,
-		Stream:  basic.Stream,
-		RawBody: rawBody,
-		Headers: clientHeaders,
+	// 7. 套餐额度准入（账户选择之前）
+	if !admitPackageQuota(c, model.PlatformClaude, actualModel, rawBody) {
+		return
 	}
+	defer releasePackageReservation(c)
 
-	if req.Stream {
-		h.handleClaudeStreamWithRetry(c, req, accountType, actualModel)
-	} else {
-		h.handleClaudeNonStreamWithRetry(c, req, accountType, actualModel)
+	// 8. 按模型构建请求并转发（发生模型回退时以回退模型重新路由）
+	dispatch := func(modelName string) error {
+		targetPlatform := resolveTargetPlatform(modelName, model.PlatformClaude)
+		body := rawBody
+		if modelName != actualModel {
+			replaced, err := replaceRequestModel(rawBody, modelName)
+			if err != nil {
+				return err
+			}
+			body = replaced
+		}
+
+		// 构建透传请求（模型映射由调度器在账号级别处理）
+		req := &adapter.Request{
+			Model:   modelName,
+			Stream:  basic.Stream,
+			RawBody: body,
+			Headers: clientHeaders,
+		}
+
+		// 跨格式路由：转换为统一请求，响应由流式转换器/停止原因映射转回 Claude 格式
+		if targetPlatform != model.PlatformClaude {
+			converted, err := convertClaudeRequest(body)
+			if err != nil {
+				return err
+			}
+			converted.Model = modelName
+			converted.Stream = basic.Stream
+			converted.RawBody = body
+			req = converted
+			log.Debug("ClaudeMessages 跨格式路由 | Model: %s | Platform: %s", modelName, targetPlatform)
+		}
+
+		if req.Stream {
+			h.handleClaudeStreamWithRetry(c, req, targetPlatform, modelName)
+		} else {
+			h.handleClaudeNonStreamWithRetry(c, req, targetPlatform, modelName)
+		}
+		return nil
+	}
+
+	h.initModelFallback(c, model.PlatformClaude, actualModel, dispatch)
+	if err := dispatch(actualModel); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"type": "error",
+			"error": gin.H{
+				"type":    "invalid_request_error",
+				"message": "invalid request: " + err.Error(),
+			},
+		})
 	}
 }
 
-// OpenAIChatCompletions OpenAI 平台专用接口 POST /openai/v1/chat/completions
-// 强制只从 OpenAI 平台账户中选择，不做平台自动检测
+// OpenAIChatCompletions OpenAI 格式接口 POST /openai/v1/chat/completions
+// 默认从 OpenAI 平台账户中选择；模型属于 Claude/Gemini 时转换格式后由对应平台账户处理
 func (h *ProxyHandler) OpenAIChatCompletions(c *gin.Context) {
 	// 读取原始请求体用于日志记录
 	rawBody, err := io.ReadAll(c.Request.Body)
@@ -653,22 +687,56 @@ func (h *ProxyHandler) OpenAIChatCompletions(c *gin.Context) {
 	// 保存原始请求体到 context
 	c.Set("request_body", rawBody)
 
-	// 强制使用 OpenAI 平台（不自动检测）
-	accountType := "openai"
+	// 确定服务平台：模型属于 Claude/Gemini 时跨格式路由，否则使用 OpenAI 平台
 	actualModel := scheduler.GetActualModel(req.Model) // 去掉可能的 "type," 前缀
+	accountType := resolveTargetPlatform(actualModel, model.PlatformOpenAI)
 
 	// 使用原始模型名（不再做全局模型映射，只在账号级别映射）
 	req.Model = actualModel
 
+	// 检查 API Key 平台/模型权限
+	if !checkAPIKeyAccess(c, model.PlatformOpenAI, accountType, actualModel) {
+		return
+	}
+
 	// 检查模型是否启用
 	if !h.checkModelEnabled(c, actualModel) {
 		return
 	}
 
-	if req.Stream {
-		h.handleOpenAIStreamWithRetry(c, &req, accountType, actualModel)
-	} else {
-		h.handleOpenAINonStreamWithRetry(c, &req, accountType, actualModel)
+	// 套餐额度准入（账户选择之前）
+	if !admitPackageQuota(c, model.PlatformOpenAI, actualModel, rawBody) {
+		return
+	}
+	defer releasePackageReservation(c)
+
+	// 按模型构建请求并转发（发生模型回退时以回退模型重新路由）
+	dispatch := func(modelName string) error {
+		targetPlatform := resolveTargetPlatform(modelName, model.PlatformOpenAI)
+		modelReq := req
+		modelReq.Model = modelName
+
+		// 跨格式路由：转换为目标平台请求，响应由流式转换器/停止原因映射转回 OpenAI 格式
+		target := &modelReq
+		if targetPlatform != model.PlatformOpenAI {
+			converted, err := convertOpenAIRequest(&modelReq, targetPlatform)
+			if err != nil {
+				return err
+			}
+			target = converted
+		}
+
+		if req.Stream {
+			h.handleOpenAIStreamWithRetry(c, target, targetPlatform, modelName)
+		} else {
+			h.handleOpenAINonStreamWithRetry(c, target, targetPlatform, modelName)
+		}
+		return nil
+	}
+
+	h.initModelFallback(c, model.PlatformOpenAI, actualModel, dispatch)
+	if err := dispatch(actualModel); err != nil {
+		response.CustomBadRequest(c, err.Error())
 	}
 }
 
@@ -686,6 +754,20 @@ func convertStopReason(reason string) string {
 	}
 }
 
+// convertStopReasonToClaude 转换停止原因为 Claude 格式（跨格式路由时上游可能返回 OpenAI 格式）
+func convertStopReasonToClaude(reason string) string {
+	switch reason {
+	case "stop":
+		return "end_turn"
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	default:
+		return reason
+	}
+}
+
 // GeminiChat Gemini 原生格式接口 POST /gemini/v1/chat
 func (h *ProxyHandler) GeminiChat(c *gin.Context) {
 	// 读取原始请求体用于日志记录
@@ -724,11 +806,22 @@ func (h *ProxyHandler) GeminiChat(c *gin.Context) {
 	// 保存原始模型名（不再做全局模型映射，只在账号级别映射）
 	originalModel := req.Model
 
+	// 检查 API Key 平台/模型权限
+	if !checkAPIKeyAccess(c, "gemini", "gemini", originalModel) {
+		return
+	}
+
 	// 检查模型是否启用
 	if !h.checkModelEnabled(c, req.Model) {
 		return
 	}
 
+	// 套餐额度准入（账户选择之前）
+	if !admitPackageQuota(c, "gemini", originalModel, rawBody) {
+		return
+	}
+	defer releasePackageReservation(c)
+
 	if req.Stream {
 		h.handleGeminiStream(c, &req, originalModel)
 	} else {
@@ -737,7 +830,7 @@ func (h *ProxyHandler) GeminiChat(c *gin.Context) {
 }
 
 func (h *ProxyHandler) handleGeminiNonStream(c *gin.Context, req *adapter.Request, originalModel string) {
-	retryReq := h.createRetryRequest(c)
+	retryReq := h.createRetryRequest(c, model.PlatformGemini)
 
 	result, err := retryReq.ExecuteWithRetry(
 		c.Request.Context(),
@@ -752,6 +845,7 @@ func (h *ProxyHandler) handleGeminiNonStream(c *gin.Context, req *adapter.Reques
 	)
 
 	if err != nil {
+		recordFailure(c, originalModel, http.StatusBadGateway, err)
 		c.JSON(http.StatusBadGateway, gin.H{
 			"error": gin.H{
 				"code":    502,
@@ -852,13 +946,13 @@ func (h *ProxyHandler) handleGeminiStream(c *gin.Context, req *adapter.Request,
 		}
 	}
 
-	// 使用 RateWriter 包装 writer，在写入时修改 token 值
-	rateWriter := NewRateWriter(writer, priceRate)
+	// 使用 UsageRewriter 包装 writer，按事件改写 usage 中的 token 值
+	rateWriter := NewUsageRewriter(writer, priceRate, true)
 
-	// 使用 TailWriter 捕获末尾 2KB 响应（包装 RateWriter）
+	// 使用 TailWriter 捕获末尾 2KB 响应（包装 UsageRewriter）
 	tailWriter := adapter.NewTailWriter(rateWriter, 2048)
 
-	retryReq := h.createRetryRequest(c)
+	retryReq := h.createRetryRequest(c, model.PlatformGemini)
 
 	result, err := retryReq.ExecuteStreamWithRetry(
 		c.Request.Context(),
@@ -872,8 +966,11 @@ func (h *ProxyHandler) handleGeminiStream(c *gin.Context, req *adapter.Request,
 		},
 		tailWriter,
 	)
+	// 输出缓冲的剩余数据，之后才能直接向 writer 追加结束/错误事件
+	rateWriter.Close()
 
 	if err != nil {
+		recordFailure(c, originalModel, http.StatusBadGateway, err)
 		errData, _ := json.Marshal(gin.H{
 			"error": gin.H{
 				"code":    502,
@@ -1116,6 +1213,12 @@ func (h *ProxyHandler) recordUsage(c *gin.Context, modelName string, usage *adap
 		return
 	}
 
+	// 取出套餐预留，由异步记录负责按实际费用结算
+	reservation := takePackageReservation(c)
+
+	// 模型回退记录（未发生回退时为空）
+	requestedModel, fallbackHops := fallbackLogFields(c)
+
 	// 应用倍率到 token（用于日志记录和费用计算）
 	ratedInputTokens := int(float64(usage.InputTokens) * priceRate)
 	ratedOutputTokens := int(float64(usage.OutputTokens) * priceRate)
@@ -1131,9 +1234,23 @@ func (h *ProxyHandler) recordUsage(c *gin.Context, modelName string, usage *adap
 		logger.Int("计费output", ratedOutputTokens),
 	)
 
-	// 异步记录使用统计
+	// 请求体/响应体记录判定（采样）在请求协程中完成
+	captureBodies := ShouldCaptureBodies(c, true)
+
+	// 异步记录使用统计（沿用请求追踪，但不随请求结束而取消）
+	usageCtx := context.WithoutCancel(c.Request.Context())
 	go func() {
-		ctx := context.Background()
+		ctx, span := tracing.Start(usageCtx, "usage.record", tracing.WithAttributes(
+			tracing.String("model", modelName),
+			tracing.Uint("account.id", accountID),
+			tracing.Uint("user.id", uid),
+		))
+		defer span.End()
+
+		// 提前返回时释放预留（Commit 之后 Release 不生效）
+		if reservation != nil {
+			defer reservation.Release()
+		}
 
 		// 计算费用（使用倍率后的 token）
 		tokenUsage := &service.TokenUsage{
@@ -1144,6 +1261,7 @@ func (h *ProxyHandler) recordUsage(c *gin.Context, modelName string, usage *adap
 		}
 		costBreakdown, err := h.pricingService.CalculateCost(ctx, modelName, tokenUsage, 1.0) // 倍率已应用到token，这里用1.0
 		if err != nil {
+			span.RecordError(err)
 			log.ErrorZ("计算费用失败",
 				logger.Uint("user_id", uid),
 				logger.String("model", modelName),
@@ -1177,31 +1295,32 @@ func (h *ProxyHandler) recordUsage(c *gin.Context, modelName string, usage *adap
 			Success:                  true,
 			StatusCode:               200,
 			UpstreamStatusCode:       upstreamStatusCode,
+			RequestedModel:           requestedModel,
+			FallbackHops:             fallbackHops,
 			CreatedAt:                time.Now(),
 		}
 
-		// 记录请求头和请求体
+		// 按记录策略记录请求体和响应体（请求头始终记录）
+		if !captureBodies {
+			requestBody, responseBody = nil, nil
+		}
 		SetRequestDetails(requestLog, c.Request.Header, requestBody)
 
 		// 记录响应体
-		// 非流式：完整响应（最大64KB）
+		// 非流式：完整响应
 		// 流式：末尾内容（用于查看 usage/cache 等信息）
-		if len(responseBody) > 0 {
-			if len(responseBody) > 65536 {
-				requestLog.ResponseBody = string(responseBody[:65536]) + "...[truncated]"
-			} else if isStream {
-				// 流式响应标记为末尾内容
-				requestLog.ResponseBody = "[stream tail] " + string(responseBody)
-			} else {
-				requestLog.ResponseBody = string(responseBody)
-			}
+		responsePrefix := ""
+		if isStream {
+			responsePrefix = "[stream tail] "
 		}
+		requestLog.ResponseBody = service.GetBodyCaptureService().Capture(responseBody, responsePrefix)
 
 		// 直接保存请求日志到数据库
 		LogRequest(requestLog)
 
 		// 记录到 Redis（倍率已应用，这里用 1.0）
 		if err := h.usageService.RecordRequest(ctx, uid, keyID, requestLog, 1.0); err != nil {
+			span.RecordError(err)
 			log.ErrorZ("记录使用统计失败",
 				logger.Uint("user_id", uid),
 				logger.Uint("api_key_id", keyID),
@@ -1266,26 +1385,28 @@ func (h *ProxyHandler) recordUsage(c *gin.Context, modelName string, usage *adap
 			}
 		}
 
-		// 更新绑定的套餐使用量（只扣绑定的套餐）
-		if pkgID > 0 {
-			// 获取套餐信息用于惰性重置检查
-			userPackage, err := h.userPackageRepo.GetByID(pkgID)
-			if err == nil && userPackage != nil {
-				// 先检查周期是否需要重置（惰性重置）
-				if userPackage.ResetPeriodUsageIfNeeded() {
-					// 如果重置了，需要先保存重置后的状态
-					h.userPackageRepo.Update(userPackage)
-				}
-				// 增加使用量
-				if err := h.userPackageRepo.IncrementUsage(pkgID, pkgType, costBreakdown.TotalCost); err != nil {
-					log.ErrorZ("更新用户套餐使用量失败",
-						logger.Uint("user_id", uid),
-						logger.Uint("package_id", pkgID),
-						logger.String("package_type", pkgType),
-						logger.Float64("total_cost", costBreakdown.TotalCost),
-						logger.Err(err),
-					)
-				}
+		// 结算绑定的套餐使用量（只扣绑定的套餐）
+		if reservation != nil {
+			if err := reservation.Commit(costBreakdown.TotalCost); err != nil {
+				log.ErrorZ("更新用户套餐使用量失败",
+					logger.Uint("user_id", uid),
+					logger.Uint("package_id", pkgID),
+					logger.String("package_type", pkgType),
+					logger.Float64("reserved", reservation.Amount),
+					logger.Float64("total_cost", costBreakdown.TotalCost),
+					logger.Err(err),
+				)
+			}
+		} else if pkgID > 0 {
+			// 增加使用量（订阅套餐跨周期时在同一条 UPDATE 中重置）
+			if err := h.userPackageRepo.IncrementUsage(pkgID, pkgType, costBreakdown.TotalCost); err != nil {
+				log.ErrorZ("更新用户套餐使用量失败",
+					logger.Uint("user_id", uid),
+					logger.Uint("package_id", pkgID),
+					logger.String("package_type", pkgType),
+					logger.Float64("total_cost", costBreakdown.TotalCost),
+					logger.Err(err),
+				)
 			}
 		}
 
@@ -1318,6 +1439,50 @@ func (h *ProxyHandler) recordNonStreamUsage(c *gin.Context, modelName string, re
 	h.recordUsage(c, modelName, usage, false, requestBody, responseBody, upstreamStatusCode, accountID)
 }
 
+// recordFailure 记录失败请求日志（无 token 与费用），请求体/响应体按记录策略记录
+// 上游返回错误时同时记录上游状态码，响应体为上游错误内容
+func recordFailure(c *gin.Context, modelName string, statusCode int, err error) {
+	uid := c.GetUint("api_key_user_id")
+	if uid == 0 || err == nil {
+		return
+	}
+	keyID := c.GetUint("api_key_id")
+	requestedModel, fallbackHops := fallbackLogFields(c)
+
+	requestLog := &model.RequestLog{
+		UserID:         &uid,
+		APIKeyID:       &keyID,
+		Platform:       scheduler.DetectPlatform(modelName),
+		Model:          modelName,
+		Endpoint:       c.Request.URL.Path,
+		Method:         c.Request.Method,
+		Path:           c.Request.URL.Path,
+		RequestIP:      c.ClientIP(),
+		UserAgent:      c.GetHeader("User-Agent"),
+		Success:        false,
+		StatusCode:     statusCode,
+		Error:          strings.ToValidUTF8(truncateForLog(err.Error(), 990), ""),
+		RequestedModel: requestedModel,
+		FallbackHops:   fallbackHops,
+		CreatedAt:      time.Now(),
+	}
+
+	requestBody := contextRequestBody(c)
+	var responseBody []byte
+	var upstreamErr *adapter.UpstreamError
+	if errors.As(err, &upstreamErr) {
+		SetUpstreamError(requestLog, upstreamErr.StatusCode, strings.ToValidUTF8(truncateForLog(upstreamErr.Message, 1990), ""))
+		responseBody = []byte(upstreamErr.Message)
+	}
+	if !ShouldCaptureBodies(c, false) {
+		requestBody, responseBody = nil, nil
+	}
+	SetRequestDetails(requestLog, c.Request.Header, requestBody)
+	requestLog.ResponseBody = service.GetBodyCaptureService().Capture(responseBody, "")
+
+	LogRequest(requestLog)
+}
+
 // getProxyErrorTypeAndCode 根据错误判断错误类型和HTTP状态码
 // 如果是未知错误，会自动发现并注册到数据库
 func getProxyErrorTypeAndCode(err error) (string, int) {
@@ -1349,6 +1514,8 @@ func getProxyErrorTypeAndCode(err error) (string, int) {
 		return model.ErrorTypeNoAvailableAccount, http.StatusServiceUnavailable
 	case strings.Contains(errMsg, "all accounts failed"):
 		return model.ErrorTypeAllAccountsFailed, http.StatusBadGateway
+	case errors.Is(err, scheduler.ErrAccountConcurrencyFull):
+		return model.ErrorTypeAccountConcurrency, http.StatusTooManyRequests
 
 	// 不支持的模型/适配器
 	case errMsg == "no adapter found for account type":
@@ -0,0 +1,234 @@
+/*
+ * 文件作用：代理请求准入检查，在选择上游账户之前拦截不可服务的请求
+ * 负责功能：
+ *   - API Key 平台/模型访问权限检查
+ *   - 套餐额度准入与预留（过期/耗尽/周期额度）
+ *   - 预留结算与释放
+ *   - 按平台原生格式返回错误
+ * 重要程度：⭐⭐⭐⭐ 重要（计费准入）
+ * 依赖模块：middleware, service, model
+ */
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"cli-proxy/internal/middleware"
+	"cli-proxy/internal/model"
+	"cli-proxy/internal/service"
+	"cli-proxy/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+const packageReservationKey = "package_reservation"
+
+// checkAPIKeyAccess 检查 API Key 的平台和模型访问权限
+// format 为客户端请求格式（决定错误响应格式），platform 为实际服务的平台（跨格式路由时两者不同）
+// modelName 必须是客户端请求的原始模型名（账户级映射之前）
+// 返回 false 时已写入错误响应
+func checkAPIKeyAccess(c *gin.Context, format, platform, modelName string) bool {
+	log := logger.GetLogger("proxy")
+	keyID, _ := c.Get("api_key_id")
+
+	if !middleware.CheckPlatformAccess(c, platform) {
+		log.Info("API Key 平台访问受限 | KeyID: %v | Platform: %s", keyID, platform)
+		writePlatformError(c, format, http.StatusForbidden, model.ErrorTypePlatformForbid,
+			"This API key is not allowed to access platform: "+platform)
+		return false
+	}
+
+	if !middleware.CheckModelAccess(c, modelName) {
+		log.Info("API Key 模型访问受限 | KeyID: %v | Model: %s", keyID, modelName)
+		writePlatformError(c, format, http.StatusForbidden, model.ErrorTypeModelForbidden,
+			"This API key is not allowed to access model: "+modelName)
+		return false
+	}
+
+	return true
+}
+
+// admitPackageQuota 套餐额度准入（在账户选择之前调用）
+// 检查 API Key 绑定的套餐是否可用，并预留本次请求的预估费用
+// 返回 false 时已写入错误响应
+func admitPackageQuota(c *gin.Context, platform, modelName string, rawBody []byte) bool {
+	pkgVal, ok := c.Get("api_key_package_id")
+	if !ok {
+		return true
+	}
+	pkgID, ok := pkgVal.(uint)
+	if !ok || pkgID == 0 {
+		return true
+	}
+
+	priceRate := 1.0
+	if rate, ok := c.Get("api_key_price_rate"); ok {
+		if r, ok := rate.(float64); ok {
+			priceRate = r
+		}
+	}
+
+	quotaService := service.GetPackageQuotaService()
+	ctx := c.Request.Context()
+	estimate := quotaService.EstimateCost(ctx, modelName, len(rawBody), extractMaxTokens(rawBody), priceRate)
+
+	reservation, err := quotaService.Admit(ctx, pkgID, estimate)
+	if err != nil {
+		log := logger.GetLogger("proxy")
+		log.Info("套餐准入拒绝 | PackageID: %d | Model: %s | Estimate: %.6f | Reason: %v", pkgID, modelName, estimate, err)
+		writePlatformError(c, platform, http.StatusForbidden, getPackageErrorType(err), err.Error())
+		return false
+	}
+
+	c.Set(packageReservationKey, reservation)
+	return true
+}
+
+// takePackageReservation 取出并清除当前请求的套餐预留（取出方负责结算或释放）
+func takePackageReservation(c *gin.Context) *service.PackageReservation {
+	val, ok := c.Get(packageReservationKey)
+	if !ok {
+		return nil
+	}
+	reservation, _ := val.(*service.PackageReservation)
+	if reservation != nil {
+		c.Set(packageReservationKey, (*service.PackageReservation)(nil))
+	}
+	return reservation
+}
+
+// releasePackageReservation 释放未结算的套餐预留（请求失败时）
+func releasePackageReservation(c *gin.Context) {
+	if reservation := takePackageReservation(c); reservation != nil {
+		reservation.Release()
+	}
+}
+
+// extractMaxTokens 从请求体提取最大输出 token（兼容各平台字段名）
+func extractMaxTokens(rawBody []byte) int {
+	var body struct {
+		MaxTokens           int `json:"max_tokens"`
+		MaxCompletionTokens int `json:"max_completion_tokens"`
+		MaxOutputTokens     int `json:"max_output_tokens"`
+		GenerationConfig    struct {
+			MaxOutputTokens int `json:"maxOutputTokens"`
+		} `json:"generationConfig"`
+	}
+	if err := json.Unmarshal(rawBody, &body); err != nil {
+		return 0
+	}
+	switch {
+	case body.MaxTokens > 0:
+		return body.MaxTokens
+	case body.MaxCompletionTokens > 0:
+		return body.MaxCompletionTokens
+	case body.MaxOutputTokens > 0:
+		return body.MaxOutputTokens
+	default:
+		return body.GenerationConfig.MaxOutputTokens
+	}
+}
+
+// getPackageErrorType 套餐准入错误对应的错误类型
+func getPackageErrorType(err error) string {
+	switch {
+	case errors.Is(err, service.ErrPackageExpired):
+		return model.ErrorTypePackageExpired
+	case errors.Is(err, service.ErrPackageDailyQuota):
+		return model.ErrorTypeDailyLimit
+	case errors.Is(err, service.ErrPackageMonthQuota):
+		return model.ErrorTypeMonthlyQuota
+	default:
+		return model.ErrorTypeQuotaExceeded
+	}
+}
+
+// writePlatformError 按平台原生错误格式返回错误并中断请求
+func writePlatformError(c *gin.Context, platform string, statusCode int, errorType, originalError string) {
+	message, _ := getCustomErrorMessage(errorType, originalError)
+
+	switch platform {
+	case "claude":
+		c.AbortWithStatusJSON(statusCode, gin.H{
+			"type": "error",
+			"error": gin.H{
+				"type":    claudeErrorType(statusCode),
+				"message": message,
+			},
+		})
+	case "gemini":
+		c.AbortWithStatusJSON(statusCode, gin.H{
+			"error": gin.H{
+				"code":    statusCode,
+				"message": message,
+				"status":  geminiErrorStatus(statusCode),
+			},
+		})
+	default:
+		c.AbortWithStatusJSON(statusCode, gin.H{
+			"error": gin.H{
+				"message": message,
+				"type":    openAIErrorType(statusCode),
+				"code":    errorType,
+			},
+		})
+	}
+}
+
+// claudeErrorType HTTP 状态码对应的 Claude 错误类型
+func claudeErrorType(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case http.StatusServiceUnavailable:
+		return "overloaded_error"
+	default:
+		return "api_error"
+	}
+}
+
+// openAIErrorType HTTP 状态码对应的 OpenAI 错误类型
+func openAIErrorType(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest, http.StatusNotFound:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	default:
+		return "api_error"
+	}
+}
+
+// geminiErrorStatus HTTP 状态码对应的 Gemini 错误状态
+func geminiErrorStatus(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return "INVALID_ARGUMENT"
+	case http.StatusUnauthorized:
+		return "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		return "PERMISSION_DENIED"
+	case http.StatusNotFound:
+		return "NOT_FOUND"
+	case http.StatusTooManyRequests:
+		return "RESOURCE_EXHAUSTED"
+	case http.StatusServiceUnavailable:
+		return "UNAVAILABLE"
+	default:
+		return "INTERNAL"
+	}
+}
@@ -67,14 +67,49 @@ func GetProxyConfig(c *gin.Context) {
 	c.JSON(http.StatusOK, proxy)
 }
 
+// ProxyConfigRequest 创建/更新代理配置请求（密码不会在响应中返回）
+type ProxyConfigRequest struct {
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	Host          string `json:"host"`
+	Port          int    `json:"port"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`       // 更新时为空表示保持不变
+	ClearPassword bool   `json:"clear_password"` // 是否清除密码
+	Enabled       bool   `json:"enabled"`
+	IsDefault     bool   `json:"is_default"`
+	Remark        string `json:"remark"`
+}
+
+// apply 将请求字段写入代理配置
+func (r *ProxyConfigRequest) apply(proxy *model.Proxy) {
+	proxy.Name = r.Name
+	proxy.Type = r.Type
+	proxy.Host = r.Host
+	proxy.Port = r.Port
+	proxy.Username = r.Username
+	proxy.Enabled = r.Enabled
+	proxy.IsDefault = r.IsDefault
+	proxy.Remark = r.Remark
+	if r.Password != "" {
+		proxy.Password = r.Password
+	} else if r.ClearPassword {
+		proxy.Password = ""
+	}
+	proxy.HasPassword = proxy.Password != ""
+}
+
 // CreateProxyConfig 创建代理配置
 func CreateProxyConfig(c *gin.Context) {
-	var proxy model.Proxy
-	if err := c.ShouldBindJSON(&proxy); err != nil {
+	var req ProxyConfigRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	var proxy model.Proxy
+	req.apply(&proxy)
+
 	// 设置默认值
 	if proxy.Type == "" {
 		proxy.Type = model.ProxyTypeHTTP
@@ -106,16 +141,17 @@ func UpdateProxyConfig(c *gin.Context) {
 		return
 	}
 
-	var proxy model.Proxy
-	if err := c.ShouldBindJSON(&proxy); err != nil {
+	var req ProxyConfigRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	proxy.ID = uint(id)
-	proxy.CreatedAt = existing.CreatedAt
+	// 在已有配置上更新，未提供密码时保留原密码
+	proxy := existing
+	req.apply(proxy)
 
-	if err := service.GetProxyService().Update(&proxy); err != nil {
+	if err := service.GetProxyService().Update(proxy); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -245,6 +281,13 @@ func TestProxyConnectivity(c *gin.Context) {
 		req.Type = "http"
 	}
 
+	// 测试已保存的代理且未填写密码时，使用已保存的密码
+	if req.ID > 0 && req.Password == "" {
+		if saved, err := service.GetProxyService().GetByID(req.ID); err == nil && saved != nil {
+			req.Password = saved.Password
+		}
+	}
+
 	start := time.Now()
 
 	// 测试目标 URL（使用 Google 或 Cloudflare 来测试代理，更稳定）
@@ -0,0 +1,108 @@
+/*
+ * 文件作用：跨格式路由，按模型所属平台选择账户并在客户端格式与上游格式间转换请求
+ * 负责功能：
+ *   - 模型所属平台判断
+ *   - OpenAI 请求 → Claude / Gemini 账户
+ *   - Claude 请求 → OpenAI / Gemini 账户
+ *   - 非流式响应的工具调用输出（OpenAI tool_calls / Claude tool_use）
+ * 重要程度：⭐⭐⭐⭐ 重要（跨格式路由）
+ * 依赖模块：adapter, scheduler, model
+ */
+package handler
+
+import (
+	"encoding/json"
+
+	"cli-proxy/internal/model"
+	"cli-proxy/internal/proxy/adapter"
+	"cli-proxy/internal/proxy/scheduler"
+
+	"github.com/gin-gonic/gin"
+)
+
+// resolveTargetPlatform 根据模型判断实际服务的平台
+// 模型属于 Claude/OpenAI/Gemini 时返回该平台，否则返回客户端格式对应的平台
+func resolveTargetPlatform(modelName, format string) string {
+	switch platform := scheduler.DetectPlatform(modelName); platform {
+	case model.PlatformClaude, model.PlatformOpenAI, model.PlatformGemini:
+		return platform
+	default:
+		return format
+	}
+}
+
+// convertOpenAIRequest 将 OpenAI 格式请求转换为目标平台适配器需要的请求
+// Claude 账户透传 RawBody，因此重新生成 Claude 格式请求体；Gemini 账户需要将 system 消息提取到 System
+func convertOpenAIRequest(req *adapter.Request, platform string) (*adapter.Request, error) {
+	conv := adapter.NewFormatConverter()
+
+	switch platform {
+	case model.PlatformClaude:
+		body, err := json.Marshal(conv.UnifiedToClaude(req))
+		if err != nil {
+			return nil, err
+		}
+		converted := *req
+		converted.RawBody = body
+		converted.Headers = nil
+		return &converted, nil
+	case model.PlatformGemini:
+		converted := conv.OpenAIToUnified(conv.UnifiedToOpenAI(req))
+		converted.RawBody = req.RawBody
+		return converted, nil
+	default:
+		return req, nil
+	}
+}
+
+// convertClaudeRequest 将 Claude 原始请求体转换为 OpenAI/Gemini 账户使用的统一请求
+// tool_use/tool_result 转换为 tool_calls 和 role=tool 消息
+func convertClaudeRequest(rawBody []byte) (*adapter.Request, error) {
+	conv := adapter.NewFormatConverter()
+	claudeReq, err := conv.ParseClaudeRequest(rawBody)
+	if err != nil {
+		return nil, err
+	}
+	return conv.ClaudeToUnified(claudeReq), nil
+}
+
+// replaceRequestModel 替换原始请求体中的 model 字段（透传请求切换模型时使用）
+func replaceRequestModel(rawBody []byte, modelName string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(rawBody, &fields); err != nil {
+		return nil, err
+	}
+	value, err := json.Marshal(modelName)
+	if err != nil {
+		return nil, err
+	}
+	fields["model"] = value
+	return json.Marshal(fields)
+}
+
+// buildOpenAIMessage 构建 OpenAI 格式的 assistant 消息（含工具调用）
+func buildOpenAIMessage(resp *adapter.Response) gin.H {
+	message := gin.H{
+		"role":    "assistant",
+		"content": resp.Content,
+	}
+	if len(resp.ToolCalls) > 0 {
+		message["tool_calls"] = resp.ToolCalls
+		if resp.Content == "" {
+			message["content"] = nil
+		}
+	}
+	return message
+}
+
+// buildClaudeContent 构建 Claude 格式的响应内容块（文本 + tool_use）
+func buildClaudeContent(resp *adapter.Response) []interface{} {
+	content := make([]interface{}, 0, len(resp.ToolCalls)+1)
+	if resp.Content != "" || len(resp.ToolCalls) == 0 {
+		content = append(content, gin.H{"type": "text", "text": resp.Content})
+	}
+	for _, block := range adapter.ToolCallsToClaudeBlocks(resp.ToolCalls) {
+		content = append(content, block)
+	}
+	return content
+}
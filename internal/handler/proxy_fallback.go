@@ -0,0 +1,170 @@
+/*
+ * 文件作用：模型回退链，账户重试耗尽后按管理员配置切换到下一个模型
+ * 负责功能：
+ *   - 回退链加载（AIModel.FallbackModels，按顺序尝试）
+ *   - 回退资格判断与静默准入检查（平台/模型权限、模型启用）
+ *   - 回退记录（X-Model-Fallback 响应头、请求日志）
+ * 重要程度：⭐⭐⭐ 一般（可用性增强，未配置回退链时不生效）
+ * 依赖模块：scheduler, service, middleware, model
+ */
+package handler
+
+import (
+	"encoding/json"
+	"strings"
+
+	"cli-proxy/internal/middleware"
+	"cli-proxy/internal/model"
+	"cli-proxy/internal/proxy/scheduler"
+	"cli-proxy/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// modelFallbackHeader 回退路径响应头，如 "claude-opus-4-5 -> claude-sonnet-4-5"
+const modelFallbackHeader = "X-Model-Fallback"
+
+// modelFallbackKey gin context 中回退状态的 key
+const modelFallbackKey = "model_fallback"
+
+// modelFallback 单个请求的模型回退状态
+type modelFallback struct {
+	format    string                   // 入口格式（claude/openai），未识别平台的模型按入口格式路由
+	requested string                   // 客户端请求的模型
+	current   string                   // 当前正在服务的模型
+	chain     []string                 // 尚未尝试的回退模型
+	hops      []model.ModelFallbackHop // 已发生的回退
+	// dispatch 以指定模型重新路由请求（按入口格式转换并执行），请求无法转换时返回错误
+	dispatch func(modelName string) error
+}
+
+// initModelFallback 加载请求模型的回退链，未配置时不做任何处理
+// 回退链只取请求模型自身的配置，不递归展开回退模型的回退链
+func (h *ProxyHandler) initModelFallback(c *gin.Context, format, modelName string, dispatch func(modelName string) error) {
+	chain, err := h.pricingService.GetFallbackModels(c.Request.Context(), modelName)
+	if err != nil {
+		logger.GetLogger("proxy").Error("加载模型回退链失败: %v", err)
+		return
+	}
+
+	seen := map[string]bool{modelName: true}
+	var remaining []string
+	for _, name := range chain {
+		if !seen[name] {
+			seen[name] = true
+			remaining = append(remaining, name)
+		}
+	}
+	if len(remaining) == 0 {
+		return
+	}
+
+	c.Set(modelFallbackKey, &modelFallback{
+		format:    format,
+		requested: modelName,
+		current:   modelName,
+		chain:     remaining,
+		dispatch:  dispatch,
+	})
+}
+
+// getModelFallback 获取请求的回退状态，未配置回退链时返回 nil
+func getModelFallback(c *gin.Context) *modelFallback {
+	if v, ok := c.Get(modelFallbackKey); ok {
+		if fb, ok := v.(*modelFallback); ok {
+			return fb
+		}
+	}
+	return nil
+}
+
+// hasModelFallback 请求是否还有可用的回退模型
+// 流式处理器据此延迟刷新响应头，以便回退时仍能写入 X-Model-Fallback
+func hasModelFallback(c *gin.Context) bool {
+	fb := getModelFallback(c)
+	return fb != nil && len(fb.chain) > 0
+}
+
+// fallbackToNextModel 重试耗尽后切换到回退链中的下一个模型
+// 返回 true 表示已由回退模型处理（含回退模型最终失败时写入的错误响应），调用方不应再写入响应
+func (h *ProxyHandler) fallbackToNextModel(c *gin.Context, err error) bool {
+	fb := getModelFallback(c)
+	if fb == nil || !scheduler.IsFallbackEligible(err) || c.Request.Context().Err() != nil {
+		return false
+	}
+	// 已向客户端写入内容（流式已开始输出）时无法切换
+	if c.Writer.Size() > 0 {
+		return false
+	}
+
+	log := logger.GetLogger("proxy")
+	for len(fb.chain) > 0 {
+		next := fb.chain[0]
+		fb.chain = fb.chain[1:]
+
+		if !h.fallbackAllowed(c, fb.format, next) {
+			log.Info("跳过回退模型 | From: %s | To: %s | 原因: 无权限或已禁用", fb.current, next)
+			continue
+		}
+
+		prev := fb.current
+		fb.hops = append(fb.hops, model.ModelFallbackHop{
+			From:   prev,
+			To:     next,
+			Reason: truncateForLog(err.Error(), 200),
+		})
+		fb.current = next
+		fb.setHeader(c)
+		log.Warn("模型回退 | From: %s | To: %s | 原因: %v", prev, next, err)
+
+		if dispatchErr := fb.dispatch(next); dispatchErr != nil {
+			// 请求无法转换为回退模型的格式（尚未发出请求），撤销本次回退
+			log.Warn("回退模型请求转换失败 | Model: %s | Error: %v", next, dispatchErr)
+			fb.hops = fb.hops[:len(fb.hops)-1]
+			fb.current = prev
+			fb.setHeader(c)
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// fallbackAllowed 静默检查回退模型的 API Key 权限与启用状态（不写入错误响应）
+func (h *ProxyHandler) fallbackAllowed(c *gin.Context, format, modelName string) bool {
+	if !middleware.CheckPlatformAccess(c, resolveTargetPlatform(modelName, format)) {
+		return false
+	}
+	if !middleware.CheckModelAccess(c, modelName) {
+		return false
+	}
+	enabled, exists, err := h.pricingService.IsModelEnabled(c.Request.Context(), modelName)
+	if err != nil {
+		return true
+	}
+	return !exists || enabled
+}
+
+// setHeader 写入回退路径响应头（请求模型 -> 回退模型 -> ...），未发生回退时移除
+func (fb *modelFallback) setHeader(c *gin.Context) {
+	if len(fb.hops) == 0 {
+		c.Writer.Header().Del(modelFallbackHeader)
+		return
+	}
+	names := make([]string, 0, len(fb.hops)+1)
+	names = append(names, fb.requested)
+	for _, hop := range fb.hops {
+		names = append(names, hop.To)
+	}
+	c.Header(modelFallbackHeader, strings.Join(names, " -> "))
+}
+
+// fallbackLogFields 请求日志的回退字段（未发生回退时为空）
+func fallbackLogFields(c *gin.Context) (requestedModel, hops string) {
+	fb := getModelFallback(c)
+	if fb == nil || len(fb.hops) == 0 {
+		return "", ""
+	}
+	data, _ := json.Marshal(fb.hops)
+	return fb.requested, string(data)
+}
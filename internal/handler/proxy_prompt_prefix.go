@@ -0,0 +1,107 @@
+/*
+ * 文件作用：提示前缀识别，计算系统提示和开头消息的哈希用于提示缓存亲和路由
+ * 负责功能：
+ *   - 提取请求中稳定的提示前缀（系统提示、工具定义、开头消息）
+ *   - 计算前缀哈希并设置到重试请求
+ * 重要程度：⭐⭐⭐ 一般（提升上游提示缓存命中率）
+ * 依赖模块：scheduler, service
+ */
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"cli-proxy/internal/proxy/scheduler"
+	"cli-proxy/internal/service"
+)
+
+// promptPrefixMinBytes 前缀过短时上游不会缓存（Anthropic 最少 1024 token），不做亲和
+const promptPrefixMinBytes = 4096
+
+// promptPrefixFields 构成提示前缀的字段（兼容 Claude / OpenAI Chat / Responses / Gemini 格式）
+var promptPrefixFields = []string{"system", "instructions", "systemInstruction", "system_instruction", "tools"}
+
+// withPromptAffinity 按系统配置为重试请求设置提示前缀哈希
+func withPromptAffinity(retryReq *scheduler.RetryableRequest, body []byte) *scheduler.RetryableRequest {
+	configService := service.GetConfigService()
+	if !configService.GetPromptAffinityEnabled() {
+		return retryReq
+	}
+	prefix := promptPrefixHash(body)
+	if prefix == "" {
+		return retryReq
+	}
+	return retryReq.WithPromptPrefix(prefix, configService.GetPromptAffinityTTL())
+}
+
+// promptPrefixHash 计算模型、系统提示、工具定义和开头消息的哈希，前缀过短或无法解析时返回空串
+func promptPrefixHash(body []byte) string {
+	var fields map[string]json.RawMessage
+	if len(body) == 0 || json.Unmarshal(body, &fields) != nil {
+		return ""
+	}
+
+	// Gemini Code Assist 格式将请求内容包在 request 字段内
+	content := fields
+	if raw, ok := fields["request"]; ok {
+		var inner map[string]json.RawMessage
+		if json.Unmarshal(raw, &inner) == nil {
+			content = inner
+		}
+	}
+
+	// 上游提示缓存按模型隔离，模型名参与哈希但不计入前缀长度
+	hash := sha256.New()
+	hash.Write(fields["model"])
+	hash.Write([]byte{0})
+
+	size := 0
+	write := func(key string, value []byte) {
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+		hash.Write(value)
+		hash.Write([]byte{0})
+		size += len(value)
+	}
+	for _, key := range promptPrefixFields {
+		if raw, ok := content[key]; ok {
+			write(key, raw)
+		}
+	}
+	for _, key := range []string{"messages", "contents", "input"} {
+		if raw, ok := content[key]; ok {
+			write(key, leadingMessages(raw))
+		}
+	}
+
+	if size < promptPrefixMinBytes {
+		return ""
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:32]
+}
+
+// leadingMessages 取开头的系统消息及第一条非系统消息（同一对话后续轮次中保持不变）
+// 字符串输入（Responses API）原样返回
+func leadingMessages(raw json.RawMessage) []byte {
+	var messages []json.RawMessage
+	if json.Unmarshal(raw, &messages) != nil {
+		return raw
+	}
+
+	end := 0
+	for end < len(messages) {
+		var msg struct {
+			Role string `json:"role"`
+		}
+		_ = json.Unmarshal(messages[end], &msg)
+		end++
+		if msg.Role != "system" && msg.Role != "developer" {
+			break
+		}
+	}
+
+	leading, _ := json.Marshal(messages[:end])
+	return leading
+}
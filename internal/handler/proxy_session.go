@@ -0,0 +1,154 @@
+/*
+ * 文件作用：客户端会话识别，从请求中提取客户端会话标识用于账户粘性
+ * 负责功能：
+ *   - 按入口格式的会话标识提取器（Claude Code / Codex / Gemini CLI）
+ *   - 会话 ID 规范化（按 API Key 隔离，超长标识取哈希）
+ * 重要程度：⭐⭐⭐⭐ 重要（决定会话粘性粒度与上游提示缓存命中率）
+ * 依赖模块：model
+ */
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"cli-proxy/internal/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSessionTokenLen 会话标识超过该长度时取哈希，控制绑定键长度
+const maxSessionTokenLen = 64
+
+// sessionSource 会话标识提取的数据来源（请求体按需解析一次）
+type sessionSource struct {
+	c      *gin.Context
+	body   []byte
+	parsed bool
+	fields map[string]json.RawMessage
+}
+
+// field 读取请求体中的字符串字段，支持嵌套路径，如 field("metadata", "user_id")
+func (s *sessionSource) field(path ...string) string {
+	if !s.parsed {
+		s.parsed = true
+		if len(s.body) > 0 {
+			_ = json.Unmarshal(s.body, &s.fields)
+		}
+	}
+
+	fields := s.fields
+	for i, key := range path {
+		raw, ok := fields[key]
+		if !ok {
+			return ""
+		}
+		if i == len(path)-1 {
+			var value string
+			if json.Unmarshal(raw, &value) != nil {
+				return ""
+			}
+			return strings.TrimSpace(value)
+		}
+		fields = nil
+		if json.Unmarshal(raw, &fields) != nil {
+			return ""
+		}
+	}
+	return ""
+}
+
+// sessionExtractor 会话标识提取器，未找到时返回空串
+type sessionExtractor func(src *sessionSource) string
+
+// headerSession 从请求头提取会话标识
+func headerSession(name string) sessionExtractor {
+	return func(src *sessionSource) string {
+		return strings.TrimSpace(src.c.GetHeader(name))
+	}
+}
+
+// bodySession 从请求体字段提取会话标识
+func bodySession(path ...string) sessionExtractor {
+	return func(src *sessionSource) string {
+		return src.field(path...)
+	}
+}
+
+// claudeMetadataSession Claude Code 的 metadata.user_id
+// 格式为 user_{hash}_account_{uuid}_session_{uuid}，只取会话部分；其他客户端使用完整值
+func claudeMetadataSession(src *sessionSource) string {
+	userID := src.field("metadata", "user_id")
+	if idx := strings.LastIndex(userID, "_session_"); idx >= 0 {
+		if session := userID[idx+len("_session_"):]; session != "" {
+			return session
+		}
+	}
+	return userID
+}
+
+// sessionExtractors 按入口格式的提取器，按顺序取第一个非空值
+var sessionExtractors = map[string][]sessionExtractor{
+	// Claude Code：x-session-id 请求头，其次 metadata.user_id 中的会话 ID
+	model.PlatformClaude: {
+		headerSession("x-session-id"),
+		claudeMetadataSession,
+	},
+	// Codex：session_id 请求头（每个会话固定），其次 prompt_cache_key
+	model.PlatformOpenAI: {
+		headerSession("x-session-id"),
+		headerSession("session_id"),
+		headerSession("Session-Id"),
+		bodySession("prompt_cache_key"),
+	},
+	// Gemini CLI：请求体 session_id（Code Assist 格式在 request 内），其次安装级用户 ID 请求头
+	model.PlatformGemini: {
+		headerSession("x-session-id"),
+		bodySession("session_id"),
+		bodySession("request", "session_id"),
+		headerSession("x-gemini-api-privileged-user-id"),
+	},
+}
+
+// clientSessionID 按入口格式提取客户端会话标识，客户端未提供时返回空串
+func clientSessionID(c *gin.Context, format string, body []byte) string {
+	src := &sessionSource{c: c, body: body}
+	for _, extract := range sessionExtractors[format] {
+		if token := extract(src); token != "" {
+			return normalizeSessionToken(token)
+		}
+	}
+	return ""
+}
+
+// normalizeSessionToken 超长标识取哈希，避免绑定键过长
+func normalizeSessionToken(token string) string {
+	if len(token) <= maxSessionTokenLen {
+		return token
+	}
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])[:32]
+}
+
+// scopedSessionID 加上 API Key ID 前缀，避免不同用户的会话冲突
+func scopedSessionID(c *gin.Context, token string) string {
+	if apiKeyID, ok := c.Get("api_key_id"); ok {
+		if id, ok := apiKeyID.(uint); ok {
+			return fmt.Sprintf("apikey:%d:%s", id, token)
+		}
+	}
+	return token
+}
+
+// contextRequestBody 获取保存在 context 中的原始请求体
+func contextRequestBody(c *gin.Context) []byte {
+	if rb, ok := c.Get("request_body"); ok {
+		if body, ok := rb.([]byte); ok {
+			return body
+		}
+	}
+	return nil
+}
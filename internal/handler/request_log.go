@@ -4,9 +4,10 @@
  *   - 请求日志列表查询（分页、筛选）
  *   - 请求汇总统计
  *   - 账户负载统计
+ *   - 提示缓存命中率与节省统计（按账户/API Key/模型）
  *   - 按时间范围查询
  * 重要程度：⭐⭐⭐ 一般（日志查询功能）
- * 依赖模块：repository
+ * 依赖模块：repository, service
  */
 package handler
 
@@ -16,18 +17,21 @@ import (
 	"time"
 
 	"cli-proxy/internal/repository"
+	"cli-proxy/internal/service"
 	"cli-proxy/pkg/response"
 
 	"github.com/gin-gonic/gin"
 )
 
 type RequestLogHandler struct {
-	repo *repository.RequestLogRepository
+	repo           *repository.RequestLogRepository
+	pricingService *service.PricingService
 }
 
 func NewRequestLogHandler() *RequestLogHandler {
 	return &RequestLogHandler{
-		repo: repository.NewRequestLogRepository(),
+		repo:           repository.NewRequestLogRepository(),
+		pricingService: service.NewPricingService(),
 	}
 }
 
@@ -121,3 +125,38 @@ func (h *RequestLogHandler) GetAccountLoadStats(c *gin.Context) {
 
 	response.Success(c, stats)
 }
+
+// GetCacheStats 获取提示缓存命中率与节省统计
+// group_by: account（默认）/ api_key / model
+func (h *RequestLogHandler) GetCacheStats(c *gin.Context) {
+	groupBy := c.DefaultQuery("group_by", repository.CacheStatsByAccount)
+	switch groupBy {
+	case repository.CacheStatsByAccount, repository.CacheStatsByAPIKey, repository.CacheStatsByModel:
+	default:
+		response.BadRequest(c, "group_by 仅支持 account / api_key / model")
+		return
+	}
+
+	// 默认最近24小时
+	endTime := time.Now()
+	startTime := endTime.Add(-24 * time.Hour)
+
+	if start := c.Query("start_time"); start != "" {
+		if t, err := time.Parse(time.RFC3339, start); err == nil {
+			startTime = t
+		}
+	}
+	if end := c.Query("end_time"); end != "" {
+		if t, err := time.Parse(time.RFC3339, end); err == nil {
+			endTime = t
+		}
+	}
+
+	stats, err := h.pricingService.GetCacheHitStats(c.Request.Context(), groupBy, startTime, endTime)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.Success(c, stats)
+}
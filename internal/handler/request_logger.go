@@ -3,9 +3,10 @@
  * 负责功能：
  *   - 请求日志异步写入
  *   - 日志对象构建
+ *   - 请求体/响应体按记录策略脱敏、截断、压缩
  *   - 单例模式延迟初始化
  * 重要程度：⭐⭐⭐ 一般（日志记录）
- * 依赖模块：model, repository
+ * 依赖模块：model, repository, service
  */
 package handler
 
@@ -17,6 +18,9 @@ import (
 
 	"cli-proxy/internal/model"
 	"cli-proxy/internal/repository"
+	"cli-proxy/internal/service"
+
+	"github.com/gin-gonic/gin"
 )
 
 // RequestLogger 请求日志记录器
@@ -72,32 +76,35 @@ func BuildRequestLog(
 	}
 }
 
+// ShouldCaptureBodies 按记录策略判断本次请求是否记录请求体/响应体
+func ShouldCaptureBodies(c *gin.Context, success bool) bool {
+	var debugUntil *time.Time
+	if until, ok := c.Get("api_key_body_capture_until"); ok {
+		if t, ok := until.(time.Time); ok {
+			debugUntil = &t
+		}
+	}
+	return service.GetBodyCaptureService().ShouldCapture(success, debugUntil)
+}
+
 // SetRequestDetails 设置请求详情（请求头和请求体）
+// 请求体经脱敏、截断（可选压缩）后记录，不记录时传 nil
 func SetRequestDetails(log *model.RequestLog, headers http.Header, body []byte) {
 	// 过滤敏感头部
 	filteredHeaders := filterSensitiveHeaders(headers)
 	if headersJSON, err := json.Marshal(filteredHeaders); err == nil {
 		log.RequestHeaders = string(headersJSON)
 	}
-	// 限制请求体大小（最大 64KB）
-	if len(body) > 65536 {
-		log.RequestBody = string(body[:65536]) + "...[truncated]"
-	} else {
-		log.RequestBody = string(body)
-	}
+	log.RequestBody = service.GetBodyCaptureService().Capture(body, "")
 }
 
 // SetResponseDetails 设置响应详情（响应头和响应体）
+// 响应体经脱敏、截断（可选压缩）后记录，不记录时传 nil
 func SetResponseDetails(log *model.RequestLog, headers http.Header, body []byte, upstreamStatusCode int) {
 	if headersJSON, err := json.Marshal(headers); err == nil {
 		log.ResponseHeaders = string(headersJSON)
 	}
-	// 限制响应体大小（最大 64KB）
-	if len(body) > 65536 {
-		log.ResponseBody = string(body[:65536]) + "...[truncated]"
-	} else {
-		log.ResponseBody = string(body)
-	}
+	log.ResponseBody = service.GetBodyCaptureService().Capture(body, "")
 	log.UpstreamStatusCode = upstreamStatusCode
 }
 
@@ -4,6 +4,7 @@
  *   - 公开接口路由（登录、注册、验证码）
  *   - 管理后台路由（/api/admin/*）
  *   - 代理转发路由（/claude/*, /openai/*, /responses）
+ *   - Prometheus 指标路由（/metrics）
  *   - 中间件配置（JWT、API Key、操作日志）
  *   - 静态文件服务
  * 重要程度：⭐⭐⭐⭐⭐ 核心（所有请求的入口）
@@ -24,6 +25,9 @@ func RegisterRoutes(r *gin.Engine) {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 
+	// Prometheus 指标（独立采集 Token 认证，未配置时不开放）
+	r.GET("/metrics", middleware.MetricsAuth(), Metrics)
+
 	// 全局操作日志中间件（放在认证之后，记录所有写操作）
 	r.Use(middleware.OperationLogger())
 
@@ -65,9 +69,11 @@ func RegisterRoutes(r *gin.Engine) {
 
 	// ========== 代理转发接口 (需要 API Key 认证) ==========
 	proxyGroup := r.Group("")
+	proxyGroup.Use(middleware.ProxyMetrics()) // 代理请求指标（最先执行，覆盖认证失败和限流）
 	proxyGroup.Use(middleware.APIKeyAuth())
 	proxyGroup.Use(middleware.ClientFilter())           // 客户端过滤
 	proxyGroup.Use(middleware.CheckAllowedClients())    // API Key 客户端限制检查
+	proxyGroup.Use(middleware.APIKeyRateLimit())        // API Key 频率/日请求/月额度限制（被客户端过滤拒绝的请求不计数）
 	proxyGroup.Use(middleware.UserConcurrencyControl()) // 用户并发控制
 	{
 		// ========== 按平台区分的路由 ==========
@@ -91,6 +97,10 @@ func RegisterRoutes(r *gin.Engine) {
 
 		// Gemini 平台 - 使用 Gemini 原生格式
 		proxyGroup.POST("/gemini/v1/chat", proxyHandler.GeminiChat)
+
+		// Gemini 原生 API (Gemini CLI / Google SDK) - 支持 x-goog-api-key 或 ?key= 认证
+		proxyGroup.GET("/gemini/v1beta/models", proxyHandler.GeminiListModels)
+		proxyGroup.POST("/gemini/v1beta/models/:modelAction", proxyHandler.GeminiNative)
 	}
 
 	// API Key Handler
@@ -175,9 +185,12 @@ func RegisterRoutes(r *gin.Engine) {
 			// API Key 管理（所有用户的）
 			adminAPIKeys := admin.Group("/api-keys")
 			{
-				adminAPIKeys.GET("/lookup", apiKeyHandler.AdminLookup)          // 按ID批量查询 API Key（用于前端映射显示）
-				adminAPIKeys.GET("", apiKeyHandler.AdminListAll)                // 获取所有 API Key
-				adminAPIKeys.GET("/:id/logs", apiKeyHandler.AdminGetAPIKeyLogs) // 获取 API Key 使用日志
+				adminAPIKeys.GET("/lookup", apiKeyHandler.AdminLookup)                          // 按ID批量查询 API Key（用于前端映射显示）
+				adminAPIKeys.GET("", apiKeyHandler.AdminListAll)                                // 获取所有 API Key
+				adminAPIKeys.GET("/:id/logs", apiKeyHandler.AdminGetAPIKeyLogs)                 // 获取 API Key 使用日志
+				adminAPIKeys.PUT("/:id/account-groups", apiKeyHandler.AdminUpdateAccountGroups) // 设置 API Key 绑定的账户分组
+				adminAPIKeys.PUT("/:id/hedge", apiKeyHandler.AdminUpdateHedge)                  // 设置 API Key 请求对冲
+				adminAPIKeys.PUT("/:id/body-capture", apiKeyHandler.AdminUpdateBodyCapture)     // 设置 API Key 调试记录请求体/响应体
 			}
 
 			// 账户管理
@@ -230,6 +243,7 @@ func RegisterRoutes(r *gin.Engine) {
 				logs.GET("", requestLogHandler.List)
 				logs.GET("/summary", requestLogHandler.GetSummary)
 				logs.GET("/account-load", requestLogHandler.GetAccountLoadStats)
+				logs.GET("/cache-stats", requestLogHandler.GetCacheStats)        // 提示缓存命中率与节省（group_by=account/api_key/model）
 				logs.GET("/usage-summary", usageHandler.AdminGetAllUsageSummary) // 所有用户使用汇总（MySQL）
 			}
 
@@ -275,9 +289,13 @@ func RegisterRoutes(r *gin.Engine) {
 				cache.GET("/stats", cacheHandler.GetStats)                       // 获取缓存统计
 				cache.GET("/sessions", cacheHandler.ListSessions)                // 列出所有会话
 				cache.DELETE("/sessions/:sessionId", cacheHandler.RemoveSession) // 移除会话
+				cache.POST("/sessions/pin", cacheHandler.PinSessions)            // 批量固定会话到账户
+				cache.POST("/sessions/migrate", cacheHandler.MigrateSessions)    // 批量迁移会话到账户
+				cache.POST("/sessions/expire", cacheHandler.ExpireSessions)      // 批量过期会话
 				cache.GET("/accounts", cacheHandler.ListAccountsCache)           // 列出有缓存的账号（聚合）
 				cache.GET("/users", cacheHandler.ListUsersCache)                 // 列出有缓存的用户（聚合）
 				cache.GET("/unavailable", cacheHandler.ListUnavailableAccounts)  // 列出不可用账户
+				cache.GET("/breakers", cacheHandler.ListCircuitBreakers)         // 列出账户熔断器状态
 				cache.POST("/clear", cacheHandler.ClearCache)                    // 按类型清理缓存
 				cache.DELETE("/users/:id", cacheHandler.ClearUserCache)          // 清理用户缓存
 				cache.DELETE("/api-keys/:id", cacheHandler.ClearAPIKeyCache)     // 清理 API Key 缓存
@@ -294,6 +312,7 @@ func RegisterRoutes(r *gin.Engine) {
 				accountCache.GET("/concurrency", cacheHandler.GetAccountConcurrency)      // 获取并发信息
 				accountCache.PUT("/concurrency", cacheHandler.SetAccountConcurrencyLimit) // 设置并发限制
 				accountCache.DELETE("/concurrency", cacheHandler.ResetAccountConcurrency) // 重置并发计数
+				accountCache.DELETE("/breaker", cacheHandler.ResetCircuitBreaker)         // 重置熔断器
 			}
 
 			// 系统配置管理
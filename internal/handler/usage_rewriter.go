@@ -0,0 +1,413 @@
+/*
+ * 文件作用：流式 token 用量改写器，按 API Key 倍率改写返回给客户端的 usage
+ * 负责功能：
+ *   - SSE 增量解析：按事件边界缓冲（兼容事件跨多次写入拆分）
+ *   - JSON 数组流解析（Gemini 原生非 SSE 流）：按数组元素缓冲
+ *   - 仅改写 usage 对象内的 token 数字（Claude / OpenAI / Responses / Gemini），正文内容原样保留
+ *   - 保持 Flush 语义，流结束时 Close 输出剩余数据
+ * 重要程度：⭐⭐⭐⭐ 重要（计费展示一致性）
+ * 依赖模块：无
+ */
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxPendingEventSize 单个事件最大缓冲字节数，超过后原样透传直到事件结束（避免异常上游撑爆内存）
+const maxPendingEventSize = 8 * 1024 * 1024
+
+// usagePaths 各平台 usage 对象在事件 JSON 中的位置
+var usagePaths = map[string]bool{
+	"usage":                  true, // Claude message_delta / OpenAI chat.completion.chunk
+	"message.usage":          true, // Claude message_start
+	"response.usage":         true, // Responses API response.completed 等
+	"usageMetadata":          true, // Gemini
+	"response.usageMetadata": true, // Gemini Code Assist 包装格式
+}
+
+// UsageRewriter 用量改写写入器，包装 io.Writer 并将倍率应用到流中的 usage 对象
+// 心跳 goroutine 可能并发写入，内部加锁
+type UsageRewriter struct {
+	mu      sync.Mutex
+	writer  io.Writer
+	rate    float64
+	sse     bool
+	buf     []byte
+	scanned int  // buf 中已扫描（未找到边界）的位置
+	skip    bool // 当前事件超过缓冲上限，原样透传到事件结束
+	closed  bool
+	json    jsonStreamState
+}
+
+// jsonStreamState JSON 数组流扫描状态
+type jsonStreamState struct {
+	depth     int
+	inString  bool
+	escaped   bool
+	topArray  bool
+	elemStart int // 当前元素在 buf 中的起始位置，-1 表示不在元素内
+}
+
+// NewUsageRewriter 创建用量改写写入器，sse 为 false 时按 JSON 数组流（Gemini 原生 alt=json）处理
+func NewUsageRewriter(w io.Writer, rate float64, sse bool) *UsageRewriter {
+	return &UsageRewriter{writer: w, rate: rate, sse: sse, json: jsonStreamState{elemStart: -1}}
+}
+
+// Write 实现 io.Writer 接口，完整事件改写后写入，不完整事件缓冲到下次写入
+// 返回原始长度，避免调用者认为写入不完整
+func (rw *UsageRewriter) Write(p []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.rate == 1.0 || rw.closed {
+		return rw.writer.Write(p)
+	}
+	rw.buf = append(rw.buf, p...)
+	var err error
+	if rw.sse {
+		err = rw.drainSSE()
+	} else {
+		err = rw.drainJSON()
+	}
+	return len(p), err
+}
+
+// Flush 实现 http.Flusher 接口（如果底层 writer 支持）
+// 不完整的事件继续缓冲，客户端本就无法解析半个事件
+func (rw *UsageRewriter) Flush() {
+	if f, ok := rw.writer.(interface{ Flush() }); ok {
+		f.Flush()
+	}
+}
+
+// Close 流结束时输出剩余缓冲数据（上游缺少结尾空行时仍尝试改写），之后的写入原样透传
+// 必须在调用方直接向底层 writer 追加数据（如 [DONE]、错误事件）之前调用
+func (rw *UsageRewriter) Close() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.closed {
+		return nil
+	}
+	rw.closed = true
+	if len(rw.buf) == 0 {
+		return nil
+	}
+	rest := rw.buf
+	rw.buf = nil
+	if rw.sse && !rw.skip {
+		rest = rewriteSSEEvent(rest, rw.rate)
+	} else if !rw.sse && rw.json.elemStart >= 0 {
+		rest = append(append([]byte{}, rest[:rw.json.elemStart]...), rewriteUsage(rest[rw.json.elemStart:], rw.rate)...)
+	}
+	_, err := rw.writer.Write(rest)
+	return err
+}
+
+// drainSSE 输出 buf 中所有完整事件
+func (rw *UsageRewriter) drainSSE() error {
+	for {
+		end := sseEventEnd(rw.buf, rw.scanned)
+		if end < 0 {
+			rw.scanned = len(rw.buf)
+			if rw.skip || len(rw.buf) > maxPendingEventSize {
+				// 超大事件：已缓冲部分原样输出，剩余部分透传到事件结束
+				rw.skip = true
+				return rw.emit(len(rw.buf), false)
+			}
+			return nil
+		}
+		skip := rw.skip
+		rw.skip = false
+		if err := rw.emit(end, !skip); err != nil {
+			return err
+		}
+	}
+}
+
+// emit 输出 buf[:n]（rewrite 为 true 时先改写），并从 buf 中移除
+func (rw *UsageRewriter) emit(n int, rewrite bool) error {
+	if n == 0 {
+		return nil
+	}
+	out := rw.buf[:n]
+	if rewrite {
+		out = rewriteSSEEvent(out, rw.rate)
+	}
+	_, err := rw.writer.Write(out)
+	rw.buf = append(rw.buf[:0], rw.buf[n:]...)
+	rw.scanned = 0
+	return err
+}
+
+// sseEventEnd 返回第一个事件的结束位置（空行之后），未找到返回 -1
+// 支持 \n 和 \r\n 换行；from 之前的换行已检查过
+func sseEventEnd(buf []byte, from int) int {
+	for i := from; i < len(buf); i++ {
+		if buf[i] != '\n' {
+			continue
+		}
+		j := i
+		if j > 0 && buf[j-1] == '\r' {
+			j--
+		}
+		if j == 0 || buf[j-1] == '\n' {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// rewriteSSEEvent 改写单个 SSE 事件的 data 字段，无需改写时原样返回
+// 多行 data 按换行拼接后改写，再逐行输出为 data 行（改写不增删换行）；
+// 其余字段（event/id/注释）与换行风格保持不变
+func rewriteSSEEvent(event []byte, rate float64) []byte {
+	if !bytes.Contains(event, []byte(`"usage`)) {
+		return event
+	}
+
+	lines := bytes.SplitAfter(event, []byte("\n"))
+	var data [][]byte
+	for _, line := range lines {
+		if value, ok := sseDataValue(line); ok {
+			data = append(data, value)
+		}
+	}
+	if len(data) == 0 {
+		return event
+	}
+	payload := bytes.Join(data, []byte("\n"))
+	rewritten := rewriteUsage(payload, rate)
+	if bytes.Equal(rewritten, payload) {
+		return event
+	}
+
+	rewrittenLines := bytes.Split(rewritten, []byte("\n"))
+	out := make([]byte, 0, len(event)+16)
+	next := 0
+	for _, line := range lines {
+		if _, ok := sseDataValue(line); !ok {
+			out = append(out, line...)
+			continue
+		}
+		out = append(out, "data: "...)
+		out = append(out, rewrittenLines[next]...)
+		out = append(out, lineEnding(line)...)
+		next++
+	}
+	return out
+}
+
+// sseDataValue 解析 data 行的值（去掉 "data:" 和一个可选空格）
+func sseDataValue(line []byte) ([]byte, bool) {
+	content := bytes.TrimRight(line, "\r\n")
+	if !bytes.HasPrefix(content, []byte("data:")) {
+		return nil, false
+	}
+	value := content[len("data:"):]
+	if len(value) > 0 && value[0] == ' ' {
+		value = value[1:]
+	}
+	return value, true
+}
+
+func lineEnding(line []byte) string {
+	switch {
+	case bytes.HasSuffix(line, []byte("\r\n")):
+		return "\r\n"
+	case bytes.HasSuffix(line, []byte("\n")):
+		return "\n"
+	}
+	return ""
+}
+
+// drainJSON 扫描 JSON 数组流，元素外的字节直接输出，完整元素改写后输出
+func (rw *UsageRewriter) drainJSON() error {
+	s := &rw.json
+	start := 0 // buf 中尚未输出的起始位置
+	for i := rw.scanned; i < len(rw.buf); i++ {
+		c := rw.buf[i]
+		if s.inString {
+			switch {
+			case s.escaped:
+				s.escaped = false
+			case c == '\\':
+				s.escaped = true
+			case c == '"':
+				s.inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			s.inString = true
+		case '[', '{':
+			if s.depth == 0 && c == '[' {
+				s.topArray = true
+			} else if s.elemStart < 0 && c == '{' && (s.depth == 0 || (s.depth == 1 && s.topArray)) {
+				// 元素开始：之前的分隔符等原样输出
+				if _, err := rw.writer.Write(rw.buf[start:i]); err != nil {
+					return err
+				}
+				start = i
+				s.elemStart = i
+			}
+			s.depth++
+		case ']', '}':
+			s.depth--
+			if s.elemStart >= 0 && (s.depth == 0 || (s.depth == 1 && s.topArray)) {
+				if _, err := rw.writer.Write(rewriteUsage(rw.buf[s.elemStart:i+1], rw.rate)); err != nil {
+					return err
+				}
+				start = i + 1
+				s.elemStart = -1
+			}
+			if s.depth <= 0 {
+				s.depth = 0
+				s.topArray = false
+			}
+		}
+	}
+
+	// 元素外的字节立即输出，元素内的继续缓冲
+	end := len(rw.buf)
+	if s.elemStart >= 0 {
+		end = s.elemStart
+		if len(rw.buf)-s.elemStart > maxPendingEventSize {
+			end = len(rw.buf)
+			s.elemStart = -1
+		}
+	}
+	if end > start {
+		if _, err := rw.writer.Write(rw.buf[start:end]); err != nil {
+			return err
+		}
+	}
+	rw.buf = append(rw.buf[:0], rw.buf[end:]...)
+	rw.scanned = len(rw.buf)
+	if s.elemStart >= 0 {
+		s.elemStart = 0
+	}
+	return nil
+}
+
+// usagePatch 待替换的 token 数字位置
+type usagePatch struct {
+	start, end int
+	value      string
+}
+
+// usageFrame JSON 解析栈帧
+type usageFrame struct {
+	object   bool
+	key      string // 对象中当前字段名
+	expected bool   // 对象中下一个 token 是否为 key
+}
+
+// rewriteUsage 将倍率应用到 JSON 文档中 usage 对象的 token 字段
+// 只替换数字本身，其余字节（字段顺序、空白、转义）保持不变；无法解析或无需改写时原样返回
+func rewriteUsage(data []byte, rate float64) []byte {
+	if rate == 1.0 || !bytes.Contains(data, []byte(`"usage`)) {
+		return data
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var stack []usageFrame
+	usageDepth := -1 // usage 对象所在的栈深度，-1 表示不在 usage 内
+	var patches []usagePatch
+
+	// valueDone 一个值结束后，所在对象的下一个 token 为 key
+	valueDone := func() {
+		if n := len(stack); n > 0 && stack[n-1].object {
+			stack[n-1].expected = true
+		}
+	}
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return data
+		}
+
+		// 对象的 key
+		if n := len(stack); n > 0 && stack[n-1].object && stack[n-1].expected {
+			if key, ok := tok.(string); ok {
+				stack[n-1].key = key
+				stack[n-1].expected = false
+				continue
+			}
+		}
+
+		switch v := tok.(type) {
+		case json.Delim:
+			switch v {
+			case '{', '[':
+				if v == '{' && usageDepth < 0 && usagePaths[usagePath(stack)] {
+					usageDepth = len(stack)
+				}
+				stack = append(stack, usageFrame{object: v == '{', expected: v == '{'})
+			case '}', ']':
+				stack = stack[:len(stack)-1]
+				if usageDepth == len(stack) {
+					usageDepth = -1
+				}
+				valueDone()
+			}
+		case json.Number:
+			if n := len(stack); usageDepth >= 0 && n > 0 && stack[n-1].object && isTokenField(stack[n-1].key) {
+				if num, err := v.Int64(); err == nil {
+					end := int(dec.InputOffset())
+					patches = append(patches, usagePatch{
+						start: end - len(v),
+						end:   end,
+						value: strconv.FormatInt(int64(float64(num)*rate), 10),
+					})
+				}
+			}
+			valueDone()
+		default:
+			valueDone()
+		}
+	}
+	if len(patches) == 0 {
+		return data
+	}
+
+	out := make([]byte, 0, len(data))
+	last := 0
+	for _, p := range patches {
+		out = append(out, data[last:p.start]...)
+		out = append(out, p.value...)
+		last = p.end
+	}
+	return append(out, data[last:]...)
+}
+
+// usagePath 以 "." 拼接从根到当前位置的字段名，经过数组时返回空串（usage 不在数组内）
+func usagePath(stack []usageFrame) string {
+	keys := make([]string, 0, len(stack))
+	for _, f := range stack {
+		if !f.object {
+			return ""
+		}
+		keys = append(keys, f.key)
+	}
+	return strings.Join(keys, ".")
+}
+
+// isTokenField 是否为 token 计数字段（OpenAI/Claude 的 *_tokens、Gemini 的 *TokenCount / tokenCount）
+func isTokenField(key string) bool {
+	return strings.HasSuffix(key, "_tokens") || strings.HasSuffix(key, "TokenCount") || key == "tokenCount"
+}
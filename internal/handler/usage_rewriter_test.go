@@ -0,0 +1,272 @@
+/*
+ * 文件作用：流式 token 用量改写器测试
+ * 负责功能：
+ *   - Claude / OpenAI / Responses / Gemini SSE 与 Gemini JSON 数组流的 usage 改写结果
+ *   - 模糊测试：任意位置拆分写入与单次写入输出一致、正文字符串不被改写、Flush 透传
+ * 重要程度：⭐⭐ 辅助（测试）
+ * 依赖模块：无
+ */
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// flushRecorder 记录写入内容与 Flush 次数
+type flushRecorder struct {
+	bytes.Buffer
+	flushes int
+}
+
+func (r *flushRecorder) Flush() { r.flushes++ }
+
+// usageStream 测试用的流式响应模板，%[1]s 处填入 JSON 编码后的正文
+type usageStream struct {
+	name     string
+	sse      bool
+	template string
+	want     string // 倍率 1.5、正文为 "hi" 时的期望输出
+}
+
+var usageStreams = []usageStream{
+	{
+		name: "claude sse",
+		sse:  true,
+		template: "event: message_start\n" +
+			`data: {"type":"message_start","message":{"id":"msg_1","content":[],"usage":{"input_tokens":120,"cache_read_input_tokens":40,"output_tokens":1}}}` + "\n\n" +
+			"event: content_block_delta\n" +
+			`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":%[1]s}}` + "\n\n" +
+			"event: message_delta\n" +
+			`data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":37}}` + "\n\n" +
+			"event: message_stop\n" +
+			`data: {"type":"message_stop"}` + "\n\n",
+		want: "event: message_start\n" +
+			`data: {"type":"message_start","message":{"id":"msg_1","content":[],"usage":{"input_tokens":180,"cache_read_input_tokens":60,"output_tokens":1}}}` + "\n\n" +
+			"event: content_block_delta\n" +
+			`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"hi"}}` + "\n\n" +
+			"event: message_delta\n" +
+			`data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":55}}` + "\n\n" +
+			"event: message_stop\n" +
+			`data: {"type":"message_stop"}` + "\n\n",
+	},
+	{
+		name: "claude sse multi-line data",
+		sse:  true,
+		template: "event: content_block_delta\n" +
+			`data: {"type":"content_block_delta","index":0,` + "\n" +
+			`data: "delta":{"type":"text_delta","text":%[1]s}}` + "\n\n" +
+			"event: message_delta\r\n" +
+			`data: {"type":"message_delta",` + "\r\n" +
+			`data:"usage":{"output_tokens":37,` + "\r\n" +
+			"id: 7\r\n" +
+			`data: "input_tokens":8}}` + "\r\n\r\n",
+		want: "event: content_block_delta\n" +
+			`data: {"type":"content_block_delta","index":0,` + "\n" +
+			`data: "delta":{"type":"text_delta","text":"hi"}}` + "\n\n" +
+			"event: message_delta\r\n" +
+			`data: {"type":"message_delta",` + "\r\n" +
+			`data: "usage":{"output_tokens":55,` + "\r\n" +
+			"id: 7\r\n" +
+			`data: "input_tokens":12}}` + "\r\n\r\n",
+	},
+	{
+		name: "openai sse",
+		sse:  true,
+		template: `data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":%[1]s}}]}` + "\n\n" +
+			`data: {"id":"c1","object":"chat.completion.chunk","choices":[],"usage":{"prompt_tokens":80,"completion_tokens":20,"total_tokens":100,"prompt_tokens_details":{"cached_tokens":10}}}` + "\n\n" +
+			"data: [DONE]\n\n",
+		want: `data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"hi"}}]}` + "\n\n" +
+			`data: {"id":"c1","object":"chat.completion.chunk","choices":[],"usage":{"prompt_tokens":120,"completion_tokens":30,"total_tokens":150,"prompt_tokens_details":{"cached_tokens":15}}}` + "\n\n" +
+			"data: [DONE]\n\n",
+	},
+	{
+		name: "responses sse",
+		sse:  true,
+		template: "event: response.output_text.delta\n" +
+			`data: {"type":"response.output_text.delta","delta":%[1]s}` + "\n\n" +
+			"event: response.completed\n" +
+			`data: {"type":"response.completed","response":{"id":"r1","output":[{"type":"message","content":[{"type":"output_text","text":%[1]s}]}],"usage":{"input_tokens":64,"output_tokens":16,"total_tokens":80}}}` + "\n\n",
+		want: "event: response.output_text.delta\n" +
+			`data: {"type":"response.output_text.delta","delta":"hi"}` + "\n\n" +
+			"event: response.completed\n" +
+			`data: {"type":"response.completed","response":{"id":"r1","output":[{"type":"message","content":[{"type":"output_text","text":"hi"}]}],"usage":{"input_tokens":96,"output_tokens":24,"total_tokens":120}}}` + "\n\n",
+	},
+	{
+		name: "gemini sse crlf",
+		sse:  true,
+		template: `data: {"candidates":[{"content":{"parts":[{"text":%[1]s}],"role":"model"}}]}` + "\r\n\r\n" +
+			`data: {"candidates":[{"content":{"parts":[{"text":""}],"role":"model"},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":30,"candidatesTokenCount":7,"totalTokenCount":37}}` + "\r\n\r\n",
+		want: `data: {"candidates":[{"content":{"parts":[{"text":"hi"}],"role":"model"}}]}` + "\r\n\r\n" +
+			`data: {"candidates":[{"content":{"parts":[{"text":""}],"role":"model"},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":45,"candidatesTokenCount":10,"totalTokenCount":55}}` + "\r\n\r\n",
+	},
+	{
+		name: "gemini json array",
+		sse:  false,
+		template: `[{"candidates":[{"content":{"parts":[{"text":%[1]s}],"role":"model"}}]}` + "\r\n" +
+			`,{"candidates":[{"content":{"parts":[{"text":%[1]s}],"role":"model"},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":30,"candidatesTokenCount":7,"totalTokenCount":37}}` + "\r\n]",
+		want: `[{"candidates":[{"content":{"parts":[{"text":"hi"}],"role":"model"}}]}` + "\r\n" +
+			`,{"candidates":[{"content":{"parts":[{"text":"hi"}],"role":"model"},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":45,"candidatesTokenCount":10,"totalTokenCount":55}}` + "\r\n]",
+	},
+}
+
+// render 将正文 JSON 编码后填入模板
+func (s usageStream) render(content string) []byte {
+	encoded, _ := json.Marshal(content)
+	return []byte(fmt.Sprintf(s.template, encoded))
+}
+
+// rewriteAll 按给定分片写入改写器并在每次写入后 Flush，返回输出与底层 Flush 次数
+func rewriteAll(t testing.TB, chunks [][]byte, rate float64, sse bool) ([]byte, int) {
+	t.Helper()
+	rec := &flushRecorder{}
+	rw := NewUsageRewriter(rec, rate, sse)
+	for _, chunk := range chunks {
+		n, err := rw.Write(chunk)
+		if err != nil || n != len(chunk) {
+			t.Fatalf("Write = %d, %v; want %d, nil", n, err, len(chunk))
+		}
+		rw.Flush()
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return rec.Bytes(), rec.flushes
+}
+
+// splitAt 按偏移拆分数据（偏移累加取模后排序，重复位置只切一次）
+func splitAt(data []byte, offsets []byte) [][]byte {
+	if len(data) == 0 {
+		return [][]byte{data}
+	}
+	var cuts []int
+	pos := 0
+	for _, o := range offsets {
+		pos = (pos + int(o)) % len(data)
+		cuts = append(cuts, pos)
+	}
+	sort.Ints(cuts)
+	var chunks [][]byte
+	last := 0
+	for _, c := range cuts {
+		if c > last {
+			chunks = append(chunks, data[last:c])
+			last = c
+		}
+	}
+	return append(chunks, data[last:])
+}
+
+// jsonStrings 按顺序提取输出中所有 JSON 文档的字符串（字段名与字符串值）
+// SSE 按 data 行解析，JSON 数组流整体解析；改写只应改变数字
+func jsonStrings(t testing.TB, out []byte, sse bool) []string {
+	t.Helper()
+	var docs [][]byte
+	if sse {
+		for _, line := range bytes.Split(out, []byte("\n")) {
+			if value, ok := sseDataValue(line); ok && json.Valid(value) {
+				docs = append(docs, value)
+			}
+		}
+	} else {
+		docs = append(docs, out)
+	}
+
+	var strs []string
+	for _, doc := range docs {
+		dec := json.NewDecoder(bytes.NewReader(doc))
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("output is not valid JSON: %v\n%s", err, doc)
+			}
+			if s, ok := tok.(string); ok {
+				strs = append(strs, s)
+			}
+		}
+	}
+	return strs
+}
+
+func TestUsageRewriter(t *testing.T) {
+	for _, stream := range usageStreams {
+		t.Run(stream.name, func(t *testing.T) {
+			body := stream.render("hi")
+
+			got, _ := rewriteAll(t, [][]byte{body}, 1.5, stream.sse)
+			if string(got) != stream.want {
+				t.Errorf("rate 1.5 output mismatch\n got: %q\nwant: %q", got, stream.want)
+			}
+
+			// 逐字节写入与单次写入一致
+			var chunks [][]byte
+			for i := range body {
+				chunks = append(chunks, body[i:i+1])
+			}
+			if got, _ := rewriteAll(t, chunks, 1.5, stream.sse); string(got) != stream.want {
+				t.Errorf("byte-by-byte output mismatch\n got: %q\nwant: %q", got, stream.want)
+			}
+
+			// 倍率为 1 时原样透传
+			if got, _ := rewriteAll(t, [][]byte{body}, 1, stream.sse); !bytes.Equal(got, body) {
+				t.Errorf("rate 1 output = %q, want unchanged", got)
+			}
+		})
+	}
+}
+
+func TestUsageRewriterLeavesContentUntouched(t *testing.T) {
+	// 正文中出现 usage 字样与 token 字段时不改写
+	content := `{"usage":{"input_tokens":100,"output_tokens":5}} "usageMetadata":{"totalTokenCount":9}`
+	for _, stream := range usageStreams {
+		t.Run(stream.name, func(t *testing.T) {
+			body := stream.render(content)
+			got, _ := rewriteAll(t, [][]byte{body}, 2, stream.sse)
+			encoded, _ := json.Marshal(content)
+			if bytes.Count(got, encoded) != bytes.Count(body, encoded) {
+				t.Errorf("content was rewritten\n got: %s", got)
+			}
+		})
+	}
+}
+
+func FuzzUsageRewriter(f *testing.F) {
+	f.Add(uint8(0), uint8(0), "hi", []byte{7})
+	f.Add(uint8(1), uint8(1), "line\n\nbreak", []byte{1, 1, 1, 200})
+	f.Add(uint8(2), uint8(2), `"usage":{"input_tokens":100}`, []byte{13, 29, 31, 97})
+	f.Add(uint8(3), uint8(0), "data: {\"usage\":{\"output_tokens\":3}}\r\n\r\n", []byte{3, 250, 5})
+	f.Add(uint8(4), uint8(1), `[{"usageMetadata":{"promptTokenCount":1}}]`, []byte{2, 4, 8, 16, 32, 64, 128})
+	f.Add(uint8(4), uint8(2), "\\\"}]", []byte{255, 255, 1})
+
+	rates := []float64{0.5, 1.5, 2}
+	f.Fuzz(func(t *testing.T, streamIndex, rateIndex uint8, content string, offsets []byte) {
+		stream := usageStreams[int(streamIndex)%len(usageStreams)]
+		rate := rates[int(rateIndex)%len(rates)]
+		body := stream.render(content)
+
+		want, _ := rewriteAll(t, [][]byte{body}, rate, stream.sse)
+		chunks := splitAt(body, offsets)
+		got, flushes := rewriteAll(t, chunks, rate, stream.sse)
+
+		if !bytes.Equal(got, want) {
+			t.Fatalf("split write output differs from single write\nchunks: %q\n got: %q\nwant: %q", chunks, got, want)
+		}
+		if flushes != len(chunks) {
+			t.Errorf("underlying writer flushed %d times, want %d", flushes, len(chunks))
+		}
+
+		// 只有 usage 内的数字会被改写：所有字符串（含正文）保持不变
+		gotStrings, wantStrings := jsonStrings(t, got, stream.sse), jsonStrings(t, body, stream.sse)
+		if strings.Join(gotStrings, "\x00") != strings.Join(wantStrings, "\x00") {
+			t.Errorf("strings changed\n got: %q\nwant: %q", gotStrings, wantStrings)
+		}
+	})
+}
@@ -145,9 +145,10 @@ func (h *UserHandler) UpdateProfile(c *gin.Context) {
 		return
 	}
 
-	// 普通用户不能修改 role 和 status
+	// 普通用户不能修改 role、status 和账户分组绑定
 	req.Role = ""
 	req.Status = ""
+	req.AccountGroupIDs = nil
 
 	user, err := h.service.Update(userID, &req)
 	if err != nil {
@@ -7,11 +7,12 @@
  *   - 费率倍率应用
  *   - 请求日志记录
  * 重要程度：⭐⭐⭐⭐⭐ 核心（代理认证核心）
- * 依赖模块：service, repository, model
+ * 依赖模块：service, repository, model, tracing
  */
 package middleware
 
 import (
+	"errors"
 	"strings"
 
 	"cli-proxy/internal/model"
@@ -19,6 +20,7 @@ import (
 	"cli-proxy/internal/service"
 	"cli-proxy/pkg/logger"
 	"cli-proxy/pkg/response"
+	"cli-proxy/pkg/tracing"
 
 	"github.com/gin-gonic/gin"
 )
@@ -31,6 +33,8 @@ func APIKeyAuth() gin.HandlerFunc {
 	log := logger.GetLogger("auth")
 
 	return func(c *gin.Context) {
+		_, span := tracing.Start(c.Request.Context(), "auth.api_key")
+
 		// 从 Header 获取 API Key（支持多种格式）
 		apiKey := c.GetHeader("Authorization")
 		if apiKey == "" {
@@ -39,10 +43,18 @@ func APIKeyAuth() gin.HandlerFunc {
 		if apiKey == "" {
 			apiKey = c.GetHeader("x-api-key") // Claude 标准格式
 		}
+		if apiKey == "" {
+			apiKey = c.GetHeader("x-goog-api-key") // Gemini 原生格式
+		}
+		if apiKey == "" && strings.HasPrefix(c.Request.URL.Path, "/gemini/") {
+			apiKey = takeQueryAPIKey(c)
+		}
 
 		if apiKey == "" {
 			log.Debug("API Key 认证失败 | IP: %s | 原因: 缺少API Key", c.ClientIP())
-			response.CustomUnauthorizedAbort(c, model.ErrorTypeAuthFailed, "缺少 API Key，请在 Authorization 或 x-api-key header 中提供")
+			response.CustomUnauthorizedAbort(c, model.ErrorTypeAuthFailed, "缺少 API Key，请在 Authorization、x-api-key 或 x-goog-api-key header 中提供")
+			span.RecordError(errors.New("missing api key"))
+			span.End()
 			return
 		}
 
@@ -58,6 +70,8 @@ func APIKeyAuth() gin.HandlerFunc {
 			// 根据错误内容确定错误类型
 			errorType := getAPIKeyErrorType(err.Error())
 			response.CustomUnauthorizedAbort(c, errorType, err.Error())
+			span.RecordError(err)
+			span.End()
 			return
 		}
 
@@ -69,7 +83,12 @@ func APIKeyAuth() gin.HandlerFunc {
 		c.Set("api_key_user_id", key.UserID)
 		c.Set("api_key_allowed_platforms", key.AllowedPlatforms)
 		c.Set("api_key_allowed_models", key.AllowedModels)
+		c.Set("api_key_blocked_models", key.BlockedModels)
 		c.Set("api_key_rate_limit", key.RateLimit)
+		c.Set("api_key_hedge_enabled", key.HedgeEnabled)
+		if key.BodyCaptureUntil != nil {
+			c.Set("api_key_body_capture_until", *key.BodyCaptureUntil)
+		}
 
 		// 添加套餐信息（用于扣费）
 		if key.UserPackageID != nil {
@@ -97,6 +116,9 @@ func APIKeyAuth() gin.HandlerFunc {
 		log.Info("API Key 认证 | KeyID: %d | GlobalRate: %.2f | FinalRate: %.2f | Path: %s",
 			key.ID, globalRate, priceRate, c.Request.URL.Path)
 
+		span.SetAttributes(tracing.Uint("api_key.id", key.ID), tracing.Uint("user.id", key.UserID))
+		span.End()
+
 		c.Next()
 	}
 }
@@ -141,7 +163,16 @@ func CheckPlatformAccess(c *gin.Context, platform string) bool {
 }
 
 // CheckModelAccess 检查模型访问权限
+// 先检查禁止列表，再检查允许列表；支持通配符（如 claude-opus-*）
 func CheckModelAccess(c *gin.Context, modelName string) bool {
+	if blocked, exists := c.Get("api_key_blocked_models"); exists {
+		if blockedStr, ok := blocked.(string); ok && blockedStr != "" {
+			if matchModelList(blockedStr, modelName) {
+				return false
+			}
+		}
+	}
+
 	allowed, exists := c.Get("api_key_allowed_models")
 	if !exists {
 		return true
@@ -153,16 +184,50 @@ func CheckModelAccess(c *gin.Context, modelName string) bool {
 	}
 
 	// 检查是否在允许的模型列表中
-	models := strings.Split(allowedStr, ",")
-	for _, m := range models {
-		if strings.TrimSpace(m) == modelName {
+	return matchModelList(allowedStr, modelName)
+}
+
+// matchModelList 检查模型是否匹配逗号分隔的模型列表中的任一项
+func matchModelList(list, modelName string) bool {
+	for _, m := range strings.Split(list, ",") {
+		if MatchModelPattern(strings.TrimSpace(m), modelName) {
 			return true
 		}
 	}
-
 	return false
 }
 
+// MatchModelPattern 模型名通配符匹配（不区分大小写）
+// 支持 "*" 匹配任意字符，如 "claude-opus-*"、"*-haiku-*"、"*"
+func MatchModelPattern(pattern, modelName string) bool {
+	if pattern == "" {
+		return false
+	}
+	pattern = strings.ToLower(pattern)
+	modelName = strings.ToLower(modelName)
+
+	if !strings.Contains(pattern, "*") {
+		return pattern == modelName
+	}
+
+	parts := strings.Split(pattern, "*")
+	// 首段必须是前缀
+	if !strings.HasPrefix(modelName, parts[0]) {
+		return false
+	}
+	rest := modelName[len(parts[0]):]
+	// 末段必须是后缀
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		idx := strings.Index(rest, part)
+		if idx < 0 {
+			return false
+		}
+		rest = rest[idx+len(part):]
+	}
+	return strings.HasSuffix(rest, last)
+}
+
 // getAPIKeyErrorType 根据错误信息判断错误类型
 func getAPIKeyErrorType(errMsg string) string {
 	switch {
@@ -176,3 +241,15 @@ func getAPIKeyErrorType(errMsg string) string {
 		return model.ErrorTypeAuthFailed
 	}
 }
+
+// takeQueryAPIKey 读取 Gemini 原生客户端的 ?key= 参数，并从请求 URL 中移除，避免后续处理再用到
+// 访问日志在认证前已读取查询串，由 Logger 中间件统一脱敏
+func takeQueryAPIKey(c *gin.Context) string {
+	query := c.Request.URL.Query()
+	apiKey := query.Get("key")
+	if apiKey != "" {
+		query.Del("key")
+		c.Request.URL.RawQuery = query.Encode()
+	}
+	return apiKey
+}
@@ -7,13 +7,14 @@
  *   - 验证结果日志记录
  *   - API Key客户端限制检查
  * 重要程度：⭐⭐⭐⭐ 重要（安全过滤）
- * 依赖模块：service, model, cache
+ * 依赖模块：service, model, cache, tracing
  */
 package middleware
 
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io"
 	"strings"
 
@@ -21,6 +22,7 @@ import (
 	"cli-proxy/internal/service"
 	"cli-proxy/pkg/logger"
 	"cli-proxy/pkg/response"
+	"cli-proxy/pkg/tracing"
 
 	"github.com/gin-gonic/gin"
 )
@@ -41,7 +43,13 @@ func ClientFilter() gin.HandlerFunc {
 		reqCtx := buildRequestContext(c)
 
 		// 执行验证
+		_, span := tracing.Start(c.Request.Context(), "client_filter")
 		result := filterService.ValidateRequest(reqCtx)
+		span.SetAttributes(tracing.String("client.type", result.ClientType), tracing.Bool("client.allowed", result.Allowed))
+		if !result.Allowed {
+			span.RecordError(errors.New("client not allowed"))
+		}
+		span.End()
 
 		// 记录验证结果
 		if result.Allowed {
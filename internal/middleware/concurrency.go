@@ -3,14 +3,19 @@
  * 负责功能：
  *   - 用户并发数检查
  *   - 并发计数器管理
- *   - 请求完成后释放计数
- *   - 超限拒绝请求
+ *   - 请求完成后按租约释放计数，请求期间心跳续约
+ *   - 超限时排队等待，等待超时或队列已满才拒绝；客户端断开取消等待
  * 重要程度：⭐⭐⭐⭐ 重要（资源保护）
  * 依赖模块：cache, repository, model
  */
 package middleware
 
 import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+
 	"cli-proxy/internal/cache"
 	"cli-proxy/internal/model"
 	"cli-proxy/internal/repository"
@@ -21,7 +26,7 @@ import (
 )
 
 // UserConcurrencyControl 用户并发控制中间件
-// 限制每个用户的最大并发请求数
+// 限制每个用户的最大并发请求数，超限请求排队等待空闲槽位
 func UserConcurrencyControl() gin.HandlerFunc {
 	sessionCache := cache.GetSessionCache()
 	userRepo := repository.NewUserRepository()
@@ -55,33 +60,66 @@ func UserConcurrencyControl() gin.HandlerFunc {
 			limit = 10 // 默认限制
 		}
 
-		// 尝试获取并发槽位
-		acquired, current, err := sessionCache.AcquireUserConcurrency(c.Request.Context(), uid, limit)
+		// 获取并发槽位（租约），已满时排队等待
+		meta := cache.LeaseMeta{RequestID: GetRequestID(c), Model: peekRequestModel(c)}
+		leaseID, current, err := sessionCache.AcquireUserConcurrencyWait(c.Request.Context(), uid, limit, meta)
 		if err != nil {
-			log.Warn("获取用户并发槽位失败: userID=%d, error=%v", uid, err)
-			// Redis 错误不阻止请求
-			c.Next()
-			return
-		}
-
-		if !acquired {
-			log.Info("用户并发超限: userID=%d, current=%d, limit=%d", uid, current, limit)
-			response.CustomTooManyRequestsAbort(c, model.ErrorTypeUserConcurrencyLimit,
-				"Too many concurrent requests. Please try again later.")
+			switch {
+			case cache.IsQueueRejected(err):
+				log.Info("用户并发超限: userID=%d, current=%d, limit=%d, reason=%v", uid, current, limit, err)
+				response.CustomTooManyRequestsAbort(c, model.ErrorTypeUserConcurrencyLimit,
+					"Too many concurrent requests. Please try again later.")
+			case c.Request.Context().Err() != nil:
+				// 客户端排队期间断开，无需响应
+				c.Abort()
+			default:
+				log.Warn("获取用户并发槽位失败: userID=%d, error=%v", uid, err)
+				// Redis 错误不阻止请求
+				c.Next()
+			}
 			return
 		}
 
 		// 确保释放并发槽位
 		c.Set("user_concurrency_acquired", true)
 		c.Set("user_concurrency_uid", uid)
+		c.Set("user_concurrency_lease", leaseID)
 
-		// 使用 defer 释放并发槽位
+		// 请求期间心跳续约，长时间流式请求不会因租约过期被低估
+		stopHeartbeat := sessionCache.StartLeaseHeartbeat(c.Request.Context(), cache.SlotScopeUser, uid, leaseID)
+
+		// 使用 defer 按租约释放并发槽位
 		defer func() {
-			if err := sessionCache.ReleaseUserConcurrency(c.Request.Context(), uid); err != nil {
-				log.Warn("释放用户并发槽位失败: userID=%d, error=%v", uid, err)
+			stopHeartbeat()
+			if err := sessionCache.ReleaseUserConcurrency(c.Request.Context(), uid, leaseID); err != nil {
+				log.Warn("释放用户并发槽位失败: userID=%d, lease=%s, error=%v", uid, leaseID, err)
 			}
 		}()
 
 		c.Next()
 	}
 }
+
+// peekRequestModel 读取请求模型用于租约展示（不消费请求体）
+// Gemini 原生接口模型在路径中，其余接口取请求体的 model 字段
+func peekRequestModel(c *gin.Context) string {
+	if modelAction := c.Param("modelAction"); modelAction != "" {
+		modelName, _, _ := strings.Cut(modelAction, ":")
+		return modelName
+	}
+	if c.Request.Body == nil {
+		return ""
+	}
+
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+	if err != nil {
+		return ""
+	}
+
+	var body struct {
+		Model string `json:"model"`
+	}
+	json.Unmarshal(bodyBytes, &body)
+	return body.Model
+}
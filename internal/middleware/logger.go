@@ -2,11 +2,12 @@
  * 文件作用：HTTP请求日志中间件，记录所有HTTP请求的详细信息
  * 负责功能：
  *   - 请求ID生成和传递
+ *   - 请求级追踪 Span（沿用并返回 W3C traceparent）
  *   - 请求/响应时间记录
  *   - 请求体大小统计
- *   - 敏感信息脱敏（token/password）
+ *   - 敏感信息脱敏（token/password、查询参数中的 API Key）
  * 重要程度：⭐⭐⭐ 一般（调试和监控）
- * 依赖模块：logger
+ * 依赖模块：logger, tracing
  */
 package middleware
 
@@ -14,11 +15,14 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
 	"io"
+	"net/url"
 	"strings"
 	"time"
 
 	"cli-proxy/pkg/logger"
+	"cli-proxy/pkg/tracing"
 
 	"github.com/gin-gonic/gin"
 )
@@ -74,6 +78,27 @@ func getRealClientIP(c *gin.Context) string {
 	return c.ClientIP()
 }
 
+// sensitiveQueryParams 访问日志中需要脱敏的查询参数（Gemini 原生客户端用 ?key= 传 API Key）
+var sensitiveQueryParams = []string{"key", "api_key", "token", "access_token"}
+
+// redactQuery 遮蔽查询串中的凭据参数值，其余参数保持原样
+func redactQuery(rawQuery string) string {
+	parts := strings.Split(rawQuery, "&")
+	for i, part := range parts {
+		name, _, _ := strings.Cut(part, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		for _, param := range sensitiveQueryParams {
+			if strings.EqualFold(name, param) {
+				parts[i] = name + "=***"
+				break
+			}
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
 // Logger HTTP请求日志中间件
 // 功能：生成request_id、注入context、记录详细结构化日志
 func Logger() gin.HandlerFunc {
@@ -95,10 +120,26 @@ func Logger() gin.HandlerFunc {
 
 		// 注入到 Go Context（用于日志）
 		ctx := logger.SetRequestID(c.Request.Context(), requestID)
+
+		// 请求级追踪 Span：客户端传入 traceparent 时作为父 Span
+		if parent, ok := tracing.ParseTraceparent(c.GetHeader(tracing.TraceparentHeader)); ok {
+			ctx = tracing.ContextWithRemoteParent(ctx, parent)
+		}
+		ctx, span := tracing.Start(ctx, "HTTP "+c.Request.Method,
+			tracing.WithKind(tracing.SpanKindServer),
+			tracing.WithAttributes(
+				tracing.String("http.request.method", c.Request.Method),
+				tracing.String("url.path", path),
+				tracing.String("request_id", requestID),
+			),
+		)
 		c.Request = c.Request.WithContext(ctx)
 
 		// 设置响应头
 		c.Header(RequestIDHeader, requestID)
+		if span != nil {
+			c.Header(tracing.TraceparentHeader, span.SpanContext().Traceparent())
+		}
 
 		// 获取请求体大小
 		var requestBodySize int64
@@ -120,6 +161,17 @@ func Logger() gin.HandlerFunc {
 		latency := time.Since(start)
 		status := c.Writer.Status()
 
+		// 结束追踪 Span（路由模板在处理后才确定）
+		if route := c.FullPath(); route != "" {
+			span.SetName(c.Request.Method + " " + route)
+			span.SetAttributes(tracing.String("http.route", route))
+		}
+		span.SetAttributes(tracing.Int("http.response.status_code", status))
+		if status >= 500 {
+			span.RecordError(fmt.Errorf("HTTP %d", status))
+		}
+		span.End()
+
 		// 获取详细信息
 		clientIP := getRealClientIP(c)
 		method := c.Request.Method
@@ -156,10 +208,10 @@ func Logger() gin.HandlerFunc {
 			errMsg = c.Errors.String()
 		}
 
-		// 构建完整路径
+		// 构建完整路径（查询参数中的凭据脱敏）
 		fullPath := path
 		if query != "" {
-			fullPath = path + "?" + query
+			fullPath = path + "?" + redactQuery(query)
 		}
 
 		// 构建日志字段
@@ -194,6 +246,9 @@ func Logger() gin.HandlerFunc {
 		if errMsg != "" {
 			fields = append(fields, logger.String("error", errMsg))
 		}
+		if span != nil {
+			fields = append(fields, logger.String("trace_id", span.SpanContext().TraceID.String()))
+		}
 
 		// 添加代理相关信息（如果存在）
 		if xff := c.GetHeader("X-Forwarded-For"); xff != "" {
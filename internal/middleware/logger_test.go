@@ -0,0 +1,180 @@
+/*
+ * 文件作用：请求日志中间件的追踪测试
+ * 负责功能：
+ *   - 客户端 traceparent 作为请求 Span 的父 Span
+ *   - 响应头返回请求 Span 的 traceparent
+ *   - 访问日志中查询参数凭据脱敏
+ * 重要程度：⭐⭐ 辅助（测试）
+ * 依赖模块：logger, tracing
+ */
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cli-proxy/pkg/logger"
+	"cli-proxy/pkg/tracing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testLogDir 测试日志目录（日志器按模块缓存，整个包只初始化一次）
+var testLogDir string
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	dir, err := os.MkdirTemp("", "middleware-log")
+	if err != nil {
+		panic(err)
+	}
+	testLogDir = dir
+	if err := logger.Init(dir, logger.LevelInfo); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// accessLogEntry 读取 http.log 中指定 request_id 的访问日志
+func accessLogEntry(t *testing.T, requestID string) map[string]interface{} {
+	t.Helper()
+	f, err := os.Open(filepath.Join(testLogDir, "http.log"))
+	if err != nil {
+		t.Fatalf("open access log: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry map[string]interface{}
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry["request_id"] == requestID {
+			return entry
+		}
+	}
+	t.Fatalf("no access log line for request %s", requestID)
+	return nil
+}
+
+func TestLoggerTraceparent(t *testing.T) {
+	const inbound = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	tests := []struct {
+		name        string
+		traceparent string
+		wantParent  bool // 请求 Span 是否以客户端 Span 为父
+	}{
+		{name: "inbound traceparent", traceparent: inbound, wantParent: true},
+		{name: "no traceparent", traceparent: ""},
+		{name: "invalid traceparent", traceparent: "00-xyz-00f067aa0ba902b7-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter := tracing.NewInMemoryExporter()
+			if err := tracing.Init(tracing.Options{SampleRatio: 1, Exporter: exporter}); err != nil {
+				t.Fatalf("Init: %v", err)
+			}
+			defer tracing.Shutdown(context.Background())
+
+			var handlerSpan tracing.SpanContext
+			router := gin.New()
+			router.Use(Logger())
+			router.POST("/v1/messages", func(c *gin.Context) {
+				handlerSpan = tracing.SpanFromContext(c.Request.Context()).SpanContext()
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+			if tt.traceparent != "" {
+				req.Header.Set(tracing.TraceparentHeader, tt.traceparent)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			tracing.Shutdown(context.Background())
+
+			outbound, ok := tracing.ParseTraceparent(rec.Header().Get(tracing.TraceparentHeader))
+			if !ok {
+				t.Fatalf("response traceparent = %q, want valid", rec.Header().Get(tracing.TraceparentHeader))
+			}
+			if outbound != handlerSpan {
+				t.Errorf("response traceparent = %s, want request span %s", outbound.Traceparent(), handlerSpan.Traceparent())
+			}
+
+			spans := exporter.Spans()
+			if len(spans) != 1 {
+				t.Fatalf("exported %d spans, want 1", len(spans))
+			}
+			span := spans[0]
+			if span.Name != "POST /v1/messages" || span.Kind != tracing.SpanKindServer {
+				t.Errorf("span = %q kind %d, want server span named after the route", span.Name, span.Kind)
+			}
+			if span.SpanID != outbound.SpanID || span.TraceID != outbound.TraceID {
+				t.Errorf("span %s/%s does not match response traceparent %s", span.TraceID, span.SpanID, outbound.Traceparent())
+			}
+
+			parent, _ := tracing.ParseTraceparent(inbound)
+			if tt.wantParent {
+				if span.TraceID != parent.TraceID || span.ParentSpanID != parent.SpanID {
+					t.Errorf("span trace/parent = %s/%s, want %s/%s", span.TraceID, span.ParentSpanID, parent.TraceID, parent.SpanID)
+				}
+			} else if span.TraceID == parent.TraceID || span.ParentSpanID != (tracing.SpanID{}) {
+				t.Errorf("span trace/parent = %s/%s, want a new root trace", span.TraceID, span.ParentSpanID)
+			}
+		})
+	}
+}
+
+func TestLoggerRedactsQueryAPIKey(t *testing.T) {
+	const secret = "sk-gemini-query-secret"
+
+	tests := []struct {
+		name     string
+		query    string
+		wantPath string
+	}{
+		{name: "gemini key", query: "key=" + secret + "&alt=sse", wantPath: "/gemini/v1beta/models/gemini-pro:streamGenerateContent?key=***&alt=sse"},
+		{name: "upper case name", query: "alt=sse&KEY=" + secret, wantPath: "/gemini/v1beta/models/gemini-pro:streamGenerateContent?alt=sse&KEY=***"},
+		{name: "escaped name", query: "%6Bey=" + secret, wantPath: "/gemini/v1beta/models/gemini-pro:streamGenerateContent?key=***"},
+		{name: "access token", query: "access_token=" + secret, wantPath: "/gemini/v1beta/models/gemini-pro:streamGenerateContent?access_token=***"},
+		{name: "no credentials", query: "alt=sse&keyword=a", wantPath: "/gemini/v1beta/models/gemini-pro:streamGenerateContent?alt=sse&keyword=a"},
+	}
+
+	router := gin.New()
+	router.Use(Logger())
+	router.POST("/gemini/v1beta/models/:action", func(c *gin.Context) {
+		// 模拟认证中间件在日志中间件之后移除 ?key=
+		takeQueryAPIKey(c)
+		c.Status(http.StatusUnauthorized)
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requestID := strings.ReplaceAll(t.Name(), "/", "-")
+			req := httptest.NewRequest(http.MethodPost, "/gemini/v1beta/models/gemini-pro:streamGenerateContent?"+tt.query, nil)
+			req.Header.Set(RequestIDHeader, requestID)
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			entry := accessLogEntry(t, requestID)
+			if entry["path"] != tt.wantPath {
+				t.Errorf("logged path = %v, want %s", entry["path"], tt.wantPath)
+			}
+		})
+	}
+
+	data, err := os.ReadFile(filepath.Join(testLogDir, "http.log"))
+	if err != nil {
+		t.Fatalf("read access log: %v", err)
+	}
+	if strings.Contains(string(data), secret) {
+		t.Errorf("access log contains the query API key:\n%s", data)
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ * 文件作用：指标中间件，记录代理接口请求指标并保护 /metrics 采集端点
+ * 负责功能：
+ *   - 代理接口请求计数与耗时直方图（按路由/方法/状态码）
+ *   - /metrics 采集 Token 校验（独立于管理后台 JWT 和 API Key）
+ * 重要程度：⭐⭐⭐ 一般（可观测性）
+ * 依赖模块：config, metrics
+ */
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cli-proxy/internal/config"
+	"cli-proxy/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	proxyRequestsTotal = metrics.NewCounterVec("cliproxy_http_requests_total",
+		"Client-facing proxy requests by route, method and status code.",
+		"route", "method", "status")
+	proxyRequestDuration = metrics.NewHistogramVec("cliproxy_http_request_duration_seconds",
+		"Client-facing proxy request duration in seconds (including retries and streaming).",
+		nil, "route", "method", "status")
+)
+
+// ProxyMetrics 代理接口请求指标中间件
+func ProxyMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		// 使用路由模板而非原始路径，避免路径参数导致序列数膨胀
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		proxyRequestsTotal.Inc(route, c.Request.Method, status)
+		proxyRequestDuration.Observe(time.Since(start).Seconds(), route, c.Request.Method, status)
+	}
+}
+
+// MetricsAuth /metrics 采集 Token 校验，未配置 Token 时端点不开放
+func MetricsAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !config.Cfg.Metrics.Enabled() {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.Cfg.Metrics.Token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"code":    401,
+				"message": "Invalid metrics token",
+			})
+			return
+		}
+		c.Next()
+	}
+}
@@ -121,6 +121,7 @@ func init() {
 
 		// 缓存管理
 		{regexp.MustCompile(`^/api/admin/cache/clear$`), model.ModuleCache, model.ActionClear, nil, nil, nil, descClearCache},
+		{regexp.MustCompile(`^/api/admin/cache/sessions/expire$`), model.ModuleCache, model.ActionClear, nil, nil, nil, descExpireSessions},
 		{regexp.MustCompile(`^/api/admin/cache/sessions/(.+)$`), model.ModuleCache, model.ActionDelete, nil, nil, nil, descRemoveSession},
 		{regexp.MustCompile(`^/api/admin/cache/users/(\d+)$`), model.ModuleCache, model.ActionClear, getPathID, nil, getUsernameByID, descClearUserCache},
 		{regexp.MustCompile(`^/api/admin/cache/api-keys/(\d+)$`), model.ModuleCache, model.ActionClear, getPathID, nil, getAPIKeyNameByID, descClearAPIKeyCache},
@@ -528,6 +529,10 @@ func descRemoveSession(c *gin.Context, body map[string]interface{}) string {
 	return "移除会话"
 }
 
+func descExpireSessions(c *gin.Context, body map[string]interface{}) string {
+	return "批量过期会话"
+}
+
 func descClearUserCache(c *gin.Context, body map[string]interface{}) string {
 	return "清理用户 #" + c.Param("id") + " 缓存"
 }
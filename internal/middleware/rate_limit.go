@@ -0,0 +1,107 @@
+/*
+ * 文件作用：API Key 限流中间件，执行 API Key 的频率/日请求/月额度限制
+ * 负责功能：
+ *   - 每分钟请求频率限制
+ *   - 每日请求次数限制
+ *   - 月度费用额度检查
+ *   - 输出 Retry-After 和 x-ratelimit-* 响应头
+ * 重要程度：⭐⭐⭐⭐ 重要（资源保护）
+ * 依赖模块：service, model
+ */
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cli-proxy/internal/model"
+	"cli-proxy/internal/service"
+	"cli-proxy/pkg/logger"
+	"cli-proxy/pkg/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyRateLimit API Key 限流中间件
+// 必须放在 APIKeyAuth 之后；放在客户端过滤之后，被拒绝的请求不占用频率窗口和日计数
+func APIKeyRateLimit() gin.HandlerFunc {
+	limiter := service.GetAPIKeyLimiter()
+	log := logger.GetLogger("middleware")
+
+	return func(c *gin.Context) {
+		key := GetAPIKey(c)
+		if key == nil {
+			c.Next()
+			return
+		}
+
+		result := limiter.Check(c.Request.Context(), key)
+		setRateLimitHeaders(c, result)
+
+		if result.Allowed {
+			c.Next()
+			return
+		}
+
+		retryAfter := int(math.Ceil(result.RetryAfter.Seconds()))
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+
+		switch result.Reason {
+		case service.APIKeyLimitMonthly:
+			log.Info("API Key 月额度超限: keyID=%d, used=%.4f, quota=%.2f", key.ID, result.MonthlyUsed, result.MonthlyQuota)
+			response.CustomErrorAbort(c, http.StatusTooManyRequests, model.ErrorTypeMonthlyQuota,
+				fmt.Sprintf("Monthly quota exceeded: $%.2f of $%.2f used", result.MonthlyUsed, result.MonthlyQuota))
+		case service.APIKeyLimitDaily:
+			log.Info("API Key 日请求超限: keyID=%d, limit=%d", key.ID, result.DailyLimit)
+			response.CustomErrorAbort(c, http.StatusTooManyRequests, model.ErrorTypeDailyLimit,
+				fmt.Sprintf("Daily request limit of %d exceeded", result.DailyLimit))
+		default:
+			log.Info("API Key 频率超限: keyID=%d, limit=%d/min", key.ID, result.Limit)
+			response.CustomErrorAbort(c, http.StatusTooManyRequests, model.ErrorTypeRateLimit,
+				fmt.Sprintf("Rate limit exceeded. Please retry after %d seconds", retryAfter))
+		}
+	}
+}
+
+// setRateLimitHeaders 设置 x-ratelimit-* 响应头
+func setRateLimitHeaders(c *gin.Context, result *service.APIKeyLimitResult) {
+	if result.Limit > 0 {
+		remaining := result.Remaining
+		if remaining < 0 {
+			remaining = 0
+		}
+		c.Header("x-ratelimit-limit-requests", strconv.Itoa(result.Limit))
+		c.Header("x-ratelimit-remaining-requests", strconv.Itoa(remaining))
+		c.Header("x-ratelimit-reset-requests", formatResetDuration(result.ResetAfter))
+	}
+	if result.DailyLimit > 0 {
+		remaining := result.DailyRemaining
+		if remaining < 0 {
+			remaining = 0
+		}
+		c.Header("x-ratelimit-limit-requests-day", strconv.Itoa(result.DailyLimit))
+		c.Header("x-ratelimit-remaining-requests-day", strconv.Itoa(remaining))
+	}
+	if result.MonthlyQuota > 0 {
+		remaining := result.MonthlyQuota - result.MonthlyUsed
+		if remaining < 0 {
+			remaining = 0
+		}
+		c.Header("x-ratelimit-limit-cost-month", strconv.FormatFloat(result.MonthlyQuota, 'f', 2, 64))
+		c.Header("x-ratelimit-remaining-cost-month", strconv.FormatFloat(remaining, 'f', 4, 64))
+	}
+}
+
+// formatResetDuration 格式化重置时间（与 OpenAI 一致，如 "12s"）
+func formatResetDuration(d time.Duration) string {
+	if d <= 0 {
+		return "0s"
+	}
+	return strconv.Itoa(int(math.Ceil(d.Seconds()))) + "s"
+}
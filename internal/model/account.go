@@ -4,6 +4,7 @@
  *   - 账户基础信息（名称、类型、状态）
  *   - OAuth凭证（Access/Refresh Token）
  *   - API密钥（Key/Secret）
+ *   - 凭证字段加密存储（serializer:encrypted）
  *   - 配额限制（并发、每日预算）
  *   - 分组关联
  * 重要程度：⭐⭐⭐⭐ 重要（核心数据结构）
@@ -62,23 +63,23 @@ type Account struct {
 	Weight    int            `gorm:"default:100" json:"weight"`               // 权重
 
 	// 通用认证字段 (敏感信息，不序列化到 JSON)
-	APIKey       string     `gorm:"size:500" json:"-"`      // API Key
-	APISecret    string     `gorm:"size:500" json:"-"`      // API Secret
-	AccessToken  string     `gorm:"size:2000" json:"-"`     // Access Token
-	RefreshToken string     `gorm:"size:2000" json:"-"`     // Refresh Token
-	TokenExpiry  *time.Time `json:"token_expiry,omitempty"` // Token 过期时间
+	APIKey       string     `gorm:"size:1000;serializer:encrypted" json:"-"` // API Key
+	APISecret    string     `gorm:"size:1000;serializer:encrypted" json:"-"` // API Secret
+	AccessToken  string     `gorm:"type:text;serializer:encrypted" json:"-"` // Access Token
+	RefreshToken string     `gorm:"type:text;serializer:encrypted" json:"-"` // Refresh Token
+	TokenExpiry  *time.Time `json:"token_expiry,omitempty"`                 // Token 过期时间
 
 	// Claude Official 专用
-	SessionKey        string `gorm:"type:text" json:"-"`                        // Session Key (敏感)
+	SessionKey        string `gorm:"type:text;serializer:encrypted" json:"-"`   // Session Key (敏感)
 	OrganizationID    string `gorm:"size:100" json:"organization_id,omitempty"` // 组织 ID
 	SubscriptionLevel string `gorm:"size:20" json:"subscription_level,omitempty"`  // 订阅级别: free/pro/team
 	OpusAccess        bool   `gorm:"default:false" json:"opus_access"`             // 是否有 Opus 权限
 
 	// AWS Bedrock 专用 (敏感信息，不序列化)
 	AWSAccessKey    string `gorm:"size:100" json:"-"`
-	AWSSecretKey    string `gorm:"size:200" json:"-"`
+	AWSSecretKey    string `gorm:"size:500;serializer:encrypted" json:"-"`
 	AWSRegion       string `gorm:"size:30" json:"aws_region,omitempty"`
-	AWSSessionToken string `gorm:"size:2000" json:"-"`
+	AWSSessionToken string `gorm:"type:text;serializer:encrypted" json:"-"`
 
 	// Azure OpenAI 专用
 	AzureEndpoint      string `gorm:"size:200" json:"azure_endpoint,omitempty"`
@@ -157,6 +158,8 @@ type AccountGroup struct {
 	Description string         `gorm:"size:500" json:"description,omitempty"`
 	Platform    string         `gorm:"size:20" json:"platform,omitempty"` // 限定平台
 	IsDefault   bool           `gorm:"default:false" json:"is_default"`   // 是否默认分组
+	IsFallback  bool           `gorm:"default:false" json:"is_fallback"`  // 是否兜底分组（绑定的专属分组无可用账户时使用）
+	IsExclusive bool           `gorm:"default:false" json:"is_exclusive"` // 是否独占分组（成员账户只调度给绑定该分组的请求）
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
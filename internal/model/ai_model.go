@@ -35,6 +35,7 @@ type AIModel struct {
 	IsDefault        bool           `gorm:"default:false" json:"is_default"`                        // 是否默认模型
 	SortOrder        int            `gorm:"default:0" json:"sort_order"`                            // 排序
 	Aliases          string         `gorm:"type:text" json:"aliases"`                               // 别名列表，逗号分隔
+	FallbackModels   string         `gorm:"type:text" json:"fallback_models"`                       // 回退模型链，逗号分隔（按顺序尝试，账户重试耗尽后切换）
 	Capabilities     string         `gorm:"type:text" json:"capabilities"`                          // 能力列表 JSON
 	CreatedAt        time.Time      `json:"created_at"`
 	UpdatedAt        time.Time      `json:"updated_at"`
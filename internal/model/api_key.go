@@ -45,6 +45,11 @@ type APIKey struct {
 	AllowedModels    string `gorm:"type:text" json:"allowed_models,omitempty"`     // 允许的模型列表 (逗号分隔)
 	BlockedModels    string `gorm:"type:text" json:"blocked_models,omitempty"`     // 禁止的模型列表 (逗号分隔)
 	AllowedClients   string `gorm:"size:200" json:"allowed_clients,omitempty"`     // 允许的客户端类型 (逗号分隔, 如: claude_code,codex_cli)
+	AccountGroupIDs  string `gorm:"size:200" json:"account_group_ids,omitempty"`   // 绑定的账户分组ID (逗号分隔，空=使用套餐/用户绑定)
+	HedgeEnabled     bool   `gorm:"default:false" json:"hedge_enabled"`            // 非流式请求对冲（需系统配置开启，由管理员设置）
+
+	// 调试：截止时间前的请求始终记录请求体/响应体（由管理员设置）
+	BodyCaptureUntil *time.Time `json:"body_capture_until,omitempty"`
 
 	// 限制配置
 	RateLimit     int        `gorm:"default:60" json:"rate_limit"`               // 每分钟请求限制
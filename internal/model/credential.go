@@ -0,0 +1,54 @@
+/*
+ * 文件作用：凭证字段加密序列化器，读写数据库时透明加解密敏感字段
+ * 负责功能：
+ *   - 注册 GORM 序列化器 encrypted（字段标签 serializer:encrypted）
+ *   - 写入时加密，读取时解密（兼容迁移前的明文）
+ * 重要程度：⭐⭐⭐⭐ 重要（凭证存储安全）
+ * 依赖模块：gorm, encryption
+ */
+package model
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"cli-proxy/pkg/encryption"
+
+	"gorm.io/gorm/schema"
+)
+
+func init() {
+	schema.RegisterSerializer("encrypted", EncryptedSerializer{})
+}
+
+// EncryptedSerializer 字符串字段加密序列化器
+// 注意：map 形式的 Updates 不经过序列化器，写入凭证时需先调用 encryption.Encrypt
+type EncryptedSerializer struct{}
+
+// Scan 读取并解密
+func (EncryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var raw string
+	switch v := dbValue.(type) {
+	case nil:
+	case []byte:
+		raw = string(v)
+	case string:
+		raw = v
+	default:
+		return fmt.Errorf("unsupported value type %T for encrypted field %s", dbValue, field.Name)
+	}
+
+	plaintext, err := encryption.Decrypt(raw)
+	if err != nil {
+		return fmt.Errorf("decrypt %s: %w", field.Name, err)
+	}
+	field.ReflectValueOf(ctx, dst).SetString(plaintext)
+	return nil
+}
+
+// Value 加密后写入
+func (EncryptedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	plaintext, _ := fieldValue.(string)
+	return encryption.Encrypt(plaintext)
+}
@@ -0,0 +1,105 @@
+/*
+ * 文件作用：凭证字段加密序列化器测试
+ * 负责功能：
+ *   - encrypted 序列化器写入加密、读取解密
+ *   - 迁移前明文、NULL 与不支持类型的读取
+ *   - 未配置密钥时读取密文报错
+ * 重要程度：⭐⭐ 辅助（测试）
+ * 依赖模块：gorm, encryption
+ */
+package model
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"cli-proxy/pkg/encryption"
+
+	"gorm.io/gorm/schema"
+)
+
+// proxyPasswordField 解析 Proxy.Password 字段（serializer:encrypted）
+func proxyPasswordField(t *testing.T) *schema.Field {
+	t.Helper()
+	s, err := schema.Parse(&Proxy{}, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		t.Fatalf("schema.Parse: %v", err)
+	}
+	field := s.LookUpField("Password")
+	if field == nil {
+		t.Fatal("Password field not found")
+	}
+	if _, ok := field.Serializer.(EncryptedSerializer); !ok {
+		t.Fatalf("Password serializer = %T, want EncryptedSerializer", field.Serializer)
+	}
+	return field
+}
+
+func TestEncryptedSerializer(t *testing.T) {
+	keyring, err := encryption.NewKeyring(bytes.Repeat([]byte{3}, 32))
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	encryption.SetDefault(keyring)
+	t.Cleanup(func() { encryption.SetDefault(nil) })
+
+	ctx := context.Background()
+	field := proxyPasswordField(t)
+	var serializer EncryptedSerializer
+
+	// 写入：加密后入库，空串不加密
+	var proxy Proxy
+	dst := reflect.ValueOf(&proxy).Elem()
+	stored, err := serializer.Value(ctx, field, dst, "s3cret")
+	if err != nil {
+		t.Fatalf("Value: %v", err)
+	}
+	ciphertext, _ := stored.(string)
+	if !encryption.IsEncrypted(ciphertext) {
+		t.Fatalf("Value = %v, want encrypted string", stored)
+	}
+	if empty, err := serializer.Value(ctx, field, dst, ""); err != nil || empty != "" {
+		t.Errorf("Value(\"\") = (%v, %v), want empty", empty, err)
+	}
+
+	// 读取：各种数据库返回类型
+	tests := []struct {
+		name    string
+		dbValue interface{}
+		want    string
+		wantErr bool
+	}{
+		{name: "ciphertext string", dbValue: ciphertext, want: "s3cret"},
+		{name: "ciphertext bytes", dbValue: []byte(ciphertext), want: "s3cret"},
+		{name: "legacy plaintext", dbValue: "legacy-password", want: "legacy-password"},
+		{name: "null", dbValue: nil, want: ""},
+		{name: "tampered", dbValue: ciphertext[:len(ciphertext)-4] + "AAAA", wantErr: true},
+		{name: "unsupported type", dbValue: 42, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := Proxy{Password: "unchanged"}
+			err := serializer.Scan(ctx, field, reflect.ValueOf(&proxy).Elem(), tt.dbValue)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Scan error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && proxy.Password != tt.want {
+				t.Errorf("Password = %q, want %q", proxy.Password, tt.want)
+			}
+		})
+	}
+
+	// 未配置密钥：密文无法读取，明文仍可读取
+	encryption.SetDefault(nil)
+	proxy = Proxy{}
+	if err := serializer.Scan(ctx, field, reflect.ValueOf(&proxy).Elem(), ciphertext); !errors.Is(err, encryption.ErrNoKey) {
+		t.Errorf("Scan without key error = %v, want %v", err, encryption.ErrNoKey)
+	}
+	if err := serializer.Scan(ctx, field, reflect.ValueOf(&proxy).Elem(), "plain"); err != nil || proxy.Password != "plain" {
+		t.Errorf("Scan plaintext without key = (%q, %v), want plain", proxy.Password, err)
+	}
+}
@@ -36,6 +36,9 @@ type Package struct {
 	// 模型限制
 	AllowedModels string       `gorm:"type:text" json:"allowed_models"`                     // 允许的模型（逗号分隔，空=全部）
 
+	// 账户路由
+	AccountGroupIDs string     `gorm:"size:200" json:"account_group_ids,omitempty"`         // 绑定的账户分组ID（逗号分隔，空=不限）
+
 	Description string         `gorm:"size:500" json:"description"`                         // 套餐描述
 	Status      string         `gorm:"size:20;default:active" json:"status"`                // active/disabled
 	CreatedAt   time.Time      `json:"created_at"`
@@ -98,17 +101,20 @@ func (up *UserPackage) QuotaRemaining() float64 {
 	return up.QuotaTotal - up.QuotaUsed
 }
 
-// ResetPeriodUsageIfNeeded 如果需要，重置周期使用量
+// UsagePeriods 返回 now 所在的日/周/月周期标识（YYYY-MM-DD / ISO 周 YYYY-WW / YYYY-MM）
+// 周使用 ISO 周所属年份，跨年的第 1 周或第 52/53 周不会与上一年混淆
+func UsagePeriods(now time.Time) (day, week, month string) {
+	year, w := now.ISOWeek()
+	return now.Format("2006-01-02"), fmt.Sprintf("%d-%02d", year, w), now.Format("2006-01")
+}
+
+// ResetPeriodUsageIfNeeded 如果需要，重置周期使用量（仅修改内存中的副本，持久化使用 UserPackageRepository.ResetPeriodUsage）
 func (up *UserPackage) ResetPeriodUsageIfNeeded() bool {
 	if up.Type != "subscription" {
 		return false
 	}
 
-	now := time.Now()
-	today := now.Format("2006-01-02")
-	year, week := now.ISOWeek()
-	thisWeek := now.Format("2006") + "-" + padWeek(week)
-	thisMonth := now.Format("2006-01")
+	today, thisWeek, thisMonth := UsagePeriods(time.Now())
 
 	changed := false
 
@@ -123,7 +129,6 @@ func (up *UserPackage) ResetPeriodUsageIfNeeded() bool {
 	if up.LastResetWeek != thisWeek {
 		up.WeeklyUsed = 0
 		up.LastResetWeek = thisWeek
-		_ = year // 使用变量
 		changed = true
 	}
 
@@ -137,10 +142,6 @@ func (up *UserPackage) ResetPeriodUsageIfNeeded() bool {
 	return changed
 }
 
-func padWeek(week int) string {
-	return fmt.Sprintf("%02d", week)
-}
-
 // CanUse 检查是否可以使用指定金额
 func (up *UserPackage) CanUse(amount float64) bool {
 	if up.Status != "active" {
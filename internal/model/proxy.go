@@ -25,7 +25,8 @@ type Proxy struct {
 	Host        string         `gorm:"size:200;not null" json:"host"`              // 代理主机
 	Port        int            `gorm:"not null" json:"port"`                       // 代理端口
 	Username    string         `gorm:"size:100" json:"username,omitempty"`         // 认证用户名
-	Password    string         `gorm:"size:100" json:"password,omitempty"`         // 认证密码
+	Password    string         `gorm:"size:500;serializer:encrypted" json:"-"`     // 认证密码（加密存储，不序列化）
+	HasPassword bool           `gorm:"-" json:"has_password"`                      // 是否已设置密码
 	Enabled     bool           `gorm:"default:true" json:"enabled"`                // 是否启用
 	IsDefault   bool           `gorm:"default:false" json:"is_default"`            // 是否为默认代理（用于OAuth认证）
 	TestStatus  string         `gorm:"size:20" json:"test_status"`                 // 测试状态: success, failed, 空表示未测试
@@ -38,6 +39,12 @@ type Proxy struct {
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
+// AfterFind 查询后标记是否已设置密码（密码本身不返回给前端）
+func (p *Proxy) AfterFind(tx *gorm.DB) error {
+	p.HasPassword = p.Password != ""
+	return nil
+}
+
 // ProxyType 代理类型常量
 const (
 	ProxyTypeHTTP   = "http"
@@ -4,7 +4,7 @@
  *   - 请求基础信息（账户、用户、平台、模型）
  *   - Token使用统计
  *   - 费用记录
- *   - 请求/响应详情（可选）
+ *   - 请求/响应详情（可选，支持压缩存储）
  *   - 错误信息记录
  * 重要程度：⭐⭐⭐ 一般（日志数据结构）
  * 依赖模块：gorm
@@ -12,6 +12,11 @@
 package model
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -33,6 +38,10 @@ type RequestLog struct {
 	UserAgent  string `gorm:"size:500" json:"user_agent,omitempty"`     // User-Agent
 	SessionID  string `gorm:"size:100;index" json:"session_id,omitempty"` // 会话ID
 
+	// 模型回退（账户重试耗尽后按回退链切换模型）
+	RequestedModel string `gorm:"size:100" json:"requested_model,omitempty"` // 客户端请求的模型（发生回退时记录）
+	FallbackHops   string `gorm:"type:text" json:"fallback_hops,omitempty"`  // 回退记录 JSON（[]ModelFallbackHop）
+
 	// 完整请求/响应记录
 	RequestHeaders  string `gorm:"type:text" json:"request_headers,omitempty"`   // 请求头 JSON
 	RequestBody     string `gorm:"type:longtext" json:"request_body,omitempty"`  // 请求体
@@ -78,6 +87,61 @@ func (r *RequestLog) TableName() string {
 	return "request_logs"
 }
 
+// AfterFind 查询后自动解压请求体/响应体
+func (r *RequestLog) AfterFind(tx *gorm.DB) error {
+	r.RequestBody = DecompressBody(r.RequestBody)
+	r.ResponseBody = DecompressBody(r.ResponseBody)
+	return nil
+}
+
+// compressedBodyPrefix 压缩存储的请求体/响应体前缀（gzip + base64）
+const compressedBodyPrefix = "gzip:"
+
+// CompressBody 压缩请求体/响应体用于存储，压缩后不更小时原样返回
+func CompressBody(body string) string {
+	if body == "" {
+		return body
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(body))
+	if err := zw.Close(); err != nil {
+		return body
+	}
+	encoded := compressedBodyPrefix + base64.StdEncoding.EncodeToString(buf.Bytes())
+	if len(encoded) >= len(body) {
+		return body
+	}
+	return encoded
+}
+
+// DecompressBody 解压 CompressBody 的结果，未压缩或解压失败时原样返回
+func DecompressBody(body string) string {
+	if !strings.HasPrefix(body, compressedBodyPrefix) {
+		return body
+	}
+	data, err := base64.StdEncoding.DecodeString(body[len(compressedBodyPrefix):])
+	if err != nil {
+		return body
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return body
+	}
+	plain, err := io.ReadAll(zr)
+	if err != nil {
+		return body
+	}
+	return string(plain)
+}
+
+// ModelFallbackHop 一次模型回退
+type ModelFallbackHop struct {
+	From   string `json:"from"`   // 失败的模型
+	To     string `json:"to"`     // 回退到的模型
+	Reason string `json:"reason"` // 失败原因
+}
+
 // RequestLogSummary 请求日志摘要统计
 type RequestLogSummary struct {
 	TotalRequests            int64   `json:"total_requests"`
@@ -136,6 +200,20 @@ type ModelUsageStats struct {
 	TotalCost    float64 `json:"total_cost"`
 }
 
+// CacheHitStats 提示缓存命中统计（按账户/API Key/模型分组）
+type CacheHitStats struct {
+	Key                      string  `json:"key"`                         // 分组键（账户ID/API Key ID/模型名）
+	Name                     string  `json:"name"`                        // 分组名称
+	RequestCount             int64   `json:"request_count"`               // 成功请求数
+	InputTokens              int64   `json:"input_tokens"`                // 未命中缓存的输入Token
+	CacheCreationInputTokens int64   `json:"cache_creation_input_tokens"` // 缓存创建Token
+	CacheReadInputTokens     int64   `json:"cache_read_input_tokens"`     // 缓存读取Token
+	HitRate                  float64 `json:"hit_rate"`                    // 命中率（缓存读取 / 全部输入，0-1）
+	CacheCreateCost          float64 `json:"cache_create_cost"`           // 缓存创建费用（模型原价）
+	CacheReadCost            float64 `json:"cache_read_cost"`             // 缓存读取费用（模型原价）
+	Savings                  float64 `json:"savings"`                     // 节省费用（相比全部按输入价计费，已扣除缓存创建溢价）
+}
+
 // UnavailableAccount 临时不可用账户
 type UnavailableAccount struct {
 	AccountID    uint   `json:"account_id"`
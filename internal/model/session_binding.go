@@ -0,0 +1,30 @@
+/*
+ * 文件作用：会话绑定持久化数据模型，保存会话粘性绑定快照
+ * 负责功能：
+ *   - 会话与账户的绑定记录（内存后端重启后恢复，保持上游提示缓存命中）
+ * 重要程度：⭐⭐ 辅助（会话粘性持久化）
+ * 依赖模块：无
+ */
+package model
+
+import "time"
+
+// SessionBindingRecord 会话绑定快照
+type SessionBindingRecord struct {
+	SessionID  string    `gorm:"size:191;primaryKey" json:"session_id"`
+	AccountID  uint      `gorm:"index" json:"account_id"`
+	Platform   string    `gorm:"size:20" json:"platform"`
+	Model      string    `gorm:"size:100" json:"model"`
+	UserID     uint      `gorm:"index" json:"user_id"`
+	APIKeyID   uint      `json:"api_key_id"`
+	ClientIP   string    `gorm:"size:50" json:"client_ip"`
+	UserAgent  string    `gorm:"size:500" json:"user_agent"`
+	BoundAt    time.Time `json:"bound_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpireAt   time.Time `gorm:"index" json:"expire_at"`
+	Pinned     bool      `gorm:"default:false" json:"pinned"` // 管理员固定的会话不过期
+}
+
+func (r *SessionBindingRecord) TableName() string {
+	return "session_bindings"
+}
@@ -98,6 +98,52 @@ const (
 	ConfigSMTPFromEmail  = "smtp_from_email" // 发件人邮箱
 	ConfigSMTPFromName   = "smtp_from_name"  // 发件人名称
 	ConfigSMTPEncryption = "smtp_encryption" // 加密方式: none, ssl, starttls
+
+	// 并发排队配置
+	ConfigConcurrencyQueueEnabled  = "concurrency_queue_enabled"   // 并发已满时是否排队等待
+	ConfigConcurrencyQueueMaxWait  = "concurrency_queue_max_wait"  // 最大排队等待时间（秒）
+	ConfigConcurrencyQueueMaxDepth = "concurrency_queue_max_depth" // 每个账户/用户的最大排队数
+
+	// 请求重试配置
+	ConfigRetryMaxRetries                = "retry_max_retries"                   // 最大重试次数
+	ConfigRetryDelay                     = "retry_delay"                         // 初始退避时间（毫秒）
+	ConfigRetryBackoff                   = "retry_backoff"                       // 退避系数
+	ConfigRetryMaxDelay                  = "retry_max_delay"                     // 单次退避上限（毫秒）
+	ConfigRetryJitter                    = "retry_jitter"                        // 退避随机抖动比例（0-1）
+	ConfigRetryTotalTimeout              = "retry_total_timeout"                 // 请求总时长上限（秒）
+	ConfigRetryFirstByteTimeout          = "retry_first_byte_timeout"            // 流式请求首字节超时（秒）
+	ConfigRetryNonStreamFirstByteTimeout = "retry_non_stream_first_byte_timeout" // 非流式请求首字节超时（秒）
+
+	// 账户熔断配置
+	ConfigCircuitBreakerEnabled        = "circuit_breaker_enabled"          // 是否启用账户熔断
+	ConfigCircuitBreakerWindow         = "circuit_breaker_window"           // 错误率统计窗口（秒）
+	ConfigCircuitBreakerMinRequests    = "circuit_breaker_min_requests"     // 窗口内最少请求数
+	ConfigCircuitBreakerErrorRate      = "circuit_breaker_error_rate"       // 熔断错误率阈值（0-1）
+	ConfigCircuitBreakerOpenDuration   = "circuit_breaker_open_duration"    // 熔断持续时间（秒）
+	ConfigCircuitBreakerHalfOpenProbes = "circuit_breaker_half_open_probes" // 半开探测请求数
+
+	// 账户选择策略配置
+	ConfigSchedulerStrategy          = "scheduler_strategy"           // 默认账户选择策略
+	ConfigSchedulerStrategyPlatforms = "scheduler_strategy_platforms" // 按平台指定策略（platform=strategy,...）
+	ConfigSchedulerStrategyGroups    = "scheduler_strategy_groups"    // 按账户分组指定策略（groupID=strategy,...）
+
+	// 非流式请求对冲配置
+	ConfigHedgeEnabled    = "hedge_enabled"     // 是否启用请求对冲（总开关）
+	ConfigHedgeModels     = "hedge_models"      // 对所有 API Key 启用对冲的模型（逗号分隔，前缀匹配）
+	ConfigHedgePercentile = "hedge_percentile"  // 触发对冲的近期耗时百分位
+	ConfigHedgeMinDelay   = "hedge_min_delay"   // 最小触发延迟（毫秒）
+
+	// 提示缓存亲和路由配置
+	ConfigPromptAffinityEnabled = "prompt_affinity_enabled" // 是否按提示前缀优先路由到最近服务过的账户
+	ConfigPromptAffinityTTL     = "prompt_affinity_ttl"     // 亲和记录有效期（秒）
+
+	// 请求/响应体记录策略
+	ConfigBodyCaptureMode           = "body_capture_mode"            // 记录模式: off, errors, sampled
+	ConfigBodyCaptureSampleRate     = "body_capture_sample_rate"     // sampled 模式下成功请求的采样百分比（0-100）
+	ConfigBodyCaptureMaxSize        = "body_capture_max_size"        // 单个请求/响应体最大记录字节数
+	ConfigBodyCaptureRedactPatterns = "body_capture_redact_patterns" // 脱敏正则（每行一个）
+	ConfigBodyCaptureRedactPaths    = "body_capture_redact_paths"    // 脱敏 JSONPath（每行一个）
+	ConfigBodyCaptureCompress       = "body_capture_compress"        // 是否压缩存储
 )
 
 // 默认配置
@@ -153,4 +199,55 @@ var DefaultConfigs = []SystemConfig{
 	{Key: ConfigSMTPFromEmail, Value: "", Type: "string", Desc: "发件人邮箱地址", Category: "email"},
 	{Key: ConfigSMTPFromName, Value: "Cli-Proxy", Type: "string", Desc: "发件人名称", Category: "email"},
 	{Key: ConfigSMTPEncryption, Value: "starttls", Type: "string", Desc: "加密方式: none(无), ssl(SSL/465端口), starttls(STARTTLS/587端口)", Category: "email"},
+	// 并发排队配置
+	{Key: ConfigConcurrencyQueueEnabled, Value: "true", Type: "bool", Desc: "用户/账户并发已满时排队等待空闲槽位，而不是立即拒绝", Category: "concurrency"},
+	{Key: ConfigConcurrencyQueueMaxWait, Value: "10", Type: "int", Desc: "并发排队最大等待时间（秒），超时返回 429", Category: "concurrency"},
+	{Key: ConfigConcurrencyQueueMaxDepth, Value: "20", Type: "int", Desc: "每个用户/账户的最大排队请求数，队列已满直接返回 429", Category: "concurrency"},
+	// 请求重试配置
+	{Key: ConfigRetryMaxRetries, Value: "5", Type: "int", Desc: "请求失败后的最大重试次数（切换账户重试），0=不重试", Category: "retry"},
+	{Key: ConfigRetryDelay, Value: "1000", Type: "int", Desc: "重试初始退避时间（毫秒）", Category: "retry"},
+	{Key: ConfigRetryBackoff, Value: "1.5", Type: "float", Desc: "重试退避递增系数", Category: "retry"},
+	{Key: ConfigRetryMaxDelay, Value: "10000", Type: "int", Desc: "单次退避上限（毫秒），上游 Retry-After 不超过该值时按其等待", Category: "retry"},
+	{Key: ConfigRetryJitter, Value: "0.2", Type: "float", Desc: "退避随机抖动比例（0-1），避免大量请求同时重试", Category: "retry"},
+	{Key: ConfigRetryTotalTimeout, Value: "600", Type: "int", Desc: "单个请求总时长上限（秒），包含所有尝试、排队和退避等待，超出后取消请求（流式请求开始输出后不再受限），0=不限制", Category: "retry"},
+	{Key: ConfigRetryFirstByteTimeout, Value: "60", Type: "int", Desc: "流式请求等待上游首字节的超时（秒），超时后切换账户重试，0=不限制", Category: "retry"},
+	{Key: ConfigRetryNonStreamFirstByteTimeout, Value: "300", Type: "int", Desc: "非流式请求（含对冲请求）等待上游响应的超时（秒），超时后切换账户重试，0=不限制", Category: "retry"},
+	// 账户熔断配置
+	{Key: ConfigCircuitBreakerEnabled, Value: "true", Type: "bool", Desc: "是否启用账户熔断（错误率过高的账户暂停调度）", Category: "circuit_breaker"},
+	{Key: ConfigCircuitBreakerWindow, Value: "60", Type: "int", Desc: "熔断错误率统计窗口（秒）", Category: "circuit_breaker"},
+	{Key: ConfigCircuitBreakerMinRequests, Value: "10", Type: "int", Desc: "窗口内最少请求数，不足时不熔断", Category: "circuit_breaker"},
+	{Key: ConfigCircuitBreakerErrorRate, Value: "0.5", Type: "float", Desc: "熔断错误率阈值（0-1）", Category: "circuit_breaker"},
+	{Key: ConfigCircuitBreakerOpenDuration, Value: "30", Type: "int", Desc: "熔断持续时间（秒），到期后放行探测请求", Category: "circuit_breaker"},
+	{Key: ConfigCircuitBreakerHalfOpenProbes, Value: "2", Type: "int", Desc: "半开状态放行的探测请求数，全部成功后恢复调度", Category: "circuit_breaker"},
+	// 账户选择策略配置
+	{Key: ConfigSchedulerStrategy, Value: "weighted_random", Type: "string", Desc: "默认账户选择策略: weighted_random(按优先级*权重随机), least_in_flight(最少在途), ewma_latency(最低延迟), power_of_two(随机二选一取负载低者), headroom(5h/7d用量余量优先)", Category: "scheduler"},
+	{Key: ConfigSchedulerStrategyPlatforms, Value: "", Type: "string", Desc: "按平台指定选择策略，格式: claude=headroom,openai=least_in_flight", Category: "scheduler"},
+	{Key: ConfigSchedulerStrategyGroups, Value: "", Type: "string", Desc: "按账户分组指定选择策略（优先于平台策略），格式: 分组ID=策略，如 3=ewma_latency,5=power_of_two", Category: "scheduler"},
+	// 非流式请求对冲配置
+	{Key: ConfigHedgeEnabled, Value: "false", Type: "bool", Desc: "是否启用非流式请求对冲（首个账户响应过慢时并发请求第二个账户，取先返回者，仅对启用对冲的 API Key 或模型生效）", Category: "hedge"},
+	{Key: ConfigHedgeModels, Value: "", Type: "string", Desc: "对所有 API Key 启用对冲的模型（逗号分隔，前缀匹配），如 claude-haiku,gpt-4o-mini", Category: "hedge"},
+	{Key: ConfigHedgePercentile, Value: "95", Type: "int", Desc: "触发对冲的近期耗时百分位（1-99），首个账户超过该耗时仍未返回时发起对冲", Category: "hedge"},
+	{Key: ConfigHedgeMinDelay, Value: "1000", Type: "int", Desc: "对冲最小触发延迟（毫秒）", Category: "hedge"},
+	// 提示缓存亲和路由配置
+	{Key: ConfigPromptAffinityEnabled, Value: "true", Type: "bool", Desc: "是否启用提示缓存亲和路由（相同系统提示和开头消息的请求优先路由到最近服务过的账户，提高上游提示缓存命中率）", Category: "scheduler"},
+	{Key: ConfigPromptAffinityTTL, Value: "300", Type: "int", Desc: "提示缓存亲和记录有效期（秒），应与上游提示缓存有效期一致", Category: "scheduler"},
+	// 请求/响应体记录策略
+	{Key: ConfigBodyCaptureMode, Value: "sampled", Type: "string", Desc: "请求/响应体记录模式: off(不记录), errors(仅失败请求), sampled(失败请求全部记录，成功请求按采样比例记录)；开启调试的 API Key 在有效期内始终记录", Category: "body_capture"},
+	{Key: ConfigBodyCaptureSampleRate, Value: "100", Type: "float", Desc: "sampled 模式下成功请求的采样百分比（0-100）", Category: "body_capture"},
+	{Key: ConfigBodyCaptureMaxSize, Value: "65536", Type: "int", Desc: "单个请求/响应体最大记录字节数（脱敏后截断）", Category: "body_capture"},
+	{Key: ConfigBodyCaptureRedactPatterns, Value: DefaultBodyCaptureRedactPatterns, Type: "string", Desc: "脱敏正则表达式（每行一个），匹配内容替换为 [REDACTED]", Category: "body_capture"},
+	{Key: ConfigBodyCaptureRedactPaths, Value: DefaultBodyCaptureRedactPaths, Type: "string", Desc: "脱敏 JSONPath（每行一个，支持 .key、['key']、[*]、[n]、..key、[?(@.key=='value')]），匹配的字段值替换为 [REDACTED]", Category: "body_capture"},
+	{Key: ConfigBodyCaptureCompress, Value: "false", Type: "bool", Desc: "是否 gzip 压缩存储请求/响应体（查询时自动解压）", Category: "body_capture"},
 }
+
+// DefaultBodyCaptureRedactPatterns 默认脱敏正则：API Key、Bearer Token、邮箱
+const DefaultBodyCaptureRedactPatterns = `sk-[A-Za-z0-9_\-]{16,}
+AIza[0-9A-Za-z_\-]{35}
+(?i)bearer\s+[A-Za-z0-9._\-]{16,}
+[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`
+
+// DefaultBodyCaptureRedactPaths 默认脱敏 JSONPath：各平台的工具调用结果
+const DefaultBodyCaptureRedactPaths = `$.messages[*].content[?(@.type=='tool_result')].content
+$.messages[?(@.role=='tool')].content
+$.input[?(@.type=='function_call_output')].output
+$.contents[*].parts[*].functionResponse.response`
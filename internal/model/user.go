@@ -27,6 +27,7 @@ type User struct {
 	Balance        float64        `gorm:"type:decimal(10,4);default:0" json:"balance"`      // 余额（美元）
 	PriceRate      float64        `gorm:"type:decimal(5,2);default:1.0" json:"price_rate"`  // 价格倍率，默认1.0（原价），0表示免费
 	MaxConcurrency int            `gorm:"default:10" json:"max_concurrency"`               // 最大并发数
+	AccountGroupIDs string        `gorm:"size:200" json:"account_group_ids,omitempty"`     // 绑定的账户分组ID (逗号分隔，空=不限)
 	CreatedAt      time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
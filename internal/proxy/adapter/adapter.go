@@ -2,6 +2,7 @@
  * 文件作用：适配器接口定义和通用工具，定义所有AI平台适配器的统一接口
  * 负责功能：
  *   - Adapter 接口定义（Send/SendStream）
+ *   - 统一请求/响应结构（含工具定义、工具调用、工具结果）
  *   - 适配器注册表管理
  *   - UpstreamError 上游错误类型
  *   - StreamResult 流式结果封装
@@ -14,9 +15,12 @@ package adapter
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"time"
 
 	"cli-proxy/internal/model"
 )
@@ -29,6 +33,8 @@ var (
 type UpstreamError struct {
 	StatusCode int
 	Message    string
+	ResetAt    *time.Time    // 上游返回的限流恢复时间（可选）
+	RetryAfter time.Duration // 上游建议的重试等待时间（Retry-After 等响应头，可选）
 }
 
 func (e *UpstreamError) Error() string {
@@ -43,17 +49,25 @@ func NewUpstreamError(statusCode int, message string) *UpstreamError {
 	}
 }
 
+// NewUpstreamErrorFromResponse 创建上游错误，并从响应头解析建议的重试等待时间
+func NewUpstreamErrorFromResponse(resp *http.Response, message string) *UpstreamError {
+	err := NewUpstreamError(resp.StatusCode, message)
+	err.RetryAfter = ParseRetryAfter(resp.Header, time.Now())
+	return err
+}
+
 // Request 统一请求结构
 type Request struct {
-	Model       string        `json:"model"`
-	Messages    []Message     `json:"messages"`
-	MaxTokens   int           `json:"max_tokens,omitempty"`
-	Temperature float64       `json:"temperature,omitempty"`
-	TopP        float64       `json:"top_p,omitempty"`
-	Stream      bool          `json:"stream,omitempty"`
-	Stop        []string      `json:"stop,omitempty"`
-	System      string        `json:"system,omitempty"`
-	Tools       []interface{} `json:"tools,omitempty"`
+	Model       string      `json:"model"`
+	Messages    []Message   `json:"messages"`
+	MaxTokens   int         `json:"max_tokens,omitempty"`
+	Temperature float64     `json:"temperature,omitempty"`
+	TopP        float64     `json:"top_p,omitempty"`
+	Stream      bool        `json:"stream,omitempty"`
+	Stop        []string    `json:"stop,omitempty"`
+	System      string      `json:"system,omitempty"`
+	Tools       []Tool      `json:"tools,omitempty"`
+	ToolChoice  interface{} `json:"tool_choice,omitempty"` // OpenAI 格式："auto"/"none"/"required" 或 {"type":"function","function":{"name":...}}
 
 	// 原始请求体（用于直接转发）
 	RawBody []byte `json:"-"`
@@ -64,9 +78,13 @@ type Request struct {
 }
 
 // Message 消息结构
+// 工具调用使用 OpenAI 语义：assistant 消息携带 ToolCalls，工具结果为 role=tool 的消息
 type Message struct {
-	Role    string      `json:"role"`
-	Content interface{} `json:"content"` // string 或 []ContentBlock
+	Role       string      `json:"role"`
+	Content    interface{} `json:"content"` // string 或 []ContentBlock
+	ToolCalls  []ToolCall  `json:"tool_calls,omitempty"`
+	ToolCallID string      `json:"tool_call_id,omitempty"` // role=tool 时对应的工具调用 ID
+	Name       string      `json:"name,omitempty"`         // role=tool 时的工具名
 }
 
 // ContentBlock 内容块
@@ -78,6 +96,78 @@ type ContentBlock struct {
 		MediaType string `json:"media_type"`
 		Data      string `json:"data"`
 	} `json:"source,omitempty"`
+
+	// tool_use
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+
+	// tool_result
+	ToolUseID string      `json:"tool_use_id,omitempty"`
+	Content   interface{} `json:"content,omitempty"` // string 或内容块数组
+	IsError   bool        `json:"is_error,omitempty"`
+}
+
+// Tool 工具定义
+// JSON 序列化为 OpenAI 格式，反序列化同时兼容 OpenAI {type,function} 与 Claude {name,input_schema}
+type Tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"` // JSON Schema
+}
+
+// MarshalJSON 输出 OpenAI 工具格式
+func (t Tool) MarshalJSON() ([]byte, error) {
+	type function Tool
+	return json.Marshal(struct {
+		Type     string   `json:"type"`
+		Function function `json:"function"`
+	}{Type: "function", Function: function(t)})
+}
+
+// UnmarshalJSON 解析 OpenAI 或 Claude 工具格式
+func (t *Tool) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Type     string `json:"type"`
+		Function *struct {
+			Name        string          `json:"name"`
+			Description string          `json:"description"`
+			Parameters  json.RawMessage `json:"parameters"`
+		} `json:"function"`
+		Name        string          `json:"name"`
+		Description string          `json:"description"`
+		Parameters  json.RawMessage `json:"parameters"`
+		InputSchema json.RawMessage `json:"input_schema"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw.Function != nil {
+		t.Name = raw.Function.Name
+		t.Description = raw.Function.Description
+		t.Parameters = raw.Function.Parameters
+		return nil
+	}
+	t.Name = raw.Name
+	t.Description = raw.Description
+	t.Parameters = raw.Parameters
+	if len(raw.InputSchema) > 0 {
+		t.Parameters = raw.InputSchema
+	}
+	return nil
+}
+
+// ToolCall 模型发起的工具调用（OpenAI 格式）
+type ToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction 工具调用的函数名和参数
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` // JSON 字符串
 }
 
 // Response 统一响应结构
@@ -85,11 +175,13 @@ type Response struct {
 	ID           string            `json:"id"`
 	Model        string            `json:"model"`
 	Content      string            `json:"content"`
+	ToolCalls    []ToolCall        `json:"tool_calls,omitempty"`
 	StopReason   string            `json:"stop_reason,omitempty"`
 	InputTokens  int               `json:"input_tokens"`
 	OutputTokens int               `json:"output_tokens"`
 	Error        *Error            `json:"error,omitempty"`
 	Headers      map[string]string `json:"-"` // 响应头（用于获取限流信息等）
+	Body         []byte            `json:"-"` // 原始响应体（原生格式透传时使用）
 }
 
 // Error 错误结构
@@ -105,8 +105,10 @@ func (a *AzureOpenAIAdapter) Send(ctx context.Context, account *model.Account, r
 
 	content := ""
 	stopReason := ""
+	var toolCalls []ToolCall
 	if len(openAIResp.Choices) > 0 {
 		content = openAIResp.Choices[0].Message.Content
+		toolCalls = openAIResp.Choices[0].Message.ToolCalls
 		stopReason = openAIResp.Choices[0].FinishReason
 	}
 
@@ -117,6 +119,7 @@ func (a *AzureOpenAIAdapter) Send(ctx context.Context, account *model.Account, r
 		ID:           openAIResp.ID,
 		Model:        openAIResp.Model,
 		Content:      content,
+		ToolCalls:    toolCalls,
 		StopReason:   stopReason,
 		InputTokens:  openAIResp.Usage.PromptTokens,
 		OutputTokens: openAIResp.Usage.CompletionTokens,
@@ -160,7 +163,7 @@ func (a *AzureOpenAIAdapter) SendStream(ctx context.Context, account *model.Acco
 	if resp.StatusCode != http.StatusOK {
 		respBody, _ := ReadResponseBody(resp)
 		log.Error("Azure OpenAI Stream API 错误 - StatusCode: %d, Body: %s", resp.StatusCode, string(respBody))
-		return nil, NewUpstreamError(resp.StatusCode, string(respBody))
+		return nil, NewUpstreamErrorFromResponse(resp, string(respBody))
 	}
 
 	log.Debug("Azure OpenAI Stream 响应状态码: %d, 开始接收流式数据", resp.StatusCode)
@@ -235,15 +238,18 @@ func convertToOpenAIRequest(req *Request) *openAIRequest {
 		case []interface{}:
 			for _, block := range v {
 				if b, ok := block.(map[string]interface{}); ok {
-					if b["type"] == "text" {
-						content += b["text"].(string)
+					if t, ok := b["text"].(string); ok && b["type"] == "text" {
+						content += t
 					}
 				}
 			}
 		}
 		messages = append(messages, openAIMessage{
-			Role:    msg.Role,
-			Content: content,
+			Role:       msg.Role,
+			Content:    content,
+			ToolCalls:  msg.ToolCalls,
+			ToolCallID: msg.ToolCallID,
+			Name:       msg.Name,
 		})
 	}
 
@@ -255,5 +261,7 @@ func convertToOpenAIRequest(req *Request) *openAIRequest {
 		TopP:        req.TopP,
 		Stream:      req.Stream,
 		Stop:        req.Stop,
+		Tools:       req.Tools,
+		ToolChoice:  req.ToolChoice,
 	}
 }
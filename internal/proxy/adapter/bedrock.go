@@ -3,15 +3,14 @@
  * 负责功能：
  *   - AWS Bedrock API 请求转发
  *   - AWS Signature V4 签名认证
- *   - Claude on Bedrock 格式转换
- *   - 流式响应处理
+ *   - Claude on Bedrock 格式转换（含工具调用）
+ *   - 流式响应处理（AWS Event Stream 解码，输出 Claude 原生 SSE）
  * 重要程度：⭐⭐⭐⭐ 重要（Bedrock平台适配器）
  * 依赖模块：model, logger, http_client
  */
 package adapter
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"crypto/hmac"
@@ -55,6 +54,8 @@ type bedrockRequest struct {
 	Temperature      float64          `json:"temperature,omitempty"`
 	TopP             float64          `json:"top_p,omitempty"`
 	StopSequences    []string         `json:"stop_sequences,omitempty"`
+	Tools            []ClaudeTool     `json:"tools,omitempty"`
+	ToolChoice       interface{}      `json:"tool_choice,omitempty"`
 }
 
 type bedrockMessage struct {
@@ -64,16 +65,13 @@ type bedrockMessage struct {
 
 // Bedrock Claude 响应格式
 type bedrockResponse struct {
-	ID      string `json:"id"`
-	Type    string `json:"type"`
-	Role    string `json:"role"`
-	Content []struct {
-		Type string `json:"type"`
-		Text string `json:"text"`
-	} `json:"content"`
-	Model        string `json:"model"`
-	StopReason   string `json:"stop_reason"`
-	StopSequence string `json:"stop_sequence,omitempty"`
+	ID           string               `json:"id"`
+	Type         string               `json:"type"`
+	Role         string               `json:"role"`
+	Content      []ClaudeContentBlock `json:"content"`
+	Model        string               `json:"model"`
+	StopReason   string               `json:"stop_reason"`
+	StopSequence string               `json:"stop_sequence,omitempty"`
 	Usage        struct {
 		InputTokens  int `json:"input_tokens"`
 		OutputTokens int `json:"output_tokens"`
@@ -84,31 +82,10 @@ type bedrockResponse struct {
 	} `json:"error,omitempty"`
 }
 
-// Bedrock 流式响应事件
-type bedrockStreamEvent struct {
-	Type         string `json:"type"`
-	Index        int    `json:"index,omitempty"`
-	ContentBlock *struct {
-		Type string `json:"type"`
-		Text string `json:"text"`
-	} `json:"content_block,omitempty"`
-	Delta *struct {
-		Type       string `json:"type"`
-		Text       string `json:"text,omitempty"`
-		StopReason string `json:"stop_reason,omitempty"`
-	} `json:"delta,omitempty"`
-	Message *bedrockResponse `json:"message,omitempty"`
-	Usage   *struct {
-		OutputTokens int `json:"output_tokens"`
-	} `json:"usage,omitempty"`
-}
-
 func (a *BedrockAdapter) Send(ctx context.Context, account *model.Account, req *Request) (*Response, error) {
 	log := logger.GetLogger("proxy")
 
-	bedrockReq := a.convertRequest(req)
-
-	body, err := json.Marshal(bedrockReq)
+	body, err := a.buildBody(req)
 	if err != nil {
 		log.Error("Bedrock 序列化请求失败: %v", err)
 		return nil, err
@@ -176,6 +153,7 @@ func (a *BedrockAdapter) Send(ctx context.Context, account *model.Account, req *
 		ID:           bedrockResp.ID,
 		Model:        req.Model,
 		Content:      content,
+		ToolCalls:    claudeBlocksToToolCalls(bedrockResp.Content),
 		StopReason:   bedrockResp.StopReason,
 		InputTokens:  bedrockResp.Usage.InputTokens,
 		OutputTokens: bedrockResp.Usage.OutputTokens,
@@ -185,9 +163,7 @@ func (a *BedrockAdapter) Send(ctx context.Context, account *model.Account, req *
 func (a *BedrockAdapter) SendStream(ctx context.Context, account *model.Account, req *Request, writer io.Writer) (*StreamResult, error) {
 	log := logger.GetLogger("proxy")
 
-	bedrockReq := a.convertRequest(req)
-
-	body, err := json.Marshal(bedrockReq)
+	body, err := a.buildBody(req)
 	if err != nil {
 		log.Error("Bedrock Stream 序列化请求失败: %v", err)
 		return nil, err
@@ -207,7 +183,8 @@ func (a *BedrockAdapter) SendStream(ctx context.Context, account *model.Account,
 	log.Debug("Bedrock Stream 请求开始 - URL: %s, AccountID: %d, Model: %s, Region: %s",
 		url, account.ID, req.Model, account.AWSRegion)
 
-	client := GetHTTPClient(account)
+	// 使用流式 HTTP 客户端（10分钟超时）
+	client := GetStreamHTTPClient(account)
 	resp, err := client.Do(httpReq)
 	if err != nil {
 		log.Error("Bedrock Stream 请求失败 - 网络错误: %v", err)
@@ -218,96 +195,185 @@ func (a *BedrockAdapter) SendStream(ctx context.Context, account *model.Account,
 	if resp.StatusCode != http.StatusOK {
 		respBody, _ := ReadResponseBody(resp)
 		log.Error("Bedrock Stream API 错误 - StatusCode: %d, Body: %s", resp.StatusCode, string(respBody))
-		return nil, NewUpstreamError(resp.StatusCode, string(respBody))
+		return nil, NewUpstreamErrorFromResponse(resp, string(respBody))
 	}
 
 	log.Debug("Bedrock Stream 响应状态码: %d, 开始接收流式数据", resp.StatusCode)
 
+	result, err := a.relayEventStream(resp.Body, writer)
+	if err != nil {
+		if ctx.Err() != nil {
+			log.Info("Bedrock Stream 因 context 取消而结束: %v", ctx.Err())
+			return result, ctx.Err()
+		}
+		log.Error("Bedrock Stream 读取错误: %v", err)
+		return result, err
+	}
+
+	log.Info("Bedrock Stream 请求完成 - Model: %s, InputTokens: %d, OutputTokens: %d, CacheCreation: %d, CacheRead: %d",
+		req.Model, result.InputTokens, result.OutputTokens, result.CacheCreationInputTokens, result.CacheReadInputTokens)
+	return result, nil
+}
+
+// bedrockChunk Event Stream chunk 帧的负载（bytes 为 base64 编码的 Claude 流式事件）
+type bedrockChunk struct {
+	Bytes []byte `json:"bytes"`
+}
+
+// bedrockStreamUsage Claude 流式事件中的用量字段
+type bedrockStreamUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// bedrockUsageEvent 用于提取用量的 Claude 流式事件
+type bedrockUsageEvent struct {
+	Type    string `json:"type"`
+	Message *struct {
+		Usage bedrockStreamUsage `json:"usage"`
+	} `json:"message,omitempty"`
+	Usage   *bedrockStreamUsage `json:"usage,omitempty"`
+	Metrics *struct {
+		InputTokenCount  int `json:"inputTokenCount"`
+		OutputTokenCount int `json:"outputTokenCount"`
+	} `json:"amazon-bedrock-invocationMetrics,omitempty"`
+}
+
+// relayEventStream 解码 Event Stream 帧，以 Claude 原生 SSE 写出并提取用量
+// 用量来自 message_start（输入/缓存）和 message_delta（输出），message_stop 中的调用指标作为兜底
+func (a *BedrockAdapter) relayEventStream(body io.Reader, writer io.Writer) (*StreamResult, error) {
 	result := &StreamResult{}
+	flusher, hasFlusher := writer.(http.Flusher)
+	decoder := newEventStreamDecoder(body)
 
-	// Bedrock 使用 Amazon Event Stream 格式，这里简化处理
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if !strings.HasPrefix(line, "data: ") {
+	for {
+		msg, err := decoder.Next()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+
+		switch msg.Headers[":message-type"] {
+		case "exception", "error":
+			return result, a.writeStreamException(writer, msg)
+		}
+		if msg.Headers[":event-type"] != "chunk" {
 			continue
 		}
 
-		data := strings.TrimPrefix(line, "data: ")
-		if data == "" {
+		var chunk bedrockChunk
+		if err := json.Unmarshal(msg.Payload, &chunk); err != nil || len(chunk.Bytes) == 0 {
 			continue
 		}
 
-		var event bedrockStreamEvent
-		if err := json.Unmarshal([]byte(data), &event); err != nil {
+		var event bedrockUsageEvent
+		if err := json.Unmarshal(chunk.Bytes, &event); err != nil || event.Type == "" {
 			continue
 		}
 
-		// 解析 usage 信息
 		switch event.Type {
 		case "message_start":
-			// Bedrock 在 message_start 中包含 input_tokens
 			if event.Message != nil {
 				result.InputTokens = event.Message.Usage.InputTokens
+				result.OutputTokens = event.Message.Usage.OutputTokens
+				result.CacheCreationInputTokens = event.Message.Usage.CacheCreationInputTokens
+				result.CacheReadInputTokens = event.Message.Usage.CacheReadInputTokens
 			}
 		case "message_delta":
-			// Bedrock 在 message_delta 中包含 output_tokens
-			if event.Usage != nil {
+			if event.Usage != nil && event.Usage.OutputTokens > 0 {
 				result.OutputTokens = event.Usage.OutputTokens
 			}
-		}
-
-		// 转换为 OpenAI 流式格式
-		switch event.Type {
-		case "content_block_delta":
-			if event.Delta != nil && event.Delta.Text != "" {
-				openAIChunk := map[string]interface{}{
-					"id":     "chatcmpl-bedrock",
-					"object": "chat.completion.chunk",
-					"model":  req.Model,
-					"choices": []map[string]interface{}{
-						{
-							"index": 0,
-							"delta": map[string]interface{}{
-								"content": event.Delta.Text,
-							},
-							"finish_reason": nil,
-						},
-					},
+		case "message_stop":
+			if event.Metrics != nil {
+				if result.InputTokens == 0 {
+					result.InputTokens = event.Metrics.InputTokenCount
 				}
-				chunkData, _ := json.Marshal(openAIChunk)
-				writer.Write([]byte("data: " + string(chunkData) + "\n\n"))
-			}
-		case "message_delta":
-			if event.Delta != nil && event.Delta.StopReason != "" {
-				openAIChunk := map[string]interface{}{
-					"id":     "chatcmpl-bedrock",
-					"object": "chat.completion.chunk",
-					"model":  req.Model,
-					"choices": []map[string]interface{}{
-						{
-							"index":         0,
-							"delta":         map[string]interface{}{},
-							"finish_reason": event.Delta.StopReason,
-						},
-					},
+				if result.OutputTokens == 0 {
+					result.OutputTokens = event.Metrics.OutputTokenCount
 				}
-				chunkData, _ := json.Marshal(openAIChunk)
-				writer.Write([]byte("data: " + string(chunkData) + "\n\n"))
 			}
 		}
+
+		if _, err := fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", event.Type, chunk.Bytes); err != nil {
+			return result, err
+		}
+		if hasFlusher {
+			flusher.Flush()
+		}
 	}
+}
 
-	writer.Write([]byte("data: [DONE]\n\n"))
+// writeStreamException 将 Event Stream 异常帧以 Claude error 事件写出，并返回对应的上游错误
+func (a *BedrockAdapter) writeStreamException(writer io.Writer, msg *eventStreamMessage) error {
+	exceptionType := msg.Headers[":exception-type"]
+	if exceptionType == "" {
+		exceptionType = msg.Headers[":error-code"]
+	}
+	var payload struct {
+		Message string `json:"message"`
+	}
+	json.Unmarshal(msg.Payload, &payload)
+	if payload.Message == "" {
+		payload.Message = msg.Headers[":error-message"]
+	}
 
-	if err := scanner.Err(); err != nil {
-		log.Error("Bedrock Stream 读取错误: %v", err)
-		return result, err
+	statusCode, errorType := bedrockExceptionStatus(exceptionType)
+	errorEvent, _ := json.Marshal(map[string]interface{}{
+		"type": "error",
+		"error": map[string]string{
+			"type":    errorType,
+			"message": payload.Message,
+		},
+	})
+	writer.Write([]byte("event: error\ndata: " + string(errorEvent) + "\n\n"))
+	if flusher, ok := writer.(http.Flusher); ok {
+		flusher.Flush()
 	}
 
-	log.Info("Bedrock Stream 请求完成 - Model: %s, InputTokens: %d, OutputTokens: %d",
-		req.Model, result.InputTokens, result.OutputTokens)
-	return result, nil
+	return NewUpstreamError(statusCode, exceptionType+": "+payload.Message)
+}
+
+// bedrockExceptionStatus Bedrock 流式异常类型对应的 HTTP 状态码和 Claude 错误类型
+func bedrockExceptionStatus(exceptionType string) (int, string) {
+	switch exceptionType {
+	case "throttlingException":
+		return http.StatusTooManyRequests, "rate_limit_error"
+	case "validationException":
+		return http.StatusBadRequest, "invalid_request_error"
+	case "accessDeniedException":
+		return http.StatusForbidden, "permission_error"
+	case "serviceUnavailableException":
+		return http.StatusServiceUnavailable, "overloaded_error"
+	case "modelTimeoutException":
+		return http.StatusGatewayTimeout, "api_error"
+	default:
+		return http.StatusInternalServerError, "api_error"
+	}
+}
+
+// buildBody 构建 Bedrock 请求体
+// Claude 客户端透传的原始请求体直接复用（保留 cache_control / thinking 等字段），否则由统一请求转换
+func (a *BedrockAdapter) buildBody(req *Request) ([]byte, error) {
+	if len(req.Messages) > 0 || len(req.RawBody) == 0 {
+		return json.Marshal(a.convertRequest(req))
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(req.RawBody, &fields); err != nil {
+		return nil, err
+	}
+	// 模型和流式由 URL 指定，metadata 不被 Bedrock 接受
+	delete(fields, "model")
+	delete(fields, "stream")
+	delete(fields, "metadata")
+	if _, ok := fields["anthropic_version"]; !ok {
+		fields["anthropic_version"] = json.RawMessage(`"bedrock-2023-05-31"`)
+	}
+	return json.Marshal(fields)
 }
 
 func (a *BedrockAdapter) buildURL(account *model.Account, modelName string, stream bool) string {
@@ -356,28 +422,26 @@ func (a *BedrockAdapter) convertModelID(modelName string) string {
 }
 
 func (a *BedrockAdapter) convertRequest(req *Request) *bedrockRequest {
-	messages := make([]bedrockMessage, 0, len(req.Messages))
+	claudeReq := NewFormatConverter().UnifiedToClaude(req)
 
-	for _, msg := range req.Messages {
+	messages := make([]bedrockMessage, 0, len(claudeReq.Messages))
+	for _, msg := range claudeReq.Messages {
 		messages = append(messages, bedrockMessage{
 			Role:    msg.Role,
 			Content: msg.Content,
 		})
 	}
 
-	maxTokens := req.MaxTokens
-	if maxTokens == 0 {
-		maxTokens = 4096
-	}
-
 	return &bedrockRequest{
 		AnthropicVersion: "bedrock-2023-05-31",
-		MaxTokens:        maxTokens,
-		System:           req.System,
+		MaxTokens:        claudeReq.MaxTokens,
+		System:           claudeReq.System,
 		Messages:         messages,
-		Temperature:      req.Temperature,
-		TopP:             req.TopP,
-		StopSequences:    req.Stop,
+		Temperature:      claudeReq.Temperature,
+		TopP:             claudeReq.TopP,
+		StopSequences:    claudeReq.StopSequences,
+		Tools:            claudeReq.Tools,
+		ToolChoice:       claudeReq.ToolChoice,
 	}
 }
 
@@ -120,7 +120,7 @@ func (a *ClaudeAdapter) doSendWithRetry(ctx context.Context, account *model.Acco
 			}
 		}
 
-		return nil, NewUpstreamError(resp.StatusCode, errStr)
+		return nil, NewUpstreamErrorFromResponse(resp, errStr)
 	}
 
 	// 解析响应提取 usage 信息
@@ -197,7 +197,7 @@ func (a *ClaudeAdapter) doSendStreamWithRetry(ctx context.Context, account *mode
 
 		// 发送 SSE 错误事件给客户端
 		a.sendSSEError(writer, fmt.Sprintf("upstream_error_%d", resp.StatusCode), errStr)
-		return nil, NewUpstreamError(resp.StatusCode, errStr)
+		return nil, NewUpstreamErrorFromResponse(resp, errStr)
 	}
 
 	// 透传 SSE 流并解析 usage
@@ -586,14 +586,11 @@ func (a *ClaudeAdapter) addOAuthBeta(httpReq *http.Request) {
 // parseResponse 解析响应提取 usage
 func (a *ClaudeAdapter) parseResponse(respBody []byte) (*Response, error) {
 	var resp struct {
-		ID      string `json:"id"`
-		Type    string `json:"type"`
-		Model   string `json:"model"`
-		Content []struct {
-			Type string `json:"type"`
-			Text string `json:"text"`
-		} `json:"content"`
-		StopReason string `json:"stop_reason"`
+		ID         string               `json:"id"`
+		Type       string               `json:"type"`
+		Model      string               `json:"model"`
+		Content    []ClaudeContentBlock `json:"content"`
+		StopReason string               `json:"stop_reason"`
 		Usage      struct {
 			InputTokens  int `json:"input_tokens"`
 			OutputTokens int `json:"output_tokens"`
@@ -628,6 +625,7 @@ func (a *ClaudeAdapter) parseResponse(respBody []byte) (*Response, error) {
 		ID:           resp.ID,
 		Model:        resp.Model,
 		Content:      content,
+		ToolCalls:    claudeBlocksToToolCalls(resp.Content),
 		StopReason:   resp.StopReason,
 		InputTokens:  resp.Usage.InputTokens,
 		OutputTokens: resp.Usage.OutputTokens,
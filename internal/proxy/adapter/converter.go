@@ -4,6 +4,7 @@
  *   - OpenAI ↔ Claude 格式转换
  *   - OpenAI ↔ Gemini 格式转换
  *   - 请求/响应格式标准化
+ *   - 统一请求（adapter.Request）与 OpenAI/Claude 请求互转
  * 重要程度：⭐⭐⭐ 一般（格式转换辅助）
  * 依赖模块：无
  */
@@ -26,11 +27,16 @@ type OpenAIRequest struct {
 	TopP        float64         `json:"top_p,omitempty"`
 	Stream      bool            `json:"stream,omitempty"`
 	Stop        []string        `json:"stop,omitempty"`
+	Tools       []Tool          `json:"tools,omitempty"`
+	ToolChoice  interface{}     `json:"tool_choice,omitempty"`
 }
 
 type OpenAIMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	Name       string     `json:"name,omitempty"`
 }
 
 type OpenAIResponse struct {
@@ -60,6 +66,8 @@ type ClaudeRequest struct {
 	TopP          float64         `json:"top_p,omitempty"`
 	Stream        bool            `json:"stream,omitempty"`
 	StopSequences []string        `json:"stop_sequences,omitempty"`
+	Tools         []ClaudeTool    `json:"tools,omitempty"`
+	ToolChoice    interface{}     `json:"tool_choice,omitempty"`
 }
 
 type ClaudeMessage struct {
@@ -68,8 +76,19 @@ type ClaudeMessage struct {
 }
 
 type ClaudeContentBlock struct {
-	Type string `json:"type"`
-	Text string `json:"text,omitempty"`
+	Type   string      `json:"type"`
+	Text   string      `json:"text,omitempty"`
+	Source interface{} `json:"source,omitempty"`
+
+	// tool_use
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+
+	// tool_result
+	ToolUseID string      `json:"tool_use_id,omitempty"`
+	Content   interface{} `json:"content,omitempty"`
+	IsError   bool        `json:"is_error,omitempty"`
 }
 
 type ClaudeResponse struct {
@@ -125,37 +144,9 @@ type GeminiResponse struct {
 
 // ======================== OpenAI -> Other ========================
 
-// OpenAIToClaude 将 OpenAI 请求转换为 Claude 请求
+// OpenAIToClaude 将 OpenAI 请求转换为 Claude 请求（含工具定义、工具调用和工具结果）
 func (c *FormatConverter) OpenAIToClaude(req *OpenAIRequest) *ClaudeRequest {
-	messages := make([]ClaudeMessage, 0, len(req.Messages))
-	var system string
-
-	for _, msg := range req.Messages {
-		if msg.Role == "system" {
-			system = msg.Content
-			continue
-		}
-		messages = append(messages, ClaudeMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
-		})
-	}
-
-	maxTokens := req.MaxTokens
-	if maxTokens == 0 {
-		maxTokens = 4096
-	}
-
-	return &ClaudeRequest{
-		Model:         req.Model,
-		MaxTokens:     maxTokens,
-		System:        system,
-		Messages:      messages,
-		Temperature:   req.Temperature,
-		TopP:          req.TopP,
-		Stream:        req.Stream,
-		StopSequences: req.Stop,
-	}
+	return c.UnifiedToClaude(c.OpenAIToUnified(req))
 }
 
 // OpenAIToGemini 将 OpenAI 请求转换为 Gemini 请求
@@ -201,34 +192,9 @@ func (c *FormatConverter) OpenAIToGemini(req *OpenAIRequest) *GeminiRequest {
 
 // ======================== Claude -> Other ========================
 
-// ClaudeToOpenAI 将 Claude 请求转换为 OpenAI 请求
+// ClaudeToOpenAI 将 Claude 请求转换为 OpenAI 请求（含工具定义、工具调用和工具结果）
 func (c *FormatConverter) ClaudeToOpenAI(req *ClaudeRequest) *OpenAIRequest {
-	messages := make([]OpenAIMessage, 0, len(req.Messages)+1)
-
-	if req.System != "" {
-		messages = append(messages, OpenAIMessage{
-			Role:    "system",
-			Content: req.System,
-		})
-	}
-
-	for _, msg := range req.Messages {
-		content := c.extractTextContent(msg.Content)
-		messages = append(messages, OpenAIMessage{
-			Role:    msg.Role,
-			Content: content,
-		})
-	}
-
-	return &OpenAIRequest{
-		Model:       req.Model,
-		Messages:    messages,
-		MaxTokens:   req.MaxTokens,
-		Temperature: req.Temperature,
-		TopP:        req.TopP,
-		Stream:      req.Stream,
-		Stop:        req.StopSequences,
-	}
+	return c.UnifiedToOpenAI(c.ClaudeToUnified(req))
 }
 
 // ClaudeToGemini 将 Claude 请求转换为 Gemini 请求
@@ -392,8 +358,9 @@ func (c *FormatConverter) ClaudeResponseToOpenAI(resp *ClaudeResponse) *OpenAIRe
 			{
 				Index: 0,
 				Message: OpenAIMessage{
-					Role:    "assistant",
-					Content: content,
+					Role:      "assistant",
+					Content:   content,
+					ToolCalls: claudeBlocksToToolCalls(resp.Content),
 				},
 				FinishReason: finishReason,
 			},
@@ -455,22 +422,28 @@ func (c *FormatConverter) GeminiResponseToOpenAI(resp *GeminiResponse) *OpenAIRe
 func (c *FormatConverter) OpenAIResponseToClaude(resp *OpenAIResponse) *ClaudeResponse {
 	content := ""
 	stopReason := ""
+	var toolCalls []ToolCall
 
 	if len(resp.Choices) > 0 {
 		content = resp.Choices[0].Message.Content
+		toolCalls = resp.Choices[0].Message.ToolCalls
 		stopReason = c.openAIStopReasonToClaude(resp.Choices[0].FinishReason)
 	}
 
+	blocks := make([]ClaudeContentBlock, 0, len(toolCalls)+1)
+	if content != "" || len(toolCalls) == 0 {
+		blocks = append(blocks, ClaudeContentBlock{
+			Type: "text",
+			Text: content,
+		})
+	}
+	blocks = append(blocks, ToolCallsToClaudeBlocks(toolCalls)...)
+
 	return &ClaudeResponse{
-		ID:   resp.ID,
-		Type: "message",
-		Role: "assistant",
-		Content: []ClaudeContentBlock{
-			{
-				Type: "text",
-				Text: content,
-			},
-		},
+		ID:      resp.ID,
+		Type:    "message",
+		Role:    "assistant",
+		Content: blocks,
 		Model:      resp.Model,
 		StopReason: stopReason,
 		Usage: struct {
@@ -483,6 +456,144 @@ func (c *FormatConverter) OpenAIResponseToClaude(resp *OpenAIResponse) *ClaudeRe
 	}
 }
 
+// ======================== Unified Request ========================
+
+// ParseClaudeRequest 解析 Claude 原始请求体
+// system 支持字符串或内容块数组，消息内容保留原始结构
+func (c *FormatConverter) ParseClaudeRequest(body []byte) (*ClaudeRequest, error) {
+	var raw struct {
+		ClaudeRequest
+		System interface{} `json:"system,omitempty"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, err
+	}
+	req := raw.ClaudeRequest
+	if raw.System != nil {
+		req.System = c.extractTextContent(raw.System)
+	}
+	return &req, nil
+}
+
+// UnifiedToOpenAI 将统一请求转换为 OpenAI 请求（内容块合并为文本）
+func (c *FormatConverter) UnifiedToOpenAI(req *Request) *OpenAIRequest {
+	messages := make([]OpenAIMessage, 0, len(req.Messages)+1)
+
+	if req.System != "" {
+		messages = append(messages, OpenAIMessage{
+			Role:    "system",
+			Content: req.System,
+		})
+	}
+
+	for _, msg := range req.Messages {
+		messages = append(messages, OpenAIMessage{
+			Role:       msg.Role,
+			Content:    c.extractTextContent(msg.Content),
+			ToolCalls:  msg.ToolCalls,
+			ToolCallID: msg.ToolCallID,
+			Name:       msg.Name,
+		})
+	}
+
+	return &OpenAIRequest{
+		Model:       req.Model,
+		Messages:    messages,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		Stream:      req.Stream,
+		Stop:        req.Stop,
+		Tools:       req.Tools,
+		ToolChoice:  req.ToolChoice,
+	}
+}
+
+// OpenAIToUnified 将 OpenAI 请求转换为统一请求（system 消息提取到 System 字段）
+func (c *FormatConverter) OpenAIToUnified(req *OpenAIRequest) *Request {
+	messages := make([]Message, 0, len(req.Messages))
+	var system string
+
+	for _, msg := range req.Messages {
+		if msg.Role == "system" {
+			if system != "" {
+				system += "\n\n"
+			}
+			system += msg.Content
+			continue
+		}
+		messages = append(messages, Message{
+			Role:       msg.Role,
+			Content:    msg.Content,
+			ToolCalls:  msg.ToolCalls,
+			ToolCallID: msg.ToolCallID,
+			Name:       msg.Name,
+		})
+	}
+
+	return &Request{
+		Model:       req.Model,
+		Messages:    messages,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		Stream:      req.Stream,
+		Stop:        req.Stop,
+		System:      system,
+		Tools:       req.Tools,
+		ToolChoice:  req.ToolChoice,
+	}
+}
+
+// ClaudeToUnified 将 Claude 请求转换为统一请求
+// tool_use 转换为 ToolCalls，tool_result 转换为 role=tool 消息
+func (c *FormatConverter) ClaudeToUnified(req *ClaudeRequest) *Request {
+	return &Request{
+		Model:       req.Model,
+		Messages:    claudeMessagesToUnified(req.Messages),
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		Stream:      req.Stream,
+		Stop:        req.StopSequences,
+		System:      req.System,
+		Tools:       toolsFromClaude(req.Tools),
+		ToolChoice:  toolChoiceFromClaude(req.ToolChoice),
+	}
+}
+
+// UnifiedToClaude 将统一请求转换为 Claude 请求
+// system 消息合并到 System，ToolCalls 转换为 tool_use，role=tool 消息转换为 tool_result
+func (c *FormatConverter) UnifiedToClaude(req *Request) *ClaudeRequest {
+	system := req.System
+	for _, msg := range req.Messages {
+		if msg.Role == "system" {
+			if system != "" {
+				system += "\n\n"
+			}
+			system += c.extractTextContent(msg.Content)
+		}
+	}
+
+	maxTokens := req.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = 4096
+	}
+
+	return &ClaudeRequest{
+		Model:         req.Model,
+		MaxTokens:     maxTokens,
+		System:        system,
+		Messages:      unifiedMessagesToClaude(req.Messages),
+		Temperature:   req.Temperature,
+		TopP:          req.TopP,
+		Stream:        req.Stream,
+		StopSequences: req.Stop,
+		Tools:         toolsToClaude(req.Tools),
+		ToolChoice:    toolChoiceToClaude(req.ToolChoice),
+	}
+}
+
 // ======================== Helper Functions ========================
 
 func (c *FormatConverter) extractTextContent(content interface{}) string {
@@ -535,6 +646,8 @@ func (c *FormatConverter) claudeStopReasonToOpenAI(reason string) string {
 		return "length"
 	case "stop_sequence":
 		return "stop"
+	case "tool_use":
+		return "tool_calls"
 	default:
 		return reason
 	}
@@ -561,6 +674,8 @@ func (c *FormatConverter) openAIStopReasonToClaude(reason string) string {
 		return "max_tokens"
 	case "content_filter":
 		return "content_filter"
+	case "tool_calls":
+		return "tool_use"
 	default:
 		return reason
 	}
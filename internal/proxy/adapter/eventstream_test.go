@@ -0,0 +1,239 @@
+/*
+ * 文件作用：Event Stream 解码与 Bedrock 流式转发测试
+ * 负责功能：
+ *   - 使用 testdata 中的 Bedrock 二进制帧样本（按 Bedrock 响应格式构造）验证解码
+ *   - 前导/整帧 CRC 错误、截断帧、异常帧
+ *   - chunk.bytes base64 解码后输出的 Claude SSE 与用量提取
+ * 重要程度：⭐⭐ 辅助（测试）
+ * 依赖模块：无
+ */
+package adapter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readFixture 读取 testdata 中的样本数据
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("read fixture %s: %v", name, err)
+	}
+	return data
+}
+
+// firstFrameLen 返回第一帧的总长度
+func firstFrameLen(data []byte) int {
+	return int(binary.BigEndian.Uint32(data[0:4]))
+}
+
+// frameEnd 返回前 n 帧的结束位置
+func frameEnd(data []byte, n int) int {
+	offset := 0
+	for i := 0; i < n; i++ {
+		offset += firstFrameLen(data[offset:])
+	}
+	return offset
+}
+
+// corrupt 复制数据并翻转指定位置的一个字节
+func corrupt(data []byte, offset int) []byte {
+	out := append([]byte(nil), data...)
+	out[offset] ^= 0xff
+	return out
+}
+
+func TestEventStreamDecoderNext(t *testing.T) {
+	stream := readFixture(t, "bedrock_stream.bin")
+	frameLen := firstFrameLen(stream)
+
+	tests := []struct {
+		name       string
+		input      []byte
+		wantFrames int
+		wantErr    error  // errors.Is 判断，nil 表示只检查 wantErrMsg
+		wantErrMsg string // 错误信息包含的内容，空表示期望正常结束（io.EOF）
+	}{
+		{name: "recorded stream", input: stream, wantFrames: 8},
+		{name: "empty stream", input: nil, wantFrames: 0},
+		{name: "prelude crc mismatch", input: corrupt(stream, 8), wantErr: ErrEventStreamCRC, wantErrMsg: "(prelude)"},
+		{name: "prelude length tampered", input: corrupt(stream, 3), wantErr: ErrEventStreamCRC, wantErrMsg: "(prelude)"},
+		{name: "message crc mismatch", input: corrupt(stream, frameLen-1), wantErr: ErrEventStreamCRC, wantErrMsg: "(message)"},
+		{name: "payload tampered", input: corrupt(stream, frameLen-10), wantErr: ErrEventStreamCRC, wantErrMsg: "(message)"},
+		{name: "second frame crc mismatch", input: corrupt(stream, frameEnd(stream, 2)-1), wantFrames: 1, wantErr: ErrEventStreamCRC, wantErrMsg: "(message)"},
+		{name: "truncated prelude", input: stream[:6], wantErrMsg: "truncated prelude"},
+		{name: "truncated message", input: stream[:frameLen-5], wantErrMsg: "truncated message"},
+		{name: "truncated after first frame", input: stream[:frameLen+20], wantFrames: 1, wantErrMsg: "truncated message"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoder := newEventStreamDecoder(bytes.NewReader(tt.input))
+			frames := 0
+			var err error
+			for {
+				var msg *eventStreamMessage
+				msg, err = decoder.Next()
+				if err != nil {
+					break
+				}
+				frames++
+				if got := msg.Headers[":event-type"]; got != "chunk" {
+					t.Errorf("frame %d :event-type = %q, want chunk", frames, got)
+				}
+				if got := msg.Headers[":message-type"]; got != "event" {
+					t.Errorf("frame %d :message-type = %q, want event", frames, got)
+				}
+			}
+
+			if frames != tt.wantFrames {
+				t.Errorf("decoded %d frames, want %d", frames, tt.wantFrames)
+			}
+			if tt.wantErrMsg == "" {
+				if err != io.EOF {
+					t.Fatalf("err = %v, want io.EOF", err)
+				}
+				return
+			}
+			if err == io.EOF {
+				t.Fatalf("err = io.EOF, want error containing %q", tt.wantErrMsg)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want errors.Is %v", err, tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErrMsg) {
+				t.Errorf("err = %v, want containing %q", err, tt.wantErrMsg)
+			}
+		})
+	}
+}
+
+func TestEventStreamDecoderExceptionHeaders(t *testing.T) {
+	decoder := newEventStreamDecoder(bytes.NewReader(readFixture(t, "bedrock_throttling.bin")))
+	var last *eventStreamMessage
+	for {
+		msg, err := decoder.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		last = msg
+	}
+	if last == nil {
+		t.Fatal("no frames decoded")
+	}
+
+	want := map[string]string{
+		":message-type":   "exception",
+		":exception-type": "throttlingException",
+		":content-type":   "application/json",
+	}
+	for name, value := range want {
+		if got := last.Headers[name]; got != value {
+			t.Errorf("header %s = %q, want %q", name, got, value)
+		}
+	}
+	if !bytes.Contains(last.Payload, []byte("Too many requests")) {
+		t.Errorf("payload = %s, want exception message", last.Payload)
+	}
+}
+
+func TestBedrockRelayEventStream(t *testing.T) {
+	stream := readFixture(t, "bedrock_stream.bin")
+	golden := string(readFixture(t, "bedrock_stream.sse"))
+	throttling := readFixture(t, "bedrock_throttling.bin")
+	frameLen := firstFrameLen(stream)
+
+	// 异常帧之前的 4 个事件与正常流的前 4 个事件相同
+	goldenEvents := strings.SplitAfter(golden, "\n\n")
+	throttledPrefix := strings.Join(goldenEvents[:4], "")
+
+	tests := []struct {
+		name       string
+		input      []byte
+		wantOutput string
+		wantResult StreamResult
+		wantStatus int    // 期望的 UpstreamError 状态码，0 表示不检查
+		wantErrMsg string // 错误信息包含的内容，空表示期望成功
+	}{
+		{
+			name:       "recorded stream",
+			input:      stream,
+			wantOutput: golden,
+			wantResult: StreamResult{InputTokens: 25, OutputTokens: 12, CacheCreationInputTokens: 1024, CacheReadInputTokens: 2048},
+		},
+		{
+			name:  "throttling exception frame",
+			input: throttling,
+			wantOutput: throttledPrefix + "event: error\n" +
+				`data: {"error":{"message":"Too many requests, please wait before trying again.","type":"rate_limit_error"},"type":"error"}` + "\n\n",
+			wantResult: StreamResult{InputTokens: 25, OutputTokens: 1, CacheCreationInputTokens: 1024, CacheReadInputTokens: 2048},
+			wantStatus: http.StatusTooManyRequests,
+			wantErrMsg: "throttlingException: Too many requests",
+		},
+		{
+			name:       "truncated after first frame",
+			input:      stream[:frameLen+20],
+			wantOutput: goldenEvents[0],
+			wantResult: StreamResult{InputTokens: 25, OutputTokens: 1, CacheCreationInputTokens: 1024, CacheReadInputTokens: 2048},
+			wantErrMsg: "truncated message",
+		},
+		{
+			name:       "message crc mismatch",
+			input:      corrupt(stream, frameLen-1),
+			wantOutput: "",
+			wantErrMsg: "checksum mismatch",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			result, err := (&BedrockAdapter{}).relayEventStream(bytes.NewReader(tt.input), rec)
+
+			if got := rec.Body.String(); got != tt.wantOutput {
+				t.Errorf("output mismatch\n got: %q\nwant: %q", got, tt.wantOutput)
+			}
+			if result == nil {
+				t.Fatal("result is nil")
+			}
+			if result.InputTokens != tt.wantResult.InputTokens ||
+				result.OutputTokens != tt.wantResult.OutputTokens ||
+				result.CacheCreationInputTokens != tt.wantResult.CacheCreationInputTokens ||
+				result.CacheReadInputTokens != tt.wantResult.CacheReadInputTokens {
+				t.Errorf("result = %+v, want %+v", *result, tt.wantResult)
+			}
+			if tt.wantOutput != "" && !rec.Flushed {
+				t.Error("writer was never flushed")
+			}
+
+			if tt.wantErrMsg == "" {
+				if err != nil {
+					t.Fatalf("err = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErrMsg) {
+				t.Fatalf("err = %v, want containing %q", err, tt.wantErrMsg)
+			}
+			if tt.wantStatus != 0 {
+				var upstreamErr *UpstreamError
+				if !errors.As(err, &upstreamErr) || upstreamErr.StatusCode != tt.wantStatus {
+					t.Errorf("err = %v, want UpstreamError with status %d", err, tt.wantStatus)
+				}
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@
  *   - Gemini API 请求转发
  *   - OpenAI 格式到 Gemini 格式转换
  *   - 流式SSE响应处理
+ *   - 工具调用（functionCall/functionResponse）转换
  *   - Usage数据解析
  * 重要程度：⭐⭐⭐⭐ 重要（Gemini平台适配器）
  * 依赖模块：model, logger, http_client
@@ -48,6 +49,8 @@ type geminiRequest struct {
 	Contents          []geminiContent         `json:"contents"`
 	SystemInstruction *geminiContent          `json:"systemInstruction,omitempty"`
 	GenerationConfig  *geminiGenerationConfig `json:"generationConfig,omitempty"`
+	Tools             []geminiTool            `json:"tools,omitempty"`
+	ToolConfig        *geminiToolConfig       `json:"toolConfig,omitempty"`
 }
 
 type geminiContent struct {
@@ -56,7 +59,36 @@ type geminiContent struct {
 }
 
 type geminiPart struct {
-	Text string `json:"text,omitempty"`
+	Text             string                  `json:"text,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type geminiFunctionCall struct {
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type geminiFunctionResponse struct {
+	Name     string          `json:"name"`
+	Response json.RawMessage `json:"response"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []geminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type geminiFunctionDeclaration struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+type geminiToolConfig struct {
+	FunctionCallingConfig struct {
+		Mode                 string   `json:"mode"`
+		AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+	} `json:"functionCallingConfig"`
 }
 
 type geminiGenerationConfig struct {
@@ -70,10 +102,8 @@ type geminiGenerationConfig struct {
 type geminiResponse struct {
 	Candidates []struct {
 		Content struct {
-			Parts []struct {
-				Text string `json:"text"`
-			} `json:"parts"`
-			Role string `json:"role"`
+			Parts []geminiPart `json:"parts"`
+			Role  string       `json:"role"`
 		} `json:"content"`
 		FinishReason string `json:"finishReason"`
 	} `json:"candidates"`
@@ -151,12 +181,19 @@ func (a *GeminiAdapter) Send(ctx context.Context, account *model.Account, req *R
 
 	content := ""
 	stopReason := ""
+	var toolCalls []ToolCall
 	if len(geminiResp.Candidates) > 0 {
 		candidate := geminiResp.Candidates[0]
 		for _, part := range candidate.Content.Parts {
 			content += part.Text
+			if part.FunctionCall != nil {
+				toolCalls = append(toolCalls, a.convertFunctionCall(part.FunctionCall))
+			}
+		}
+		stopReason = a.convertStopReason(candidate.FinishReason)
+		if len(toolCalls) > 0 && stopReason == "stop" {
+			stopReason = "tool_calls"
 		}
-		stopReason = candidate.FinishReason
 	}
 
 	log.Info("Gemini 请求成功 - Model: %s, InputTokens: %d, OutputTokens: %d",
@@ -166,7 +203,8 @@ func (a *GeminiAdapter) Send(ctx context.Context, account *model.Account, req *R
 		ID:           "", // Gemini 不返回 ID
 		Model:        req.Model,
 		Content:      content,
-		StopReason:   a.convertStopReason(stopReason),
+		ToolCalls:    toolCalls,
+		StopReason:   stopReason,
 		InputTokens:  geminiResp.UsageMetadata.PromptTokenCount,
 		OutputTokens: geminiResp.UsageMetadata.CandidatesTokenCount,
 	}, nil
@@ -213,7 +251,7 @@ func (a *GeminiAdapter) SendStream(ctx context.Context, account *model.Account,
 		log.Error("Gemini Stream API 错误 - StatusCode: %d, Body: %s", resp.StatusCode, string(respBody))
 		// 发送 SSE 错误事件给客户端
 		a.sendSSEError(writer, fmt.Sprintf("upstream_error_%d", resp.StatusCode), string(respBody))
-		return nil, NewUpstreamError(resp.StatusCode, string(respBody))
+		return nil, NewUpstreamErrorFromResponse(resp, string(respBody))
 	}
 
 	log.Info("Gemini Stream 开始传输 | StatusCode: %d | AccountID: %d", resp.StatusCode, account.ID)
@@ -270,6 +308,7 @@ func (a *GeminiAdapter) SendStream(ctx context.Context, account *model.Account,
 	}()
 
 	// Gemini 流式响应格式不同，需要转换为 OpenAI 格式
+	toolCallCount := 0
 	scanner := bufio.NewScanner(resp.Body)
 	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
 
@@ -313,23 +352,49 @@ func (a *GeminiAdapter) SendStream(ctx context.Context, account *model.Account,
 
 		// 转换为 OpenAI 流式格式
 		if len(chunk.Candidates) > 0 && len(chunk.Candidates[0].Content.Parts) > 0 {
+			delta := map[string]interface{}{}
+			text := ""
+			var toolCalls []map[string]interface{}
+			for _, part := range chunk.Candidates[0].Content.Parts {
+				text += part.Text
+				if part.FunctionCall != nil {
+					// Gemini 一次返回完整调用参数，按出现顺序分配 tool_calls 索引
+					call := a.convertFunctionCall(part.FunctionCall)
+					toolCalls = append(toolCalls, map[string]interface{}{
+						"index":    toolCallCount,
+						"id":       call.ID,
+						"type":     call.Type,
+						"function": call.Function,
+					})
+					toolCallCount++
+				}
+			}
+			if text != "" || len(toolCalls) == 0 {
+				delta["content"] = text
+			}
+			if len(toolCalls) > 0 {
+				delta["tool_calls"] = toolCalls
+			}
+
 			openAIChunk := map[string]interface{}{
 				"id":     "chatcmpl-gemini",
 				"object": "chat.completion.chunk",
 				"model":  req.Model,
 				"choices": []map[string]interface{}{
 					{
-						"index": 0,
-						"delta": map[string]interface{}{
-							"content": chunk.Candidates[0].Content.Parts[0].Text,
-						},
+						"index":         0,
+						"delta":         delta,
 						"finish_reason": nil,
 					},
 				},
 			}
 
 			if chunk.Candidates[0].FinishReason != "" {
-				openAIChunk["choices"].([]map[string]interface{})[0]["finish_reason"] = a.convertStopReason(chunk.Candidates[0].FinishReason)
+				finishReason := a.convertStopReason(chunk.Candidates[0].FinishReason)
+				if toolCallCount > 0 && finishReason == "stop" {
+					finishReason = "tool_calls"
+				}
+				openAIChunk["choices"].([]map[string]interface{})[0]["finish_reason"] = finishReason
 			}
 
 			chunkData, _ := json.Marshal(openAIChunk)
@@ -405,8 +470,27 @@ func (a *GeminiAdapter) buildURL(account *model.Account, modelName string, strea
 
 func (a *GeminiAdapter) convertRequest(req *Request) *geminiRequest {
 	contents := make([]geminiContent, 0, len(req.Messages))
+	toolNames := make(map[string]string) // 工具调用 ID -> 函数名（functionResponse 需要函数名）
 
 	for _, msg := range req.Messages {
+		// 工具结果：连续的 tool 消息合并到同一个 functionResponse 内容中
+		if msg.Role == "tool" {
+			name := msg.Name
+			if name == "" {
+				name = toolNames[msg.ToolCallID]
+			}
+			part := geminiPart{FunctionResponse: &geminiFunctionResponse{
+				Name:     name,
+				Response: a.convertToolResult(msg.Content),
+			}}
+			if n := len(contents); n > 0 && contents[n-1].Role == "user" && contents[n-1].Parts[0].FunctionResponse != nil {
+				contents[n-1].Parts = append(contents[n-1].Parts, part)
+			} else {
+				contents = append(contents, geminiContent{Role: "user", Parts: []geminiPart{part}})
+			}
+			continue
+		}
+
 		role := msg.Role
 		if role == "assistant" {
 			role = "model"
@@ -419,16 +503,28 @@ func (a *GeminiAdapter) convertRequest(req *Request) *geminiRequest {
 		case []interface{}:
 			for _, block := range v {
 				if b, ok := block.(map[string]interface{}); ok {
-					if b["type"] == "text" {
-						text += b["text"].(string)
+					if t, ok := b["text"].(string); ok && b["type"] == "text" {
+						text += t
 					}
 				}
 			}
 		}
 
+		parts := make([]geminiPart, 0, len(msg.ToolCalls)+1)
+		if text != "" || len(msg.ToolCalls) == 0 {
+			parts = append(parts, geminiPart{Text: text})
+		}
+		for _, call := range msg.ToolCalls {
+			toolNames[call.ID] = call.Function.Name
+			parts = append(parts, geminiPart{FunctionCall: &geminiFunctionCall{
+				Name: call.Function.Name,
+				Args: toolInputJSON(json.RawMessage(call.Function.Arguments)),
+			}})
+		}
+
 		contents = append(contents, geminiContent{
 			Role:  role,
-			Parts: []geminiPart{{Text: text}},
+			Parts: parts,
 		})
 	}
 
@@ -451,9 +547,106 @@ func (a *GeminiAdapter) convertRequest(req *Request) *geminiRequest {
 		}
 	}
 
+	if len(req.Tools) > 0 {
+		declarations := make([]geminiFunctionDeclaration, 0, len(req.Tools))
+		for _, tool := range req.Tools {
+			declarations = append(declarations, geminiFunctionDeclaration{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  cleanGeminiSchema(tool.Parameters),
+			})
+		}
+		geminiReq.Tools = []geminiTool{{FunctionDeclarations: declarations}}
+		geminiReq.ToolConfig = a.convertToolChoice(req.ToolChoice)
+	}
+
 	return geminiReq
 }
 
+// convertToolChoice 将 OpenAI tool_choice 转换为 Gemini functionCallingConfig
+func (a *GeminiAdapter) convertToolChoice(choice interface{}) *geminiToolConfig {
+	config := &geminiToolConfig{}
+	switch v := choice.(type) {
+	case string:
+		switch v {
+		case "none":
+			config.FunctionCallingConfig.Mode = "NONE"
+		case "required":
+			config.FunctionCallingConfig.Mode = "ANY"
+		default:
+			return nil
+		}
+	case map[string]interface{}:
+		name := toolChoiceName(v)
+		if name == "" {
+			return nil
+		}
+		config.FunctionCallingConfig.Mode = "ANY"
+		config.FunctionCallingConfig.AllowedFunctionNames = []string{name}
+	default:
+		return nil
+	}
+	return config
+}
+
+// convertToolResult 将工具结果转换为 functionResponse.response（必须是 JSON 对象）
+func (a *GeminiAdapter) convertToolResult(content interface{}) json.RawMessage {
+	text := extractBlockText(content)
+	if trimmed := strings.TrimSpace(text); strings.HasPrefix(trimmed, "{") && json.Valid([]byte(trimmed)) {
+		return json.RawMessage(trimmed)
+	}
+	data, _ := json.Marshal(map[string]string{"content": text})
+	return data
+}
+
+// convertFunctionCall 将 Gemini functionCall 转换为统一工具调用（Gemini 不返回调用 ID，自动生成）
+func (a *GeminiAdapter) convertFunctionCall(call *geminiFunctionCall) ToolCall {
+	return ToolCall{
+		ID:   newToolCallID(),
+		Type: "function",
+		Function: ToolCallFunction{
+			Name:      call.Name,
+			Arguments: string(toolInputJSON(call.Args)),
+		},
+	}
+}
+
+// cleanGeminiSchema 移除 Gemini 不支持的 JSON Schema 字段
+func cleanGeminiSchema(schema json.RawMessage) json.RawMessage {
+	if len(schema) == 0 {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(schema, &v); err != nil {
+		return schema
+	}
+	data, err := json.Marshal(stripSchemaKeys(v))
+	if err != nil {
+		return schema
+	}
+	return data
+}
+
+// stripSchemaKeys 递归移除 $schema、additionalProperties 等 Gemini 不接受的字段
+func stripSchemaKeys(v interface{}) interface{} {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		delete(node, "$schema")
+		delete(node, "additionalProperties")
+		for key, child := range node {
+			node[key] = stripSchemaKeys(child)
+		}
+		return node
+	case []interface{}:
+		for i, child := range node {
+			node[i] = stripSchemaKeys(child)
+		}
+		return node
+	default:
+		return v
+	}
+}
+
 func (a *GeminiAdapter) convertStopReason(reason string) string {
 	switch reason {
 	case "STOP":
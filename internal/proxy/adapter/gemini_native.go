@@ -0,0 +1,242 @@
+/*
+ * 文件作用：Gemini 原生 API 透传，供 Gemini CLI / Google SDK 直接使用原生请求和响应格式
+ * 负责功能：
+ *   - generateContent / countTokens 原生请求转发
+ *   - streamGenerateContent 流式转发（SSE 或 JSON 数组）
+ *   - 从 usageMetadata 提取计费用量
+ * 重要程度：⭐⭐⭐⭐ 重要（Gemini 原生客户端接入）
+ * 依赖模块：model, logger, http_client
+ */
+package adapter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"cli-proxy/internal/model"
+	"cli-proxy/pkg/logger"
+)
+
+// Gemini 原生接口动作
+const (
+	GeminiActionGenerateContent       = "generateContent"
+	GeminiActionStreamGenerateContent = "streamGenerateContent"
+	GeminiActionCountTokens           = "countTokens"
+)
+
+// geminiUsageChunk 原生响应中的用量字段
+type geminiUsageChunk struct {
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+		TotalTokenCount      int `json:"totalTokenCount"`
+	} `json:"usageMetadata"`
+	TotalTokens int `json:"totalTokens"` // countTokens 响应
+}
+
+// SendNative 转发原生 generateContent / countTokens 请求
+// req.RawBody 为原生请求体，响应体原样放入 Response.Body，用量从 usageMetadata 解析
+func (a *GeminiAdapter) SendNative(ctx context.Context, account *model.Account, req *Request, action string) (*Response, error) {
+	log := logger.GetLogger("proxy")
+
+	reqURL := a.buildNativeURL(account, req.Model, action, false)
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", reqURL, bytes.NewReader(req.RawBody))
+	if err != nil {
+		log.Error("Gemini Native 创建请求失败: %v", err)
+		return nil, err
+	}
+	a.setNativeHeaders(httpReq, account)
+
+	log.Debug("Gemini Native 请求开始 - URL: %s, AccountID: %d, Model: %s",
+		strings.Split(reqURL, "?")[0], account.ID, req.Model)
+
+	client := GetHTTPClient(account)
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		log.Error("Gemini Native 请求失败 - 网络错误: %v", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ReadResponseBody(resp)
+	if err != nil {
+		log.Error("Gemini Native 读取响应失败: %v", err)
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		log.Error("Gemini Native API 错误 - StatusCode: %d, Body: %s", resp.StatusCode, truncateBody(string(respBody), 1000))
+		return nil, NewUpstreamErrorFromResponse(resp, string(respBody))
+	}
+
+	var usage geminiUsageChunk
+	json.Unmarshal(respBody, &usage)
+
+	log.Info("Gemini Native 请求成功 - Action: %s, Model: %s, InputTokens: %d, OutputTokens: %d",
+		action, req.Model, usage.UsageMetadata.PromptTokenCount, usage.UsageMetadata.CandidatesTokenCount)
+
+	return &Response{
+		Model:        req.Model,
+		InputTokens:  usage.UsageMetadata.PromptTokenCount,
+		OutputTokens: usage.UsageMetadata.CandidatesTokenCount,
+		Body:         respBody,
+	}, nil
+}
+
+// SendNativeStream 转发原生 streamGenerateContent 请求
+// 上游始终使用 alt=sse；sse 为 false 时按 Gemini 默认格式以 JSON 数组输出给客户端
+func (a *GeminiAdapter) SendNativeStream(ctx context.Context, account *model.Account, req *Request, writer io.Writer, sse bool) (*StreamResult, error) {
+	log := logger.GetLogger("proxy")
+
+	reqURL := a.buildNativeURL(account, req.Model, GeminiActionStreamGenerateContent, true)
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", reqURL, bytes.NewReader(req.RawBody))
+	if err != nil {
+		log.Error("Gemini Native Stream 创建请求失败: %v", err)
+		return nil, err
+	}
+	a.setNativeHeaders(httpReq, account)
+	httpReq.Header.Set("Accept", "text/event-stream")
+
+	log.Info("Gemini Native Stream 请求开始 | URL: %s | AccountID: %d | Model: %s",
+		strings.Split(reqURL, "?")[0], account.ID, req.Model)
+
+	client := GetStreamHTTPClient(account)
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		log.Error("Gemini Native Stream 请求失败 - 网络错误: %v", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ReadResponseBody(resp)
+		log.Error("Gemini Native Stream API 错误 - StatusCode: %d, Body: %s", resp.StatusCode, truncateBody(string(respBody), 1000))
+		return nil, NewUpstreamErrorFromResponse(resp, string(respBody))
+	}
+
+	// 监控 context 取消（客户端断开）
+	streamDone := make(chan struct{})
+	defer close(streamDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+			log.Info("Gemini Native Stream 客户端断开或超时，关闭上游连接")
+			resp.Body.Close()
+		case <-streamDone:
+		}
+	}()
+
+	flusher, hasFlusher := writer.(http.Flusher)
+	write := func(data string) error {
+		if _, err := writer.Write([]byte(data)); err != nil {
+			return err
+		}
+		if hasFlusher {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	result := &StreamResult{}
+	chunkCount := 0
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "" {
+			continue
+		}
+
+		var usage geminiUsageChunk
+		if err := json.Unmarshal([]byte(data), &usage); err == nil {
+			if usage.UsageMetadata.PromptTokenCount > 0 {
+				result.InputTokens = usage.UsageMetadata.PromptTokenCount
+			}
+			if usage.UsageMetadata.CandidatesTokenCount > 0 {
+				result.OutputTokens = usage.UsageMetadata.CandidatesTokenCount
+			}
+		}
+
+		var out string
+		switch {
+		case sse:
+			out = "data: " + data + "\r\n\r\n"
+		case chunkCount == 0:
+			out = "[" + data
+		default:
+			out = ",\r\n" + data
+		}
+		if err := write(out); err != nil {
+			log.Warn("Gemini Native Stream 写入客户端失败: %v", err)
+			return result, err
+		}
+		chunkCount++
+	}
+
+	// 读取失败时不补数组结尾：调用方在已输出的流末尾追加错误元素和 ]
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			log.Info("Gemini Native Stream 因 context 取消而结束: %v", ctx.Err())
+			return result, ctx.Err()
+		}
+		log.Error("Gemini Native Stream 读取上游错误: %v", err)
+		return result, err
+	}
+
+	if !sse {
+		closing := "]"
+		if chunkCount == 0 {
+			closing = "[]"
+		}
+		if err := write(closing); err != nil {
+			return result, err
+		}
+	}
+
+	log.Info("Gemini Native Stream 传输完成 | Model: %s | AccountID: %d | InputTokens: %d | OutputTokens: %d",
+		req.Model, account.ID, result.InputTokens, result.OutputTokens)
+	return result, nil
+}
+
+// buildNativeURL 构建原生接口 URL（API Key 账户使用 key 参数认证）
+func (a *GeminiAdapter) buildNativeURL(account *model.Account, modelName, action string, sse bool) string {
+	baseURL := "https://generativelanguage.googleapis.com/v1beta"
+	if account.BaseURL != "" {
+		baseURL = strings.TrimSuffix(account.BaseURL, "/")
+	}
+	modelName = strings.TrimPrefix(modelName, "models/")
+
+	query := url.Values{}
+	if sse {
+		query.Set("alt", "sse")
+	}
+	if account.APIKey != "" {
+		query.Set("key", account.APIKey)
+	}
+
+	reqURL := fmt.Sprintf("%s/models/%s:%s", baseURL, modelName, action)
+	if len(query) > 0 {
+		reqURL += "?" + query.Encode()
+	}
+	return reqURL
+}
+
+// setNativeHeaders 设置原生请求头（OAuth 账户使用 Bearer Token 认证）
+func (a *GeminiAdapter) setNativeHeaders(httpReq *http.Request, account *model.Account) {
+	httpReq.Header.Set("Content-Type", "application/json")
+	if account.APIKey == "" && account.AccessToken != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+account.AccessToken)
+	}
+}
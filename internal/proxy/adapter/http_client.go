@@ -7,8 +7,9 @@
  *   - SOCKS5/HTTP代理支持
  *   - gzip响应自动解压
  *   - 连接池参数配置
+ *   - 上游请求追踪（客户端 Span）
  * 重要程度：⭐⭐⭐⭐⭐ 核心（所有上游请求的基础）
- * 依赖模块：model, logger
+ * 依赖模块：model, logger, tracing
  */
 package adapter
 
@@ -29,6 +30,7 @@ import (
 
 	"cli-proxy/internal/model"
 	"cli-proxy/pkg/logger"
+	"cli-proxy/pkg/tracing"
 
 	utls "github.com/refraction-networking/utls"
 	"golang.org/x/net/proxy"
@@ -41,7 +43,7 @@ var (
 	// 默认客户端（无代理）- 普通请求
 	defaultHTTPClient = &http.Client{
 		Timeout: 120 * time.Second,
-		Transport: &http.Transport{
+		Transport: tracing.NewTransport(&http.Transport{
 			MaxIdleConns:        100,
 			MaxIdleConnsPerHost: 20,
 			IdleConnTimeout:     90 * time.Second,
@@ -50,13 +52,13 @@ var (
 				Timeout:   30 * time.Second,
 				KeepAlive: 30 * time.Second,
 			}).DialContext,
-		},
+		}),
 	}
 
 	// 默认客户端（无代理）- 流式请求
 	defaultStreamClient = &http.Client{
 		Timeout: 600 * time.Second, // 10 分钟超时
-		Transport: &http.Transport{
+		Transport: tracing.NewTransport(&http.Transport{
 			MaxIdleConns:          100,
 			MaxIdleConnsPerHost:   20,
 			IdleConnTimeout:       120 * time.Second,
@@ -67,7 +69,7 @@ var (
 				Timeout:   30 * time.Second,
 				KeepAlive: 30 * time.Second,
 			}).DialContext,
-		},
+		}),
 	}
 
 	// 代理客户端缓存（避免每次请求都创建新客户端）
@@ -216,7 +218,7 @@ func getOrCreateProxyClient(proxyURLStr string, streaming bool) *http.Client {
 	}
 
 	client := &http.Client{
-		Transport: transport,
+		Transport: tracing.NewTransport(transport),
 		Timeout:   timeout,
 	}
 
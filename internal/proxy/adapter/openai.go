@@ -3,6 +3,7 @@
  * 负责功能：
  *   - OpenAI Chat Completions API 转发
  *   - 流式SSE响应处理
+ *   - 工具调用（tools/tool_calls/role=tool）透传
  *   - Usage数据解析（输入/输出Token）
  *   - 错误响应处理
  * 重要程度：⭐⭐⭐⭐⭐ 核心（OpenAI平台核心适配器）
@@ -51,11 +52,16 @@ type openAIRequest struct {
 	TopP        float64         `json:"top_p,omitempty"`
 	Stream      bool            `json:"stream,omitempty"`
 	Stop        []string        `json:"stop,omitempty"`
+	Tools       []Tool          `json:"tools,omitempty"`
+	ToolChoice  interface{}     `json:"tool_choice,omitempty"`
 }
 
 type openAIMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	Name       string     `json:"name,omitempty"`
 }
 
 // OpenAI 响应格式
@@ -150,8 +156,10 @@ func (a *OpenAIAdapter) Send(ctx context.Context, account *model.Account, req *R
 
 	content := ""
 	stopReason := ""
+	var toolCalls []ToolCall
 	if len(openAIResp.Choices) > 0 {
 		content = openAIResp.Choices[0].Message.Content
+		toolCalls = openAIResp.Choices[0].Message.ToolCalls
 		stopReason = openAIResp.Choices[0].FinishReason
 	}
 
@@ -162,6 +170,7 @@ func (a *OpenAIAdapter) Send(ctx context.Context, account *model.Account, req *R
 		ID:           openAIResp.ID,
 		Model:        openAIResp.Model,
 		Content:      content,
+		ToolCalls:    toolCalls,
 		StopReason:   stopReason,
 		InputTokens:  openAIResp.Usage.PromptTokens,
 		OutputTokens: openAIResp.Usage.CompletionTokens,
@@ -211,7 +220,7 @@ func (a *OpenAIAdapter) SendStream(ctx context.Context, account *model.Account,
 	if resp.StatusCode != http.StatusOK {
 		respBody, _ := ReadResponseBody(resp)
 		log.Error("OpenAI Stream API 错误 - StatusCode: %d, Body: %s", resp.StatusCode, string(respBody))
-		return nil, NewUpstreamError(resp.StatusCode, string(respBody))
+		return nil, NewUpstreamErrorFromResponse(resp, string(respBody))
 	}
 
 	log.Debug("OpenAI Stream 响应状态码: %d, 开始接收流式数据", resp.StatusCode)
@@ -257,44 +266,5 @@ func (a *OpenAIAdapter) SendStream(ctx context.Context, account *model.Account,
 }
 
 func (a *OpenAIAdapter) convertRequest(req *Request) *openAIRequest {
-	messages := make([]openAIMessage, 0, len(req.Messages))
-
-	// 如果有 system prompt，添加到消息开头
-	if req.System != "" {
-		messages = append(messages, openAIMessage{
-			Role:    "system",
-			Content: req.System,
-		})
-	}
-
-	for _, msg := range req.Messages {
-		content := ""
-		switch v := msg.Content.(type) {
-		case string:
-			content = v
-		case []interface{}:
-			// 处理多模态内容
-			for _, block := range v {
-				if b, ok := block.(map[string]interface{}); ok {
-					if b["type"] == "text" {
-						content += b["text"].(string)
-					}
-				}
-			}
-		}
-		messages = append(messages, openAIMessage{
-			Role:    msg.Role,
-			Content: content,
-		})
-	}
-
-	return &openAIRequest{
-		Model:       req.Model,
-		Messages:    messages,
-		MaxTokens:   req.MaxTokens,
-		Temperature: req.Temperature,
-		TopP:        req.TopP,
-		Stream:      req.Stream,
-		Stop:        req.Stop,
-	}
+	return convertToOpenAIRequest(req)
 }
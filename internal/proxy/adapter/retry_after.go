@@ -0,0 +1,64 @@
+/*
+ * 文件作用：解析上游响应头中的重试等待时间
+ * 负责功能：
+ *   - Retry-After（秒数或 HTTP 日期）/ retry-after-ms
+ *   - anthropic-ratelimit-{requests,tokens,input-tokens,output-tokens}-reset（RFC 3339，配额耗尽时）
+ *   - anthropic-ratelimit-unified-reset（Unix 秒，统一限流已拒绝时）
+ * 重要程度：⭐⭐⭐ 一般（重试退避）
+ * 依赖模块：无
+ */
+package adapter
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// anthropicRateLimitKinds Anthropic 分项限流头（anthropic-ratelimit-{kind}-remaining / -reset）
+var anthropicRateLimitKinds = []string{"requests", "tokens", "input-tokens", "output-tokens"}
+
+// ParseRetryAfter 从响应头解析建议的重试等待时间，未提供时返回 0
+func ParseRetryAfter(header http.Header, now time.Time) time.Duration {
+	if header == nil {
+		return 0
+	}
+
+	if ms, err := strconv.ParseFloat(header.Get("retry-after-ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+			if seconds > 0 {
+				return time.Duration(seconds * float64(time.Second))
+			}
+		} else if at, err := http.ParseTime(value); err == nil && at.After(now) {
+			return at.Sub(now)
+		}
+	}
+
+	// 分项配额耗尽时等待最晚恢复的一项
+	var wait time.Duration
+	for _, kind := range anthropicRateLimitKinds {
+		if header.Get("anthropic-ratelimit-"+kind+"-remaining") != "0" {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, header.Get("anthropic-ratelimit-"+kind+"-reset"))
+		if err == nil && at.Sub(now) > wait {
+			wait = at.Sub(now)
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+
+	if header.Get("anthropic-ratelimit-unified-status") != "rejected" {
+		return 0
+	}
+	if unix, err := strconv.ParseInt(header.Get("anthropic-ratelimit-unified-reset"), 10, 64); err == nil {
+		if at := time.Unix(unix, 0); at.After(now) {
+			return at.Sub(now)
+		}
+	}
+	return 0
+}
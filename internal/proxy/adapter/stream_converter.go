@@ -0,0 +1,512 @@
+/*
+ * 文件作用：流式响应格式转换，将适配器输出的 SSE 转换为客户端期望的格式
+ * 负责功能：
+ *   - 账户类型对应的输出格式判断
+ *   - Claude SSE → OpenAI chat.completion.chunk（含 tool_use → tool_calls）
+ *   - OpenAI chat.completion.chunk → Claude SSE（含 tool_calls → tool_use）
+ *   - 跨格式流式发送（SendStreamAs）
+ * 重要程度：⭐⭐⭐⭐ 重要（跨格式路由）
+ * 依赖模块：model
+ */
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"cli-proxy/internal/model"
+)
+
+// 输出格式
+const (
+	FormatClaude = "claude"
+	FormatOpenAI = "openai"
+)
+
+// OutputFormat 账户类型对应适配器的流式输出格式
+// 返回空表示原样透传（如 Responses API），不做格式转换
+func OutputFormat(accountType string) string {
+	switch accountType {
+	case model.AccountTypeClaudeOfficial, model.AccountTypeClaudeConsole, model.AccountTypeBedrock:
+		return FormatClaude
+	case model.AccountTypeOpenAI, model.AccountTypeAzureOpenAI,
+		model.AccountTypeGemini, model.AccountTypeGeminiAPI:
+		return FormatOpenAI
+	default:
+		return ""
+	}
+}
+
+// StreamConverter 流式格式转换 Writer
+type StreamConverter interface {
+	io.Writer
+	// Finish 上游流正常结束后补齐目标格式的结束事件
+	Finish(result *StreamResult)
+}
+
+// NewStreamConverter 创建流式格式转换器
+// from/to 相同或无法转换时返回 nil
+func NewStreamConverter(w io.Writer, from, to, modelName string) StreamConverter {
+	switch {
+	case from == FormatClaude && to == FormatOpenAI:
+		return &claudeToOpenAIStream{
+			sseLineWriter: sseLineWriter{w: w},
+			conv:          NewFormatConverter(),
+			model:         modelName,
+			toolIndexes:   make(map[int]int),
+		}
+	case from == FormatOpenAI && to == FormatClaude:
+		return &openAIToClaudeStream{
+			sseLineWriter: sseLineWriter{w: w},
+			conv:          NewFormatConverter(),
+			model:         modelName,
+			openBlock:     -1,
+			toolBlocks:    make(map[int]int),
+		}
+	default:
+		return nil
+	}
+}
+
+// SendStreamAs 发送流式请求，并将适配器输出转换为客户端期望的格式
+func SendStreamAs(ctx context.Context, adp Adapter, account *model.Account, req *Request, w io.Writer, clientFormat string) (*StreamResult, error) {
+	conv := NewStreamConverter(w, OutputFormat(account.Type), clientFormat, req.Model)
+	if conv == nil {
+		return adp.SendStream(ctx, account, req, w)
+	}
+	result, err := adp.SendStream(ctx, account, req, conv)
+	if err == nil {
+		conv.Finish(result)
+	}
+	return result, err
+}
+
+// sseLineWriter 按行切分 SSE 输入（上游可能跨 Write 拆分行，心跳可能并发写入）
+type sseLineWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+}
+
+// feed 追加数据并对每个完整行调用 handle
+func (s *sseLineWriter) feed(p []byte, handle func(line string)) {
+	s.buf = append(s.buf, p...)
+	for {
+		idx := bytes.IndexByte(s.buf, '\n')
+		if idx < 0 {
+			return
+		}
+		line := strings.TrimRight(string(s.buf[:idx]), "\r")
+		s.buf = s.buf[idx+1:]
+		handle(line)
+	}
+}
+
+// writeRaw 写入底层 writer
+func (s *sseLineWriter) writeRaw(data string) {
+	s.w.Write([]byte(data))
+}
+
+// Flush 实现 http.Flusher 接口（如果底层 writer 支持）
+func (s *sseLineWriter) Flush() {
+	if f, ok := s.w.(interface{ Flush() }); ok {
+		f.Flush()
+	}
+}
+
+// ======================== Claude → OpenAI ========================
+
+// claudeToOpenAIStream 将 Claude SSE 转换为 OpenAI chunk（[DONE] 由调用方写入）
+type claudeToOpenAIStream struct {
+	sseLineWriter
+	conv         *FormatConverter
+	model        string
+	id           string
+	created      int64
+	inputTokens  int
+	outputTokens int
+	finished     bool
+	toolIndexes  map[int]int // Claude 内容块索引 -> OpenAI tool_calls 索引
+}
+
+func (s *claudeToOpenAIStream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.feed(p, s.handleLine)
+	return len(p), nil
+}
+
+func (s *claudeToOpenAIStream) handleLine(line string) {
+	if strings.HasPrefix(line, ":") {
+		// 心跳注释原样透传
+		s.writeRaw(line + "\n\n")
+		return
+	}
+	if !strings.HasPrefix(line, "data:") {
+		return
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+
+	var event struct {
+		Type         string `json:"type"`
+		Index        int    `json:"index"`
+		ContentBlock struct {
+			Type string `json:"type"`
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"content_block"`
+		Message struct {
+			ID    string `json:"id"`
+			Model string `json:"model"`
+			Usage struct {
+				InputTokens int `json:"input_tokens"`
+			} `json:"usage"`
+		} `json:"message"`
+		Delta struct {
+			Type        string `json:"type"`
+			Text        string `json:"text"`
+			PartialJSON string `json:"partial_json"`
+			StopReason  string `json:"stop_reason"`
+		} `json:"delta"`
+		Usage struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		return
+	}
+
+	switch event.Type {
+	case "message_start":
+		s.id = "chatcmpl-" + strings.TrimPrefix(event.Message.ID, "msg_")
+		s.created = time.Now().Unix()
+		if event.Message.Model != "" {
+			s.model = event.Message.Model
+		}
+		s.inputTokens = event.Message.Usage.InputTokens
+		s.writeChunk(map[string]interface{}{"role": "assistant", "content": ""}, nil, nil)
+	case "content_block_start":
+		if event.ContentBlock.Type == "tool_use" {
+			toolIndex := len(s.toolIndexes)
+			s.toolIndexes[event.Index] = toolIndex
+			s.writeChunk(map[string]interface{}{
+				"tool_calls": []map[string]interface{}{{
+					"index":    toolIndex,
+					"id":       event.ContentBlock.ID,
+					"type":     "function",
+					"function": map[string]string{"name": event.ContentBlock.Name, "arguments": ""},
+				}},
+			}, nil, nil)
+		}
+	case "content_block_delta":
+		switch event.Delta.Type {
+		case "text_delta":
+			if event.Delta.Text != "" {
+				s.writeChunk(map[string]interface{}{"content": event.Delta.Text}, nil, nil)
+			}
+		case "input_json_delta":
+			if toolIndex, ok := s.toolIndexes[event.Index]; ok && event.Delta.PartialJSON != "" {
+				s.writeChunk(map[string]interface{}{
+					"tool_calls": []map[string]interface{}{{
+						"index":    toolIndex,
+						"function": map[string]string{"arguments": event.Delta.PartialJSON},
+					}},
+				}, nil, nil)
+			}
+		}
+	case "message_delta":
+		if event.Usage.InputTokens > 0 {
+			s.inputTokens = event.Usage.InputTokens
+		}
+		s.outputTokens = event.Usage.OutputTokens
+		if event.Delta.StopReason != "" {
+			reason := s.conv.claudeStopReasonToOpenAI(event.Delta.StopReason)
+			s.writeChunk(map[string]interface{}{}, &reason, map[string]int{
+				"prompt_tokens":     s.inputTokens,
+				"completion_tokens": s.outputTokens,
+				"total_tokens":      s.inputTokens + s.outputTokens,
+			})
+			s.finished = true
+		}
+	case "error":
+		s.writeRaw("data: " + string(mustMarshal(map[string]json.RawMessage{"error": event.Error})) + "\n\n")
+	}
+}
+
+// writeChunk 写入 OpenAI chunk
+func (s *claudeToOpenAIStream) writeChunk(delta map[string]interface{}, finishReason *string, usage map[string]int) {
+	chunk := map[string]interface{}{
+		"id":      s.id,
+		"object":  "chat.completion.chunk",
+		"created": s.created,
+		"model":   s.model,
+		"choices": []map[string]interface{}{
+			{
+				"index":         0,
+				"delta":         delta,
+				"finish_reason": finishReason,
+			},
+		},
+	}
+	if usage != nil {
+		chunk["usage"] = usage
+	}
+	s.writeRaw("data: " + string(mustMarshal(chunk)) + "\n\n")
+}
+
+// Finish 上游未发送 stop_reason 时补齐结束 chunk
+func (s *claudeToOpenAIStream) Finish(result *StreamResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.finished || s.id == "" {
+		return
+	}
+	reason := "stop"
+	s.writeChunk(map[string]interface{}{}, &reason, nil)
+	s.finished = true
+}
+
+// ======================== OpenAI → Claude ========================
+
+// openAIToClaudeStream 将 OpenAI chunk 转换为 Claude SSE
+// 文本与工具调用依次映射为独立的内容块（text / tool_use）
+type openAIToClaudeStream struct {
+	sseLineWriter
+	conv         *FormatConverter
+	model        string
+	id           string
+	started      bool
+	stopReason   string
+	inputTokens  int
+	outputTokens int
+	finished     bool
+
+	blockCount int         // 已开启的内容块数量
+	openBlock  int         // 当前未关闭的内容块索引（-1 表示无）
+	textBlock  bool        // 当前内容块是否为文本块
+	toolBlocks map[int]int // OpenAI tool_calls 索引 -> Claude 内容块索引
+}
+
+func (s *openAIToClaudeStream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.feed(p, s.handleLine)
+	return len(p), nil
+}
+
+func (s *openAIToClaudeStream) handleLine(line string) {
+	if strings.HasPrefix(line, ":") {
+		s.writeRaw(line + "\n\n")
+		return
+	}
+	if !strings.HasPrefix(line, "data:") {
+		return
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if data == "" || data == "[DONE]" {
+		return
+	}
+
+	var chunk struct {
+		ID      string `json:"id"`
+		Model   string `json:"model"`
+		Type    string `json:"type"`
+		Choices []struct {
+			Delta struct {
+				Content   string `json:"content"`
+				ToolCalls []struct {
+					Index    int    `json:"index"`
+					ID       string `json:"id"`
+					Function struct {
+						Name      string `json:"name"`
+						Arguments string `json:"arguments"`
+					} `json:"function"`
+				} `json:"tool_calls"`
+			} `json:"delta"`
+			FinishReason *string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage *struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return
+	}
+
+	// 错误事件（适配器可能已输出 Claude 格式错误）
+	if chunk.Type == "error" {
+		s.writeEvent("error", json.RawMessage(data))
+		return
+	}
+	if len(chunk.Error) > 0 {
+		var openAIErr struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+		}
+		json.Unmarshal(chunk.Error, &openAIErr)
+		if openAIErr.Type == "" {
+			openAIErr.Type = "api_error"
+		}
+		s.writeEvent("error", map[string]interface{}{
+			"type":  "error",
+			"error": map[string]string{"type": openAIErr.Type, "message": openAIErr.Message},
+		})
+		return
+	}
+
+	if chunk.Model != "" && !s.started {
+		s.model = chunk.Model
+	}
+	if chunk.Usage != nil {
+		s.inputTokens = chunk.Usage.PromptTokens
+		s.outputTokens = chunk.Usage.CompletionTokens
+	}
+
+	for _, choice := range chunk.Choices {
+		if choice.Delta.Content != "" {
+			s.start(chunk.ID)
+			if s.openBlock < 0 || !s.textBlock {
+				s.openContentBlock(true, map[string]string{"type": "text", "text": ""})
+			}
+			s.writeEvent("content_block_delta", map[string]interface{}{
+				"type":  "content_block_delta",
+				"index": s.openBlock,
+				"delta": map[string]string{"type": "text_delta", "text": choice.Delta.Content},
+			})
+		}
+		for _, call := range choice.Delta.ToolCalls {
+			s.start(chunk.ID)
+			blockIndex, ok := s.toolBlocks[call.Index]
+			if !ok {
+				id := call.ID
+				if id == "" {
+					id = newToolCallID()
+				}
+				blockIndex = s.openContentBlock(false, map[string]interface{}{
+					"type":  "tool_use",
+					"id":    id,
+					"name":  call.Function.Name,
+					"input": map[string]interface{}{},
+				})
+				s.toolBlocks[call.Index] = blockIndex
+			}
+			if call.Function.Arguments != "" {
+				s.writeEvent("content_block_delta", map[string]interface{}{
+					"type":  "content_block_delta",
+					"index": blockIndex,
+					"delta": map[string]string{"type": "input_json_delta", "partial_json": call.Function.Arguments},
+				})
+			}
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			s.stopReason = s.conv.openAIStopReasonToClaude(*choice.FinishReason)
+		}
+	}
+}
+
+// start 首个内容到达时输出 message_start
+func (s *openAIToClaudeStream) start(upstreamID string) {
+	if s.started {
+		return
+	}
+	s.started = true
+	s.id = "msg_" + strings.TrimPrefix(upstreamID, "chatcmpl-")
+	if upstreamID == "" {
+		s.id = fmt.Sprintf("msg_%d", time.Now().UnixNano())
+	}
+	s.writeEvent("message_start", map[string]interface{}{
+		"type": "message_start",
+		"message": map[string]interface{}{
+			"id":            s.id,
+			"type":          "message",
+			"role":          "assistant",
+			"model":         s.model,
+			"content":       []interface{}{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage":         map[string]int{"input_tokens": s.inputTokens, "output_tokens": 0},
+		},
+	})
+}
+
+// openContentBlock 关闭当前内容块并开启新内容块，返回新内容块索引
+func (s *openAIToClaudeStream) openContentBlock(text bool, block interface{}) int {
+	s.closeContentBlock()
+	s.openBlock = s.blockCount
+	s.blockCount++
+	s.textBlock = text
+	s.writeEvent("content_block_start", map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         s.openBlock,
+		"content_block": block,
+	})
+	return s.openBlock
+}
+
+// closeContentBlock 关闭当前内容块
+func (s *openAIToClaudeStream) closeContentBlock() {
+	if s.openBlock < 0 {
+		return
+	}
+	s.writeEvent("content_block_stop", map[string]interface{}{
+		"type":  "content_block_stop",
+		"index": s.openBlock,
+	})
+	s.openBlock = -1
+}
+
+// Finish 输出 content_block_stop、message_delta（含 usage）和 message_stop
+func (s *openAIToClaudeStream) Finish(result *StreamResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.finished {
+		return
+	}
+	s.finished = true
+
+	if result != nil {
+		if result.InputTokens > 0 {
+			s.inputTokens = result.InputTokens
+		}
+		if result.OutputTokens > 0 {
+			s.outputTokens = result.OutputTokens
+		}
+	}
+	s.start("")
+	if s.blockCount == 0 {
+		s.openContentBlock(true, map[string]string{"type": "text", "text": ""})
+	}
+	s.closeContentBlock()
+
+	stopReason := s.stopReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	s.writeEvent("message_delta", map[string]interface{}{
+		"type":  "message_delta",
+		"delta": map[string]interface{}{"stop_reason": stopReason, "stop_sequence": nil},
+		"usage": map[string]int{"input_tokens": s.inputTokens, "output_tokens": s.outputTokens},
+	})
+	s.writeEvent("message_stop", map[string]string{"type": "message_stop"})
+}
+
+// writeEvent 写入 Claude SSE 事件
+func (s *openAIToClaudeStream) writeEvent(event string, data interface{}) {
+	s.writeRaw("event: " + event + "\ndata: " + string(mustMarshal(data)) + "\n\n")
+}
+
+// mustMarshal JSON 编码（数据结构固定，忽略错误）
+func mustMarshal(v interface{}) []byte {
+	data, _ := json.Marshal(v)
+	return data
+}
@@ -0,0 +1,327 @@
+/*
+ * 文件作用：工具调用格式映射，在统一格式（OpenAI 语义）与 Claude 格式间转换工具定义、调用和结果
+ * 负责功能：
+ *   - Claude tool_use/tool_result 内容块 ↔ 统一 ToolCalls / role=tool 消息
+ *   - 工具定义与 tool_choice 转换
+ *   - 响应内容块中的工具调用提取
+ * 重要程度：⭐⭐⭐⭐ 重要（Agent 类客户端跨格式调用）
+ * 依赖模块：无
+ */
+package adapter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+// ClaudeTool Claude 工具定义
+type ClaudeTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+// claudeMessagesToUnified 将 Claude 消息转换为统一消息
+// tool_use 块转换为 assistant 的 ToolCalls，tool_result 块拆分为 role=tool 消息
+func claudeMessagesToUnified(msgs []ClaudeMessage) []Message {
+	result := make([]Message, 0, len(msgs))
+	toolNames := make(map[string]string) // tool_use id -> 工具名
+
+	for _, msg := range msgs {
+		blocks, ok := msg.Content.([]interface{})
+		if !ok {
+			result = append(result, Message{Role: msg.Role, Content: msg.Content})
+			continue
+		}
+
+		var content []interface{}
+		var toolCalls []ToolCall
+		var toolResults []Message
+		for _, raw := range blocks {
+			m, ok := raw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			switch m["type"] {
+			case "tool_use":
+				block := decodeClaudeBlock(m)
+				toolNames[block.ID] = block.Name
+				toolCalls = append(toolCalls, ToolCall{
+					ID:   block.ID,
+					Type: "function",
+					Function: ToolCallFunction{
+						Name:      block.Name,
+						Arguments: string(toolInputJSON(block.Input)),
+					},
+				})
+			case "tool_result":
+				block := decodeClaudeBlock(m)
+				text := extractBlockText(block.Content)
+				if block.IsError && text != "" {
+					text = "Error: " + text
+				}
+				toolResults = append(toolResults, Message{
+					Role:       "tool",
+					Content:    text,
+					ToolCallID: block.ToolUseID,
+					Name:       toolNames[block.ToolUseID],
+				})
+			default:
+				content = append(content, m)
+			}
+		}
+
+		// 工具结果回应上一轮 assistant 的调用，需排在同一条消息的其他内容之前
+		result = append(result, toolResults...)
+		if len(content) > 0 || len(toolCalls) > 0 {
+			result = append(result, Message{
+				Role:      msg.Role,
+				Content:   simplifyBlocks(content),
+				ToolCalls: toolCalls,
+			})
+		}
+	}
+	return result
+}
+
+// unifiedMessagesToClaude 将统一消息转换为 Claude 消息（system 消息需由调用方提取）
+// 连续的 role=tool 消息合并为一条 user 消息中的多个 tool_result 块
+func unifiedMessagesToClaude(msgs []Message) []ClaudeMessage {
+	result := make([]ClaudeMessage, 0, len(msgs))
+	mergingToolResults := false
+
+	for _, msg := range msgs {
+		switch {
+		case msg.Role == "system":
+			continue
+		case msg.Role == "tool":
+			block := ClaudeContentBlock{
+				Type:      "tool_result",
+				ToolUseID: msg.ToolCallID,
+				Content:   extractBlockText(msg.Content),
+			}
+			if mergingToolResults {
+				last := &result[len(result)-1]
+				last.Content = append(last.Content.([]ClaudeContentBlock), block)
+			} else {
+				result = append(result, ClaudeMessage{Role: "user", Content: []ClaudeContentBlock{block}})
+				mergingToolResults = true
+			}
+			continue
+		case len(msg.ToolCalls) > 0:
+			blocks := make([]ClaudeContentBlock, 0, len(msg.ToolCalls)+1)
+			if text := extractBlockText(msg.Content); text != "" {
+				blocks = append(blocks, ClaudeContentBlock{Type: "text", Text: text})
+			}
+			for _, call := range msg.ToolCalls {
+				blocks = append(blocks, ClaudeContentBlock{
+					Type:  "tool_use",
+					ID:    call.ID,
+					Name:  call.Function.Name,
+					Input: toolInputJSON(json.RawMessage(call.Function.Arguments)),
+				})
+			}
+			result = append(result, ClaudeMessage{Role: msg.Role, Content: blocks})
+		default:
+			result = append(result, ClaudeMessage{Role: msg.Role, Content: msg.Content})
+		}
+		mergingToolResults = false
+	}
+	return result
+}
+
+// toolsToClaude 将统一工具定义转换为 Claude 格式
+func toolsToClaude(tools []Tool) []ClaudeTool {
+	if len(tools) == 0 {
+		return nil
+	}
+	result := make([]ClaudeTool, 0, len(tools))
+	for _, tool := range tools {
+		schema := tool.Parameters
+		if len(schema) == 0 {
+			schema = json.RawMessage(`{"type":"object","properties":{}}`)
+		}
+		result = append(result, ClaudeTool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: schema,
+		})
+	}
+	return result
+}
+
+// toolsFromClaude 将 Claude 工具定义转换为统一格式
+func toolsFromClaude(tools []ClaudeTool) []Tool {
+	if len(tools) == 0 {
+		return nil
+	}
+	result := make([]Tool, 0, len(tools))
+	for _, tool := range tools {
+		result = append(result, Tool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  tool.InputSchema,
+		})
+	}
+	return result
+}
+
+// toolChoiceToClaude 将 OpenAI tool_choice 转换为 Claude 格式
+func toolChoiceToClaude(choice interface{}) interface{} {
+	switch v := choice.(type) {
+	case string:
+		switch v {
+		case "auto":
+			return map[string]string{"type": "auto"}
+		case "required":
+			return map[string]string{"type": "any"}
+		case "none":
+			return map[string]string{"type": "none"}
+		}
+	case map[string]interface{}:
+		if name := toolChoiceName(v); name != "" {
+			return map[string]string{"type": "tool", "name": name}
+		}
+	}
+	return nil
+}
+
+// toolChoiceFromClaude 将 Claude tool_choice 转换为 OpenAI 格式
+func toolChoiceFromClaude(choice interface{}) interface{} {
+	m, ok := choice.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	switch m["type"] {
+	case "auto":
+		return "auto"
+	case "any":
+		return "required"
+	case "none":
+		return "none"
+	case "tool":
+		if name, _ := m["name"].(string); name != "" {
+			return map[string]interface{}{
+				"type":     "function",
+				"function": map[string]string{"name": name},
+			}
+		}
+	}
+	return nil
+}
+
+// toolChoiceName 提取 OpenAI 指定工具的 tool_choice 中的工具名
+func toolChoiceName(choice map[string]interface{}) string {
+	if fn, ok := choice["function"].(map[string]interface{}); ok {
+		name, _ := fn["name"].(string)
+		return name
+	}
+	return ""
+}
+
+// claudeBlocksToToolCalls 提取 Claude 响应内容块中的工具调用
+func claudeBlocksToToolCalls(blocks []ClaudeContentBlock) []ToolCall {
+	var calls []ToolCall
+	for _, block := range blocks {
+		if block.Type != "tool_use" {
+			continue
+		}
+		calls = append(calls, ToolCall{
+			ID:   block.ID,
+			Type: "function",
+			Function: ToolCallFunction{
+				Name:      block.Name,
+				Arguments: string(toolInputJSON(block.Input)),
+			},
+		})
+	}
+	return calls
+}
+
+// ToolCallsToClaudeBlocks 将工具调用转换为 Claude tool_use 内容块（用于构建 Claude 格式响应）
+func ToolCallsToClaudeBlocks(calls []ToolCall) []ClaudeContentBlock {
+	blocks := make([]ClaudeContentBlock, 0, len(calls))
+	for _, call := range calls {
+		blocks = append(blocks, ClaudeContentBlock{
+			Type:  "tool_use",
+			ID:    call.ID,
+			Name:  call.Function.Name,
+			Input: toolInputJSON(json.RawMessage(call.Function.Arguments)),
+		})
+	}
+	return blocks
+}
+
+// newToolCallID 为不返回调用 ID 的上游（Gemini）生成工具调用 ID
+func newToolCallID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "call_" + hex.EncodeToString(b)
+}
+
+// toolInputJSON 规范化工具参数（空或非法 JSON 时返回空对象）
+func toolInputJSON(input json.RawMessage) json.RawMessage {
+	if len(input) == 0 || !json.Valid(input) {
+		return json.RawMessage("{}")
+	}
+	return input
+}
+
+// decodeClaudeBlock 将通用 map 内容块解码为 ClaudeContentBlock
+func decodeClaudeBlock(m map[string]interface{}) ClaudeContentBlock {
+	var block ClaudeContentBlock
+	if data, err := json.Marshal(m); err == nil {
+		json.Unmarshal(data, &block)
+	}
+	return block
+}
+
+// extractBlockText 提取字符串或内容块数组中的文本
+func extractBlockText(content interface{}) string {
+	switch v := content.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []interface{}:
+		var texts []string
+		for _, raw := range v {
+			if m, ok := raw.(map[string]interface{}); ok && m["type"] == "text" {
+				if t, ok := m["text"].(string); ok {
+					texts = append(texts, t)
+				}
+			}
+		}
+		return strings.Join(texts, "\n")
+	case []ClaudeContentBlock:
+		var texts []string
+		for _, block := range v {
+			if block.Type == "text" {
+				texts = append(texts, block.Text)
+			}
+		}
+		return strings.Join(texts, "\n")
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+// simplifyBlocks 内容块全部为文本时合并为字符串，否则保留内容块
+func simplifyBlocks(blocks []interface{}) interface{} {
+	if len(blocks) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	for _, raw := range blocks {
+		m, ok := raw.(map[string]interface{})
+		if !ok || m["type"] != "text" {
+			return blocks
+		}
+		t, _ := m["text"].(string)
+		sb.WriteString(t)
+	}
+	return sb.String()
+}
@@ -0,0 +1,504 @@
+/*
+ * 文件作用：账户熔断器，按滑动窗口错误率暂停向故障账户调度请求
+ * 负责功能：
+ *   - 熔断器注册表（按账户 ID，线程安全）
+ *   - 滑动窗口错误率统计，超过阈值熔断（Closed -> Open）
+ *   - 熔断到期后半开，限量放行探测请求（Open -> HalfOpen -> Closed/Open）
+ *   - 状态与状态变更记录查询（管理端展示）
+ * 重要程度：⭐⭐⭐⭐ 重要（故障账户快速隔离）
+ * 依赖模块：model, logger
+ */
+package scheduler
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"cli-proxy/internal/model"
+	"cli-proxy/pkg/logger"
+)
+
+// CircuitState 熔断器状态
+type CircuitState int
+
+const (
+	CircuitClosed   CircuitState = iota // 正常
+	CircuitOpen                         // 熔断
+	CircuitHalfOpen                     // 半开
+)
+
+// String 状态名称
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// MarshalText 序列化为状态名称
+func (s CircuitState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// breakerBuckets 滑动窗口分桶数
+const breakerBuckets = 10
+
+// maxBreakerTransitions 保留的状态变更记录数
+const maxBreakerTransitions = 100
+
+// BreakerConfig 熔断器配置
+type BreakerConfig struct {
+	Enabled        bool
+	Window         time.Duration // 错误率统计窗口
+	MinRequests    int           // 窗口内最少请求数，不足时不熔断
+	ErrorRate      float64       // 熔断错误率阈值（0-1）
+	OpenDuration   time.Duration // 熔断持续时间，到期后进入半开
+	HalfOpenProbes int           // 半开状态允许的探测请求数，全部成功后恢复
+}
+
+// DefaultBreakerConfig 默认熔断器配置
+var DefaultBreakerConfig = BreakerConfig{
+	Enabled:        true,
+	Window:         time.Minute,
+	MinRequests:    10,
+	ErrorRate:      0.5,
+	OpenDuration:   30 * time.Second,
+	HalfOpenProbes: 2,
+}
+
+// breakerBucket 滑动窗口分桶
+type breakerBucket struct {
+	index    int64 // 分桶序号（时间 / 分桶宽度）
+	success  int
+	failures int
+}
+
+// CircuitBreaker 单个账户的熔断器
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	accountID uint
+	state     CircuitState
+
+	bucketSize time.Duration
+	buckets    [breakerBuckets]breakerBucket
+
+	openedAt       time.Time
+	probesInFlight int       // 半开状态已放行且未完成的探测
+	probeSuccesses int       // 半开状态已成功的探测
+	lastProbeAt    time.Time // 最近一次放行探测的时间
+	lastChangeAt   time.Time
+}
+
+// BreakerTransition 熔断器状态变更记录
+type BreakerTransition struct {
+	AccountID uint         `json:"account_id"`
+	From      CircuitState `json:"from"`
+	To        CircuitState `json:"to"`
+	Reason    string       `json:"reason"`
+	At        time.Time    `json:"at"`
+}
+
+// BreakerInfo 熔断器状态
+type BreakerInfo struct {
+	AccountID      uint         `json:"account_id"`
+	State          CircuitState `json:"state"`
+	Requests       int          `json:"requests"` // 窗口内请求数
+	Failures       int          `json:"failures"` // 窗口内失败数
+	ErrorRate      float64      `json:"error_rate"`
+	OpenedAt       *time.Time   `json:"opened_at,omitempty"`
+	ProbesInFlight int          `json:"probes_in_flight"`
+	LastChangeAt   *time.Time   `json:"last_change_at,omitempty"`
+}
+
+// BreakerStats 熔断器统计
+type BreakerStats struct {
+	Enabled     bool                `json:"enabled"`
+	OpenCount   int                 `json:"open_count"`
+	HalfOpen    int                 `json:"half_open_count"`
+	Breakers    []BreakerInfo       `json:"breakers"`
+	Transitions []BreakerTransition `json:"transitions"` // 最近的状态变更（新的在前）
+}
+
+// breakerRegistry 熔断器注册表
+type breakerRegistry struct {
+	mu          sync.Mutex
+	config      BreakerConfig
+	breakers    map[uint]*CircuitBreaker
+	transitions []BreakerTransition
+}
+
+func newBreakerRegistry() *breakerRegistry {
+	return &breakerRegistry{
+		config:   DefaultBreakerConfig,
+		breakers: make(map[uint]*CircuitBreaker),
+	}
+}
+
+// SetBreakerConfig 更新熔断器配置，关闭熔断时所有账户恢复正常
+func (s *Scheduler) SetBreakerConfig(cfg BreakerConfig) {
+	s.breakers.mu.Lock()
+	s.breakers.config = cfg
+	if !cfg.Enabled {
+		s.breakers.breakers = make(map[uint]*CircuitBreaker)
+	}
+	s.breakers.mu.Unlock()
+}
+
+// GetBreakerStats 获取熔断器状态及最近的状态变更
+func (s *Scheduler) GetBreakerStats() *BreakerStats {
+	return s.breakers.stats()
+}
+
+// ResetBreaker 重置账户熔断器为正常状态
+func (s *Scheduler) ResetBreaker(accountID uint) {
+	s.breakers.reset(accountID)
+}
+
+// selectWithBreaker 按选择策略选择账户并占用熔断器探测名额
+// 探测名额被并发请求抢占时将该账户移出候选后重新选择，无可选账户时返回 nil
+func (s *Scheduler) selectWithBreaker(ctx context.Context, accounts []*model.Account, groupIDs []uint) *model.Account {
+	candidates := accounts
+	for len(candidates) > 0 {
+		selected := s.pickAccount(ctx, candidates, groupIDs)
+		if s.breakers.acquire(selected.ID) {
+			return selected
+		}
+		remaining := make([]*model.Account, 0, len(candidates)-1)
+		for _, acc := range candidates {
+			if acc.ID != selected.ID {
+				remaining = append(remaining, acc)
+			}
+		}
+		candidates = remaining
+	}
+	return nil
+}
+
+func (r *breakerRegistry) getConfig() BreakerConfig {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.config
+}
+
+// get 获取账户熔断器，create 为 false 且不存在时返回 nil
+func (r *breakerRegistry) get(accountID uint, create bool) *CircuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cb, ok := r.breakers[accountID]
+	if !ok && create {
+		cb = &CircuitBreaker{accountID: accountID}
+		r.breakers[accountID] = cb
+	}
+	return cb
+}
+
+// record 记录状态变更
+func (r *breakerRegistry) record(t BreakerTransition) {
+	r.mu.Lock()
+	r.transitions = append(r.transitions, t)
+	if len(r.transitions) > maxBreakerTransitions {
+		r.transitions = r.transitions[len(r.transitions)-maxBreakerTransitions:]
+	}
+	r.mu.Unlock()
+
+	log := logger.GetLogger("scheduler")
+	log.Warn("账户熔断器状态变更 - 账户ID: %d, %s -> %s, 原因: %s", t.AccountID, t.From, t.To, t.Reason)
+}
+
+// available 账户是否可参与调度（不占用探测名额）
+func (r *breakerRegistry) available(accountID uint) bool {
+	cfg := r.getConfig()
+	if !cfg.Enabled {
+		return true
+	}
+	cb := r.get(accountID, false)
+	if cb == nil {
+		return true
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case CircuitOpen:
+		return time.Since(cb.openedAt) >= cfg.OpenDuration
+	case CircuitHalfOpen:
+		cb.expireProbes(cfg, time.Now())
+		return cb.probesInFlight+cb.probeSuccesses < cfg.HalfOpenProbes
+	}
+	return true
+}
+
+// acquire 选中账户时调用，半开状态占用一个探测名额；名额已满时返回 false
+func (r *breakerRegistry) acquire(accountID uint) bool {
+	cfg := r.getConfig()
+	if !cfg.Enabled {
+		return true
+	}
+	cb := r.get(accountID, false)
+	if cb == nil {
+		return true
+	}
+
+	now := time.Now()
+	cb.mu.Lock()
+	var transition *BreakerTransition
+	allowed := true
+	switch cb.state {
+	case CircuitOpen:
+		if now.Sub(cb.openedAt) < cfg.OpenDuration {
+			allowed = false
+			break
+		}
+		transition = cb.transition(CircuitHalfOpen, "熔断到期，放行探测请求", now)
+		cb.probesInFlight = 1
+		cb.lastProbeAt = now
+	case CircuitHalfOpen:
+		cb.expireProbes(cfg, now)
+		if cb.probesInFlight+cb.probeSuccesses >= cfg.HalfOpenProbes {
+			allowed = false
+			break
+		}
+		cb.probesInFlight++
+		cb.lastProbeAt = now
+	}
+	cb.mu.Unlock()
+
+	if transition != nil {
+		r.record(*transition)
+	}
+	return allowed
+}
+
+// release 选中后未实际执行（如并发已满、客户端断开），归还探测名额
+func (r *breakerRegistry) release(accountID uint) {
+	cb := r.get(accountID, false)
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	if cb.state == CircuitHalfOpen && cb.probesInFlight > 0 {
+		cb.probesInFlight--
+	}
+	cb.mu.Unlock()
+}
+
+// onSuccess 记录成功请求
+func (r *breakerRegistry) onSuccess(accountID uint) {
+	cfg := r.getConfig()
+	if !cfg.Enabled {
+		return
+	}
+
+	now := time.Now()
+	cb := r.get(accountID, true)
+	cb.mu.Lock()
+	cb.add(cfg, now, false)
+	var transition *BreakerTransition
+	if cb.state == CircuitHalfOpen {
+		if cb.probesInFlight > 0 {
+			cb.probesInFlight--
+		}
+		cb.probeSuccesses++
+		if cb.probeSuccesses >= cfg.HalfOpenProbes {
+			transition = cb.transition(CircuitClosed, "探测请求全部成功", now)
+			cb.buckets = [breakerBuckets]breakerBucket{}
+		}
+	}
+	cb.mu.Unlock()
+
+	if transition != nil {
+		r.record(*transition)
+	}
+}
+
+// onFailure 记录失败请求，错误率超过阈值或半开探测失败时熔断
+func (r *breakerRegistry) onFailure(accountID uint, reason string) {
+	cfg := r.getConfig()
+	if !cfg.Enabled {
+		return
+	}
+
+	now := time.Now()
+	cb := r.get(accountID, true)
+	cb.mu.Lock()
+	cb.add(cfg, now, true)
+	var transition *BreakerTransition
+	switch cb.state {
+	case CircuitHalfOpen:
+		transition = cb.transition(CircuitOpen, "探测请求失败: "+reason, now)
+		cb.openedAt = now
+	case CircuitClosed:
+		requests, failures := cb.totals(cfg, now)
+		if requests >= cfg.MinRequests && float64(failures) >= cfg.ErrorRate*float64(requests) {
+			transition = cb.transition(CircuitOpen, "窗口错误率超过阈值: "+reason, now)
+			cb.openedAt = now
+		}
+	}
+	cb.mu.Unlock()
+
+	if transition != nil {
+		r.record(*transition)
+	}
+}
+
+// errorRate 账户窗口内错误率（0-1），无统计数据时为 0
+func (r *breakerRegistry) errorRate(accountID uint) float64 {
+	cfg := r.getConfig()
+	if !cfg.Enabled {
+		return 0
+	}
+	cb := r.get(accountID, false)
+	if cb == nil {
+		return 0
+	}
+	cb.mu.Lock()
+	requests, failures := cb.totals(cfg, time.Now())
+	cb.mu.Unlock()
+	if requests == 0 {
+		return 0
+	}
+	return float64(failures) / float64(requests)
+}
+
+// reset 重置账户熔断器
+func (r *breakerRegistry) reset(accountID uint) {
+	r.mu.Lock()
+	cb, ok := r.breakers[accountID]
+	delete(r.breakers, accountID)
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	cb.mu.Lock()
+	from := cb.state
+	cb.mu.Unlock()
+	if from != CircuitClosed {
+		r.record(BreakerTransition{AccountID: accountID, From: from, To: CircuitClosed, Reason: "管理员重置", At: time.Now()})
+	}
+}
+
+// stats 状态快照
+func (r *breakerRegistry) stats() *BreakerStats {
+	r.mu.Lock()
+	cfg := r.config
+	breakers := make([]*CircuitBreaker, 0, len(r.breakers))
+	for _, cb := range r.breakers {
+		breakers = append(breakers, cb)
+	}
+	transitions := make([]BreakerTransition, len(r.transitions))
+	for i, t := range r.transitions {
+		transitions[len(r.transitions)-1-i] = t
+	}
+	r.mu.Unlock()
+
+	now := time.Now()
+	result := &BreakerStats{
+		Enabled:     cfg.Enabled,
+		Breakers:    make([]BreakerInfo, 0, len(breakers)),
+		Transitions: transitions,
+	}
+	for _, cb := range breakers {
+		cb.mu.Lock()
+		info := BreakerInfo{
+			AccountID:      cb.accountID,
+			State:          cb.state,
+			ProbesInFlight: cb.probesInFlight,
+		}
+		info.Requests, info.Failures = cb.totals(cfg, now)
+		if info.Requests > 0 {
+			info.ErrorRate = float64(info.Failures) / float64(info.Requests)
+		}
+		if cb.state != CircuitClosed {
+			openedAt := cb.openedAt
+			info.OpenedAt = &openedAt
+		}
+		if !cb.lastChangeAt.IsZero() {
+			lastChangeAt := cb.lastChangeAt
+			info.LastChangeAt = &lastChangeAt
+		}
+		cb.mu.Unlock()
+
+		switch info.State {
+		case CircuitOpen:
+			result.OpenCount++
+		case CircuitHalfOpen:
+			result.HalfOpen++
+		}
+		result.Breakers = append(result.Breakers, info)
+	}
+
+	// 非正常状态在前，其余按账户 ID
+	sort.Slice(result.Breakers, func(i, j int) bool {
+		if result.Breakers[i].State != result.Breakers[j].State {
+			return result.Breakers[i].State > result.Breakers[j].State
+		}
+		return result.Breakers[i].AccountID < result.Breakers[j].AccountID
+	})
+	return result
+}
+
+// ==================== CircuitBreaker 内部方法（调用方持有 cb.mu） ====================
+
+// transition 切换状态并返回变更记录
+func (cb *CircuitBreaker) transition(to CircuitState, reason string, now time.Time) *BreakerTransition {
+	t := &BreakerTransition{AccountID: cb.accountID, From: cb.state, To: to, Reason: reason, At: now}
+	cb.state = to
+	cb.lastChangeAt = now
+	cb.probesInFlight = 0
+	cb.probeSuccesses = 0
+	return t
+}
+
+// expireProbes 探测请求长时间未回报结果（如进程内异常退出）时回收名额，避免半开状态卡死
+func (cb *CircuitBreaker) expireProbes(cfg BreakerConfig, now time.Time) {
+	if cb.probesInFlight > 0 && now.Sub(cb.lastProbeAt) > cfg.OpenDuration+cfg.Window {
+		cb.probesInFlight = 0
+	}
+}
+
+// bucketIndex 当前分桶序号，窗口配置变化时清空已有分桶
+func (cb *CircuitBreaker) bucketIndex(cfg BreakerConfig, now time.Time) int64 {
+	size := cfg.Window / breakerBuckets
+	if size <= 0 {
+		size = time.Second
+	}
+	if size != cb.bucketSize {
+		cb.bucketSize = size
+		cb.buckets = [breakerBuckets]breakerBucket{}
+	}
+	return now.UnixNano() / int64(size)
+}
+
+// add 计入一次请求结果
+func (cb *CircuitBreaker) add(cfg BreakerConfig, now time.Time, failed bool) {
+	index := cb.bucketIndex(cfg, now)
+	bucket := &cb.buckets[index%breakerBuckets]
+	if bucket.index != index {
+		*bucket = breakerBucket{index: index}
+	}
+	if failed {
+		bucket.failures++
+	} else {
+		bucket.success++
+	}
+}
+
+// totals 窗口内请求数和失败数
+func (cb *CircuitBreaker) totals(cfg BreakerConfig, now time.Time) (int, int) {
+	index := cb.bucketIndex(cfg, now)
+	requests, failures := 0, 0
+	for _, bucket := range cb.buckets {
+		if index-bucket.index < breakerBuckets {
+			requests += bucket.success + bucket.failures
+			failures += bucket.failures
+		}
+	}
+	return requests, failures
+}
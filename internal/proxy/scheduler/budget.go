@@ -0,0 +1,144 @@
+/*
+ * 文件作用：账户每日预算控制，跳过当日费用已达到 DailyBudget 的账户
+ * 负责功能：
+ *   - 账户当日费用查询（短时间缓存）
+ *   - 预算耗尽账户标记为不可用（budget_exhausted），预算调高后解除标记
+ *   - 次日零点自动恢复（标记 TTL 到次日零点）
+ * 重要程度：⭐⭐⭐⭐ 重要（账户费用控制）
+ * 依赖模块：cache, model
+ */
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"cli-proxy/internal/model"
+	"cli-proxy/pkg/logger"
+)
+
+// UnavailableReasonBudgetExhausted 账户当日预算耗尽的不可用原因
+const UnavailableReasonBudgetExhausted = "budget_exhausted"
+
+// 账户当日费用缓存时间，避免每次选择账户都查库
+const accountDailyCostCacheTTL = 30 * time.Second
+
+// DailyCostFunc 查询账户某天费用的函数（date 格式 2006-01-02）
+// 由 service 层注入（scheduler 不能直接依赖 service，避免循环引用）
+type DailyCostFunc func(ctx context.Context, accountID uint, date string) (float64, error)
+
+// accountDailyCost 账户当日费用缓存
+type accountDailyCost struct {
+	date      string
+	cost      float64
+	fetchedAt time.Time
+}
+
+// budgetTracker 账户预算跟踪
+type budgetTracker struct {
+	mu     sync.Mutex
+	costFn DailyCostFunc
+	costs  map[uint]*accountDailyCost // accountID -> 当日费用
+}
+
+// SetDailyCostFunc 设置账户当日费用查询函数
+func (s *Scheduler) SetDailyCostFunc(fn DailyCostFunc) {
+	s.budget.mu.Lock()
+	defer s.budget.mu.Unlock()
+	s.budget.costFn = fn
+}
+
+// isBudgetExhausted 检查账户当日费用是否已达到每日预算
+// 达到预算时标记账户不可用直到次日零点；已有标记时仍按当前 DailyBudget 重新比较，预算调高后立即解除标记
+func (s *Scheduler) isBudgetExhausted(ctx context.Context, acc *model.Account) bool {
+	if acc.DailyBudget <= 0 {
+		return false
+	}
+
+	marked := false
+	if s.sessionCache != nil {
+		if unavailable, reason, _ := s.sessionCache.IsAccountUnavailable(ctx, acc.ID); unavailable && reason == UnavailableReasonBudgetExhausted {
+			marked = true
+		}
+	}
+
+	now := time.Now()
+	cost, ok := s.getAccountDailyCost(ctx, acc.ID, now)
+	if !ok {
+		// 无法查询当日费用时沿用已有标记
+		return marked
+	}
+
+	log := logger.GetLogger("scheduler")
+	if cost < acc.DailyBudget {
+		if marked {
+			s.sessionCache.ClearAccountUnavailable(ctx, acc.ID)
+			log.Info("账户每日预算已调整，解除预算耗尽标记 - ID: %d, 名称: %s, 今日费用: %.4f, 预算: %.2f", acc.ID, acc.Name, cost, acc.DailyBudget)
+		}
+		return false
+	}
+	if marked {
+		return true
+	}
+
+	if s.sessionCache != nil {
+		s.sessionCache.MarkAccountUnavailable(ctx, acc.ID, UnavailableReasonBudgetExhausted, nextDayStart(now).Sub(now))
+	}
+	log.Warn("账户每日预算已耗尽 - ID: %d, 名称: %s, 今日费用: %.4f, 预算: %.2f", acc.ID, acc.Name, cost, acc.DailyBudget)
+	return true
+}
+
+// filterByBudget 过滤掉当日预算已耗尽的账户
+func (s *Scheduler) filterByBudget(ctx context.Context, accounts []*model.Account) []*model.Account {
+	filtered := make([]*model.Account, 0, len(accounts))
+	for _, acc := range accounts {
+		if s.isBudgetExhausted(ctx, acc) {
+			continue
+		}
+		filtered = append(filtered, acc)
+	}
+	return filtered
+}
+
+// getAccountDailyCost 获取账户当日费用（短时间缓存）
+// 未注入查询函数或查询失败且无缓存时返回 false
+func (s *Scheduler) getAccountDailyCost(ctx context.Context, accountID uint, now time.Time) (float64, bool) {
+	today := now.Format("2006-01-02")
+
+	s.budget.mu.Lock()
+	costFn := s.budget.costFn
+	cached, ok := s.budget.costs[accountID]
+	if ok && cached.date == today && now.Sub(cached.fetchedAt) < accountDailyCostCacheTTL {
+		s.budget.mu.Unlock()
+		return cached.cost, true
+	}
+	s.budget.mu.Unlock()
+
+	if costFn == nil {
+		return 0, false
+	}
+
+	cost, err := costFn(ctx, accountID, today)
+	if err != nil {
+		// 查询失败时沿用当日旧值，不阻止请求
+		if ok && cached.date == today {
+			return cached.cost, true
+		}
+		return 0, false
+	}
+
+	s.budget.mu.Lock()
+	if s.budget.costs == nil {
+		s.budget.costs = make(map[uint]*accountDailyCost)
+	}
+	s.budget.costs[accountID] = &accountDailyCost{date: today, cost: cost, fetchedAt: now}
+	s.budget.mu.Unlock()
+	return cost, true
+}
+
+// nextDayStart 次日零点
+func nextDayStart(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,70 @@
+/*
+ * 文件作用：账户每日预算控制测试
+ * 负责功能：
+ *   - 达到预算时标记不可用，未达到时放行
+ *   - 已有 budget_exhausted 标记时按当前 DailyBudget 重新比较（预算调高后解除标记）
+ *   - 无法查询费用时沿用已有标记
+ * 重要程度：⭐⭐ 辅助（测试）
+ * 依赖模块：cache, model, logger
+ */
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cli-proxy/internal/cache"
+	"cli-proxy/internal/model"
+	"cli-proxy/pkg/logger"
+)
+
+func TestIsBudgetExhausted(t *testing.T) {
+	if err := logger.Init(t.TempDir(), logger.LevelError); err != nil {
+		t.Fatalf("logger.Init: %v", err)
+	}
+	ctx := context.Background()
+	sessionCache := cache.GetSessionCache()
+
+	tests := []struct {
+		name       string
+		budget     float64
+		cost       float64
+		costErr    error
+		marked     bool // 检查前已有 budget_exhausted 标记
+		want       bool
+		wantMarked bool // 检查后是否有标记
+	}{
+		{name: "no budget", budget: 0, cost: 100, want: false},
+		{name: "under budget", budget: 10, cost: 5, want: false},
+		{name: "budget reached marks account", budget: 10, cost: 10, want: true, wantMarked: true},
+		{name: "marked and still over budget", budget: 10, cost: 12, marked: true, want: true, wantMarked: true},
+		{name: "marked but budget raised", budget: 20, cost: 12, marked: true, want: false},
+		{name: "marked and cost unavailable", budget: 20, costErr: errors.New("db down"), marked: true, want: true, wantMarked: true},
+		{name: "unmarked and cost unavailable", budget: 20, costErr: errors.New("db down"), want: false},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acc := &model.Account{ID: uint(91000 + i), Name: tt.name, DailyBudget: tt.budget}
+			t.Cleanup(func() { sessionCache.ClearAccountUnavailable(ctx, acc.ID) })
+
+			s := &Scheduler{sessionCache: sessionCache}
+			s.SetDailyCostFunc(func(ctx context.Context, accountID uint, date string) (float64, error) {
+				return tt.cost, tt.costErr
+			})
+			if tt.marked {
+				sessionCache.MarkAccountUnavailable(ctx, acc.ID, UnavailableReasonBudgetExhausted, time.Hour)
+			}
+
+			if got := s.isBudgetExhausted(ctx, acc); got != tt.want {
+				t.Errorf("isBudgetExhausted = %v, want %v", got, tt.want)
+			}
+			unavailable, reason, _ := sessionCache.IsAccountUnavailable(ctx, acc.ID)
+			if marked := unavailable && reason == UnavailableReasonBudgetExhausted; marked != tt.wantMarked {
+				t.Errorf("budget_exhausted mark = %v, want %v", marked, tt.wantMarked)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+/*
+ * 文件作用：账户分组路由，将 API Key / 套餐 / 用户绑定到专属账户池
+ * 负责功能：
+ *   - 分组成员缓存（随调度器 Refresh 加载）
+ *   - 请求绑定分组解析（API Key > 套餐 > 用户）
+ *   - 专属分组无可用账户时使用兜底分组
+ *   - 独占分组的账户只调度给绑定该分组的请求
+ * 重要程度：⭐⭐⭐⭐ 重要（企业客户账户隔离）
+ * 依赖模块：model, repository
+ */
+package scheduler
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cli-proxy/internal/model"
+	"cli-proxy/internal/repository"
+	"cli-proxy/pkg/logger"
+)
+
+// 分组绑定缓存时间（API Key / 用户 / 套餐修改后最多延迟该时间生效）
+const groupBindingCacheTTL = time.Minute
+
+// accountGroupInfo 分组缓存信息
+type accountGroupInfo struct {
+	name        string
+	isFallback  bool
+	isExclusive bool
+	members     map[uint]bool // accountID 集合
+}
+
+// groupBindingKey 分组绑定缓存键
+type groupBindingKey struct {
+	userID   uint
+	apiKeyID uint
+}
+
+// groupBinding 请求绑定的分组
+type groupBinding struct {
+	groupIDs  []uint
+	fetchedAt time.Time
+}
+
+// groupRouter 账户分组路由
+type groupRouter struct {
+	mu       sync.RWMutex
+	groups   map[uint]*accountGroupInfo // groupID -> 分组
+	bindings map[groupBindingKey]*groupBinding
+
+	groupRepo       *repository.AccountGroupRepository
+	apiKeyRepo      *repository.APIKeyRepository
+	userRepo        *repository.UserRepository
+	userPackageRepo *repository.UserPackageRepository
+}
+
+// newGroupRouter 创建分组路由
+func newGroupRouter() *groupRouter {
+	return &groupRouter{
+		groups:          make(map[uint]*accountGroupInfo),
+		bindings:        make(map[groupBindingKey]*groupBinding),
+		groupRepo:       repository.NewAccountGroupRepository(),
+		apiKeyRepo:      repository.NewAPIKeyRepository(),
+		userRepo:        repository.NewUserRepository(),
+		userPackageRepo: repository.NewUserPackageRepository(),
+	}
+}
+
+// load 加载分组及成员（同时清空绑定缓存）
+func (g *groupRouter) load() {
+	groups, err := g.groupRepo.GetAll()
+	if err != nil {
+		return
+	}
+	memberships, err := g.groupRepo.GetMemberships()
+	if err != nil {
+		return
+	}
+
+	loaded := make(map[uint]*accountGroupInfo, len(groups))
+	for _, group := range groups {
+		members := make(map[uint]bool, len(memberships[group.ID]))
+		for _, accountID := range memberships[group.ID] {
+			members[accountID] = true
+		}
+		loaded[group.ID] = &accountGroupInfo{
+			name:        group.Name,
+			isFallback:  group.IsFallback,
+			isExclusive: group.IsExclusive,
+			members:     members,
+		}
+	}
+
+	g.mu.Lock()
+	g.groups = loaded
+	g.bindings = make(map[groupBindingKey]*groupBinding)
+	g.mu.Unlock()
+}
+
+// resolveAccountGroups 解析请求绑定的账户分组
+// 优先级：API Key 绑定 > API Key 绑定套餐的分组 > 用户绑定
+// 返回空表示不限制分组
+func (s *Scheduler) resolveAccountGroups(userID, apiKeyID uint) []uint {
+	if userID == 0 && apiKeyID == 0 {
+		return nil
+	}
+	g := s.groups
+	key := groupBindingKey{userID: userID, apiKeyID: apiKeyID}
+
+	g.mu.RLock()
+	cached, ok := g.bindings[key]
+	g.mu.RUnlock()
+	if ok && time.Since(cached.fetchedAt) < groupBindingCacheTTL {
+		return cached.groupIDs
+	}
+
+	var groupIDs []uint
+	if apiKeyID > 0 {
+		if apiKey, err := g.apiKeyRepo.GetByID(apiKeyID); err == nil {
+			groupIDs = parseGroupIDs(apiKey.AccountGroupIDs)
+			if len(groupIDs) == 0 && apiKey.UserPackageID != nil {
+				if up, err := g.userPackageRepo.GetByID(*apiKey.UserPackageID); err == nil && up.Package != nil {
+					groupIDs = parseGroupIDs(up.Package.AccountGroupIDs)
+				}
+			}
+			if userID == 0 {
+				userID = apiKey.UserID
+			}
+		}
+	}
+	if len(groupIDs) == 0 && userID > 0 {
+		if user, err := g.userRepo.GetByID(userID); err == nil {
+			groupIDs = parseGroupIDs(user.AccountGroupIDs)
+		}
+	}
+
+	g.mu.Lock()
+	g.bindings[key] = &groupBinding{groupIDs: groupIDs, fetchedAt: time.Now()}
+	g.mu.Unlock()
+	return groupIDs
+}
+
+// routeByAccountGroups 按绑定分组筛选账户
+// 优先返回绑定分组内的账户；绑定分组内没有账户时返回兜底分组内的账户
+// 独占分组的账户只返回给绑定了该分组的请求；groupIDs 为空时只排除独占账户
+func (s *Scheduler) routeByAccountGroups(groupIDs []uint, accounts []*model.Account) []*model.Account {
+	if len(accounts) == 0 {
+		return accounts
+	}
+	log := logger.GetLogger("scheduler")
+	g := s.groups
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if len(groupIDs) == 0 {
+		shared := make([]*model.Account, 0, len(accounts))
+		for _, acc := range accounts {
+			if !g.isExclusiveMember(acc.ID) {
+				shared = append(shared, acc)
+			}
+		}
+		return shared
+	}
+
+	dedicated := make([]*model.Account, 0, len(accounts))
+	for _, acc := range accounts {
+		if g.inBoundGroups(groupIDs, acc.ID) {
+			dedicated = append(dedicated, acc)
+		}
+	}
+	if len(dedicated) > 0 {
+		log.Debug("账户分组路由 - 分组: %v, 可用账户数: %d", groupIDs, len(dedicated))
+		return dedicated
+	}
+
+	fallback := make([]*model.Account, 0, len(accounts))
+	for _, acc := range accounts {
+		if g.inFallbackGroups(acc.ID) {
+			fallback = append(fallback, acc)
+		}
+	}
+	if len(fallback) > 0 {
+		log.Info("专属分组无可用账户，使用兜底分组 - 分组: %v, 兜底账户数: %d", groupIDs, len(fallback))
+	} else {
+		log.Warn("专属分组及兜底分组均无可用账户 - 分组: %v", groupIDs)
+	}
+	return fallback
+}
+
+// inAccountGroups 检查账户是否属于绑定分组或兜底分组（用于会话粘性校验）
+// groupIDs 为空时只要求账户不属于独占分组
+func (s *Scheduler) inAccountGroups(groupIDs []uint, accountID uint) bool {
+	g := s.groups
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if len(groupIDs) == 0 {
+		return !g.isExclusiveMember(accountID)
+	}
+	return g.inBoundGroups(groupIDs, accountID) || g.inFallbackGroups(accountID)
+}
+
+// inBoundGroups 账户是否属于绑定分组之一（调用方需持有读锁）
+func (g *groupRouter) inBoundGroups(groupIDs []uint, accountID uint) bool {
+	for _, groupID := range groupIDs {
+		if group := g.groups[groupID]; group != nil && group.members[accountID] {
+			return true
+		}
+	}
+	return false
+}
+
+// inFallbackGroups 账户是否属于兜底分组且不属于任何独占分组（调用方需持有读锁）
+func (g *groupRouter) inFallbackGroups(accountID uint) bool {
+	fallback := false
+	for _, group := range g.groups {
+		if !group.members[accountID] {
+			continue
+		}
+		if group.isExclusive {
+			return false
+		}
+		fallback = fallback || group.isFallback
+	}
+	return fallback
+}
+
+// isExclusiveMember 账户是否属于独占分组（调用方需持有读锁）
+func (g *groupRouter) isExclusiveMember(accountID uint) bool {
+	for _, group := range g.groups {
+		if group.isExclusive && group.members[accountID] {
+			return true
+		}
+	}
+	return false
+}
+
+// parseGroupIDs 解析逗号分隔的分组ID
+func parseGroupIDs(value string) []uint {
+	if value == "" {
+		return nil
+	}
+	var ids []uint
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || id == 0 {
+			continue
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids
+}
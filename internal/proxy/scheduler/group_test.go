@@ -0,0 +1,91 @@
+/*
+ * 文件作用：账户分组路由测试
+ * 负责功能：
+ *   - 绑定分组优先、无账户时使用兜底分组
+ *   - 独占分组的账户只调度给绑定该分组的请求
+ *   - 会话粘性校验与分组路由规则一致
+ * 重要程度：⭐⭐ 辅助（测试）
+ * 依赖模块：model, logger
+ */
+package scheduler
+
+import (
+	"reflect"
+	"testing"
+
+	"cli-proxy/internal/model"
+	"cli-proxy/pkg/logger"
+)
+
+// testGroupScheduler 构造带分组缓存的调度器：
+// 分组 1 独占 {1,2,5}，分组 2 独占 {3}，分组 3 兜底 {4,5,7}，分组 4 普通 {7,8}；账户 6 未分组
+// 账户 5 同时属于独占分组与兜底分组，独占优先
+func testGroupScheduler(t *testing.T) *Scheduler {
+	t.Helper()
+	if err := logger.Init(t.TempDir(), logger.LevelError); err != nil {
+		t.Fatalf("logger.Init: %v", err)
+	}
+	members := func(ids ...uint) map[uint]bool {
+		m := make(map[uint]bool, len(ids))
+		for _, id := range ids {
+			m[id] = true
+		}
+		return m
+	}
+	return &Scheduler{groups: &groupRouter{groups: map[uint]*accountGroupInfo{
+		1: {name: "enterprise-a", isExclusive: true, members: members(1, 2, 5)},
+		2: {name: "enterprise-b", isExclusive: true, members: members(3)},
+		3: {name: "shared", isFallback: true, members: members(4, 5, 7)},
+		4: {name: "team", members: members(7, 8)},
+	}}}
+}
+
+func TestRouteByAccountGroups(t *testing.T) {
+	s := testGroupScheduler(t)
+	all := []*model.Account{{ID: 1}, {ID: 2}, {ID: 3}, {ID: 4}, {ID: 5}, {ID: 6}, {ID: 7}, {ID: 8}}
+
+	tests := []struct {
+		name     string
+		groupIDs []uint
+		accounts []*model.Account
+		want     []uint
+	}{
+		{name: "unbound excludes exclusive accounts", accounts: all, want: []uint{4, 6, 7, 8}},
+		{name: "bound to exclusive group", groupIDs: []uint{1}, accounts: all, want: []uint{1, 2, 5}},
+		{name: "bound to several groups", groupIDs: []uint{1, 2}, accounts: all, want: []uint{1, 2, 3, 5}},
+		{name: "bound to shared group", groupIDs: []uint{4}, accounts: all, want: []uint{7, 8}},
+		{name: "bound group empty uses fallback", groupIDs: []uint{2}, accounts: []*model.Account{{ID: 1}, {ID: 4}, {ID: 6}}, want: []uint{4}},
+		{name: "fallback skips other exclusive accounts", groupIDs: []uint{99}, accounts: all, want: []uint{4, 7}},
+		{name: "unbound with only exclusive accounts", accounts: []*model.Account{{ID: 1}, {ID: 3}}, want: []uint{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []uint{}
+			for _, acc := range s.routeByAccountGroups(tt.groupIDs, tt.accounts) {
+				got = append(got, acc.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("routeByAccountGroups(%v) = %v, want %v", tt.groupIDs, got, tt.want)
+			}
+
+			// 会话粘性校验与路由结果一致（绑定分组有账户时兜底账户仍可保持粘性）
+			for _, acc := range tt.accounts {
+				routed := false
+				for _, id := range tt.want {
+					routed = routed || id == acc.ID
+				}
+				if in := s.inAccountGroups(tt.groupIDs, acc.ID); routed && !in {
+					t.Errorf("inAccountGroups(%v, %d) = false for a routed account", tt.groupIDs, acc.ID)
+				}
+			}
+		})
+	}
+
+	// 未绑定分组时独占账户不能保持会话粘性
+	for id, want := range map[uint]bool{1: false, 3: false, 4: true, 5: false, 6: true, 8: true} {
+		if got := s.inAccountGroups(nil, id); got != want {
+			t.Errorf("inAccountGroups(nil, %d) = %v, want %v", id, got, want)
+		}
+	}
+}
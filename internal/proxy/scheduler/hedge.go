@@ -0,0 +1,280 @@
+/*
+ * 文件作用：非流式请求对冲，首个账户响应过慢时并发请求第二个账户，取先成功者
+ * 负责功能：
+ *   - 按模型统计近期非流式请求耗时，计算对冲触发延迟（百分位）
+ *   - 对冲执行：超时后选择另一账户并发请求，先成功者返回，另一方取消
+ *   - 对冲统计（触发次数、对冲获胜次数）
+ * 重要程度：⭐⭐⭐ 一般（延迟敏感场景可选功能）
+ * 依赖模块：cache, model, adapter, logger
+ */
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cli-proxy/internal/model"
+	"cli-proxy/internal/proxy/adapter"
+	"cli-proxy/pkg/logger"
+)
+
+// 对冲延迟统计参数
+const (
+	hedgeLatencyWindow     = 256 // 每个模型保留的最近耗时样本数
+	hedgeLatencyMinSamples = 20  // 样本不足时不触发对冲
+)
+
+// HedgeOptions 对冲配置
+type HedgeOptions struct {
+	Percentile float64       // 触发对冲的耗时百分位（0-100）
+	MinDelay   time.Duration // 最小触发延迟，避免样本偏低时过早对冲
+}
+
+// HedgeStats 对冲统计
+type HedgeStats struct {
+	Fired int64 `json:"fired"` // 触发对冲次数
+	Won   int64 `json:"won"`   // 对冲请求先于首个请求成功的次数
+}
+
+// latencyWindow 单个模型的耗时环形缓冲
+type latencyWindow struct {
+	samples [hedgeLatencyWindow]time.Duration
+	next    int
+	count   int
+}
+
+// hedgeTracker 按模型统计耗时与对冲计数
+type hedgeTracker struct {
+	mu      sync.Mutex
+	windows map[string]*latencyWindow
+
+	fired atomic.Int64
+	won   atomic.Int64
+}
+
+func newHedgeTracker() *hedgeTracker {
+	return &hedgeTracker{windows: make(map[string]*latencyWindow)}
+}
+
+// observe 记录一次成功的非流式请求耗时
+func (h *hedgeTracker) observe(modelName string, latency time.Duration) {
+	if latency <= 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w, ok := h.windows[modelName]
+	if !ok {
+		w = &latencyWindow{}
+		h.windows[modelName] = w
+	}
+	w.samples[w.next] = latency
+	w.next = (w.next + 1) % hedgeLatencyWindow
+	if w.count < hedgeLatencyWindow {
+		w.count++
+	}
+}
+
+// percentile 模型近期耗时的百分位，样本不足时返回 false
+func (h *hedgeTracker) percentile(modelName string, p float64) (time.Duration, bool) {
+	h.mu.Lock()
+	w, ok := h.windows[modelName]
+	if !ok || w.count < hedgeLatencyMinSamples {
+		h.mu.Unlock()
+		return 0, false
+	}
+	sorted := make([]time.Duration, w.count)
+	copy(sorted, w.samples[:w.count])
+	h.mu.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	p = math.Max(0, math.Min(100, p))
+	index := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return sorted[index], true
+}
+
+// GetHedgeStats 获取对冲统计
+func (s *Scheduler) GetHedgeStats() *HedgeStats {
+	return &HedgeStats{
+		Fired: s.hedges.fired.Load(),
+		Won:   s.hedges.won.Load(),
+	}
+}
+
+// WithHedge 启用非流式请求对冲（仅 ExecuteWithRetry 生效）
+func (r *RetryableRequest) WithHedge(opts HedgeOptions) *RetryableRequest {
+	r.hedge = &opts
+	return r
+}
+
+// hedgeAttempt 对冲中单个账户的执行结果
+type hedgeAttempt struct {
+	account *model.Account
+	resp    *adapter.Response
+	err     error
+	hedged  bool // 是否为对冲请求
+}
+
+func (a hedgeAttempt) succeeded() bool {
+	return a.err == nil && a.resp != nil && a.resp.Error == nil
+}
+
+// attemptError 失败原因（上游返回错误响应时转换为 error）
+func (a hedgeAttempt) attemptError() error {
+	if a.err == nil && a.resp != nil && a.resp.Error != nil {
+		return errors.New(a.resp.Error.Message)
+	}
+	return a.err
+}
+
+// hedgeDelay 本次请求的对冲触发延迟，返回 0 表示不对冲
+func (r *RetryableRequest) hedgeDelay(modelName string) time.Duration {
+	if r.hedge == nil {
+		return 0
+	}
+	delay, ok := r.Scheduler.hedges.percentile(GetActualModel(modelName), r.hedge.Percentile)
+	if !ok {
+		return 0
+	}
+	if delay < r.hedge.MinDelay {
+		delay = r.hedge.MinDelay
+	}
+	return delay
+}
+
+// executeHedged 执行单次尝试，首个账户超过对冲延迟未响应时并发请求另一个账户
+// 返回先成功的账户及结果；均失败时返回首个账户的结果。落败方被取消，不计费
+func (r *RetryableRequest) executeHedged(
+	ctx context.Context,
+	account *model.Account,
+	modelName string,
+	execFunc func(ctx context.Context, account *model.Account) (*adapter.Response, error),
+) (*model.Account, *adapter.Response, error) {
+	delay := r.hedgeDelay(modelName)
+	if delay <= 0 {
+		resp, err := execFunc(ctx, account)
+		return account, resp, err
+	}
+
+	log := logger.GetLogger("scheduler")
+	results := make(chan hedgeAttempt, 2)
+	primaryCtx, cancelPrimary := context.WithCancel(ctx)
+	go func() {
+		resp, err := execFunc(primaryCtx, account)
+		results <- hedgeAttempt{account: account, resp: resp, err: err}
+	}()
+
+	timer := time.NewTimer(delay)
+	select {
+	case res := <-results:
+		timer.Stop()
+		cancelPrimary()
+		return res.account, res.resp, res.err
+	case <-timer.C:
+	}
+
+	hedgeAccount, hedgeCtx, cancelHedge, ok := r.startHedge(ctx, account, modelName)
+	if !ok {
+		res := <-results
+		cancelPrimary()
+		return res.account, res.resp, res.err
+	}
+	r.Scheduler.hedges.fired.Add(1)
+	log.Info("触发请求对冲 - 模型: %s, 首个账户: %d, 对冲账户: %d, 延迟: %v", modelName, account.ID, hedgeAccount.ID, delay)
+	go func() {
+		resp, err := execFunc(hedgeCtx, hedgeAccount)
+		results <- hedgeAttempt{account: hedgeAccount, resp: resp, err: err, hedged: true}
+	}()
+
+	// 取先成功者；两者都失败时以首个账户的结果为准（由重试循环处理）
+	var primary hedgeAttempt
+	var failed []hedgeAttempt
+	for pending := 2; pending > 0; pending-- {
+		res := <-results
+		if !res.succeeded() {
+			if res.hedged {
+				failed = append(failed, res)
+			} else {
+				primary = res
+			}
+			continue
+		}
+
+		cancelPrimary()
+		cancelHedge()
+		if res.hedged {
+			r.Scheduler.hedges.won.Add(1)
+			log.Info("对冲请求获胜 - 模型: %s, 账户: %d", modelName, res.account.ID)
+			if pending == 1 {
+				failed = append(failed, primary)
+			}
+		}
+		// 先于获胜方失败的一方计入熔断器
+		for _, f := range failed {
+			r.recordBreakerFailure(ctx, f.account, f.attemptError())
+		}
+		// 仍在执行的落败方已被取消，结束后归还熔断器探测名额
+		if pending == 2 {
+			go func() {
+				loser := <-results
+				r.Scheduler.breakers.release(loser.account.ID)
+			}()
+		}
+		return res.account, res.resp, res.err
+	}
+	cancelPrimary()
+	cancelHedge()
+
+	for _, f := range failed {
+		r.recordBreakerFailure(ctx, f.account, f.attemptError())
+	}
+	return primary.account, primary.resp, primary.err
+}
+
+// startHedge 选择对冲账户并占用其并发槽位，无可用账户或槽位已满时放弃对冲
+// 对冲账户不改变会话绑定
+func (r *RetryableRequest) startHedge(ctx context.Context, primary *model.Account, modelName string) (*model.Account, context.Context, context.CancelFunc, bool) {
+	tried := r.triedAccounts[primary.ID]
+	r.triedAccounts[primary.ID] = true
+	sessionID := r.SessionID
+	r.SessionID = ""
+	hedgeAccount, err := r.selectNextAccount(ctx, modelName)
+	r.SessionID = sessionID
+	r.triedAccounts[primary.ID] = tried
+	if err != nil || hedgeAccount == nil || hedgeAccount.ID == primary.ID {
+		if hedgeAccount != nil {
+			r.Scheduler.breakers.release(hedgeAccount.ID)
+		}
+		return nil, nil, nil, false
+	}
+
+	sessionCache := r.Scheduler.GetSessionCache()
+	var leaseID string
+	if sessionCache != nil {
+		limit := hedgeAccount.MaxConcurrency
+		if limit <= 0 {
+			limit = defaultAccountConcurrency
+		}
+		var acquired bool
+		leaseID, acquired, _, err = sessionCache.AcquireConcurrencyWithLimit(ctx, hedgeAccount.ID, limit, r.leaseMeta(ctx, modelName))
+		if err != nil || !acquired {
+			r.Scheduler.breakers.release(hedgeAccount.ID)
+			return nil, nil, nil, false
+		}
+	}
+
+	hedgeCtx, cancel := context.WithCancel(ctx)
+	release := r.holdLease(ctx, sessionCache, hedgeAccount.ID, leaseID)
+	return hedgeAccount, hedgeCtx, func() {
+		cancel()
+		release()
+	}, true
+}
@@ -0,0 +1,246 @@
+/*
+ * 文件作用：调度器 Prometheus 指标，记录上游请求、重试、账户切换和 Token 刷新
+ * 负责功能：
+ *   - 上游请求计数与耗时直方图（按平台/模型/账户/状态）
+ *   - 模型标签目录（只使用 ai_models 中的模型名，其余记为 other）
+ *   - 流式首字节时间直方图
+ *   - 重试与账户切换计数
+ *   - OAuth Token 刷新结果计数
+ *   - 采集时读取：账户在途并发、会话绑定数、熔断器状态
+ * 重要程度：⭐⭐⭐ 一般（可观测性）
+ * 依赖模块：metrics, adapter, cache, repository
+ */
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cli-proxy/internal/model"
+	"cli-proxy/internal/proxy/adapter"
+	"cli-proxy/internal/repository"
+	"cli-proxy/pkg/metrics"
+)
+
+var (
+	upstreamRequestsTotal = metrics.NewCounterVec("cliproxy_upstream_requests_total",
+		"Upstream request attempts by platform, model, account and status.",
+		"platform", "model", "account", "status")
+	upstreamRequestDuration = metrics.NewHistogramVec("cliproxy_upstream_request_duration_seconds",
+		"Upstream request attempt duration in seconds.",
+		nil, "platform", "model", "account", "status")
+	streamFirstByteSeconds = metrics.NewHistogramVec("cliproxy_stream_time_to_first_token_seconds",
+		"Time from upstream stream start to first byte in seconds.",
+		[]float64{0.25, 0.5, 1, 2, 3, 5, 10, 20, 30, 60}, "platform", "model", "account")
+	retriesTotal = metrics.NewCounterVec("cliproxy_retries_total",
+		"Request attempts after the first one, by platform and model.",
+		"platform", "model")
+	accountSwitchesTotal = metrics.NewCounterVec("cliproxy_account_switches_total",
+		"Retries that switched to a different account after a failure.",
+		"platform", "model")
+	tokenRefreshTotal = metrics.NewCounterVec("cliproxy_token_refresh_total",
+		"OAuth access token refresh outcomes by account type.",
+		"account_type", "result")
+
+	_ = metrics.NewGaugeFunc("cliproxy_account_in_flight",
+		"In-flight upstream requests per account (concurrency slots held).",
+		[]string{"platform", "account"}, collectAccountInFlight)
+	_ = metrics.NewGaugeFunc("cliproxy_session_bindings",
+		"Number of active session-to-account bindings.",
+		nil, collectSessionBindings)
+	_ = metrics.NewGaugeFunc("cliproxy_circuit_breaker_state",
+		"Account circuit breaker state (0=closed, 1=open, 2=half-open).",
+		[]string{"account"}, collectBreakerStates)
+)
+
+// metricsCollectTimeout 采集时读取共享后端（Redis）的超时
+const metricsCollectTimeout = 2 * time.Second
+
+const (
+	// modelCatalogTTL 模型标签目录缓存时间（模型目录修改后最多延迟该时间生效）
+	modelCatalogTTL = time.Minute
+	// otherModelLabel 未收录模型的标签值
+	otherModelLabel = "other"
+)
+
+// modelLabelCatalog 模型标签目录
+// 客户端传入的模型名不受控，model 标签只使用模型目录中的模型名，避免任意 API Key 制造无限序列
+type modelLabelCatalog struct {
+	mu       sync.RWMutex
+	names    map[string]string // 模型名/别名 -> 目录中的模型名
+	loadedAt time.Time
+	loading  atomic.Bool
+}
+
+var metricModels = &modelLabelCatalog{}
+
+// load 从模型目录加载模型名与别名（随调度器 Refresh 加载，过期后在后台重新加载）
+func (c *modelLabelCatalog) load() {
+	db := repository.GetDB()
+	if db == nil {
+		c.set(nil)
+		return
+	}
+	models, err := repository.NewAIModelRepository(db).List("", nil)
+	if err != nil {
+		// 保留旧目录，过期后再重试
+		c.set(c.snapshot())
+		return
+	}
+
+	names := make(map[string]string, len(models))
+	for _, m := range models {
+		names[m.Name] = m.Name
+	}
+	for _, m := range models {
+		for _, alias := range strings.Split(m.Aliases, ",") {
+			if alias = strings.TrimSpace(alias); alias != "" {
+				if _, exists := names[alias]; !exists {
+					names[alias] = m.Name
+				}
+			}
+		}
+	}
+	c.set(names)
+}
+
+func (c *modelLabelCatalog) set(names map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.names = names
+	c.loadedAt = time.Now()
+}
+
+func (c *modelLabelCatalog) snapshot() map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.names
+}
+
+// label 模型名对应的标签值：目录中的模型名（别名归并到主名称），未收录时为 other
+func (c *modelLabelCatalog) label(modelName string) string {
+	name := strings.TrimPrefix(GetActualModel(modelName), "models/")
+
+	c.mu.RLock()
+	label, ok := c.names[name]
+	stale := time.Since(c.loadedAt) > modelCatalogTTL
+	c.mu.RUnlock()
+
+	if stale && c.loading.CompareAndSwap(false, true) {
+		go func() {
+			defer c.loading.Store(false)
+			c.load()
+		}()
+	}
+	if !ok {
+		return otherModelLabel
+	}
+	return label
+}
+
+// attemptStatus 单次尝试的状态标签：success / 上游状态码 / 超时 / 取消 / error
+func attemptStatus(err error) string {
+	if err == nil {
+		return "success"
+	}
+	var upstreamErr *adapter.UpstreamError
+	switch {
+	case errors.As(err, &upstreamErr):
+		return strconv.Itoa(upstreamErr.StatusCode)
+	case errors.Is(err, ErrFirstByteTimeout):
+		return "first_byte_timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	}
+	return "error"
+}
+
+// observeAttempt 记录一次上游请求尝试
+func observeAttempt(account *model.Account, modelName string, err error, duration time.Duration) {
+	platform, accountLabel, modelLabel := account.Platform, strconv.FormatUint(uint64(account.ID), 10), metricModels.label(modelName)
+	status := attemptStatus(err)
+	upstreamRequestsTotal.Inc(platform, modelLabel, accountLabel, status)
+	upstreamRequestDuration.Observe(duration.Seconds(), platform, modelLabel, accountLabel, status)
+}
+
+// observeFirstByte 记录流式请求首字节时间
+func observeFirstByte(account *model.Account, modelName string, ttft time.Duration) {
+	streamFirstByteSeconds.Observe(ttft.Seconds(), account.Platform, metricModels.label(modelName), strconv.FormatUint(uint64(account.ID), 10))
+}
+
+// observeRetry 记录重试（attempt 从 0 开始），上次失败账户与本次不同时记为账户切换
+func observeRetry(attempt int, lastAccount, account *model.Account, modelName string) {
+	if attempt == 0 {
+		return
+	}
+	modelLabel := metricModels.label(modelName)
+	retriesTotal.Inc(account.Platform, modelLabel)
+	if lastAccount != nil && lastAccount.ID != account.ID {
+		accountSwitchesTotal.Inc(account.Platform, modelLabel)
+	}
+}
+
+// ObserveTokenRefresh 记录一次 Token 刷新结果（含健康检查中的 SessionKey 重新授权）
+func ObserveTokenRefresh(accountType string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	tokenRefreshTotal.Inc(accountType, result)
+}
+
+// collectAccountInFlight 采集各账户在途并发（调度器未初始化时不输出）
+func collectAccountInFlight(emit func(value float64, labelValues ...string)) {
+	s := defaultScheduler
+	if s == nil || s.sessionCache == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), metricsCollectTimeout)
+	defer cancel()
+
+	s.mu.RLock()
+	var accounts []*model.Account
+	for _, platformAccounts := range s.accounts {
+		accounts = append(accounts, platformAccounts...)
+	}
+	s.mu.RUnlock()
+
+	for _, acc := range accounts {
+		count, err := s.sessionCache.GetAccountConcurrency(ctx, acc.ID)
+		if err != nil {
+			continue
+		}
+		emit(float64(count), acc.Platform, strconv.FormatUint(uint64(acc.ID), 10))
+	}
+}
+
+// collectSessionBindings 采集会话绑定数
+func collectSessionBindings(emit func(value float64, labelValues ...string)) {
+	s := defaultScheduler
+	if s == nil || s.sessionCache == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), metricsCollectTimeout)
+	defer cancel()
+	if stats, err := s.sessionCache.Stats(ctx); err == nil {
+		emit(float64(stats.SessionCount))
+	}
+}
+
+// collectBreakerStates 采集已创建熔断器的账户状态
+func collectBreakerStates(emit func(value float64, labelValues ...string)) {
+	s := defaultScheduler
+	if s == nil {
+		return
+	}
+	for _, info := range s.breakers.stats().Breakers {
+		emit(float64(info.State), strconv.FormatUint(uint64(info.AccountID), 10))
+	}
+}
@@ -0,0 +1,86 @@
+/*
+ * 文件作用：调度器指标测试
+ * 负责功能：
+ *   - model 标签只使用模型目录中的模型名（别名归并、未收录记为 other）
+ *   - 上游请求计数与耗时直方图不产生客户端模型名序列
+ * 重要程度：⭐⭐ 辅助（测试）
+ * 依赖模块：metrics, model
+ */
+package scheduler
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"cli-proxy/internal/model"
+	"cli-proxy/pkg/metrics"
+)
+
+// setTestModelCatalog 替换模型标签目录（测试结束后恢复）
+func setTestModelCatalog(t *testing.T, names map[string]string) {
+	t.Helper()
+	previous := metricModels.snapshot()
+	metricModels.set(names)
+	t.Cleanup(func() { metricModels.set(previous) })
+}
+
+func TestModelLabel(t *testing.T) {
+	setTestModelCatalog(t, map[string]string{
+		"claude-sonnet-4-5-20250929": "claude-sonnet-4-5-20250929",
+		"gemini-2.5-pro":             "gemini-2.5-pro",
+		"claude-3-5-sonnet-latest":   "claude-3-5-sonnet-20241022",
+	})
+
+	tests := []struct {
+		modelName string
+		want      string
+	}{
+		{modelName: "claude-sonnet-4-5-20250929", want: "claude-sonnet-4-5-20250929"},
+		{modelName: "claude-3-5-sonnet-latest", want: "claude-3-5-sonnet-20241022"},
+		{modelName: "bedrock,claude-sonnet-4-5-20250929", want: "claude-sonnet-4-5-20250929"},
+		{modelName: "models/gemini-2.5-pro", want: "gemini-2.5-pro"},
+		{modelName: "claude-sonnet-4-5-20250929-x1f3a9", want: otherModelLabel},
+		{modelName: "", want: otherModelLabel},
+	}
+	for _, tt := range tests {
+		if got := metricModels.label(tt.modelName); got != tt.want {
+			t.Errorf("label(%q) = %q, want %q", tt.modelName, got, tt.want)
+		}
+	}
+}
+
+func TestObserveAttemptModelLabel(t *testing.T) {
+	setTestModelCatalog(t, map[string]string{"gpt-4.1": "gpt-4.1", "gpt-4.1-2025-04-14": "gpt-4.1"})
+
+	account := &model.Account{ID: 98765, Platform: model.PlatformOpenAI}
+	for _, name := range []string{"gpt-4.1", "gpt-4.1-2025-04-14", "random-model-a81c", "random-model-f02e"} {
+		observeAttempt(account, name, nil, 120*time.Millisecond)
+		observeFirstByte(account, name, 80*time.Millisecond)
+	}
+
+	var buf bytes.Buffer
+	if err := metrics.Write(&buf); err != nil {
+		t.Fatalf("metrics.Write: %v", err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "random-model") || strings.Contains(out, "gpt-4.1-2025-04-14") {
+		t.Error("metrics output contains a model name outside the catalogue")
+	}
+
+	tests := []struct {
+		sample string
+		want   string
+	}{
+		{sample: `cliproxy_upstream_requests_total{platform="openai",model="gpt-4.1",account="98765",status="success"}`, want: "2"},
+		{sample: `cliproxy_upstream_requests_total{platform="openai",model="other",account="98765",status="success"}`, want: "2"},
+		{sample: `cliproxy_upstream_request_duration_seconds_count{platform="openai",model="other",account="98765",status="success"}`, want: "2"},
+		{sample: `cliproxy_stream_time_to_first_token_seconds_count{platform="openai",model="gpt-4.1",account="98765"}`, want: "2"},
+	}
+	for _, tt := range tests {
+		if !strings.Contains(out, tt.sample+" "+tt.want+"\n") {
+			t.Errorf("metrics output missing %s %s", tt.sample, tt.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+/*
+ * 文件作用：提示前缀亲和，优先将相同提示前缀的请求路由到最近服务过该前缀的账户
+ * 负责功能：
+ *   - 记录提示前缀哈希 -> 最近成功服务的账户（带过期时间）
+ *   - 账户选择时优先命中前缀亲和账户（上游提示缓存按账户隔离）
+ *   - 过期记录清理与亲和命中统计
+ * 重要程度：⭐⭐⭐ 一般（提升上游提示缓存命中率，降低成本）
+ * 依赖模块：model
+ */
+package scheduler
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cli-proxy/internal/model"
+)
+
+// 前缀亲和记录参数
+const (
+	prefixAffinityMaxEntries = 50000 // 记录数上限，超过后清理过期记录
+	prefixAffinityPruneEvery = 1024  // 每写入多少次清理一次过期记录
+	defaultPrefixAffinityTTL = 5 * time.Minute
+)
+
+// PrefixAffinityStats 前缀亲和统计
+type PrefixAffinityStats struct {
+	Entries int   `json:"entries"` // 当前记录数
+	Hits    int64 `json:"hits"`    // 命中亲和账户次数
+	Misses  int64 `json:"misses"`  // 有亲和记录但账户不可用的次数
+}
+
+// prefixEntry 单个前缀的亲和账户
+type prefixEntry struct {
+	accountID uint
+	expireAt  time.Time
+}
+
+// prefixAffinity 提示前缀亲和记录
+type prefixAffinity struct {
+	mu      sync.Mutex
+	entries map[string]prefixEntry
+	writes  int
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func newPrefixAffinity() *prefixAffinity {
+	return &prefixAffinity{entries: make(map[string]prefixEntry)}
+}
+
+// lookup 获取前缀的亲和账户，无记录或已过期时返回 false
+func (p *prefixAffinity) lookup(prefix string, now time.Time) (uint, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry, ok := p.entries[prefix]
+	if !ok || now.After(entry.expireAt) {
+		return 0, false
+	}
+	return entry.accountID, true
+}
+
+// record 记录前缀由该账户成功服务（上游缓存在 TTL 内有效）
+func (p *prefixAffinity) record(prefix string, accountID uint, ttl time.Duration, now time.Time) {
+	if prefix == "" || accountID == 0 {
+		return
+	}
+	if ttl <= 0 {
+		ttl = defaultPrefixAffinityTTL
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.entries[prefix] = prefixEntry{accountID: accountID, expireAt: now.Add(ttl)}
+	p.writes++
+	if p.writes%prefixAffinityPruneEvery == 0 || len(p.entries) > prefixAffinityMaxEntries {
+		p.prune(now)
+	}
+}
+
+// prune 清理过期记录，仍超过上限时清空（亲和只是优化，丢失不影响正确性）
+func (p *prefixAffinity) prune(now time.Time) {
+	for prefix, entry := range p.entries {
+		if now.After(entry.expireAt) {
+			delete(p.entries, prefix)
+		}
+	}
+	if len(p.entries) > prefixAffinityMaxEntries {
+		p.entries = make(map[string]prefixEntry)
+	}
+}
+
+// GetPrefixAffinityStats 获取前缀亲和统计
+func (s *Scheduler) GetPrefixAffinityStats() *PrefixAffinityStats {
+	s.prefixes.mu.Lock()
+	entries := len(s.prefixes.entries)
+	s.prefixes.mu.Unlock()
+	return &PrefixAffinityStats{
+		Entries: entries,
+		Hits:    s.prefixes.hits.Load(),
+		Misses:  s.prefixes.misses.Load(),
+	}
+}
+
+// WithPromptPrefix 设置提示前缀哈希，ttl 为亲和记录有效期（对应上游提示缓存有效期）
+func (r *RetryableRequest) WithPromptPrefix(prefix string, ttl time.Duration) *RetryableRequest {
+	r.PromptPrefix = prefix
+	r.prefixTTL = ttl
+	return r
+}
+
+// pickPrefixAffinity 候选账户中包含前缀亲和账户且熔断器放行时返回该账户
+func (r *RetryableRequest) pickPrefixAffinity(candidates []*model.Account) *model.Account {
+	if r.PromptPrefix == "" {
+		return nil
+	}
+	accountID, ok := r.Scheduler.prefixes.lookup(r.PromptPrefix, time.Now())
+	if !ok {
+		return nil
+	}
+	for _, acc := range candidates {
+		if acc.ID == accountID && r.Scheduler.breakers.acquire(acc.ID) {
+			r.Scheduler.prefixes.hits.Add(1)
+			return acc
+		}
+	}
+	r.Scheduler.prefixes.misses.Add(1)
+	return nil
+}
+
+// recordPrefixAffinity 请求成功后记录前缀亲和账户
+func (r *RetryableRequest) recordPrefixAffinity(accountID uint) {
+	if r.PromptPrefix == "" {
+		return
+	}
+	r.Scheduler.prefixes.record(r.PromptPrefix, accountID, r.prefixTTL, time.Now())
+}
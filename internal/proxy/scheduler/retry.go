@@ -1,13 +1,14 @@
 /*
  * 文件作用：请求重试机制，处理失败请求的自动重试和账户切换
  * 负责功能：
- *   - 请求重试配置（次数、延迟、退避系数）
+ *   - 请求重试配置（次数、延迟、退避系数、抖动、请求总时长、首字节超时）
+ *   - 可取消的退避等待（优先使用上游 Retry-After）
  *   - 账户切换重试（失败后尝试其他账户）
  *   - 并发控制（账户并发限制）
  *   - 可重试错误判断（连接错误、限流等）
  *   - 流式/非流式请求重试
  * 重要程度：⭐⭐⭐⭐⭐ 核心（保证请求可靠性）
- * 依赖模块：cache, model, adapter
+ * 依赖模块：cache, model, adapter, tracing
  */
 package scheduler
 
@@ -15,39 +16,59 @@ import (
 	"context"
 	"errors"
 	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"cli-proxy/internal/cache"
 	"cli-proxy/internal/model"
 	"cli-proxy/internal/proxy/adapter"
 	"cli-proxy/pkg/logger"
+	"cli-proxy/pkg/tracing"
 )
 
 var (
 	ErrAllAccountsFailed      = errors.New("all accounts failed")
 	ErrMaxRetriesExceeded     = errors.New("max retries exceeded")
 	ErrAccountConcurrencyFull = errors.New("account concurrency limit reached")
+	ErrFirstByteTimeout       = errors.New("upstream first byte timeout")
+	ErrTotalTimeout           = errors.New("request total timeout exceeded")
+	errRetryBudgetExhausted   = errors.New("retry budget exhausted")
 )
 
 // RetryConfig 重试配置
 type RetryConfig struct {
-	MaxRetries        int           // 最大重试次数
-	RetryDelay        time.Duration // 重试延迟
-	RetryBackoff      float64       // 退避系数
-	RetryableErrors   []string      // 可重试的错误类型
-	SwitchOnRateLimit bool          // 限流时是否切换账户
-	SwitchOnError     bool          // 错误时是否切换账户
+	MaxRetries                int           // 最大重试次数
+	RetryDelay                time.Duration // 重试延迟
+	RetryBackoff              float64       // 退避系数
+	MaxRetryDelay             time.Duration // 单次退避上限（0 不限制）
+	Jitter                    float64       // 退避随机抖动比例（0-1），避免大量请求同时重试
+	TotalTimeout              time.Duration // 单个请求总时长上限（0 不限制），覆盖全部尝试、排队和退避等待，超出后取消进行中的尝试；流式请求开始向客户端输出后不再受限
+	FirstByteTimeout          time.Duration // 流式请求单次尝试等待上游首字节的超时（0 不限制），超时后切换账户重试
+	NonStreamFirstByteTimeout time.Duration // 非流式请求单次尝试（含对冲）等待上游响应的超时（0 不限制），超时后切换账户重试
+	RetryableErrors           []string      // 可重试的错误类型
+	SwitchOnRateLimit         bool          // 限流时是否切换账户
+	SwitchOnError             bool          // 错误时是否切换账户
 }
 
 // DefaultRetryConfig 默认重试配置
 var DefaultRetryConfig = RetryConfig{
-	MaxRetries:        5,
-	RetryDelay:        time.Second,
-	RetryBackoff:      1.5,
-	RetryableErrors:   []string{"timeout", "connection", "403", "429", "529", "503", "502"},
-	SwitchOnRateLimit: true,
-	SwitchOnError:     true,
+	MaxRetries:                5,
+	RetryDelay:                time.Second,
+	RetryBackoff:              1.5,
+	MaxRetryDelay:             10 * time.Second,
+	Jitter:                    0.2,
+	TotalTimeout:              10 * time.Minute,
+	FirstByteTimeout:          60 * time.Second,
+	NonStreamFirstByteTimeout: 5 * time.Minute,
+	RetryableErrors:           []string{"timeout", "connection", "403", "429", "529", "503", "502"},
+	SwitchOnRateLimit:         true,
+	SwitchOnError:             true,
 }
 
 // RetryableRequest 可重试的请求
@@ -60,9 +81,20 @@ type RetryableRequest struct {
 	ClientIP      string // 客户端IP
 	UserAgent     string // 客户端User-Agent
 	OriginalModel string // 原始模型名（映射前），用于 AllowedModels 检查
+	PromptPrefix  string // 提示前缀哈希，用于提示缓存亲和路由
 
 	// 已尝试的账户 ID，避免重复使用
 	triedAccounts map[uint]bool
+
+	// 请求绑定的账户分组（首次选择账户时解析）
+	accountGroupIDs []uint
+	groupsResolved  bool
+
+	// 非流式请求对冲配置（nil 表示不对冲）
+	hedge *HedgeOptions
+
+	// 提示前缀亲和记录有效期
+	prefixTTL time.Duration
 }
 
 // NewRetryableRequest 创建可重试请求
@@ -117,7 +149,16 @@ func (r *RetryableRequest) ExecuteWithRetry(
 	var lastErr error
 	var lastAccount *model.Account
 	var lastResp *adapter.Response
-	delay := r.Config.RetryDelay
+	deadline := r.retryDeadline(startTime)
+	backoffs := 0
+	attempts := 0
+
+	// 请求总时长：所有尝试、排队和退避等待共用同一截止时间
+	ctx, cancelTotal := r.totalTimeoutContext(ctx, startTime)
+	defer cancelTotal()
+
+	// 每次上游调用（含对冲）单独计算首字节超时
+	execFunc = r.withFirstByteTimeout(execFunc)
 
 	// 记录每个账户的失败次数（用于最终标记状态）
 	accountFailures := make(map[uint]int)
@@ -133,6 +174,14 @@ func (r *RetryableRequest) ExecuteWithRetry(
 	)
 
 	for attempt := 0; attempt <= r.Config.MaxRetries; attempt++ {
+		if ctx.Err() != nil {
+			return nil, contextErr(ctx)
+		}
+		if r.budgetExhausted(deadline) {
+			break
+		}
+		attempts = attempt + 1
+
 		// 选择账户（允许重试同一账户）
 		account, err := r.selectNextAccountAllowRetry(ctx, modelName, accountFailures)
 		if err != nil {
@@ -140,13 +189,17 @@ func (r *RetryableRequest) ExecuteWithRetry(
 				// 如果没有可用账户，检查是否还有重试机会
 				if attempt < r.Config.MaxRetries {
 					// 等待后重试，可能有账户恢复
-					time.Sleep(delay)
-					delay = time.Duration(float64(delay) * r.Config.RetryBackoff)
-					continue
+					waitErr := r.backoff(ctx, &backoffs, lastErr, deadline)
+					if waitErr == nil {
+						continue
+					}
+					if ctx.Err() != nil {
+						return nil, contextErr(ctx)
+					}
 				}
 				// 所有重试都失败，标记最后使用的账户错误
-				if lastAccount != nil && lastErr != nil {
-					r.Scheduler.MarkAccountError(lastAccount.ID, lastAccount.Type, lastErr)
+				if lastAccount != nil && lastErr != nil && upstreamResetAt(lastErr) == nil {
+					r.markAccountError(lastAccount, lastErr)
 				}
 				log.ErrorZ("代理请求失败-无可用账户",
 					logger.String("model", modelName),
@@ -164,12 +217,24 @@ func (r *RetryableRequest) ExecuteWithRetry(
 		// 尝试获取并发槽位
 		sessionCache := r.Scheduler.GetSessionCache()
 		var acquired bool
+		var leaseID string
 		if sessionCache != nil {
 			concurrencyLimit := account.MaxConcurrency
 			if concurrencyLimit <= 0 {
 				concurrencyLimit = 5 // 默认值
 			}
-			acquired, _, err = sessionCache.AcquireConcurrencyWithLimit(ctx, account.ID, concurrencyLimit)
+			leaseID, acquired, _, err = sessionCache.AcquireConcurrencyWithLimit(ctx, account.ID, concurrencyLimit, r.leaseMeta(ctx, modelName))
+			if err == nil && !acquired && r.triedAccounts[account.ID] {
+				// 候选账户均已尝试过，在该账户队列中等待空闲槽位
+				leaseID, err = r.waitConcurrency(ctx, sessionCache, account, concurrencyLimit, modelName, deadline)
+				if ctx.Err() != nil {
+					return nil, contextErr(ctx)
+				}
+				acquired = err == nil
+				if cache.IsQueueRejected(err) {
+					err = nil
+				}
+			}
 			if err != nil {
 				log.WarnZ("获取并发槽位失败",
 					logger.Uint("account_id", account.ID),
@@ -187,18 +252,16 @@ func (r *RetryableRequest) ExecuteWithRetry(
 				)
 				// 标记该账户已尝试，选择下一个
 				r.triedAccounts[account.ID] = true
+				r.Scheduler.breakers.release(account.ID)
 				continue
 			}
 		}
 
-		// 确保释放并发槽位
-		releaseConcurrency := func() {
-			if sessionCache != nil && acquired {
-				sessionCache.ReleaseConcurrency(ctx, account.ID)
-			}
-		}
+		// 执行期间心跳续约，结束后按租约释放并发槽位
+		releaseConcurrency := r.holdLease(ctx, sessionCache, account.ID, leaseID)
 
 		// 记录开始执行
+		observeRetry(attempt, lastAccount, account, modelName)
 		execStart := time.Now()
 		log.InfoZ("开始执行请求",
 			logger.Int("attempt", attempt+1),
@@ -211,13 +274,24 @@ func (r *RetryableRequest) ExecuteWithRetry(
 			logger.Uint("api_key_id", r.APIKeyID),
 		)
 
-		// 执行请求
-		resp, err := execFunc(ctx, account)
+		// 执行请求（启用对冲时可能由另一账户先返回）
+		attemptCtx, span := startAttemptSpan(ctx, attempt, account, modelName, false)
+		primaryID := account.ID
+		var resp *adapter.Response
+		account, resp, err = r.executeHedged(attemptCtx, account, modelName, execFunc)
+		if account.ID != primaryID {
+			span.SetAttributes(tracing.Uint("hedge.winner_account_id", account.ID))
+		}
 
 		if err == nil && resp.Error == nil {
 			// 成功
 			releaseConcurrency()
 			r.Scheduler.MarkAccountSuccess(account.ID)
+			r.Scheduler.RecordAccountLatency(account.ID, time.Since(execStart))
+			r.Scheduler.hedges.observe(GetActualModel(modelName), time.Since(execStart))
+			observeAttempt(account, modelName, nil, time.Since(execStart))
+			span.End()
+			r.recordPrefixAffinity(account.ID)
 			log.InfoZ("代理请求成功",
 				logger.String("model", modelName),
 				logger.Uint("account_id", account.ID),
@@ -246,11 +320,21 @@ func (r *RetryableRequest) ExecuteWithRetry(
 		if err == nil && resp.Error != nil {
 			actualErr = errors.New(resp.Error.Message)
 		}
+		observeAttempt(account, modelName, actualErr, time.Since(execStart))
+		span.RecordError(actualErr)
+		span.End()
+
+		// 客户端断开或超出请求总时长：与账户健康无关，不标记账户也不再重试
+		if ctx.Err() != nil {
+			r.recordBreakerFailure(ctx, account, actualErr)
+			return nil, contextErr(ctx)
+		}
 
 		lastErr = actualErr
 		lastAccount = account
 		lastResp = resp
 		accountFailures[account.ID]++
+		r.recordBreakerFailure(ctx, account, actualErr)
 
 		log.WarnZ("请求失败，准备重试",
 			logger.Int("attempt", attempt+1),
@@ -266,10 +350,17 @@ func (r *RetryableRequest) ExecuteWithRetry(
 			logger.Duration("exec_duration", time.Since(execStart)),
 		)
 
+		// 上游明确返回限流恢复时间时立即标记，后续请求不再选中该账户
+		if upstreamResetAt(actualErr) != nil {
+			r.markAccountError(account, actualErr)
+		}
+
 		// 判断是否可以重试
 		if !r.isRetryable(actualErr) {
 			// 不可重试的错误，立即标记并返回
-			r.Scheduler.MarkAccountError(account.ID, account.Type, actualErr)
+			if upstreamResetAt(actualErr) == nil {
+				r.markAccountError(account, actualErr)
+			}
 			log.ErrorZ("代理请求失败-不可重试错误",
 				logger.String("model", modelName),
 				logger.Uint("account_id", account.ID),
@@ -290,20 +381,32 @@ func (r *RetryableRequest) ExecuteWithRetry(
 		// 如果有多个账户，标记当前账户已尝试，下次优先选其他账户
 		r.triedAccounts[account.ID] = true
 
-		// 如果不是最后一次尝试，等待后重试
+		// 如果不是最后一次尝试，等待后重试（超出重试预算时停止）
 		if attempt < r.Config.MaxRetries {
-			select {
-			case <-ctx.Done():
-				return nil, ctx.Err()
-			case <-time.After(delay):
-				delay = time.Duration(float64(delay) * r.Config.RetryBackoff)
+			if waitErr := r.backoff(ctx, &backoffs, actualErr, deadline); waitErr != nil {
+				if ctx.Err() != nil {
+					return nil, contextErr(ctx)
+				}
+				break
 			}
 		}
 	}
 
 	// 所有重试都失败，标记最后使用的账户错误
-	if lastAccount != nil && lastErr != nil {
-		r.Scheduler.MarkAccountError(lastAccount.ID, lastAccount.Type, lastErr)
+	if lastAccount != nil && lastErr != nil && upstreamResetAt(lastErr) == nil {
+		r.markAccountError(lastAccount, lastErr)
+	}
+
+	// 每次尝试都因账户并发已满而未执行
+	if lastAccount == nil {
+		log.ErrorZ("代理请求失败-账户并发已满",
+			logger.String("model", modelName),
+			logger.Uint("user_id", r.UserID),
+			logger.Uint("api_key_id", r.APIKeyID),
+			logger.String("client_ip", r.ClientIP),
+			logger.Duration("duration", time.Since(startTime)),
+		)
+		return nil, ErrAccountConcurrencyFull
 	}
 
 	log.ErrorZ("代理请求失败-重试耗尽",
@@ -315,7 +418,7 @@ func (r *RetryableRequest) ExecuteWithRetry(
 		logger.String("client_ip", r.ClientIP),
 		logger.String("error", lastErr.Error()),
 		logger.Duration("duration", time.Since(startTime)),
-		logger.Int("attempts", r.Config.MaxRetries+1),
+		logger.Int("attempts", attempts),
 	)
 
 	return &ExecuteResult{
@@ -324,6 +427,196 @@ func (r *RetryableRequest) ExecuteWithRetry(
 	}, lastErr
 }
 
+// retryDeadline 重试预算截止时间，未配置时返回零值
+func (r *RetryableRequest) retryDeadline(startTime time.Time) time.Time {
+	if r.Config.TotalTimeout <= 0 {
+		return time.Time{}
+	}
+	return startTime.Add(r.Config.TotalTimeout)
+}
+
+// budgetExhausted 重试预算是否已耗尽
+func (r *RetryableRequest) budgetExhausted(deadline time.Time) bool {
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+// backoffDelay 计算第 n 次退避的等待时间
+// 上游通过 Retry-After 等响应头给出不超过上限的等待时间时按其等待；
+// 否则按指数退避并加入随机抖动（超出上限的恢复时间由账户限流标记处理，不在本请求内等待）
+func (r *RetryableRequest) backoffDelay(n int, lastErr error) time.Duration {
+	maxDelay := r.Config.MaxRetryDelay
+	if hint := upstreamRetryAfter(lastErr); hint > 0 && (maxDelay <= 0 || hint <= maxDelay) {
+		return hint
+	}
+
+	delay := float64(r.Config.RetryDelay) * math.Pow(r.Config.RetryBackoff, float64(n))
+	if maxDelay > 0 && delay > float64(maxDelay) {
+		delay = float64(maxDelay)
+	}
+	if jitter := math.Min(r.Config.Jitter, 1); jitter > 0 {
+		delay -= delay * jitter * rand.Float64()
+	}
+	return time.Duration(delay)
+}
+
+// backoff 退避等待后返回 nil；ctx 取消时返回 ctx.Err()，等待将超出重试预算时立即返回 errRetryBudgetExhausted
+func (r *RetryableRequest) backoff(ctx context.Context, n *int, lastErr error, deadline time.Time) error {
+	delay := r.backoffDelay(*n, lastErr)
+	*n++
+	if !deadline.IsZero() && time.Now().Add(delay).After(deadline) {
+		return errRetryBudgetExhausted
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// totalTimeoutContext 派生请求总时长上下文，超出后取消进行中的尝试（context.Cause 为 ErrTotalTimeout）
+func (r *RetryableRequest) totalTimeoutContext(ctx context.Context, startTime time.Time) (context.Context, context.CancelFunc) {
+	if r.Config.TotalTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadlineCause(ctx, startTime.Add(r.Config.TotalTimeout), ErrTotalTimeout)
+}
+
+// streamTotalTimeoutContext 派生流式请求的总时长上下文
+// 调用返回的 started 后解除总时长限制：已开始输出的流式响应正常进行，不会被截断
+func (r *RetryableRequest) streamTotalTimeoutContext(ctx context.Context, startTime time.Time) (context.Context, func(), context.CancelFunc) {
+	if r.Config.TotalTimeout <= 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, func() {}, cancel
+	}
+	totalCtx, cancel := context.WithCancelCause(ctx)
+	timer := time.AfterFunc(time.Until(startTime.Add(r.Config.TotalTimeout)), func() { cancel(ErrTotalTimeout) })
+	return totalCtx, func() { timer.Stop() }, func() {
+		timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// contextErr 请求上下文结束的原因：超出总时长返回 ErrTotalTimeout，否则返回 ctx.Err()（如客户端断开）
+func contextErr(ctx context.Context) error {
+	if errors.Is(context.Cause(ctx), ErrTotalTimeout) {
+		return ErrTotalTimeout
+	}
+	return ctx.Err()
+}
+
+// withFirstByteTimeout 为非流式上游调用加上首字节超时，超时返回 ErrFirstByteTimeout
+// 非流式响应的首字节即响应头，上游通常在生成完成后才返回，因此使用单独的超时配置
+func (r *RetryableRequest) withFirstByteTimeout(
+	execFunc func(ctx context.Context, account *model.Account) (*adapter.Response, error),
+) func(ctx context.Context, account *model.Account) (*adapter.Response, error) {
+	if r.Config.NonStreamFirstByteTimeout <= 0 {
+		return execFunc
+	}
+	return func(ctx context.Context, account *model.Account) (*adapter.Response, error) {
+		attemptCtx, cancel := r.firstByteContext(ctx, r.Config.NonStreamFirstByteTimeout, func() {})
+		defer cancel()
+		resp, err := execFunc(attemptCtx, account)
+		if err != nil && errors.Is(context.Cause(attemptCtx), ErrFirstByteTimeout) {
+			err = ErrFirstByteTimeout
+		}
+		return resp, err
+	}
+}
+
+// firstByteContext 创建单次尝试的上下文：收到上游首字节时回调 onFirstByte，
+// 超过 timeout 仍未收到上游响应时取消本次尝试（timeout 为 0 不限制）
+func (r *RetryableRequest) firstByteContext(ctx context.Context, timeout time.Duration, onFirstByte func()) (context.Context, context.CancelFunc) {
+	attemptCtx, cancel := context.WithCancelCause(ctx)
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, func() { cancel(ErrFirstByteTimeout) })
+	}
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: func() {
+			if timer != nil {
+				timer.Stop()
+			}
+			onFirstByte()
+		},
+	}
+	return httptrace.WithClientTrace(attemptCtx, trace), func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		cancel(context.Canceled)
+	}
+}
+
+// startAttemptSpan 为单次尝试创建追踪 Span，标注账户与模型
+func startAttemptSpan(ctx context.Context, attempt int, account *model.Account, modelName string, stream bool) (context.Context, *tracing.Span) {
+	return tracing.Start(ctx, "scheduler.attempt", tracing.WithAttributes(
+		tracing.Int("attempt", attempt+1),
+		tracing.Uint("account.id", account.ID),
+		tracing.String("account.type", account.Type),
+		tracing.String("account.platform", account.Platform),
+		tracing.String("model", modelName),
+		tracing.Bool("stream", stream),
+	))
+}
+
+// leaseMeta 构建并发租约的请求信息
+func (r *RetryableRequest) leaseMeta(ctx context.Context, modelName string) cache.LeaseMeta {
+	return cache.LeaseMeta{
+		RequestID: logger.GetRequestID(ctx),
+		Model:     GetActualModel(modelName),
+	}
+}
+
+// waitConcurrency 排队等待账户并发槽位
+// 排队时间同样计入重试预算，预算耗尽按排队超时处理
+func (r *RetryableRequest) waitConcurrency(ctx context.Context, sessionCache *cache.SessionCache, account *model.Account, limit int, modelName string, deadline time.Time) (string, error) {
+	log := logger.GetLogger("scheduler")
+	waitStart := time.Now()
+
+	waitCtx := ctx
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	leaseID, _, err := sessionCache.AcquireConcurrencyWait(waitCtx, account.ID, limit, r.leaseMeta(ctx, modelName))
+	if err != nil && ctx.Err() == nil && waitCtx.Err() != nil {
+		err = cache.ErrQueueTimeout
+	}
+	if err != nil {
+		log.WarnZ("账户并发排队未获得槽位",
+			logger.Uint("account_id", account.ID),
+			logger.String("account_name", account.Name),
+			logger.Duration("wait", time.Since(waitStart)),
+			logger.Err(err),
+		)
+		return "", err
+	}
+	log.InfoZ("账户并发排队获得槽位",
+		logger.Uint("account_id", account.ID),
+		logger.String("account_name", account.Name),
+		logger.Duration("wait", time.Since(waitStart)),
+	)
+	return leaseID, nil
+}
+
+// holdLease 启动租约心跳，返回停止心跳并释放租约的函数
+// 获取槽位出错时 leaseID 为空，此时不释放任何槽位
+func (r *RetryableRequest) holdLease(ctx context.Context, sessionCache *cache.SessionCache, accountID uint, leaseID string) func() {
+	if sessionCache == nil || leaseID == "" {
+		return func() {}
+	}
+	stopHeartbeat := sessionCache.StartLeaseHeartbeat(ctx, cache.SlotScopeAccount, accountID, leaseID)
+	return func() {
+		stopHeartbeat()
+		sessionCache.ReleaseConcurrency(ctx, accountID, leaseID)
+	}
+}
+
 // StreamExecuteResult 流式执行结果
 type StreamExecuteResult struct {
 	Result    *adapter.StreamResult
@@ -341,7 +634,13 @@ func (r *RetryableRequest) ExecuteStreamWithRetry(
 	startTime := time.Now()
 	var lastErr error
 	var lastAccount *model.Account
-	delay := r.Config.RetryDelay
+	deadline := r.retryDeadline(startTime)
+	backoffs := 0
+	attempts := 0
+
+	// 请求总时长：开始向客户端输出前所有尝试、排队和退避等待共用同一截止时间
+	ctx, streamStarted, cancelTotal := r.streamTotalTimeoutContext(ctx, startTime)
+	defer cancelTotal()
 
 	// 记录每个账户的失败次数
 	accountFailures := make(map[uint]int)
@@ -357,18 +656,30 @@ func (r *RetryableRequest) ExecuteStreamWithRetry(
 	)
 
 	for attempt := 0; attempt <= r.Config.MaxRetries; attempt++ {
+		if ctx.Err() != nil {
+			return nil, contextErr(ctx)
+		}
+		if r.budgetExhausted(deadline) {
+			break
+		}
+		attempts = attempt + 1
+
 		// 选择账户（允许重试同一账户）
 		account, err := r.selectNextAccountAllowRetry(ctx, modelName, accountFailures)
 		if err != nil {
 			if errors.Is(err, ErrNoAvailableAccount) {
 				if attempt < r.Config.MaxRetries {
-					time.Sleep(delay)
-					delay = time.Duration(float64(delay) * r.Config.RetryBackoff)
-					continue
+					waitErr := r.backoff(ctx, &backoffs, lastErr, deadline)
+					if waitErr == nil {
+						continue
+					}
+					if ctx.Err() != nil {
+						return nil, contextErr(ctx)
+					}
 				}
 				// 所有重试都失败，标记最后使用的账户错误
-				if lastAccount != nil && lastErr != nil {
-					r.Scheduler.MarkAccountError(lastAccount.ID, lastAccount.Type, lastErr)
+				if lastAccount != nil && lastErr != nil && upstreamResetAt(lastErr) == nil {
+					r.markAccountError(lastAccount, lastErr)
 				}
 				log.ErrorZ("流式代理请求失败-无可用账户",
 					logger.String("model", modelName),
@@ -386,12 +697,24 @@ func (r *RetryableRequest) ExecuteStreamWithRetry(
 		// 尝试获取并发槽位
 		sessionCache := r.Scheduler.GetSessionCache()
 		var acquired bool
+		var leaseID string
 		if sessionCache != nil {
 			concurrencyLimit := account.MaxConcurrency
 			if concurrencyLimit <= 0 {
 				concurrencyLimit = 5 // 默认值
 			}
-			acquired, _, err = sessionCache.AcquireConcurrencyWithLimit(ctx, account.ID, concurrencyLimit)
+			leaseID, acquired, _, err = sessionCache.AcquireConcurrencyWithLimit(ctx, account.ID, concurrencyLimit, r.leaseMeta(ctx, modelName))
+			if err == nil && !acquired && r.triedAccounts[account.ID] {
+				// 候选账户均已尝试过，在该账户队列中等待空闲槽位
+				leaseID, err = r.waitConcurrency(ctx, sessionCache, account, concurrencyLimit, modelName, deadline)
+				if ctx.Err() != nil {
+					return nil, contextErr(ctx)
+				}
+				acquired = err == nil
+				if cache.IsQueueRejected(err) {
+					err = nil
+				}
+			}
 			if err != nil {
 				log.WarnZ("获取并发槽位失败",
 					logger.Uint("account_id", account.ID),
@@ -409,18 +732,16 @@ func (r *RetryableRequest) ExecuteStreamWithRetry(
 				)
 				// 标记该账户已尝试，选择下一个
 				r.triedAccounts[account.ID] = true
+				r.Scheduler.breakers.release(account.ID)
 				continue
 			}
 		}
 
-		// 确保释放并发槽位
-		releaseConcurrency := func() {
-			if sessionCache != nil && acquired {
-				sessionCache.ReleaseConcurrency(ctx, account.ID)
-			}
-		}
+		// 执行期间心跳续约，结束后按租约释放并发槽位
+		releaseConcurrency := r.holdLease(ctx, sessionCache, account.ID, leaseID)
 
 		// 记录开始执行
+		observeRetry(attempt, lastAccount, account, modelName)
 		execStart := time.Now()
 		log.InfoZ("开始执行流式请求",
 			logger.Int("attempt", attempt+1),
@@ -433,12 +754,35 @@ func (r *RetryableRequest) ExecuteStreamWithRetry(
 			logger.Uint("api_key_id", r.APIKeyID),
 		)
 
-		// 执行流式请求
-		result, err := execFunc(ctx, account, writer)
+		// 执行流式请求（统计写入字节数，用于判断失败时是否已向客户端输出）
+		tracked := &countingWriter{w: writer, onFirstWrite: streamStarted}
+		var firstByteAt atomic.Int64
+		spanCtx, span := startAttemptSpan(ctx, attempt, account, modelName, true)
+		attemptCtx, cancelAttempt := r.firstByteContext(spanCtx, r.Config.FirstByteTimeout, func() {
+			if firstByteAt.CompareAndSwap(0, time.Now().UnixNano()) {
+				span.AddEvent("first_byte")
+			}
+		})
+		result, err := execFunc(attemptCtx, account, tracked)
+		if err != nil && errors.Is(context.Cause(attemptCtx), ErrFirstByteTimeout) {
+			err = ErrFirstByteTimeout
+		}
+		cancelAttempt()
 
 		if err == nil {
 			releaseConcurrency()
 			r.Scheduler.MarkAccountSuccess(account.ID)
+			// 流式请求以首字节时间作为延迟（未观测到时退化为总耗时）
+			latency := time.Since(execStart)
+			if at := firstByteAt.Load(); at > 0 {
+				latency = time.Duration(at - execStart.UnixNano())
+				observeFirstByte(account, modelName, latency)
+				span.SetAttributes(tracing.Int64("first_byte_ms", latency.Milliseconds()))
+			}
+			observeAttempt(account, modelName, nil, time.Since(execStart))
+			span.End()
+			r.Scheduler.RecordAccountLatency(account.ID, latency)
+			r.recordPrefixAffinity(account.ID)
 			log.InfoZ("流式代理请求成功",
 				logger.String("model", modelName),
 				logger.Uint("account_id", account.ID),
@@ -465,9 +809,20 @@ func (r *RetryableRequest) ExecuteStreamWithRetry(
 		releaseConcurrency()
 
 		// 记录错误（但不立即标记账户状态）
+		observeAttempt(account, modelName, err, time.Since(execStart))
+		span.RecordError(err)
+		span.End()
+
+		// 客户端断开或超出请求总时长：与账户健康无关，不标记账户也不再重试
+		if ctx.Err() != nil {
+			r.recordBreakerFailure(ctx, account, err)
+			return nil, contextErr(ctx)
+		}
+
 		lastErr = err
 		lastAccount = account
 		accountFailures[account.ID]++
+		r.recordBreakerFailure(ctx, account, err)
 
 		log.WarnZ("流式请求失败，准备重试",
 			logger.Int("attempt", attempt+1),
@@ -483,11 +838,17 @@ func (r *RetryableRequest) ExecuteStreamWithRetry(
 			logger.Duration("exec_duration", time.Since(execStart)),
 		)
 
-		// 流式请求一旦开始就不应该重试（因为可能已经写入部分数据）
-		// 除非是在连接阶段就失败了
-		if !r.isConnectionError(err) {
+		if upstreamResetAt(err) != nil {
+			r.markAccountError(account, err)
+		}
+
+		// 已向客户端写入数据后不能重试（否则会把另一账户的响应拼接到已输出的部分之后）
+		// 只有尚未输出任何数据且为连接错误或可重试错误（429/5xx 等）时才切换账户
+		if tracked.Written() > 0 || !(r.isConnectionError(err) || r.isRetryable(err)) {
 			// 不可重试的错误，立即标记并返回
-			r.Scheduler.MarkAccountError(account.ID, account.Type, err)
+			if upstreamResetAt(err) == nil {
+				r.markAccountError(account, err)
+			}
 			log.ErrorZ("流式代理请求失败-不可重试错误",
 				logger.String("model", modelName),
 				logger.Uint("account_id", account.ID),
@@ -505,18 +866,30 @@ func (r *RetryableRequest) ExecuteStreamWithRetry(
 		r.triedAccounts[account.ID] = true
 
 		if attempt < r.Config.MaxRetries {
-			select {
-			case <-ctx.Done():
-				return nil, ctx.Err()
-			case <-time.After(delay):
-				delay = time.Duration(float64(delay) * r.Config.RetryBackoff)
+			if waitErr := r.backoff(ctx, &backoffs, err, deadline); waitErr != nil {
+				if ctx.Err() != nil {
+					return nil, contextErr(ctx)
+				}
+				break
 			}
 		}
 	}
 
 	// 所有重试都失败，标记最后使用的账户错误
-	if lastAccount != nil && lastErr != nil {
-		r.Scheduler.MarkAccountError(lastAccount.ID, lastAccount.Type, lastErr)
+	if lastAccount != nil && lastErr != nil && upstreamResetAt(lastErr) == nil {
+		r.markAccountError(lastAccount, lastErr)
+	}
+
+	// 每次尝试都因账户并发已满而未执行
+	if lastAccount == nil {
+		log.ErrorZ("流式代理请求失败-账户并发已满",
+			logger.String("model", modelName),
+			logger.Uint("user_id", r.UserID),
+			logger.Uint("api_key_id", r.APIKeyID),
+			logger.String("client_ip", r.ClientIP),
+			logger.Duration("duration", time.Since(startTime)),
+		)
+		return nil, ErrAccountConcurrencyFull
 	}
 
 	log.ErrorZ("流式代理请求失败-重试耗尽",
@@ -528,7 +901,7 @@ func (r *RetryableRequest) ExecuteStreamWithRetry(
 		logger.String("client_ip", r.ClientIP),
 		logger.String("error", lastErr.Error()),
 		logger.Duration("duration", time.Since(startTime)),
-		logger.Int("attempts", r.Config.MaxRetries+1),
+		logger.Int("attempts", attempts),
 	)
 
 	return nil, lastErr
@@ -577,15 +950,32 @@ func (r *RetryableRequest) selectNextAccount(ctx context.Context, modelName stri
 							// 账户有 ModelMapping 但不包含原始模型，移除绑定
 							log.Info("会话粘性账户 ModelMapping 不包含原始模型，移除绑定 - SessionID: %s, 账户ID: %d, 原始模型: %s, ModelMapping: %s",
 								r.SessionID, acc.ID, originalModel, acc.ModelMapping)
-							sessionCache.RemoveSessionBinding(ctx, r.SessionID)
+							unbindSession(ctx, sessionCache, binding)
 							sessionValid = false
 						}
 					}
 
+					if sessionValid && !r.Scheduler.inAccountGroups(r.getAccountGroups(), acc.ID) {
+						log.Info("会话粘性账户不在绑定分组内，移除绑定 - SessionID: %s, 账户ID: %d", r.SessionID, acc.ID)
+						unbindSession(ctx, sessionCache, binding)
+						sessionValid = false
+					}
+
+					if sessionValid && r.Scheduler.isBudgetExhausted(ctx, acc) {
+						log.Info("会话粘性账户当日预算已耗尽，移除绑定 - SessionID: %s, 账户ID: %d", r.SessionID, acc.ID)
+						unbindSession(ctx, sessionCache, binding)
+						sessionValid = false
+					}
+
 					if sessionValid && !r.Scheduler.isModelAllowed(acc, checkModel) {
 						log.Info("会话粘性账户不允许该模型，移除绑定 - SessionID: %s, 账户ID: %d, 检查模型: %s, AllowedModels: %s",
 							r.SessionID, acc.ID, checkModel, acc.AllowedModels)
-						sessionCache.RemoveSessionBinding(ctx, r.SessionID)
+						unbindSession(ctx, sessionCache, binding)
+						sessionValid = false
+					}
+
+					if sessionValid && !r.Scheduler.breakers.acquire(acc.ID) {
+						log.Info("会话粘性账户已熔断，本次改选其他账户 - SessionID: %s, 账户ID: %d", r.SessionID, acc.ID)
 						sessionValid = false
 					}
 
@@ -597,7 +987,7 @@ func (r *RetryableRequest) selectNextAccount(ctx context.Context, modelName stri
 				} else {
 					// 账户不可用，移除会话绑定并刷新调度器缓存
 					log.Info("会话粘性账户不可用，移除绑定并刷新缓存 - SessionID: %s, 账户ID: %d", r.SessionID, binding.AccountID)
-					sessionCache.RemoveSessionBinding(ctx, r.SessionID)
+					unbindSession(ctx, sessionCache, binding)
 					r.Scheduler.Refresh()
 				}
 			}
@@ -663,6 +1053,13 @@ func (r *RetryableRequest) selectNextAccount(ctx context.Context, modelName stri
 		return nil, ErrNoAvailableAccount
 	}
 
+	// 过滤当日预算已耗尽的账户
+	accounts = r.Scheduler.filterByBudget(ctx, accounts)
+	if len(accounts) == 0 {
+		log.Warn("无可用账户(每日预算已耗尽) - 模型: %s", actualModel)
+		return nil, ErrNoAvailableAccount
+	}
+
 	// 过滤掉已尝试的账户和非正常状态的账户
 	available := make([]*model.Account, 0, len(accounts))
 	for _, acc := range accounts {
@@ -685,6 +1082,11 @@ func (r *RetryableRequest) selectNextAccount(ctx context.Context, modelName stri
 				acc.ID, acc.Name, acc.Status, acc.LastError)
 			continue
 		}
+		// 跳过熔断中的账户
+		if !r.Scheduler.breakers.available(acc.ID) {
+			log.Debug("跳过熔断账户 - ID: %d, 名称: %s", acc.ID, acc.Name)
+			continue
+		}
 		// 如果配置了切换，跳过限流和过载的账户
 		if r.Config.SwitchOnRateLimit {
 			if acc.Status == model.AccountStatusRateLimited || acc.Status == model.AccountStatusOverloaded {
@@ -698,13 +1100,21 @@ func (r *RetryableRequest) selectNextAccount(ctx context.Context, modelName stri
 		available = append(available, acc)
 	}
 
-	if len(available) == 0 {
+	// 按绑定分组筛选（专属分组无可用账户时使用兜底分组）
+	available = r.Scheduler.routeByAccountGroups(r.getAccountGroups(), available)
+
+	// 【提示缓存亲和】优先选择最近服务过相同提示前缀的账户
+	selected := r.pickPrefixAffinity(available)
+	if selected != nil {
+		log.Info("提示前缀亲和命中 - 账户ID: %d, 名称: %s", selected.ID, selected.Name)
+	} else {
+		selected = r.Scheduler.selectWithBreaker(ctx, available, r.getAccountGroups())
+	}
+	if selected == nil {
 		log.Warn("没有可用账户 - 模型: %s, 总账户数: %d", modelName, len(accounts))
 		return nil, ErrNoAvailableAccount
 	}
 
-	selected := r.Scheduler.selectByWeight(available)
-
 	// 【会话粘性】绑定新选中的账户（到 Redis）
 	if r.SessionID != "" {
 		sessionCache := r.Scheduler.GetSessionCache()
@@ -719,8 +1129,9 @@ func (r *RetryableRequest) selectNextAccount(ctx context.Context, modelName stri
 				ClientIP:  r.ClientIP,
 				UserAgent: r.UserAgent,
 			}
-			sessionCache.SetSessionBinding(ctx, binding)
-			log.Info("会话粘性绑定 - SessionID: %s, 账户ID: %d, 名称: %s, UserID: %d", r.SessionID, selected.ID, selected.Name, r.UserID)
+			if bindSession(ctx, sessionCache, binding) {
+				log.Info("会话粘性绑定 - SessionID: %s, 账户ID: %d, 名称: %s, UserID: %d", r.SessionID, selected.ID, selected.Name, r.UserID)
+			}
 		}
 	}
 
@@ -772,15 +1183,32 @@ func (r *RetryableRequest) selectNextAccountAllowRetry(ctx context.Context, mode
 							// 账户有 ModelMapping 但不包含原始模型，移除绑定
 							log.Info("会话粘性账户 ModelMapping 不包含原始模型，移除绑定 - SessionID: %s, 账户ID: %d, 原始模型: %s, ModelMapping: %s",
 								r.SessionID, acc.ID, originalModel, acc.ModelMapping)
-							sessionCache.RemoveSessionBinding(ctx, r.SessionID)
+							unbindSession(ctx, sessionCache, binding)
 							sessionValid = false
 						}
 					}
 
+					if sessionValid && !r.Scheduler.inAccountGroups(r.getAccountGroups(), acc.ID) {
+						log.Info("会话粘性账户不在绑定分组内，移除绑定 - SessionID: %s, 账户ID: %d", r.SessionID, acc.ID)
+						unbindSession(ctx, sessionCache, binding)
+						sessionValid = false
+					}
+
+					if sessionValid && r.Scheduler.isBudgetExhausted(ctx, acc) {
+						log.Info("会话粘性账户当日预算已耗尽，移除绑定 - SessionID: %s, 账户ID: %d", r.SessionID, acc.ID)
+						unbindSession(ctx, sessionCache, binding)
+						sessionValid = false
+					}
+
 					if sessionValid && !r.Scheduler.isModelAllowed(acc, checkModel) {
 						log.Info("会话粘性账户不允许该模型，移除绑定 - SessionID: %s, 账户ID: %d, 检查模型: %s, AllowedModels: %s",
 							r.SessionID, acc.ID, checkModel, acc.AllowedModels)
-						sessionCache.RemoveSessionBinding(ctx, r.SessionID)
+						unbindSession(ctx, sessionCache, binding)
+						sessionValid = false
+					}
+
+					if sessionValid && !r.Scheduler.breakers.acquire(acc.ID) {
+						log.Info("会话粘性账户已熔断，本次改选其他账户 - SessionID: %s, 账户ID: %d", r.SessionID, acc.ID)
 						sessionValid = false
 					}
 
@@ -792,7 +1220,7 @@ func (r *RetryableRequest) selectNextAccountAllowRetry(ctx context.Context, mode
 				} else {
 					// 账户不可用，移除会话绑定并刷新调度器缓存
 					log.Info("会话粘性账户不可用，移除绑定并刷新缓存 - SessionID: %s, 账户ID: %d", r.SessionID, binding.AccountID)
-					sessionCache.RemoveSessionBinding(ctx, r.SessionID)
+					unbindSession(ctx, sessionCache, binding)
 					r.Scheduler.Refresh()
 				}
 			}
@@ -856,6 +1284,13 @@ func (r *RetryableRequest) selectNextAccountAllowRetry(ctx context.Context, mode
 		return nil, ErrNoAvailableAccount
 	}
 
+	// 过滤当日预算已耗尽的账户
+	accounts = r.Scheduler.filterByBudget(ctx, accounts)
+	if len(accounts) == 0 {
+		log.Warn("无可用账户(每日预算已耗尽) - 模型: %s", actualModel)
+		return nil, ErrNoAvailableAccount
+	}
+
 	// 第一轮：尝试找未尝试过的账户
 	available := make([]*model.Account, 0, len(accounts))
 	allValid := make([]*model.Account, 0, len(accounts)) // 所有有效账户（包括已尝试的）
@@ -872,6 +1307,10 @@ func (r *RetryableRequest) selectNextAccountAllowRetry(ctx context.Context, mode
 		if acc.Status == model.AccountStatusInvalid {
 			continue
 		}
+		// 跳过熔断中的账户
+		if !r.Scheduler.breakers.available(acc.ID) {
+			continue
+		}
 
 		// 收集所有有效账户
 		allValid = append(allValid, acc)
@@ -882,9 +1321,19 @@ func (r *RetryableRequest) selectNextAccountAllowRetry(ctx context.Context, mode
 		}
 	}
 
-	// 如果有未尝试的账户，优先选择
-	if len(available) > 0 {
-		selected := r.Scheduler.selectByWeight(available)
+	// 按绑定分组筛选（专属分组账户都已尝试时，优先尝试兜底分组）
+	groupIDs := r.getAccountGroups()
+	available = r.Scheduler.routeByAccountGroups(groupIDs, available)
+	allValid = r.Scheduler.routeByAccountGroups(groupIDs, allValid)
+
+	// 如果有未尝试的账户，优先选择（提示前缀亲和账户优先）
+	selected := r.pickPrefixAffinity(available)
+	if selected != nil {
+		log.Info("提示前缀亲和命中 - 账户ID: %d, 名称: %s", selected.ID, selected.Name)
+	} else {
+		selected = r.Scheduler.selectWithBreaker(ctx, available, groupIDs)
+	}
+	if selected != nil {
 
 		// 【会话粘性】绑定新选中的账户（到 Redis）
 		if r.SessionID != "" {
@@ -900,8 +1349,9 @@ func (r *RetryableRequest) selectNextAccountAllowRetry(ctx context.Context, mode
 					ClientIP:  r.ClientIP,
 					UserAgent: r.UserAgent,
 				}
-				sessionCache.SetSessionBinding(ctx, binding)
-				log.Info("会话粘性绑定 - SessionID: %s, 账户ID: %d, 名称: %s, UserID: %d", r.SessionID, selected.ID, selected.Name, r.UserID)
+				if bindSession(ctx, sessionCache, binding) {
+					log.Info("会话粘性绑定 - SessionID: %s, 账户ID: %d, 名称: %s, UserID: %d", r.SessionID, selected.ID, selected.Name, r.UserID)
+				}
 			}
 		}
 
@@ -911,32 +1361,133 @@ func (r *RetryableRequest) selectNextAccountAllowRetry(ctx context.Context, mode
 
 	// 如果所有账户都尝试过，但还有有效账户，允许重试（单账户场景）
 	if len(allValid) > 0 {
-		// 选择失败次数最少的账户
-		var minFailures = -1
-		var selected *model.Account
-		for _, acc := range allValid {
-			failures := accountFailures[acc.ID]
-			if minFailures < 0 || failures < minFailures {
-				minFailures = failures
-				selected = acc
+		// 选择失败次数最少的账户（熔断器半开探测名额已满的跳过）
+		sort.SliceStable(allValid, func(i, j int) bool {
+			return accountFailures[allValid[i].ID] < accountFailures[allValid[j].ID]
+		})
+		for _, selected := range allValid {
+			if r.Scheduler.breakers.acquire(selected.ID) {
+				log.Info("重试同一账户 - ID: %d, 名称: %s, 已失败次数: %d", selected.ID, selected.Name, accountFailures[selected.ID])
+				return selected, nil
 			}
 		}
-		if selected != nil {
-			log.Info("重试同一账户 - ID: %d, 名称: %s, 已失败次数: %d", selected.ID, selected.Name, minFailures)
-			return selected, nil
-		}
 	}
 
 	log.Warn("没有可用账户 - 模型: %s, 总账户数: %d", modelName, len(accounts))
 	return nil, ErrNoAvailableAccount
 }
 
+// getAccountGroups 获取请求绑定的账户分组（同一请求只解析一次）
+func (r *RetryableRequest) getAccountGroups() []uint {
+	if !r.groupsResolved {
+		r.accountGroupIDs = r.Scheduler.resolveAccountGroups(r.UserID, r.APIKeyID)
+		r.groupsResolved = true
+	}
+	return r.accountGroupIDs
+}
+
+// markAccountError 标记账户错误（交由错误规则匹配器决定状态，上游返回恢复时间时一并设置）
+func (r *RetryableRequest) markAccountError(account *model.Account, err error) {
+	r.Scheduler.MarkAccountErrorWithReset(account.ID, account.Type, err, upstreamResetAt(err))
+}
+
+// recordBreakerFailure 将失败计入账户熔断器
+// 客户端断开或请求本身的问题（4xx）与账户健康无关，只归还半开探测名额
+func (r *RetryableRequest) recordBreakerFailure(ctx context.Context, account *model.Account, err error) {
+	if ctx.Err() != nil || !r.isAccountFailure(err) {
+		r.Scheduler.breakers.release(account.ID)
+		return
+	}
+	reason := []rune(err.Error())
+	if len(reason) > 120 {
+		reason = append(reason[:120], []rune("...")...)
+	}
+	r.Scheduler.breakers.onFailure(account.ID, string(reason))
+}
+
+// isAccountFailure 错误是否反映账户/上游故障（认证失败、限流、5xx、超时、连接错误）
+func (r *RetryableRequest) isAccountFailure(err error) bool {
+	var upstreamErr *adapter.UpstreamError
+	if errors.As(err, &upstreamErr) {
+		code := upstreamErr.StatusCode
+		return code == http.StatusUnauthorized || code == http.StatusForbidden ||
+			code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+	}
+	return r.isRetryable(err) || r.isConnectionError(err)
+}
+
+// IsFallbackEligible 重试耗尽后的错误是否允许切换到回退模型
+// 无可用账户、并发已满、首字节超时及账户/上游故障时回退；客户端断开、超出请求总时长和请求本身的问题（4xx）不回退
+func IsFallbackEligible(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrTotalTimeout) {
+		return false
+	}
+	if errors.Is(err, ErrAllAccountsFailed) || errors.Is(err, ErrNoAvailableAccount) ||
+		errors.Is(err, ErrAccountConcurrencyFull) || errors.Is(err, ErrFirstByteTimeout) {
+		return true
+	}
+	r := &RetryableRequest{Config: DefaultRetryConfig}
+	return r.isAccountFailure(err)
+}
+
+// upstreamRetryAfter 获取上游错误携带的建议重试等待时间
+func upstreamRetryAfter(err error) time.Duration {
+	var upstreamErr *adapter.UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.RetryAfter
+	}
+	return 0
+}
+
+// upstreamResetAt 获取上游错误携带的限流恢复时间
+func upstreamResetAt(err error) *time.Time {
+	var upstreamErr *adapter.UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.ResetAt
+	}
+	return nil
+}
+
+// countingWriter 统计写入客户端的字节数（心跳可能并发写入，使用原子计数）
+type countingWriter struct {
+	w            io.Writer
+	n            atomic.Int64
+	onFirstWrite func() // 首次写入数据后回调（可选）
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	if n > 0 && cw.n.Add(int64(n)) == int64(n) && cw.onFirstWrite != nil {
+		cw.onFirstWrite()
+	}
+	return n, err
+}
+
+// Flush 实现 http.Flusher 接口（如果底层 writer 支持）
+func (cw *countingWriter) Flush() {
+	if f, ok := cw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Written 已写入的字节数
+func (cw *countingWriter) Written() int64 {
+	return cw.n.Load()
+}
+
 // isRetryable 判断错误是否可重试
 func (r *RetryableRequest) isRetryable(err error) bool {
 	if err == nil {
 		return false
 	}
 
+	// 上游限流和服务端错误可切换账户重试
+	var upstreamErr *adapter.UpstreamError
+	if errors.As(err, &upstreamErr) &&
+		(upstreamErr.StatusCode == http.StatusTooManyRequests || upstreamErr.StatusCode >= http.StatusInternalServerError) {
+		return true
+	}
+
 	errStr := strings.ToLower(err.Error())
 
 	for _, retryable := range r.Config.RetryableErrors {
@@ -990,72 +1541,3 @@ func (r *RetryableRequest) isConnectionError(err error) bool {
 
 	return false
 }
-
-// CircuitBreaker 熔断器
-type CircuitBreaker struct {
-	accountID    uint
-	failureCount int
-	lastFailure  time.Time
-	state        CircuitState
-
-	// 配置
-	FailureThreshold int           // 失败阈值
-	RecoveryTime     time.Duration // 恢复时间
-}
-
-type CircuitState int
-
-const (
-	CircuitClosed   CircuitState = iota // 正常
-	CircuitOpen                         // 熔断
-	CircuitHalfOpen                     // 半开
-)
-
-// NewCircuitBreaker 创建熔断器
-func NewCircuitBreaker(accountID uint) *CircuitBreaker {
-	return &CircuitBreaker{
-		accountID:        accountID,
-		state:            CircuitClosed,
-		FailureThreshold: 5,
-		RecoveryTime:     time.Minute * 5,
-	}
-}
-
-// Allow 检查是否允许请求
-func (cb *CircuitBreaker) Allow() bool {
-	switch cb.state {
-	case CircuitClosed:
-		return true
-	case CircuitOpen:
-		// 检查是否到了恢复时间
-		if time.Since(cb.lastFailure) > cb.RecoveryTime {
-			cb.state = CircuitHalfOpen
-			return true
-		}
-		return false
-	case CircuitHalfOpen:
-		return true
-	}
-	return true
-}
-
-// RecordSuccess 记录成功
-func (cb *CircuitBreaker) RecordSuccess() {
-	cb.failureCount = 0
-	cb.state = CircuitClosed
-}
-
-// RecordFailure 记录失败
-func (cb *CircuitBreaker) RecordFailure() {
-	cb.failureCount++
-	cb.lastFailure = time.Now()
-
-	if cb.failureCount >= cb.FailureThreshold {
-		cb.state = CircuitOpen
-	}
-}
-
-// GetState 获取状态
-func (cb *CircuitBreaker) GetState() CircuitState {
-	return cb.state
-}
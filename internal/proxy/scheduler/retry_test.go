@@ -0,0 +1,94 @@
+/*
+ * 文件作用：重试执行的追踪测试
+ * 负责功能：
+ *   - 单次尝试 Span 标注账户信息，上游 HTTP Span 为其子 Span
+ *   - 收到上游首字节时在尝试 Span 上记录 first_byte 事件
+ * 重要程度：⭐⭐ 辅助（测试）
+ * 依赖模块：tracing, model
+ */
+package scheduler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"cli-proxy/internal/model"
+	"cli-proxy/pkg/tracing"
+)
+
+func TestAttemptSpanFirstByte(t *testing.T) {
+	exporter := tracing.NewInMemoryExporter()
+	if err := tracing.Init(tracing.Options{SampleRatio: 1, Exporter: exporter}); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	defer tracing.Shutdown(context.Background())
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "data: {}\n\n")
+	}))
+	defer upstream.Close()
+	client := &http.Client{Transport: tracing.NewTransport(nil)}
+
+	r := &RetryableRequest{Config: RetryConfig{FirstByteTimeout: time.Minute}}
+	account := &model.Account{ID: 7, Type: "claude-official", Platform: model.PlatformClaude}
+
+	requestCtx, request := tracing.Start(context.Background(), "POST /v1/messages")
+	spanCtx, span := startAttemptSpan(requestCtx, 1, account, "claude-sonnet-4", true)
+	attemptCtx, cancel := r.firstByteContext(spanCtx, r.Config.FirstByteTimeout, func() {
+		span.AddEvent("first_byte")
+	})
+	req, _ := http.NewRequestWithContext(attemptCtx, http.MethodPost, upstream.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("upstream request: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	cancel()
+	span.End()
+	request.End()
+	tracing.Shutdown(context.Background())
+
+	spans := make(map[string]*tracing.SpanData)
+	for _, s := range exporter.Spans() {
+		spans[s.Name] = s
+	}
+	attempt, upstreamSpan := spans["scheduler.attempt"], spans["HTTP POST"]
+	if attempt == nil || upstreamSpan == nil {
+		t.Fatalf("exported spans = %v, want scheduler.attempt and HTTP POST", spans)
+	}
+	if attempt.ParentSpanID != spans["POST /v1/messages"].SpanID {
+		t.Errorf("attempt parent = %s, want request span", attempt.ParentSpanID)
+	}
+	if upstreamSpan.ParentSpanID != attempt.SpanID || upstreamSpan.TraceID != attempt.TraceID {
+		t.Errorf("upstream span parent = %s, want attempt span %s", upstreamSpan.ParentSpanID, attempt.SpanID)
+	}
+
+	want := map[string]interface{}{
+		"attempt":          int64(2),
+		"account.id":       int64(7),
+		"account.type":     "claude-official",
+		"account.platform": model.PlatformClaude,
+		"model":            "claude-sonnet-4",
+		"stream":           true,
+	}
+	for _, attr := range attempt.Attributes {
+		if value, ok := want[attr.Key]; ok {
+			if attr.Value != value {
+				t.Errorf("attribute %s = %v, want %v", attr.Key, attr.Value, value)
+			}
+			delete(want, attr.Key)
+		}
+	}
+	if len(want) > 0 {
+		t.Errorf("missing attempt attributes %v", want)
+	}
+
+	if len(attempt.Events) != 1 || attempt.Events[0].Name != "first_byte" {
+		t.Errorf("attempt events = %+v, want one first_byte event", attempt.Events)
+	}
+}
@@ -1,11 +1,16 @@
 /*
  * 文件作用：账户调度器，负责从多个AI平台账户中选择合适的账户处理请求
  * 负责功能：
- *   - 账户选择（按模型、按类型、按权重）
+ *   - 账户选择（按模型、按类型，选择策略见 strategy.go）
  *   - 会话粘性（同一会话路由到同一账户）
  *   - AllowedModels 过滤（账户可用模型限制）
  *   - ModelMapping 映射处理（模型名转换）
  *   - 账户状态管理（错误标记、限流恢复）
+ *   - 每日预算过滤（DailyBudget）
+ *   - 账户分组路由（专属分组/兜底分组）
+ *   - 账户熔断（滑动窗口错误率）
+ *   - 非流式请求对冲（见 hedge.go）
+ *   - 提示前缀亲和（见 prefix.go）
  *   - 定时恢复限流账户
  * 重要程度：⭐⭐⭐⭐⭐ 核心（代理转发的核心调度逻辑）
  * 依赖模块：cache, model, repository, adapter
@@ -43,6 +48,24 @@ type Scheduler struct {
 	// 内存中的账户缓存
 	accounts map[string][]*model.Account // platform -> accounts
 	lastSync time.Time
+
+	// 账户每日预算跟踪
+	budget budgetTracker
+
+	// 账户分组路由
+	groups *groupRouter
+
+	// 账户熔断器
+	breakers *breakerRegistry
+
+	// 账户选择策略
+	strategies *strategyRouter
+
+	// 非流式请求对冲统计
+	hedges *hedgeTracker
+
+	// 提示前缀亲和记录
+	prefixes *prefixAffinity
 }
 
 var defaultScheduler *Scheduler
@@ -55,6 +78,11 @@ func GetScheduler() *Scheduler {
 			repo:         repository.NewAccountRepository(),
 			sessionCache: cache.GetSessionCache(),
 			accounts:     make(map[string][]*model.Account),
+			groups:       newGroupRouter(),
+			breakers:     newBreakerRegistry(),
+			strategies:   newStrategyRouter(rand.New(rand.NewSource(time.Now().UnixNano()))),
+			hedges:       newHedgeTracker(),
+			prefixes:     newPrefixAffinity(),
 		}
 		// 初始加载
 		defaultScheduler.Refresh()
@@ -70,6 +98,26 @@ func (s *Scheduler) GetSessionCache() *cache.SessionCache {
 	return s.sessionCache
 }
 
+// bindSession 将会话绑定到新选中的账户，返回是否写入
+// 会话已被管理员固定时保留原绑定（固定账户暂不可用时仅本次请求改选其他账户）
+func bindSession(ctx context.Context, sessionCache *cache.SessionCache, binding *cache.SessionBinding) bool {
+	existing, err := sessionCache.GetSessionBinding(ctx, binding.SessionID)
+	if err == nil && existing != nil && existing.Pinned {
+		return false
+	}
+	sessionCache.SetSessionBinding(ctx, binding)
+	return true
+}
+
+// unbindSession 移除不再可用的会话绑定，固定会话保留绑定
+func unbindSession(ctx context.Context, sessionCache *cache.SessionCache, binding *cache.SessionBinding) {
+	if binding.Pinned {
+		logger.GetLogger("scheduler").Info("会话已固定，保留绑定 - SessionID: %s, 账户ID: %d", binding.SessionID, binding.AccountID)
+		return
+	}
+	sessionCache.RemoveSessionBinding(ctx, binding.SessionID)
+}
+
 // startRateLimitRecoveryTask 启动定时恢复限流账号的任务
 func (s *Scheduler) startRateLimitRecoveryTask() {
 	ticker := time.NewTicker(1 * time.Minute) // 每分钟检查一次
@@ -105,6 +153,12 @@ func (s *Scheduler) Refresh() error {
 		}
 	}
 
+	// 同步加载账户分组（分组成员变更后需要 Refresh 生效）
+	s.groups.load()
+
+	// 指标模型标签目录
+	metricModels.load()
+
 	s.lastSync = time.Now()
 	return nil
 }
@@ -122,6 +176,9 @@ func (s *Scheduler) SelectAccountWithSession(ctx context.Context, modelName stri
 		return nil, ErrUnsupportedModel
 	}
 
+	// 请求绑定的账户分组
+	groupIDs := s.resolveAccountGroups(userID, apiKeyID)
+
 	// 检查会话粘性（从 Redis）
 	if sessionID != "" && s.sessionCache != nil {
 		binding, err := s.sessionCache.GetSessionBinding(ctx, sessionID)
@@ -133,10 +190,10 @@ func (s *Scheduler) SelectAccountWithSession(ctx context.Context, modelName stri
 
 			for _, acc := range accounts {
 				if acc.ID == binding.AccountID && acc.Enabled && acc.Status == model.AccountStatusValid {
-					// 检查账户是否允许当前模型
-					if !s.isModelAllowed(acc, modelName) {
+					// 检查账户是否允许当前模型，以及当日预算是否耗尽
+					if !s.isModelAllowed(acc, modelName) || s.isBudgetExhausted(ctx, acc) || !s.inAccountGroups(groupIDs, acc.ID) {
 						// 模型不被允许，移除会话绑定，重新选择
-						s.sessionCache.RemoveSessionBinding(ctx, sessionID)
+						unbindSession(ctx, s.sessionCache, binding)
 						break
 					}
 					// 账户可用且模型允许，更新最后使用时间
@@ -145,7 +202,7 @@ func (s *Scheduler) SelectAccountWithSession(ctx context.Context, modelName stri
 				}
 			}
 			// 账户不可用或模型不允许，移除会话绑定
-			s.sessionCache.RemoveSessionBinding(ctx, sessionID)
+			unbindSession(ctx, s.sessionCache, binding)
 		}
 	}
 
@@ -168,12 +225,16 @@ func (s *Scheduler) SelectAccountWithSession(ctx context.Context, modelName stri
 
 	// 根据 AllowedModels 过滤账户
 	accounts = s.filterByAllowedModels(accounts, modelName)
+	// 过滤当日预算已耗尽的账户
+	accounts = s.filterByBudget(ctx, accounts)
+	// 按绑定分组筛选
+	accounts = s.routeByAccountGroups(groupIDs, accounts)
 	if len(accounts) == 0 {
 		return nil, ErrNoAvailableAccount
 	}
 
-	// 根据优先级和权重选择
-	account := s.selectByWeight(accounts)
+	// 按选择策略选择（默认按优先级和权重）
+	account := s.pickAccount(ctx, accounts, groupIDs)
 
 	// 绑定会话到 Redis
 	if sessionID != "" && s.sessionCache != nil && account != nil {
@@ -185,7 +246,7 @@ func (s *Scheduler) SelectAccountWithSession(ctx context.Context, modelName stri
 			UserID:    userID,
 			APIKeyID:  apiKeyID,
 		}
-		s.sessionCache.SetSessionBinding(ctx, binding)
+		bindSession(ctx, s.sessionCache, binding)
 	}
 
 	return account, nil
@@ -210,11 +271,13 @@ func (s *Scheduler) SelectAccountByType(ctx context.Context, accountType string,
 
 	// 根据 AllowedModels 过滤账户
 	accountPtrs = s.filterByAllowedModels(accountPtrs, modelName)
+	// 过滤当日预算已耗尽的账户
+	accountPtrs = s.filterByBudget(ctx, accountPtrs)
 	if len(accountPtrs) == 0 {
 		return nil, ErrNoAvailableAccount
 	}
 
-	return s.selectByWeight(accountPtrs), nil
+	return s.pickAccount(ctx, accountPtrs, nil), nil
 }
 
 // SelectAccountByTypesWithSession 根据多个账户类型选择（支持会话粘性）
@@ -243,6 +306,11 @@ func (s *Scheduler) SelectAccountByTypesWithSession(ctx context.Context, account
 
 	// 根据 AllowedModels 过滤账户
 	accountPtrs = s.filterByAllowedModels(accountPtrs, modelName)
+	// 过滤当日预算已耗尽的账户
+	accountPtrs = s.filterByBudget(ctx, accountPtrs)
+	// 按绑定分组筛选
+	groupIDs := s.resolveAccountGroups(userID, apiKeyID)
+	accountPtrs = s.routeByAccountGroups(groupIDs, accountPtrs)
 	if len(accountPtrs) == 0 {
 		return nil, ErrNoAvailableAccount
 	}
@@ -259,12 +327,12 @@ func (s *Scheduler) SelectAccountByTypesWithSession(ctx context.Context, account
 				}
 			}
 			// 账户不可用，移除会话绑定
-			s.sessionCache.RemoveSessionBinding(ctx, sessionID)
+			unbindSession(ctx, s.sessionCache, binding)
 		}
 	}
 
-	// 根据权重选择
-	account := s.selectByWeight(accountPtrs)
+	// 按选择策略选择
+	account := s.pickAccount(ctx, accountPtrs, groupIDs)
 
 	// 绑定会话到 Redis
 	if sessionID != "" && s.sessionCache != nil && account != nil {
@@ -276,8 +344,9 @@ func (s *Scheduler) SelectAccountByTypesWithSession(ctx context.Context, account
 			UserID:    userID,
 			APIKeyID:  apiKeyID,
 		}
-		s.sessionCache.SetSessionBinding(ctx, binding)
-		log.Info("会话粘性绑定 - SessionID: %s, 账户ID: %d, 名称: %s, UserID: %d", sessionID, account.ID, account.Name, userID)
+		if bindSession(ctx, s.sessionCache, binding) {
+			log.Info("会话粘性绑定 - SessionID: %s, 账户ID: %d, 名称: %s, UserID: %d", sessionID, account.ID, account.Name, userID)
+		}
 	}
 
 	return account, nil
@@ -306,6 +375,11 @@ func (s *Scheduler) SelectAccountByTypeWithSession(ctx context.Context, accountT
 
 	// 根据 AllowedModels 过滤账户
 	accountPtrs = s.filterByAllowedModels(accountPtrs, modelName)
+	// 过滤当日预算已耗尽的账户
+	accountPtrs = s.filterByBudget(ctx, accountPtrs)
+	// 按绑定分组筛选
+	groupIDs := s.resolveAccountGroups(userID, apiKeyID)
+	accountPtrs = s.routeByAccountGroups(groupIDs, accountPtrs)
 	if len(accountPtrs) == 0 {
 		return nil, ErrNoAvailableAccount
 	}
@@ -322,12 +396,12 @@ func (s *Scheduler) SelectAccountByTypeWithSession(ctx context.Context, accountT
 				}
 			}
 			// 账户不可用或类型不匹配，移除会话绑定
-			s.sessionCache.RemoveSessionBinding(ctx, sessionID)
+			unbindSession(ctx, s.sessionCache, binding)
 		}
 	}
 
-	// 根据权重选择
-	account := s.selectByWeight(accountPtrs)
+	// 按选择策略选择
+	account := s.pickAccount(ctx, accountPtrs, groupIDs)
 
 	// 绑定会话到 Redis
 	if sessionID != "" && s.sessionCache != nil && account != nil {
@@ -339,8 +413,9 @@ func (s *Scheduler) SelectAccountByTypeWithSession(ctx context.Context, accountT
 			UserID:    userID,
 			APIKeyID:  apiKeyID,
 		}
-		s.sessionCache.SetSessionBinding(ctx, binding)
-		log.Info("会话粘性绑定 - SessionID: %s, 账户ID: %d, 名称: %s, UserID: %d", sessionID, account.ID, account.Name, userID)
+		if bindSession(ctx, s.sessionCache, binding) {
+			log.Info("会话粘性绑定 - SessionID: %s, 账户ID: %d, 名称: %s, UserID: %d", sessionID, account.ID, account.Name, userID)
+		}
 	}
 
 	return account, nil
@@ -512,35 +587,6 @@ func getAccountMappedModel(acc *model.Account, originalModel string) string {
 	return ""
 }
 
-// selectByWeight 根据权重选择账户
-func (s *Scheduler) selectByWeight(accounts []*model.Account) *model.Account {
-	if len(accounts) == 1 {
-		return accounts[0]
-	}
-
-	// 计算总权重
-	totalWeight := 0
-	for _, acc := range accounts {
-		// 优先级 * 权重
-		totalWeight += acc.Priority * acc.Weight
-	}
-
-	if totalWeight == 0 {
-		return accounts[rand.Intn(len(accounts))]
-	}
-
-	// 随机选择
-	r := rand.Intn(totalWeight)
-	for _, acc := range accounts {
-		r -= acc.Priority * acc.Weight
-		if r < 0 {
-			return acc
-		}
-	}
-
-	return accounts[0]
-}
-
 // MarkAccountError 标记账户错误
 func (s *Scheduler) MarkAccountError(accountID uint, accountType string, err error) {
 	s.MarkAccountErrorWithReset(accountID, accountType, err, nil)
@@ -610,6 +656,7 @@ func (s *Scheduler) MarkAccountErrorWithReset(accountID uint, accountType string
 
 // MarkAccountSuccess 标记账户成功
 func (s *Scheduler) MarkAccountSuccess(accountID uint) {
+	s.breakers.onSuccess(accountID)
 	s.repo.IncrementRequestCount(accountID)
 	// 如果之前是错误状态，恢复正常
 	s.repo.UpdateStatus(accountID, model.AccountStatusValid, "")
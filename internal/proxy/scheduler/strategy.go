@@ -0,0 +1,353 @@
+/*
+ * 文件作用：账户选择策略，根据负载、延迟、错误率和用量余量从候选账户中选出一个
+ * 负责功能：
+ *   - 选择策略定义（加权随机 / 最少在途 / EWMA 延迟 / 二选一 / 余量优先）
+ *   - 策略路由（按分组 > 按平台 > 默认，运行时可调整）
+ *   - 账户延迟 EWMA 统计
+ *   - 纯函数选择实现（随机源与调度信号可注入，结果可复现）
+ * 重要程度：⭐⭐⭐⭐ 重要（决定请求在账户间的分布）
+ * 依赖模块：model
+ */
+package scheduler
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"cli-proxy/internal/model"
+)
+
+// SelectionStrategy 账户选择策略
+type SelectionStrategy string
+
+const (
+	StrategyWeightedRandom SelectionStrategy = "weighted_random" // 按 优先级*权重 随机（默认）
+	StrategyLeastInFlight  SelectionStrategy = "least_in_flight" // 在途请求占并发上限比例最低
+	StrategyEWMALatency    SelectionStrategy = "ewma_latency"    // 近期延迟（按错误率加权）最低
+	StrategyPowerOfTwo     SelectionStrategy = "power_of_two"    // 加权随机抽两个，取负载较低者
+	StrategyHeadroom       SelectionStrategy = "headroom"        // 5h/7d 用量余量最多者优先
+)
+
+// SelectionStrategies 所有可用策略
+var SelectionStrategies = []SelectionStrategy{
+	StrategyWeightedRandom,
+	StrategyLeastInFlight,
+	StrategyEWMALatency,
+	StrategyPowerOfTwo,
+	StrategyHeadroom,
+}
+
+// ParseSelectionStrategy 解析策略名称，未知名称返回 false
+func ParseSelectionStrategy(name string) (SelectionStrategy, bool) {
+	for _, strategy := range SelectionStrategies {
+		if string(strategy) == name {
+			return strategy, true
+		}
+	}
+	return "", false
+}
+
+// StrategyConfig 选择策略配置
+// 优先级：请求绑定分组的策略 > 平台策略 > 默认策略
+type StrategyConfig struct {
+	Default   SelectionStrategy
+	Platforms map[string]SelectionStrategy // platform -> 策略
+	Groups    map[uint]SelectionStrategy   // 账户分组ID -> 策略
+}
+
+// 延迟统计参数
+const (
+	latencyEWMAAlpha = 0.3              // 新样本权重
+	latencyStaleTTL  = 10 * time.Minute // 超过该时间无新样本视为无数据，重新探索
+)
+
+// errorRatePenalty 延迟评分的错误率惩罚系数（错误率 100% 时评分放大到 1+penalty 倍）
+const errorRatePenalty = 2.0
+
+// defaultAccountConcurrency 账户未设置并发上限时的默认值
+const defaultAccountConcurrency = 5
+
+// latencySample 账户延迟 EWMA
+type latencySample struct {
+	ewma      float64 // 毫秒
+	samples   int64
+	updatedAt time.Time
+}
+
+// strategyRouter 策略配置、延迟统计与随机源
+type strategyRouter struct {
+	mu      sync.RWMutex
+	config  StrategyConfig
+	latency map[uint]*latencySample
+
+	rngMu sync.Mutex // rand.Rand 非并发安全
+	rng   *rand.Rand
+}
+
+// newStrategyRouter 创建策略路由，rng 为选择使用的随机源（测试可传入固定种子）
+func newStrategyRouter(rng *rand.Rand) *strategyRouter {
+	return &strategyRouter{
+		config:  StrategyConfig{Default: StrategyWeightedRandom},
+		latency: make(map[uint]*latencySample),
+		rng:     rng,
+	}
+}
+
+// intn 从随机源取 [0, n) 的随机数
+func (r *strategyRouter) intn(n int) int {
+	r.rngMu.Lock()
+	defer r.rngMu.Unlock()
+	return r.rng.Intn(n)
+}
+
+// SetStrategyConfig 更新账户选择策略配置（对之后的选择生效）
+func (s *Scheduler) SetStrategyConfig(cfg StrategyConfig) {
+	if _, ok := ParseSelectionStrategy(string(cfg.Default)); !ok {
+		cfg.Default = StrategyWeightedRandom
+	}
+	s.strategies.mu.Lock()
+	s.strategies.config = cfg
+	s.strategies.mu.Unlock()
+}
+
+// GetStrategyConfig 获取账户选择策略配置
+func (s *Scheduler) GetStrategyConfig() StrategyConfig {
+	s.strategies.mu.RLock()
+	defer s.strategies.mu.RUnlock()
+	return s.strategies.config
+}
+
+// RecordAccountLatency 记录账户一次成功请求的延迟（流式为首字节时间）
+func (s *Scheduler) RecordAccountLatency(accountID uint, latency time.Duration) {
+	if latency <= 0 {
+		return
+	}
+	ms := float64(latency) / float64(time.Millisecond)
+	now := time.Now()
+
+	r := s.strategies
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sample, ok := r.latency[accountID]
+	if !ok || now.Sub(sample.updatedAt) > latencyStaleTTL {
+		r.latency[accountID] = &latencySample{ewma: ms, samples: 1, updatedAt: now}
+		return
+	}
+	sample.ewma = latencyEWMAAlpha*ms + (1-latencyEWMAAlpha)*sample.ewma
+	sample.samples++
+	sample.updatedAt = now
+}
+
+// accountLatency 账户当前延迟 EWMA，无数据或数据过期时返回 false
+func (r *strategyRouter) accountLatency(accountID uint, now time.Time) (time.Duration, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sample, ok := r.latency[accountID]
+	if !ok || now.Sub(sample.updatedAt) > latencyStaleTTL {
+		return 0, false
+	}
+	return time.Duration(sample.ewma * float64(time.Millisecond)), true
+}
+
+// strategyFor 解析本次选择使用的策略
+func (r *strategyRouter) strategyFor(platform string, groupIDs []uint) SelectionStrategy {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, groupID := range groupIDs {
+		if strategy, ok := r.config.Groups[groupID]; ok {
+			return strategy
+		}
+	}
+	if strategy, ok := r.config.Platforms[platform]; ok {
+		return strategy
+	}
+	return r.config.Default
+}
+
+// pickAccount 按策略从候选账户中选择一个
+func (s *Scheduler) pickAccount(ctx context.Context, accounts []*model.Account, groupIDs []uint) *model.Account {
+	if len(accounts) == 0 {
+		return nil
+	}
+	strategy := s.strategies.strategyFor(accounts[0].Platform, groupIDs)
+	return selectByStrategy(strategy, accounts, s.selectionSignals(ctx))
+}
+
+// selectionSignals 构建调度信号（在途数按需从并发缓存读取，同一次选择内复用）
+func (s *Scheduler) selectionSignals(ctx context.Context) selectionSignals {
+	now := time.Now()
+	inFlight := make(map[uint]int64)
+	return selectionSignals{
+		now:  now,
+		intn: s.strategies.intn,
+		inFlight: func(accountID uint) int64 {
+			if count, ok := inFlight[accountID]; ok {
+				return count
+			}
+			var count int64
+			if s.sessionCache != nil {
+				count, _ = s.sessionCache.GetAccountConcurrency(ctx, accountID)
+			}
+			inFlight[accountID] = count
+			return count
+		},
+		latency: func(accountID uint) (time.Duration, bool) {
+			return s.strategies.accountLatency(accountID, now)
+		},
+		errorRate: s.breakers.errorRate,
+	}
+}
+
+// ==================== 选择实现（纯函数） ====================
+
+// selectionSignals 选择所需的调度信号
+type selectionSignals struct {
+	now       time.Time
+	intn      func(n int) int                            // 随机源
+	inFlight  func(accountID uint) int64                 // 在途请求数
+	latency   func(accountID uint) (time.Duration, bool) // 延迟 EWMA
+	errorRate func(accountID uint) float64               // 近期错误率（0-1）
+}
+
+// selectByStrategy 按策略选择账户，未知策略按加权随机处理
+func selectByStrategy(strategy SelectionStrategy, accounts []*model.Account, sig selectionSignals) *model.Account {
+	if len(accounts) == 0 {
+		return nil
+	}
+	if len(accounts) == 1 {
+		return accounts[0]
+	}
+
+	switch strategy {
+	case StrategyLeastInFlight:
+		return selectMin(accounts, sig, func(acc *model.Account) float64 {
+			return accountLoad(acc, sig)
+		})
+	case StrategyEWMALatency:
+		return selectMin(accounts, sig, func(acc *model.Account) float64 {
+			return latencyScore(acc, sig)
+		})
+	case StrategyPowerOfTwo:
+		return selectPowerOfTwo(accounts, sig)
+	case StrategyHeadroom:
+		return selectMin(accounts, sig, func(acc *model.Account) float64 {
+			return -accountHeadroom(acc, sig.now)
+		})
+	default:
+		return weightedPick(accounts, sig.intn)
+	}
+}
+
+// selectMin 选择评分最低的账户，评分相同时按 优先级*权重 随机
+func selectMin(accounts []*model.Account, sig selectionSignals, score func(acc *model.Account) float64) *model.Account {
+	var best []*model.Account
+	var bestScore float64
+	for _, acc := range accounts {
+		current := score(acc)
+		switch {
+		case len(best) == 0 || current < bestScore:
+			best = append(best[:0], acc)
+			bestScore = current
+		case current == bestScore:
+			best = append(best, acc)
+		}
+	}
+	return weightedPick(best, sig.intn)
+}
+
+// selectPowerOfTwo 加权随机抽取两个不同账户，选负载较低者；负载相同时比较延迟
+func selectPowerOfTwo(accounts []*model.Account, sig selectionSignals) *model.Account {
+	first := weightedPick(accounts, sig.intn)
+	rest := make([]*model.Account, 0, len(accounts)-1)
+	for _, acc := range accounts {
+		if acc != first {
+			rest = append(rest, acc)
+		}
+	}
+	second := weightedPick(rest, sig.intn)
+
+	firstLoad, secondLoad := accountLoad(first, sig), accountLoad(second, sig)
+	if secondLoad < firstLoad {
+		return second
+	}
+	if secondLoad == firstLoad && latencyScore(second, sig) < latencyScore(first, sig) {
+		return second
+	}
+	return first
+}
+
+// weightedPick 按 优先级*权重 随机选择
+func weightedPick(accounts []*model.Account, intn func(n int) int) *model.Account {
+	if len(accounts) == 1 {
+		return accounts[0]
+	}
+
+	// 计算总权重
+	totalWeight := 0
+	for _, acc := range accounts {
+		// 优先级 * 权重
+		totalWeight += acc.Priority * acc.Weight
+	}
+
+	if totalWeight <= 0 {
+		return accounts[intn(len(accounts))]
+	}
+
+	// 随机选择
+	r := intn(totalWeight)
+	for _, acc := range accounts {
+		r -= acc.Priority * acc.Weight
+		if r < 0 {
+			return acc
+		}
+	}
+
+	return accounts[0]
+}
+
+// accountLoad 在途请求数占并发上限的比例
+func accountLoad(acc *model.Account, sig selectionSignals) float64 {
+	limit := acc.MaxConcurrency
+	if limit <= 0 {
+		limit = defaultAccountConcurrency
+	}
+	return float64(sig.inFlight(acc.ID)) / float64(limit)
+}
+
+// latencyScore 延迟评分（毫秒，按错误率放大）；无延迟数据时为 0，优先探索
+func latencyScore(acc *model.Account, sig selectionSignals) float64 {
+	latency, ok := sig.latency(acc.ID)
+	if !ok {
+		return 0
+	}
+	return float64(latency) / float64(time.Millisecond) * (1 + errorRatePenalty*sig.errorRate(acc.ID))
+}
+
+// accountHeadroom 用量余量百分比（0-100），取 5h/7d 窗口中较紧的一个
+// 未上报用量或窗口已重置视为余量充足
+func accountHeadroom(acc *model.Account, now time.Time) float64 {
+	used := 0.0
+	if u := windowUtilization(acc.FiveHourUtilization, acc.FiveHourResetsAt, now); u > used {
+		used = u
+	}
+	if u := windowUtilization(acc.SevenDayUtilization, acc.SevenDayResetsAt, now); u > used {
+		used = u
+	}
+	if used > 100 {
+		used = 100
+	}
+	return 100 - used
+}
+
+// windowUtilization 窗口用量，重置时间已过时返回 0
+func windowUtilization(utilization *float64, resetsAt *time.Time, now time.Time) float64 {
+	if utilization == nil {
+		return 0
+	}
+	if resetsAt != nil && !now.Before(*resetsAt) {
+		return 0
+	}
+	return *utilization
+}
@@ -0,0 +1,355 @@
+/*
+ * 文件作用：账户选择策略测试
+ * 负责功能：
+ *   - 各策略在给定随机序列与调度信号下的选择顺序
+ *   - 加权随机按 优先级*权重 的分布、固定种子结果可复现
+ *   - 运行时切换策略（默认 / 平台 / 分组）
+ * 重要程度：⭐⭐ 辅助（测试）
+ * 依赖模块：model
+ */
+package scheduler
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"cli-proxy/internal/model"
+)
+
+// testAccount 构造测试账户
+func testAccount(id uint, priority, weight int) *model.Account {
+	return &model.Account{ID: id, Platform: model.PlatformClaude, Priority: priority, Weight: weight, MaxConcurrency: 10}
+}
+
+// sequence 按顺序返回给定随机数的随机源，越界或用尽时测试失败
+func sequence(t *testing.T, values ...int) func(n int) int {
+	t.Helper()
+	i := 0
+	return func(n int) int {
+		if i >= len(values) {
+			t.Fatalf("random source exhausted after %d values", len(values))
+		}
+		v := values[i]
+		i++
+		if v < 0 || v >= n {
+			t.Fatalf("random value %d out of range [0, %d)", v, n)
+		}
+		return v
+	}
+}
+
+// testSignals 构造调度信号，未指定的在途数/延迟/错误率视为无数据
+func testSignals(intn func(n int) int, inFlight map[uint]int64, latency map[uint]time.Duration, errorRate map[uint]float64) selectionSignals {
+	return selectionSignals{
+		now:      time.Now(),
+		intn:     intn,
+		inFlight: func(accountID uint) int64 { return inFlight[accountID] },
+		latency: func(accountID uint) (time.Duration, bool) {
+			d, ok := latency[accountID]
+			return d, ok
+		},
+		errorRate: func(accountID uint) float64 { return errorRate[accountID] },
+	}
+}
+
+func pct(v float64) *float64 { return &v }
+
+func TestSelectByStrategyPickOrder(t *testing.T) {
+	// 权重 10/30/60，累计区间 [0,10) [10,40) [40,100)
+	a, b, c := testAccount(1, 1, 10), testAccount(2, 1, 30), testAccount(3, 1, 60)
+	accounts := []*model.Account{a, b, c}
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name      string
+		strategy  SelectionStrategy
+		accounts  []*model.Account
+		random    []int // 依次消耗的随机数，每次选择消耗的个数由策略决定
+		inFlight  map[uint]int64
+		latency   map[uint]time.Duration
+		errorRate map[uint]float64
+		want      []uint
+	}{
+		{
+			name:     "weighted random follows cumulative weights",
+			strategy: StrategyWeightedRandom,
+			accounts: accounts,
+			random:   []int{0, 9, 10, 39, 40, 99},
+			want:     []uint{1, 1, 2, 2, 3, 3},
+		},
+		{
+			name:     "unknown strategy falls back to weighted random",
+			strategy: SelectionStrategy("unknown"),
+			accounts: accounts,
+			random:   []int{5, 50},
+			want:     []uint{1, 3},
+		},
+		{
+			name:     "zero weights pick uniformly",
+			strategy: StrategyWeightedRandom,
+			accounts: []*model.Account{testAccount(1, 0, 100), testAccount(2, 50, 0)},
+			random:   []int{1, 0},
+			want:     []uint{2, 1},
+		},
+		{
+			name:     "least in flight picks lowest load ratio",
+			strategy: StrategyLeastInFlight,
+			accounts: []*model.Account{
+				{ID: 1, Priority: 1, Weight: 1, MaxConcurrency: 10},
+				{ID: 2, Priority: 1, Weight: 1, MaxConcurrency: 2},
+				{ID: 3, Priority: 1, Weight: 1}, // 未设置上限按默认 5 计算
+			},
+			inFlight: map[uint]int64{1: 3, 2: 1, 3: 1},
+			want:     []uint{3, 3},
+		},
+		{
+			name:     "least in flight breaks ties by weight",
+			strategy: StrategyLeastInFlight,
+			accounts: accounts,
+			inFlight: map[uint]int64{1: 1},
+			random:   []int{0, 29, 30},
+			want:     []uint{2, 2, 3},
+		},
+		{
+			name:     "ewma latency prefers accounts without samples",
+			strategy: StrategyEWMALatency,
+			accounts: accounts,
+			latency:  map[uint]time.Duration{1: 100 * time.Millisecond, 3: 50 * time.Millisecond},
+			want:     []uint{2},
+		},
+		{
+			name:     "ewma latency picks lowest latency",
+			strategy: StrategyEWMALatency,
+			accounts: accounts,
+			latency:  map[uint]time.Duration{1: 300 * time.Millisecond, 2: 200 * time.Millisecond, 3: 250 * time.Millisecond},
+			want:     []uint{2, 2},
+		},
+		{
+			name:      "ewma latency penalizes error rate",
+			strategy:  StrategyEWMALatency,
+			accounts:  accounts,
+			latency:   map[uint]time.Duration{1: 300 * time.Millisecond, 2: 200 * time.Millisecond, 3: 250 * time.Millisecond},
+			errorRate: map[uint]float64{2: 0.5}, // 200ms * (1+2*0.5) = 400ms
+			want:      []uint{3},
+		},
+		{
+			name:     "power of two keeps lower load of the two draws",
+			strategy: StrategyPowerOfTwo,
+			accounts: accounts,
+			// 第一次抽中 c（剩余 a/b 权重 10/30），第二次抽中 b
+			random:   []int{99, 35},
+			inFlight: map[uint]int64{3: 5, 2: 1},
+			want:     []uint{2},
+		},
+		{
+			name:     "power of two never compares an account with itself",
+			strategy: StrategyPowerOfTwo,
+			accounts: accounts,
+			// 第一次抽中 a（剩余 b/c 权重 30/60），第二次抽中 c；a 负载更低
+			random:   []int{0, 89},
+			inFlight: map[uint]int64{1: 2, 3: 4},
+			want:     []uint{1},
+		},
+		{
+			name:     "power of two breaks load ties by latency",
+			strategy: StrategyPowerOfTwo,
+			accounts: accounts,
+			random:   []int{10, 50},
+			latency:  map[uint]time.Duration{2: 400 * time.Millisecond, 3: 100 * time.Millisecond},
+			want:     []uint{3},
+		},
+		{
+			name:     "headroom picks the account with most remaining quota",
+			strategy: StrategyHeadroom,
+			accounts: []*model.Account{
+				{ID: 1, Priority: 1, Weight: 1, FiveHourUtilization: pct(20), SevenDayUtilization: pct(70)},
+				{ID: 2, Priority: 1, Weight: 1, FiveHourUtilization: pct(40)},
+				{ID: 3, Priority: 1, Weight: 1, FiveHourUtilization: pct(95), FiveHourResetsAt: &past}, // 窗口已重置
+			},
+			want: []uint{3},
+		},
+		{
+			name:     "headroom uses the tighter window",
+			strategy: StrategyHeadroom,
+			accounts: []*model.Account{
+				{ID: 1, Priority: 1, Weight: 1, FiveHourUtilization: pct(10), SevenDayUtilization: pct(90)},
+				{ID: 2, Priority: 1, Weight: 1, FiveHourUtilization: pct(50), SevenDayUtilization: pct(50)},
+			},
+			want: []uint{2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig := testSignals(sequence(t, tt.random...), tt.inFlight, tt.latency, tt.errorRate)
+			for i, wantID := range tt.want {
+				got := selectByStrategy(tt.strategy, tt.accounts, sig)
+				if got == nil || got.ID != wantID {
+					t.Fatalf("pick %d = %v, want account %d", i, got, wantID)
+				}
+			}
+		})
+	}
+}
+
+func TestWeightedRandomDistribution(t *testing.T) {
+	tests := []struct {
+		name     string
+		accounts []*model.Account
+		want     map[uint]float64 // 期望选中比例
+	}{
+		{
+			name:     "weights",
+			accounts: []*model.Account{testAccount(1, 1, 10), testAccount(2, 1, 30), testAccount(3, 1, 60)},
+			want:     map[uint]float64{1: 0.1, 2: 0.3, 3: 0.6},
+		},
+		{
+			name:     "priority multiplies weight",
+			accounts: []*model.Account{testAccount(1, 10, 100), testAccount(2, 30, 100)},
+			want:     map[uint]float64{1: 0.25, 2: 0.75},
+		},
+		{
+			name:     "zero weight is never picked",
+			accounts: []*model.Account{testAccount(1, 50, 0), testAccount(2, 50, 100)},
+			want:     map[uint]float64{1: 0, 2: 1},
+		},
+	}
+
+	const picks = 20000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(42))
+			sig := testSignals(rng.Intn, nil, nil, nil)
+			counts := make(map[uint]int)
+			for i := 0; i < picks; i++ {
+				counts[selectByStrategy(StrategyWeightedRandom, tt.accounts, sig).ID]++
+			}
+			for id, share := range tt.want {
+				got := float64(counts[id]) / picks
+				if math.Abs(got-share) > 0.02 {
+					t.Errorf("account %d picked %.3f of the time, want %.3f", id, got, share)
+				}
+			}
+		})
+	}
+}
+
+// newTestScheduler 创建使用固定种子随机源的调度器（不依赖数据库与缓存）
+func newTestScheduler(seed int64) *Scheduler {
+	return &Scheduler{
+		accounts:   make(map[string][]*model.Account),
+		groups:     newGroupRouter(),
+		breakers:   newBreakerRegistry(),
+		strategies: newStrategyRouter(rand.New(rand.NewSource(seed))),
+	}
+}
+
+func TestPickAccountSeededIsReproducible(t *testing.T) {
+	accounts := []*model.Account{testAccount(1, 1, 10), testAccount(2, 1, 30), testAccount(3, 1, 60)}
+	pickOrder := func(s *Scheduler) []uint {
+		order := make([]uint, 50)
+		for i := range order {
+			order[i] = s.pickAccount(context.Background(), accounts, nil).ID
+		}
+		return order
+	}
+
+	first, second := pickOrder(newTestScheduler(7)), pickOrder(newTestScheduler(7))
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("pick %d differs with the same seed: %d vs %d", i, first[i], second[i])
+		}
+	}
+}
+
+func TestSetStrategyConfigAtRuntime(t *testing.T) {
+	s := newTestScheduler(1)
+	a, b, c := testAccount(1, 1, 10), testAccount(2, 1, 30), testAccount(3, 1, 60)
+	accounts := []*model.Account{a, b, c}
+	// b 延迟最低；c 权重最高，加权随机下大多数情况选中 c
+	s.RecordAccountLatency(a.ID, 300*time.Millisecond)
+	s.RecordAccountLatency(b.ID, 100*time.Millisecond)
+	s.RecordAccountLatency(c.ID, 200*time.Millisecond)
+
+	steps := []struct {
+		name     string
+		config   StrategyConfig
+		groupIDs []uint
+		want     SelectionStrategy
+		wantID   uint // 非 0 时每次都应选中该账户
+	}{
+		{
+			name:   "default weighted random",
+			config: StrategyConfig{Default: StrategyWeightedRandom},
+			want:   StrategyWeightedRandom,
+		},
+		{
+			name:   "switch default to ewma latency",
+			config: StrategyConfig{Default: StrategyEWMALatency},
+			want:   StrategyEWMALatency,
+			wantID: b.ID,
+		},
+		{
+			name:   "invalid default falls back to weighted random",
+			config: StrategyConfig{Default: SelectionStrategy("bogus")},
+			want:   StrategyWeightedRandom,
+		},
+		{
+			name: "platform strategy overrides default",
+			config: StrategyConfig{
+				Default:   StrategyWeightedRandom,
+				Platforms: map[string]SelectionStrategy{model.PlatformClaude: StrategyEWMALatency},
+			},
+			want:   StrategyEWMALatency,
+			wantID: b.ID,
+		},
+		{
+			name: "group strategy overrides platform",
+			config: StrategyConfig{
+				Default:   StrategyEWMALatency,
+				Platforms: map[string]SelectionStrategy{model.PlatformClaude: StrategyEWMALatency},
+				Groups:    map[uint]SelectionStrategy{9: StrategyWeightedRandom},
+			},
+			groupIDs: []uint{9},
+			want:     StrategyWeightedRandom,
+		},
+		{
+			name: "unrelated group keeps platform strategy",
+			config: StrategyConfig{
+				Default:   StrategyWeightedRandom,
+				Platforms: map[string]SelectionStrategy{model.PlatformClaude: StrategyEWMALatency},
+				Groups:    map[uint]SelectionStrategy{9: StrategyWeightedRandom},
+			},
+			groupIDs: []uint{8},
+			want:     StrategyEWMALatency,
+			wantID:   b.ID,
+		},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			s.SetStrategyConfig(step.config)
+			if got := s.strategies.strategyFor(model.PlatformClaude, step.groupIDs); got != step.want {
+				t.Fatalf("strategy = %s, want %s", got, step.want)
+			}
+
+			seen := make(map[uint]int)
+			for i := 0; i < 200; i++ {
+				seen[s.pickAccount(context.Background(), accounts, step.groupIDs).ID]++
+			}
+			if step.wantID != 0 {
+				if seen[step.wantID] != 200 {
+					t.Errorf("picks = %v, want account %d every time", seen, step.wantID)
+				}
+				return
+			}
+			// 加权随机：三个账户都会被选中，且权重最高的 c 最多
+			if len(seen) != 3 || seen[c.ID] <= seen[b.ID] || seen[b.ID] <= seen[a.ID] {
+				t.Errorf("picks = %v, want weighted spread across all accounts", seen)
+			}
+		})
+	}
+}
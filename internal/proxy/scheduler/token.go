@@ -96,7 +96,9 @@ func (m *TokenManager) CheckAndRefreshToken(ctx context.Context, account *model.
 }
 
 // refreshClaudeOfficialToken 刷新 Claude Official Token
-func (m *TokenManager) refreshClaudeOfficialToken(ctx context.Context, account *model.Account) error {
+func (m *TokenManager) refreshClaudeOfficialToken(ctx context.Context, account *model.Account) (err error) {
+	defer func() { ObserveTokenRefresh(account.Type, err) }()
+
 	if account.RefreshToken == "" {
 		return fmt.Errorf("no refresh token available")
 	}
@@ -152,7 +154,9 @@ func (m *TokenManager) refreshClaudeOfficialToken(ctx context.Context, account *
 }
 
 // refreshGeminiToken 刷新 Gemini Token
-func (m *TokenManager) refreshGeminiToken(ctx context.Context, account *model.Account) error {
+func (m *TokenManager) refreshGeminiToken(ctx context.Context, account *model.Account) (err error) {
+	defer func() { ObserveTokenRefresh(account.Type, err) }()
+
 	if account.RefreshToken == "" {
 		return fmt.Errorf("no refresh token available")
 	}
@@ -7,7 +7,7 @@
  *   - 健康检查调度
  *   - 账户分组管理
  * 重要程度：⭐⭐⭐⭐⭐ 核心（账户核心仓库）
- * 依赖模块：model, gorm
+ * 依赖模块：model, gorm, encryption
  */
 package repository
 
@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"cli-proxy/internal/model"
+	"cli-proxy/pkg/encryption"
 
 	"gorm.io/gorm"
 )
@@ -305,12 +306,21 @@ func (r *AccountRepository) GetAllEnabled() ([]model.Account, error) {
 	return accounts, err
 }
 
+// UpdateToken 更新 OAuth Token（map 更新不经过字段序列化器，需显式加密）
 func (r *AccountRepository) UpdateToken(id uint, accessToken, refreshToken string, expiry *time.Time) error {
+	encryptedAccess, err := encryption.Encrypt(accessToken)
+	if err != nil {
+		return err
+	}
 	updates := map[string]interface{}{
-		"access_token": accessToken,
+		"access_token": encryptedAccess,
 	}
 	if refreshToken != "" {
-		updates["refresh_token"] = refreshToken
+		encryptedRefresh, err := encryption.Encrypt(refreshToken)
+		if err != nil {
+			return err
+		}
+		updates["refresh_token"] = encryptedRefresh
 	}
 	if expiry != nil {
 		updates["token_expiry"] = expiry
@@ -440,6 +450,24 @@ func (r *AccountGroupRepository) GetAccountsByGroup(groupID uint) ([]model.Accou
 	return accounts, err
 }
 
+// GetMemberships 获取所有分组的成员账户ID
+// 返回 map[groupID][]accountID
+func (r *AccountGroupRepository) GetMemberships() (map[uint][]uint, error) {
+	var rows []struct {
+		AccountGroupID uint
+		AccountID      uint
+	}
+	err := r.db.Table("account_group_members").Select("account_group_id, account_id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[uint][]uint)
+	for _, row := range rows {
+		result[row.AccountGroupID] = append(result[row.AccountGroupID], row.AccountID)
+	}
+	return result, nil
+}
+
 // ========== 健康检测相关方法 ==========
 
 // GetProblemAccounts 获取问题账号（需要健康检测的）
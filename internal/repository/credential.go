@@ -0,0 +1,190 @@
+/*
+ * 文件作用：上游凭证加密的密钥加载与存量数据迁移
+ * 负责功能：
+ *   - 从配置/环境变量加载主密钥并设置全局密钥环
+ *   - 存量明文凭证加密（一次性迁移）
+ *   - 主密钥轮换（用新主密钥重新包装数据密钥）
+ * 重要程度：⭐⭐⭐⭐ 重要（凭证存储安全）
+ * 依赖模块：config, encryption, gorm
+ */
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	"cli-proxy/internal/config"
+	"cli-proxy/pkg/encryption"
+)
+
+// credentialBatchSize 迁移时每批读取的行数
+const credentialBatchSize = 500
+
+// credentialColumns 加密存储的凭证列（与模型中 serializer:encrypted 字段一致）
+var credentialColumns = []struct {
+	table   string
+	columns []string
+}{
+	{"accounts", []string{"api_key", "api_secret", "access_token", "refresh_token", "session_key", "aws_secret_key", "aws_session_token"}},
+	{"proxies", []string{"password"}},
+}
+
+// InitCredentialEncryption 加载主密钥并启用凭证加密，未配置主密钥时返回 false（凭证以明文存储）
+func InitCredentialEncryption() (bool, error) {
+	cfg := config.Cfg.Credentials
+	primary, err := encryption.LoadKey(cfg.Key, cfg.KeyFile)
+	if err != nil {
+		return false, err
+	}
+
+	var previous [][]byte
+	for _, value := range cfg.PreviousKeys {
+		key, err := encryption.ParseKey(value)
+		if err != nil {
+			return false, fmt.Errorf("previous key: %w", err)
+		}
+		previous = append(previous, key)
+	}
+	for _, file := range cfg.PreviousKeyFiles {
+		key, err := encryption.LoadKey("", file)
+		if err != nil {
+			return false, fmt.Errorf("previous key file %s: %w", file, err)
+		}
+		if key != nil {
+			previous = append(previous, key)
+		}
+	}
+
+	if primary == nil {
+		if len(previous) > 0 {
+			return false, errors.New("previous credential keys configured without a primary key")
+		}
+		encryption.SetDefault(nil)
+		return false, nil
+	}
+
+	keyring, err := encryption.NewKeyring(primary, previous...)
+	if err != nil {
+		return false, err
+	}
+	encryption.SetDefault(keyring)
+	return true, nil
+}
+
+// CredentialRewrapResult 凭证加密/轮换结果
+type CredentialRewrapResult struct {
+	Rows    int `json:"rows"`    // 扫描行数
+	Updated int `json:"updated"` // 更新行数
+	Values  int `json:"values"`  // 加密或重新包装的字段数
+	Skipped int `json:"skipped"` // 迁移期间被并发修改而跳过的行数（重新执行即可）
+	Failed  int `json:"failed"`  // 无法解密的字段数（未知主密钥或数据损坏）
+}
+
+// RewrapCredentials 加密存量明文凭证；rotate 为 true 时同时将旧主密钥加密的值重新包装到当前主密钥
+// dryRun 为 true 时只统计不写入
+func RewrapCredentials(rotate, dryRun bool) (*CredentialRewrapResult, error) {
+	keyring := encryption.Default()
+	if keyring == nil {
+		return nil, encryption.ErrNoKey
+	}
+
+	result := &CredentialRewrapResult{}
+	for _, target := range credentialColumns {
+		if err := rewrapTable(keyring, target.table, target.columns, rotate, dryRun, result); err != nil {
+			return result, fmt.Errorf("%s: %w", target.table, err)
+		}
+	}
+	return result, nil
+}
+
+// rewrapTable 按主键分批处理一张表（包括软删除的行）
+func rewrapTable(keyring *encryption.Keyring, table string, columns []string, rotate, dryRun bool, result *CredentialRewrapResult) error {
+	var lastID uint64
+	for {
+		var rows []map[string]interface{}
+		err := DB.Table(table).
+			Select(append([]string{"id"}, columns...)).
+			Where("id > ?", lastID).
+			Order("id").
+			Limit(credentialBatchSize).
+			Find(&rows).Error
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+
+		for _, row := range rows {
+			id := toUint64(row["id"])
+			lastID = id
+			result.Rows++
+
+			updates := make(map[string]interface{})
+			query := DB.Table(table).Where("id = ?", id)
+			for _, column := range columns {
+				value := toString(row[column])
+				if value == "" || (!rotate && encryption.IsEncrypted(value)) {
+					continue
+				}
+				rewrapped, changed, err := keyring.Rewrap(value)
+				if err != nil {
+					result.Failed++
+					continue
+				}
+				if changed {
+					updates[column] = rewrapped
+					// 仅当值未被并发修改（如 Token 刷新）时才写入
+					query = query.Where(column+" = ?", value)
+				}
+			}
+			if len(updates) == 0 {
+				continue
+			}
+
+			if dryRun {
+				result.Updated++
+				result.Values += len(updates)
+				continue
+			}
+			res := query.Updates(updates)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				result.Skipped++
+				continue
+			}
+			result.Updated++
+			result.Values += len(updates)
+		}
+	}
+}
+
+func toUint64(v interface{}) uint64 {
+	switch n := v.(type) {
+	case int64:
+		return uint64(n)
+	case uint64:
+		return n
+	case int32:
+		return uint64(n)
+	case uint32:
+		return uint64(n)
+	case int:
+		return uint64(n)
+	case uint:
+		return uint64(n)
+	}
+	return 0
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	}
+	return ""
+}
@@ -46,6 +46,8 @@ func AutoMigrate() error {
 		&model.ModelMapping{},
 		// 邮箱验证码
 		&model.EmailVerification{},
+		// 会话绑定快照
+		&model.SessionBindingRecord{},
 	)
 }
 
@@ -11,6 +11,8 @@
 package repository
 
 import (
+	"time"
+
 	"cli-proxy/internal/model"
 
 	"gorm.io/gorm"
@@ -137,19 +139,48 @@ func (r *UserPackageRepository) ExhaustQuotas() (int64, error) {
 }
 
 // IncrementUsage 增加套餐使用量（原子操作）
-// 对于订阅类型：增加 daily_used, weekly_used, monthly_used
+// 对于订阅类型：按当前周期增加 daily_used, weekly_used, monthly_used（跨周期时先归零）
 // 对于额度类型：增加 quota_used
 func (r *UserPackageRepository) IncrementUsage(id uint, pkgType string, amount float64) error {
 	if pkgType == "subscription" {
-		return r.db.Model(&model.UserPackage{}).Where("id = ?", id).
-			Updates(map[string]interface{}{
-				"daily_used":   gorm.Expr("daily_used + ?", amount),
-				"weekly_used":  gorm.Expr("weekly_used + ?", amount),
-				"monthly_used": gorm.Expr("monthly_used + ?", amount),
-			}).Error
+		return r.AddPeriodUsage(id, amount, time.Now())
 	} else if pkgType == "quota" {
 		return r.db.Model(&model.UserPackage{}).Where("id = ?", id).
 			Update("quota_used", gorm.Expr("quota_used + ?", amount)).Error
 	}
 	return nil
 }
+
+// periodUsageSQL 按周期累加订阅用量：周期标识与 now 不一致的列先归零再累加
+// MySQL 按 SET 顺序求值，周期列必须在 last_reset_* 更新之前计算
+const periodUsageSQL = `UPDATE user_packages SET
+	daily_used   = CASE WHEN last_reset_day   = ? THEN daily_used   + ? ELSE ? END,
+	weekly_used  = CASE WHEN last_reset_week  = ? THEN weekly_used  + ? ELSE ? END,
+	monthly_used = CASE WHEN last_reset_month = ? THEN monthly_used + ? ELSE ? END,
+	last_reset_day = ?, last_reset_week = ?, last_reset_month = ?, updated_at = ?
+WHERE id = ? AND type = 'subscription' AND deleted_at IS NULL`
+
+// AddPeriodUsage 在 now 所在周期内增加订阅用量（单条 UPDATE，周期重置与累加不会相互覆盖）
+func (r *UserPackageRepository) AddPeriodUsage(id uint, amount float64, now time.Time) error {
+	day, week, month := model.UsagePeriods(now)
+	return r.db.Exec(periodUsageSQL,
+		day, amount, amount,
+		week, amount, amount,
+		month, amount, amount,
+		day, week, month, now,
+		id,
+	).Error
+}
+
+// ResetPeriodUsage 将订阅的周期用量重置到 now 所在周期（仅在周期变化时更新，已进入当前周期的用量保持不变）
+func (r *UserPackageRepository) ResetPeriodUsage(id uint, now time.Time) error {
+	day, week, month := model.UsagePeriods(now)
+	return r.db.Exec(periodUsageSQL+` AND (last_reset_day <> ? OR last_reset_week <> ? OR last_reset_month <> ?)`,
+		day, 0, 0,
+		week, 0, 0,
+		month, 0, 0,
+		day, week, month, now,
+		id,
+		day, week, month,
+	).Error
+}
@@ -30,6 +30,15 @@ func (r *RequestLogRepository) Create(log *model.RequestLog) error {
 	return r.db.Create(log).Error
 }
 
+// CountByAPIKeySince 统计 API Key 自某时刻起放行的请求次数（成功和失败请求均有日志）
+func (r *RequestLogRepository) CountByAPIKeySince(apiKeyID uint, since time.Time) (int64, error) {
+	var count int64
+	err := r.db.Model(&model.RequestLog{}).
+		Where("api_key_id = ? AND created_at >= ?", apiKeyID, since).
+		Count(&count).Error
+	return count, err
+}
+
 func (r *RequestLogRepository) List(page, pageSize int, filters map[string]interface{}) ([]model.RequestLog, int64, error) {
 	var logs []model.RequestLog
 	var total int64
@@ -190,3 +199,55 @@ func (r *RequestLogRepository) GetAccountsTotalCost(accountIDs []uint) (map[uint
 
 	return costMap, nil
 }
+
+// 提示缓存统计分组维度
+const (
+	CacheStatsByAccount = "account"
+	CacheStatsByAPIKey  = "api_key"
+	CacheStatsByModel   = "model"
+)
+
+// CacheUsageRow 按分组和模型汇总的缓存 Token 用量
+type CacheUsageRow struct {
+	GroupKey                 string `json:"group_key"`
+	GroupName                string `json:"group_name"`
+	Model                    string `json:"model"`
+	RequestCount             int64  `json:"request_count"`
+	InputTokens              int64  `json:"input_tokens"`
+	CacheCreationInputTokens int64  `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int64  `json:"cache_read_input_tokens"`
+}
+
+// GetCacheUsage 按分组维度（账户/API Key/模型）和模型汇总成功请求的缓存 Token 用量
+func (r *RequestLogRepository) GetCacheUsage(groupBy string, startTime, endTime time.Time) ([]CacheUsageRow, error) {
+	query := r.db.Model(&model.RequestLog{}).
+		Where("request_logs.created_at BETWEEN ? AND ?", startTime, endTime).
+		Where("request_logs.success = ?", true)
+
+	var groupKey, groupName string
+	switch groupBy {
+	case CacheStatsByAccount:
+		groupKey, groupName = "request_logs.account_id", "accounts.name"
+		query = query.Joins("LEFT JOIN accounts ON accounts.id = request_logs.account_id")
+	case CacheStatsByAPIKey:
+		groupKey, groupName = "request_logs.api_key_id", "api_keys.name"
+		query = query.Joins("LEFT JOIN api_keys ON api_keys.id = request_logs.api_key_id").
+			Where("request_logs.api_key_id IS NOT NULL")
+	default:
+		groupKey, groupName = "request_logs.model", "request_logs.model"
+	}
+
+	var rows []CacheUsageRow
+	err := query.Select(groupKey + ` as group_key,
+			COALESCE(MAX(` + groupName + `), '') as group_name,
+			request_logs.model as model,
+			COUNT(*) as request_count,
+			COALESCE(SUM(request_logs.input_tokens), 0) as input_tokens,
+			COALESCE(SUM(request_logs.cache_creation_input_tokens), 0) as cache_creation_input_tokens,
+			COALESCE(SUM(request_logs.cache_read_input_tokens), 0) as cache_read_input_tokens
+		`).
+		Group(groupKey + ", request_logs.model").
+		Scan(&rows).Error
+
+	return rows, err
+}
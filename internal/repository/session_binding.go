@@ -0,0 +1,47 @@
+/*
+ * 文件作用：会话绑定快照数据仓库
+ * 负责功能：
+ *   - 查询未过期（或已固定）的会话绑定快照
+ *   - 整体替换快照（事务内先清空后批量写入）
+ * 重要程度：⭐⭐ 辅助（会话粘性持久化）
+ * 依赖模块：model, gorm
+ */
+package repository
+
+import (
+	"time"
+
+	"cli-proxy/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// SessionBindingRepository 会话绑定快照数据访问层
+type SessionBindingRepository struct {
+	db *gorm.DB
+}
+
+// NewSessionBindingRepository 创建会话绑定快照仓库实例
+func NewSessionBindingRepository() *SessionBindingRepository {
+	return &SessionBindingRepository{db: DB}
+}
+
+// ListActive 获取指定时间仍未过期的会话绑定（固定会话不过期，始终返回）
+func (r *SessionBindingRepository) ListActive(now time.Time) ([]model.SessionBindingRecord, error) {
+	var records []model.SessionBindingRecord
+	err := r.db.Where("pinned = ? OR expire_at > ?", true, now).Find(&records).Error
+	return records, err
+}
+
+// ReplaceAll 用当前会话绑定整体替换快照
+func (r *SessionBindingRepository) ReplaceAll(records []model.SessionBindingRecord) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&model.SessionBindingRecord{}).Error; err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(records, 200).Error
+	})
+}
@@ -157,3 +157,13 @@ func (r *UsageRecordRepository) GetUserModelStats(userID uint) ([]model.ModelUsa
 
 	return stats, err
 }
+
+// SumCostByAPIKeySince 统计 API Key 自某时刻起的总费用
+func (r *UsageRecordRepository) SumCostByAPIKeySince(apiKeyID uint, since time.Time) (float64, error) {
+	var total float64
+	err := r.db.Model(&model.UsageRecord{}).
+		Select("COALESCE(SUM(total_cost), 0)").
+		Where("api_key_id = ? AND request_time >= ?", apiKeyID, since).
+		Scan(&total).Error
+	return total, err
+}
@@ -12,6 +12,9 @@ package service
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 
 	"cli-proxy/internal/model"
@@ -327,6 +330,8 @@ type CreateGroupRequest struct {
 	Description string `json:"description"`
 	Platform    string `json:"platform"`
 	IsDefault   bool   `json:"is_default"`
+	IsFallback  bool   `json:"is_fallback"`
+	IsExclusive bool   `json:"is_exclusive"`
 }
 
 type UpdateGroupRequest struct {
@@ -334,6 +339,8 @@ type UpdateGroupRequest struct {
 	Description string `json:"description"`
 	Platform    string `json:"platform"`
 	IsDefault   *bool  `json:"is_default"`
+	IsFallback  *bool  `json:"is_fallback"`
+	IsExclusive *bool  `json:"is_exclusive"`
 }
 
 func (s *AccountService) CreateGroup(req *CreateGroupRequest) (*model.AccountGroup, error) {
@@ -342,6 +349,8 @@ func (s *AccountService) CreateGroup(req *CreateGroupRequest) (*model.AccountGro
 		Description: req.Description,
 		Platform:    req.Platform,
 		IsDefault:   req.IsDefault,
+		IsFallback:  req.IsFallback,
+		IsExclusive: req.IsExclusive,
 	}
 
 	if err := s.groupRepo.Create(group); err != nil {
@@ -373,16 +382,29 @@ func (s *AccountService) UpdateGroup(id uint, req *UpdateGroupRequest) (*model.A
 	if req.IsDefault != nil {
 		group.IsDefault = *req.IsDefault
 	}
+	if req.IsFallback != nil {
+		group.IsFallback = *req.IsFallback
+	}
+	if req.IsExclusive != nil {
+		group.IsExclusive = *req.IsExclusive
+	}
 
 	if err := s.groupRepo.Update(group); err != nil {
 		return nil, err
 	}
 
+	// 刷新调度器分组缓存
+	scheduler.GetScheduler().Refresh()
+
 	return group, nil
 }
 
 func (s *AccountService) DeleteGroup(id uint) error {
-	return s.groupRepo.Delete(id)
+	if err := s.groupRepo.Delete(id); err != nil {
+		return err
+	}
+	scheduler.GetScheduler().Refresh()
+	return nil
 }
 
 func (s *AccountService) ListGroups(page, pageSize int) ([]model.AccountGroup, int64, error) {
@@ -400,9 +422,42 @@ func (s *AccountService) GetAllGroups() ([]model.AccountGroup, error) {
 }
 
 func (s *AccountService) AddAccountToGroup(groupID, accountID uint) error {
-	return s.groupRepo.AddAccount(groupID, accountID)
+	if err := s.groupRepo.AddAccount(groupID, accountID); err != nil {
+		return err
+	}
+	scheduler.GetScheduler().Refresh()
+	return nil
 }
 
 func (s *AccountService) RemoveAccountFromGroup(groupID, accountID uint) error {
-	return s.groupRepo.RemoveAccount(groupID, accountID)
+	if err := s.groupRepo.RemoveAccount(groupID, accountID); err != nil {
+		return err
+	}
+	scheduler.GetScheduler().Refresh()
+	return nil
+}
+
+// NormalizeAccountGroupIDs 校验并规范化逗号分隔的账户分组ID（用于 API Key / 用户 / 套餐绑定）
+func (s *AccountService) NormalizeAccountGroupIDs(value string) (string, error) {
+	var ids []string
+	seen := make(map[uint64]bool)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || id == 0 {
+			return "", fmt.Errorf("无效的账户分组ID: %s", part)
+		}
+		if seen[id] {
+			continue
+		}
+		if _, err := s.groupRepo.GetByID(uint(id)); err != nil {
+			return "", fmt.Errorf("账户分组不存在: %d", id)
+		}
+		seen[id] = true
+		ids = append(ids, strconv.FormatUint(id, 10))
+	}
+	return strings.Join(ids, ","), nil
 }
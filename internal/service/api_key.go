@@ -51,10 +51,12 @@ type CreateAPIKeyRequest struct {
 	UserPackageID    uint       `json:"user_package_id" binding:"required"` // 必须绑定用户套餐
 	AllowedPlatforms string     `json:"allowed_platforms"`
 	AllowedModels    string     `json:"allowed_models"`
+	BlockedModels    string     `json:"blocked_models"`
 	RateLimit        int        `json:"rate_limit"`
 	DailyLimit       int        `json:"daily_limit"`
 	MonthlyQuota     float64    `json:"monthly_quota"`
 	ExpiresAt        *time.Time `json:"expires_at"`
+	AccountGroupIDs  string     `json:"account_group_ids"` // 绑定的账户分组ID（仅管理员创建时生效）
 }
 
 // CreateAPIKeyResponse 创建 API Key 响应 (只在创建时返回完整 key)
@@ -116,6 +118,7 @@ func (s *APIKeyService) Create(userID uint, req *CreateAPIKeyRequest) (*CreateAP
 		UserPackageID:    &packageID,
 		AllowedPlatforms: allowedPlatforms,
 		AllowedModels:    req.AllowedModels,
+		BlockedModels:    req.BlockedModels,
 		RateLimit:        rateLimit,
 		DailyLimit:       req.DailyLimit,
 		MonthlyQuota:     req.MonthlyQuota,
@@ -221,6 +224,7 @@ type UpdateAPIKeyRequest struct {
 	Name             string     `json:"name"`
 	AllowedPlatforms string     `json:"allowed_platforms"`
 	AllowedModels    string     `json:"allowed_models"`
+	BlockedModels    string     `json:"blocked_models"`
 	RateLimit        int        `json:"rate_limit"`
 	DailyLimit       int        `json:"daily_limit"`
 	MonthlyQuota     float64    `json:"monthly_quota"`
@@ -249,6 +253,9 @@ func (s *APIKeyService) Update(id uint, userID uint, req *UpdateAPIKeyRequest) (
 	if req.AllowedModels != "" {
 		key.AllowedModels = req.AllowedModels
 	}
+	if req.BlockedModels != "" {
+		key.BlockedModels = req.BlockedModels
+	}
 	if req.RateLimit > 0 {
 		key.RateLimit = req.RateLimit
 	}
@@ -315,6 +322,11 @@ func (s *APIKeyService) AdminCreate(userID uint, req *CreateAPIKeyRequest) (*Cre
 		allowedPlatforms = req.AllowedPlatforms
 	}
 
+	accountGroupIDs, err := NewAccountService().NormalizeAccountGroupIDs(req.AccountGroupIDs)
+	if err != nil {
+		return nil, err
+	}
+
 	packageID := req.UserPackageID
 	apiKey := &model.APIKey{
 		UserID:           userID,
@@ -326,10 +338,12 @@ func (s *APIKeyService) AdminCreate(userID uint, req *CreateAPIKeyRequest) (*Cre
 		UserPackageID:    &packageID,
 		AllowedPlatforms: allowedPlatforms,
 		AllowedModels:    req.AllowedModels,
+		BlockedModels:    req.BlockedModels,
 		RateLimit:        rateLimit,
 		DailyLimit:       req.DailyLimit,
 		MonthlyQuota:     req.MonthlyQuota,
 		ExpiresAt:        req.ExpiresAt,
+		AccountGroupIDs:  accountGroupIDs,
 	}
 
 	if err := s.repo.Create(apiKey); err != nil {
@@ -383,6 +397,72 @@ func (s *APIKeyService) AdminToggleStatus(id uint) (*model.APIKey, error) {
 	return key, nil
 }
 
+// AdminUpdateAccountGroups 管理员设置 API Key 绑定的账户分组（空字符串表示解除绑定）
+func (s *APIKeyService) AdminUpdateAccountGroups(id uint, groupIDs string) (*model.APIKey, error) {
+	key, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	normalized, err := NewAccountService().NormalizeAccountGroupIDs(groupIDs)
+	if err != nil {
+		return nil, err
+	}
+	key.AccountGroupIDs = normalized
+
+	if err := s.repo.Update(key); err != nil {
+		getAPIKeyLog().Error("[apikey] 管理员设置账户分组失败 | KeyID: %d | 原因: %v", id, err)
+		return nil, err
+	}
+
+	getAPIKeyLog().Info("[apikey] 管理员设置账户分组成功 | KeyID: %d | Groups: %s", id, normalized)
+	return key, nil
+}
+
+// AdminUpdateHedge 管理员设置 API Key 是否启用非流式请求对冲
+func (s *APIKeyService) AdminUpdateHedge(id uint, enabled bool) (*model.APIKey, error) {
+	key, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	key.HedgeEnabled = enabled
+	if err := s.repo.Update(key); err != nil {
+		getAPIKeyLog().Error("[apikey] 管理员设置请求对冲失败 | KeyID: %d | 原因: %v", id, err)
+		return nil, err
+	}
+
+	getAPIKeyLog().Info("[apikey] 管理员设置请求对冲成功 | KeyID: %d | Enabled: %v", id, enabled)
+	return key, nil
+}
+
+// maxBodyCaptureDebug API Key 调试记录最长有效期
+const maxBodyCaptureDebug = 7 * 24 * time.Hour
+
+// AdminUpdateBodyCapture 管理员设置 API Key 调试记录请求体/响应体的有效期，duration 为 0 表示关闭
+func (s *APIKeyService) AdminUpdateBodyCapture(id uint, duration time.Duration) (*model.APIKey, error) {
+	if duration < 0 || duration > maxBodyCaptureDebug {
+		return nil, errors.New("调试记录有效期需在 0 到 7 天之间")
+	}
+	key, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	key.BodyCaptureUntil = nil
+	if duration > 0 {
+		until := time.Now().Add(duration)
+		key.BodyCaptureUntil = &until
+	}
+	if err := s.repo.Update(key); err != nil {
+		getAPIKeyLog().Error("[apikey] 管理员设置调试记录失败 | KeyID: %d | 原因: %v", id, err)
+		return nil, err
+	}
+
+	getAPIKeyLog().Info("[apikey] 管理员设置调试记录成功 | KeyID: %d | Duration: %v", id, duration)
+	return key, nil
+}
+
 // AdminListAll 管理员获取所有 API Key（带用户信息）
 func (s *APIKeyService) AdminListAll(page, pageSize int) ([]model.APIKey, int64, error) {
 	return s.repo.ListAllWithUser(page, pageSize)
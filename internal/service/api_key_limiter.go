@@ -0,0 +1,265 @@
+/*
+ * 文件作用：API Key 限流服务，执行 API Key 上配置的频率、日请求和月额度限制
+ * 负责功能：
+ *   - 每分钟滑动窗口请求限制（RateLimit）
+ *   - 每日请求次数限制（DailyLimit）
+ *   - 月度费用额度检查（MonthlyQuota，按该 API Key 本月的使用记录统计）
+ *   - 计数保存在共享缓存后端（Redis 后端下多实例共用同一份计数）
+ * 重要程度：⭐⭐⭐⭐ 重要（代理请求限流）
+ * 依赖模块：repository, model, cache
+ */
+package service
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"cli-proxy/internal/cache"
+	"cli-proxy/internal/model"
+	"cli-proxy/internal/repository"
+	"cli-proxy/pkg/logger"
+)
+
+// 限流拒绝原因
+const (
+	APIKeyLimitRate    = "rate"
+	APIKeyLimitDaily   = "daily"
+	APIKeyLimitMonthly = "monthly"
+)
+
+const (
+	apiKeyRateWindow       = time.Minute
+	apiKeyMonthlyCostCache = 30 * time.Second
+	// 日计数在次日零点后多保留一段时间，避免实例间时钟偏差提前丢失计数
+	apiKeyDailyCounterGrace = time.Hour
+)
+
+// APIKeyLimitResult 限流检查结果
+type APIKeyLimitResult struct {
+	Allowed    bool
+	Reason     string        // 拒绝原因: rate / daily / monthly
+	Limit      int           // 每分钟请求上限（0=不限）
+	Remaining  int           // 当前窗口剩余请求数
+	ResetAfter time.Duration // 当前窗口重置剩余时间
+	RetryAfter time.Duration // 被拒绝时建议的重试等待时间
+
+	DailyLimit     int     // 每日请求上限（0=不限）
+	DailyRemaining int     // 今日剩余请求数
+	MonthlyQuota   float64 // 月额度（0=不限）
+	MonthlyUsed    float64 // 本月已用费用
+}
+
+// apiKeyUsageSource API Key 历史用量查询（日计数恢复与月度费用）
+type apiKeyUsageSource interface {
+	// CountRequestsSince 请求日志条数（成功和失败均记录）
+	CountRequestsSince(apiKeyID uint, since time.Time) (int64, error)
+	// SumCostSince 使用记录费用合计
+	SumCostSince(apiKeyID uint, since time.Time) (float64, error)
+}
+
+// repositoryAPIKeyUsage 从请求日志和使用记录查询历史用量
+type repositoryAPIKeyUsage struct {
+	usageRecordRepo *repository.UsageRecordRepository
+	requestLogRepo  *repository.RequestLogRepository
+}
+
+func (r *repositoryAPIKeyUsage) CountRequestsSince(apiKeyID uint, since time.Time) (int64, error) {
+	return r.requestLogRepo.CountByAPIKeySince(apiKeyID, since)
+}
+
+// SumCostSince 按 usage_records 统计：daily_usage 按用户/日期/模型汇总，没有 API Key 维度
+func (r *repositoryAPIKeyUsage) SumCostSince(apiKeyID uint, since time.Time) (float64, error) {
+	return r.usageRecordRepo.SumCostByAPIKeySince(apiKeyID, since)
+}
+
+// APIKeyLimiter API Key 限流器
+type APIKeyLimiter struct {
+	counters cache.Counters // 滑动窗口、日计数和月度费用缓存（多实例共享）
+	usage    apiKeyUsageSource
+	log      *logger.Logger
+
+	mu         sync.Mutex
+	seededDate string        // seeded 对应的日期
+	seeded     map[uint]bool // 本实例当日已从请求日志恢复日计数的 API Key
+}
+
+var (
+	apiKeyLimiter     *APIKeyLimiter
+	apiKeyLimiterOnce sync.Once
+)
+
+// GetAPIKeyLimiter 获取 API Key 限流器单例
+func GetAPIKeyLimiter() *APIKeyLimiter {
+	apiKeyLimiterOnce.Do(func() {
+		apiKeyLimiter = &APIKeyLimiter{
+			counters: cache.GetSessionCache().Counters(),
+			usage: &repositoryAPIKeyUsage{
+				usageRecordRepo: repository.NewUsageRecordRepository(),
+				requestLogRepo:  repository.NewRequestLogRepository(),
+			},
+			log: logger.GetLogger("api_key_limiter"),
+		}
+	})
+	return apiKeyLimiter
+}
+
+// Check 检查 API Key 是否允许本次请求，允许时计入窗口和日计数
+// 缓存后端不可用时跳过对应限制，不阻止请求
+func (l *APIKeyLimiter) Check(ctx context.Context, key *model.APIKey) *APIKeyLimitResult {
+	now := time.Now()
+	result := &APIKeyLimitResult{
+		Allowed:      true,
+		Limit:        key.RateLimit,
+		DailyLimit:   key.DailyLimit,
+		MonthlyQuota: key.MonthlyQuota,
+	}
+
+	// 1. 月额度（只读检查，放在计数之前，避免被拒绝的请求占用窗口）
+	if key.MonthlyQuota > 0 {
+		used := l.getMonthlyCost(ctx, key.ID, now)
+		result.MonthlyUsed = used
+		if used >= key.MonthlyQuota {
+			result.Allowed = false
+			result.Reason = APIKeyLimitMonthly
+			result.RetryAfter = nextMonth(now).Sub(now)
+			return result
+		}
+	}
+
+	// 2. 每分钟滑动窗口
+	windowKey, hitID := apiKeyRateKey(key.ID), ""
+	if key.RateLimit > 0 {
+		id := newCounterID()
+		ok, count, resetAfter, err := l.counters.HitWindow(ctx, windowKey, id, key.RateLimit, apiKeyRateWindow)
+		if err != nil {
+			l.log.Warn("API Key 频率计数失败: keyID=%d, error=%v", key.ID, err)
+			result.Remaining = key.RateLimit
+		} else {
+			result.Remaining = key.RateLimit - int(count)
+			result.ResetAfter = resetAfter
+			if !ok {
+				result.Allowed = false
+				result.Reason = APIKeyLimitRate
+				result.Remaining = 0
+				result.RetryAfter = resetAfter
+				return result
+			}
+			hitID = id
+		}
+	}
+
+	// 3. 每日请求次数
+	if key.DailyLimit > 0 {
+		dailyKey := apiKeyDailyKey(key.ID, now)
+		ttl := startOfDay(now).Add(24*time.Hour).Sub(now) + apiKeyDailyCounterGrace
+		l.seedDailyCounter(ctx, key.ID, dailyKey, now, ttl)
+
+		ok, count, err := l.counters.IncrCounter(ctx, dailyKey, int64(key.DailyLimit), ttl)
+		if err != nil {
+			l.log.Warn("API Key 日请求计数失败: keyID=%d, error=%v", key.ID, err)
+			result.DailyRemaining = key.DailyLimit
+		} else {
+			result.DailyRemaining = key.DailyLimit - int(count)
+			if !ok {
+				// 被拒绝的请求不占用频率窗口
+				if hitID != "" {
+					l.counters.RemoveWindowHit(ctx, windowKey, hitID)
+				}
+				result.Allowed = false
+				result.Reason = APIKeyLimitDaily
+				result.DailyRemaining = 0
+				result.RetryAfter = startOfDay(now).Add(24 * time.Hour).Sub(now)
+				return result
+			}
+		}
+	}
+
+	return result
+}
+
+// seedDailyCounter 当日计数不存在时从请求日志恢复（服务重启或缓存清空后不重新计数）
+// 每个实例每天只查询一次；其他实例已写入计数时初始化不生效
+// 计数的是放行的请求，因此从请求日志（成功和失败均记录）而不是使用记录恢复
+func (l *APIKeyLimiter) seedDailyCounter(ctx context.Context, apiKeyID uint, dailyKey string, now time.Time, ttl time.Duration) {
+	today := now.Format("2006-01-02")
+
+	l.mu.Lock()
+	if l.seededDate != today {
+		l.seededDate, l.seeded = today, make(map[uint]bool)
+	}
+	done := l.seeded[apiKeyID]
+	l.mu.Unlock()
+	if done {
+		return
+	}
+
+	count, err := l.usage.CountRequestsSince(apiKeyID, startOfDay(now))
+	if err != nil {
+		return
+	}
+	if _, err := l.counters.InitCounter(ctx, dailyKey, count, ttl); err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	if l.seededDate == today {
+		l.seeded[apiKeyID] = true
+	}
+	l.mu.Unlock()
+}
+
+// Reset 重置某个 API Key 的频率窗口和当日计数
+func (l *APIKeyLimiter) Reset(apiKeyID uint) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := l.counters.ResetCounters(ctx, apiKeyRateKey(apiKeyID), apiKeyDailyKey(apiKeyID, time.Now())); err != nil {
+		l.log.Warn("重置 API Key 计数失败: keyID=%d, error=%v", apiKeyID, err)
+	}
+}
+
+// getMonthlyCost 获取 API Key 本月费用（共享短时间缓存，避免每个请求查库）
+// 只统计该 Key 自身的使用记录，同一用户其他 Key 的消费不占用本 Key 的额度
+func (l *APIKeyLimiter) getMonthlyCost(ctx context.Context, apiKeyID uint, now time.Time) float64 {
+	cacheKey := "api_key_monthly_cost:" + strconv.FormatUint(uint64(apiKeyID), 10) + ":" + now.Format("2006-01")
+	if cost, found, err := l.counters.CachedValue(ctx, cacheKey); err == nil && found {
+		return cost
+	}
+
+	cost, err := l.usage.SumCostSince(apiKeyID, startOfMonth(now))
+	if err != nil {
+		// 查询失败时不阻止请求
+		return 0
+	}
+	l.counters.CacheValue(ctx, cacheKey, cost, apiKeyMonthlyCostCache)
+	return cost
+}
+
+// apiKeyRateKey API Key 频率窗口的计数 key
+func apiKeyRateKey(apiKeyID uint) string {
+	return "api_key_rate:" + strconv.FormatUint(uint64(apiKeyID), 10)
+}
+
+// apiKeyDailyKey API Key 当日请求计数的 key
+func apiKeyDailyKey(apiKeyID uint, now time.Time) string {
+	return "api_key_daily:" + strconv.FormatUint(uint64(apiKeyID), 10) + ":" + now.Format("2006-01-02")
+}
+
+// startOfDay 当天零点
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// startOfMonth 当月第一天零点
+func startOfMonth(t time.Time) time.Time {
+	y, m, _ := t.Date()
+	return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+}
+
+// nextMonth 下个月第一天零点
+func nextMonth(t time.Time) time.Time {
+	y, m, _ := t.Date()
+	return time.Date(y, m+1, 1, 0, 0, 0, 0, t.Location())
+}
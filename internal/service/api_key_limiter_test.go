@@ -0,0 +1,225 @@
+/*
+ * 文件作用：API Key 限流服务测试
+ * 负责功能：
+ *   - 频率窗口、日请求次数、月额度的放行与拒绝
+ *   - 日计数从请求日志恢复（每实例每天一次）、被日限额拒绝的请求不占用频率窗口
+ *   - 多实例共用计数后端时限制不被放大、月度费用缓存共享
+ *   - 缓存后端不可用时放行
+ * 重要程度：⭐⭐ 辅助（测试）
+ * 依赖模块：cache, model, logger
+ */
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"cli-proxy/internal/cache"
+	"cli-proxy/internal/model"
+	"cli-proxy/pkg/logger"
+)
+
+// fakeAPIKeyUsage 固定的历史用量，记录查询次数
+type fakeAPIKeyUsage struct {
+	mu          sync.Mutex
+	requests    int64
+	cost        float64
+	countCalls  int
+	sumCalls    int
+	queryFailed bool
+}
+
+func (f *fakeAPIKeyUsage) CountRequestsSince(apiKeyID uint, since time.Time) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.countCalls++
+	if f.queryFailed {
+		return 0, errors.New("db down")
+	}
+	return f.requests, nil
+}
+
+func (f *fakeAPIKeyUsage) SumCostSince(apiKeyID uint, since time.Time) (float64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sumCalls++
+	if f.queryFailed {
+		return 0, errors.New("db down")
+	}
+	return f.cost, nil
+}
+
+// failingCounters 计数操作全部失败的后端
+type failingCounters struct {
+	cache.Counters
+}
+
+func (failingCounters) HitWindow(ctx context.Context, key, hitID string, limit int, window time.Duration) (bool, int64, time.Duration, error) {
+	return false, 0, 0, errors.New("redis down")
+}
+
+func (failingCounters) InitCounter(ctx context.Context, key string, value int64, ttl time.Duration) (bool, error) {
+	return false, errors.New("redis down")
+}
+
+func (failingCounters) IncrCounter(ctx context.Context, key string, limit int64, ttl time.Duration) (bool, int64, error) {
+	return false, 0, errors.New("redis down")
+}
+
+func (failingCounters) CachedValue(ctx context.Context, key string) (float64, bool, error) {
+	return 0, false, errors.New("redis down")
+}
+
+func (failingCounters) CacheValue(ctx context.Context, key string, value float64, ttl time.Duration) error {
+	return errors.New("redis down")
+}
+
+func newTestAPIKeyLimiter(t *testing.T, counters cache.Counters, usage *fakeAPIKeyUsage) *APIKeyLimiter {
+	t.Helper()
+	if err := logger.Init(t.TempDir(), logger.LevelError); err != nil {
+		t.Fatalf("logger.Init: %v", err)
+	}
+	return &APIKeyLimiter{counters: counters, usage: usage, log: logger.GetLogger("api_key_limiter")}
+}
+
+// checkN 连续检查 n 次，返回每次结果
+func checkN(l *APIKeyLimiter, key *model.APIKey, n int) []*APIKeyLimitResult {
+	results := make([]*APIKeyLimitResult, n)
+	for i := range results {
+		results[i] = l.Check(context.Background(), key)
+	}
+	return results
+}
+
+func TestAPIKeyLimiterCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		key         model.APIKey
+		requests    int64   // 请求日志中今日已有的请求数
+		cost        float64 // 本月已产生的费用
+		queryFailed bool
+		checks      int
+		wantAllowed int    // 放行次数（均在前面）
+		wantReason  string // 最后一次的拒绝原因
+	}{
+		{name: "no limits", key: model.APIKey{ID: 1}, checks: 5, wantAllowed: 5},
+		{name: "rate limit", key: model.APIKey{ID: 2, RateLimit: 3}, checks: 5, wantAllowed: 3, wantReason: APIKeyLimitRate},
+		{name: "daily limit", key: model.APIKey{ID: 3, DailyLimit: 4}, checks: 6, wantAllowed: 4, wantReason: APIKeyLimitDaily},
+		{name: "daily limit seeded from request logs", key: model.APIKey{ID: 4, DailyLimit: 10}, requests: 8, checks: 4, wantAllowed: 2, wantReason: APIKeyLimitDaily},
+		{name: "monthly quota exhausted", key: model.APIKey{ID: 5, MonthlyQuota: 10}, cost: 10, checks: 1, wantAllowed: 0, wantReason: APIKeyLimitMonthly},
+		{name: "monthly quota remaining", key: model.APIKey{ID: 6, MonthlyQuota: 10}, cost: 9.5, checks: 3, wantAllowed: 3},
+		{name: "history query failure does not block", key: model.APIKey{ID: 7, DailyLimit: 2, MonthlyQuota: 1}, queryFailed: true, checks: 3, wantAllowed: 2, wantReason: APIKeyLimitDaily},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usage := &fakeAPIKeyUsage{requests: tt.requests, cost: tt.cost, queryFailed: tt.queryFailed}
+			l := newTestAPIKeyLimiter(t, cache.NewMemoryCounters(), usage)
+			results := checkN(l, &tt.key, tt.checks)
+
+			for i, r := range results {
+				if want := i < tt.wantAllowed; r.Allowed != want {
+					t.Fatalf("check %d: Allowed = %v, want %v (reason %q)", i, r.Allowed, want, r.Reason)
+				}
+			}
+			last := results[len(results)-1]
+			if !last.Allowed && last.Reason != tt.wantReason {
+				t.Errorf("Reason = %q, want %q", last.Reason, tt.wantReason)
+			}
+			if !last.Allowed && last.RetryAfter <= 0 {
+				t.Errorf("RetryAfter = %v, want > 0", last.RetryAfter)
+			}
+		})
+	}
+}
+
+func TestAPIKeyLimiterRemaining(t *testing.T) {
+	usage := &fakeAPIKeyUsage{requests: 1}
+	l := newTestAPIKeyLimiter(t, cache.NewMemoryCounters(), usage)
+	key := &model.APIKey{ID: 11, RateLimit: 5, DailyLimit: 3}
+
+	results := checkN(l, key, 4)
+	wantRemaining := []int{4, 3, 2, 2} // 日限额拒绝时频率窗口仍按已放行的请求计算
+	wantDaily := []int{1, 0, 0, 0}
+	for i, r := range results {
+		if r.Remaining != wantRemaining[i] || r.DailyRemaining != wantDaily[i] {
+			t.Errorf("check %d: Remaining=%d DailyRemaining=%d, want %d %d", i, r.Remaining, r.DailyRemaining, wantRemaining[i], wantDaily[i])
+		}
+	}
+	if results[2].Allowed || results[2].Reason != APIKeyLimitDaily {
+		t.Fatalf("check 2 = %+v, want daily rejection", results[2])
+	}
+
+	// 被日限额拒绝的请求不占用频率窗口：窗口内只有 2 次放行的命中
+	if _, count, _, _ := l.counters.HitWindow(context.Background(), apiKeyRateKey(key.ID), "probe", 5, apiKeyRateWindow); count != 3 {
+		t.Errorf("window hits including probe = %d, want 3", count)
+	}
+
+	// 当日只从请求日志恢复一次
+	if usage.countCalls != 1 {
+		t.Errorf("CountRequestsSince calls = %d, want 1", usage.countCalls)
+	}
+
+	// Reset 清空窗口和当日计数（已恢复过的实例不再查询请求日志）
+	l.Reset(key.ID)
+	if r := l.Check(context.Background(), key); !r.Allowed || r.Remaining != 4 || r.DailyRemaining != 2 {
+		t.Errorf("after Reset = %+v, want allowed with Remaining=4 DailyRemaining=2", r)
+	}
+}
+
+func TestAPIKeyLimiterSharedAcrossReplicas(t *testing.T) {
+	counters := cache.NewMemoryCounters()
+	usage := &fakeAPIKeyUsage{requests: 0, cost: 2}
+	replicaA := newTestAPIKeyLimiter(t, counters, usage)
+	replicaB := newTestAPIKeyLimiter(t, counters, usage)
+	key := &model.APIKey{ID: 21, RateLimit: 10, DailyLimit: 6, MonthlyQuota: 5}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		l := replicaA
+		if i%2 == 1 {
+			l = replicaB
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if l.Check(context.Background(), key).Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != 6 {
+		t.Errorf("allowed = %d, want 6 (daily limit shared by both replicas)", allowed)
+	}
+	// 月度费用查询结果在实例间共享缓存（并发首批请求可能各查一次）
+	if usage.sumCalls > 20 || usage.sumCalls < 1 {
+		t.Errorf("SumCostSince calls = %d", usage.sumCalls)
+	}
+	before := usage.sumCalls
+	replicaA.Check(context.Background(), key)
+	replicaB.Check(context.Background(), key)
+	if usage.sumCalls != before {
+		t.Errorf("SumCostSince calls after cache warm = %d, want %d", usage.sumCalls, before)
+	}
+}
+
+func TestAPIKeyLimiterBackendFailureAllows(t *testing.T) {
+	usage := &fakeAPIKeyUsage{cost: 1}
+	l := newTestAPIKeyLimiter(t, failingCounters{}, usage)
+	key := &model.APIKey{ID: 31, RateLimit: 1, DailyLimit: 1, MonthlyQuota: 5}
+
+	for i, r := range checkN(l, key, 3) {
+		if !r.Allowed {
+			t.Errorf("check %d rejected with reason %q, want allowed while the backend is down", i, r.Reason)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+/*
+ * 文件作用：请求/响应体记录策略，决定请求日志是否记录请求体/响应体以及如何处理
+ * 负责功能：
+ *   - 记录判定：关闭 / 仅失败请求 / 成功请求按比例采样 / API Key 调试期内全部记录
+ *   - JSONPath 脱敏（如 tool_result 内容）与正则脱敏（API Key、邮箱等）
+ *   - 大小限制截断与可选压缩存储
+ * 重要程度：⭐⭐⭐ 一般（日志隐私与存储控制）
+ * 依赖模块：model, jsonpath, logger
+ */
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"cli-proxy/internal/model"
+	"cli-proxy/pkg/jsonpath"
+	"cli-proxy/pkg/logger"
+)
+
+// redactedValue 脱敏后的替换文本
+const redactedValue = "[REDACTED]"
+
+// BodyCaptureService 请求/响应体记录策略服务
+type BodyCaptureService struct {
+	config *ConfigService
+
+	mu          sync.RWMutex
+	rawPatterns string // 已编译规则对应的配置原文，配置变更时重新编译
+	rawPaths    string
+	patterns    []*regexp.Regexp
+	paths       []*jsonpath.Path
+}
+
+var (
+	bodyCaptureService     *BodyCaptureService
+	bodyCaptureServiceOnce sync.Once
+)
+
+// GetBodyCaptureService 获取请求/响应体记录策略服务单例
+func GetBodyCaptureService() *BodyCaptureService {
+	bodyCaptureServiceOnce.Do(func() {
+		bodyCaptureService = &BodyCaptureService{config: GetConfigService()}
+	})
+	return bodyCaptureService
+}
+
+// ShouldCapture 判断本次请求是否记录请求体/响应体
+// debugUntil 为 API Key 的调试记录截止时间，有效期内始终记录
+func (s *BodyCaptureService) ShouldCapture(success bool, debugUntil *time.Time) bool {
+	if debugUntil != nil && time.Now().Before(*debugUntil) {
+		return true
+	}
+	switch s.config.GetBodyCaptureMode() {
+	case BodyCaptureErrors:
+		return !success
+	case BodyCaptureSampled:
+		if !success {
+			return true
+		}
+		rate := s.config.GetBodyCaptureSampleRate()
+		return rate >= 100 || rand.Float64()*100 < rate
+	default:
+		return false
+	}
+}
+
+// Capture 处理待存储的请求体/响应体：脱敏 → 截断 → 加前缀 → 可选压缩
+func (s *BodyCaptureService) Capture(body []byte, prefix string) string {
+	if len(body) == 0 {
+		return ""
+	}
+	patterns, paths := s.rules()
+
+	body = redactJSONPaths(body, paths)
+	text := string(body)
+	for _, re := range patterns {
+		text = re.ReplaceAllString(text, redactedValue)
+	}
+
+	if maxSize := s.config.GetBodyCaptureMaxSize(); len(text) > maxSize {
+		text = truncateUTF8(text, maxSize) + "...[truncated]"
+	}
+	text = prefix + text
+
+	if s.config.GetBodyCaptureCompress() {
+		text = model.CompressBody(text)
+	}
+	return text
+}
+
+// rules 获取已编译的脱敏规则，配置变更后重新编译（无效规则记录日志并跳过）
+func (s *BodyCaptureService) rules() ([]*regexp.Regexp, []*jsonpath.Path) {
+	rawPatterns := s.config.GetBodyCaptureRedactPatterns()
+	rawPaths := s.config.GetBodyCaptureRedactPaths()
+
+	s.mu.RLock()
+	if s.patterns != nil && rawPatterns == s.rawPatterns && rawPaths == s.rawPaths {
+		patterns, paths := s.patterns, s.paths
+		s.mu.RUnlock()
+		return patterns, paths
+	}
+	s.mu.RUnlock()
+
+	log := logger.GetLogger("body_capture")
+	patterns := make([]*regexp.Regexp, 0)
+	for _, line := range splitLines(rawPatterns) {
+		re, err := regexp.Compile(line)
+		if err != nil {
+			log.Warn("脱敏正则无效，已忽略 | Pattern: %s | 原因: %v", line, err)
+			continue
+		}
+		patterns = append(patterns, re)
+	}
+	var paths []*jsonpath.Path
+	for _, line := range splitLines(rawPaths) {
+		p, err := jsonpath.Compile(line)
+		if err != nil {
+			log.Warn("脱敏 JSONPath 无效，已忽略 | Path: %s | 原因: %v", line, err)
+			continue
+		}
+		paths = append(paths, p)
+	}
+
+	s.mu.Lock()
+	s.rawPatterns, s.rawPaths = rawPatterns, rawPaths
+	s.patterns, s.paths = patterns, paths
+	s.mu.Unlock()
+	return patterns, paths
+}
+
+// redactJSONPaths 按 JSONPath 脱敏 JSON 文档，非 JSON 或无匹配时原样返回
+func redactJSONPaths(body []byte, paths []*jsonpath.Path) []byte {
+	trimmed := bytes.TrimSpace(body)
+	if len(paths) == 0 || len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return body
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return body
+	}
+	total := 0
+	for _, p := range paths {
+		var n int
+		doc, n = p.Replace(doc, redactedValue)
+		total += n
+	}
+	if total == 0 {
+		return body
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return body
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n")
+}
+
+// splitLines 按行拆分配置，忽略空行
+func splitLines(value string) []string {
+	var lines []string
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// truncateUTF8 按字节截断且不拆分多字节字符
+func truncateUTF8(s string, maxLen int) string {
+	if len(s) <= maxLen {
+		return s
+	}
+	for maxLen > 0 && !utf8.RuneStart(s[maxLen]) {
+		maxLen--
+	}
+	return s[:maxLen]
+}
@@ -2,10 +2,11 @@
  * 文件作用：缓存管理服务，提供Redis缓存的高层封装
  * 负责功能：
  *   - 缓存统计信息获取
- *   - 会话缓存管理
+ *   - 会话缓存管理（含批量固定/迁移/过期）
  *   - 账户/用户缓存管理
  *   - 并发计数管理
  *   - 不可用账户标记管理
+ *   - 账户熔断器状态查询/重置
  * 重要程度：⭐⭐⭐⭐ 重要（缓存管理核心）
  * 依赖模块：cache, repository, model
  */
@@ -13,18 +14,20 @@ package service
 
 import (
 	"context"
+	"errors"
+	"math"
 	"sync"
 	"time"
 
 	"cli-proxy/internal/cache"
 	"cli-proxy/internal/model"
+	"cli-proxy/internal/proxy/scheduler"
 	"cli-proxy/internal/repository"
 )
 
-// CacheService 缓存管理服务（使用内存缓存）
+// CacheService 缓存管理服务（读写 SessionCache 的共享状态后端）
 type CacheService struct {
 	sessionCache *cache.SessionCache
-	memoryCache  *cache.MemoryCache
 	accountRepo  *repository.AccountRepository
 	userRepo     *repository.UserRepository
 }
@@ -38,7 +41,6 @@ func NewCacheService() *CacheService {
 	cacheServiceOnce.Do(func() {
 		cacheServiceInstance = &CacheService{
 			sessionCache: cache.GetSessionCache(),
-			memoryCache:  cache.GetMemoryCache(),
 			accountRepo:  repository.NewAccountRepository(),
 			userRepo:     repository.NewUserRepository(),
 		}
@@ -88,6 +90,141 @@ func (s *CacheService) ClearAccountSessions(ctx context.Context, accountID uint)
 	return s.sessionCache.ClearAccountSessions(ctx, accountID)
 }
 
+// SessionFilter 批量会话操作的筛选条件（各条件同时满足；未设置任何条件时不匹配）
+type SessionFilter struct {
+	SessionIDs  []string `json:"session_ids"`
+	AccountID   uint     `json:"account_id"`
+	UserID      uint     `json:"user_id"`
+	APIKeyID    uint     `json:"api_key_id"`
+	IdleSeconds int64    `json:"idle_seconds"` // 最后使用距今超过该秒数
+}
+
+func (f *SessionFilter) empty() bool {
+	return len(f.SessionIDs) == 0 && f.AccountID == 0 && f.UserID == 0 && f.APIKeyID == 0 && f.IdleSeconds <= 0
+}
+
+// matchSessions 按筛选条件列出会话绑定
+func (s *CacheService) matchSessions(ctx context.Context, filter *SessionFilter) ([]SessionBinding, error) {
+	if filter.empty() {
+		return nil, nil
+	}
+	all, _, err := s.sessionCache.ListAllSessions(ctx, 0, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids map[string]bool
+	if len(filter.SessionIDs) > 0 {
+		ids = make(map[string]bool, len(filter.SessionIDs))
+		for _, id := range filter.SessionIDs {
+			ids[id] = true
+		}
+	}
+	idleBefore := time.Now().Add(-time.Duration(filter.IdleSeconds) * time.Second)
+
+	var matched []SessionBinding
+	for _, b := range all {
+		switch {
+		case ids != nil && !ids[b.SessionID]:
+		case filter.AccountID > 0 && b.AccountID != filter.AccountID:
+		case filter.UserID > 0 && b.UserID != filter.UserID:
+		case filter.APIKeyID > 0 && b.APIKeyID != filter.APIKeyID:
+		case filter.IdleSeconds > 0 && b.LastUsedAt.After(idleBefore):
+		default:
+			matched = append(matched, b)
+		}
+	}
+	return matched, nil
+}
+
+// rebindSession 将会话重新绑定到账户（先移除旧绑定以维护账户索引）
+func (s *CacheService) rebindSession(ctx context.Context, binding SessionBinding, account *model.Account) error {
+	if err := s.sessionCache.RemoveSessionBinding(ctx, binding.SessionID); err != nil {
+		return err
+	}
+	binding.AccountID = account.ID
+	binding.Platform = account.Platform
+	return s.sessionCache.SetSessionBinding(ctx, &binding)
+}
+
+// PinSessions 将会话固定到指定账户，会话不存在时新建绑定
+// 固定的会话不过期，调度时不会改绑到其他账户；与目标账户平台不同的会话不固定，返回固定数量和跳过数量
+func (s *CacheService) PinSessions(ctx context.Context, sessionIDs []string, accountID uint) (int64, int64, error) {
+	account, err := s.accountRepo.GetByID(accountID)
+	if err != nil {
+		return 0, 0, errors.New("目标账户不存在")
+	}
+
+	var pinned, skipped int64
+	for _, sessionID := range sessionIDs {
+		if sessionID == "" {
+			continue
+		}
+		binding := SessionBinding{SessionID: sessionID}
+		if existing, err := s.sessionCache.GetSessionBinding(ctx, sessionID); err != nil {
+			return pinned, skipped, err
+		} else if existing != nil {
+			binding = *existing
+		}
+		if binding.Platform != "" && binding.Platform != account.Platform {
+			skipped++
+			continue
+		}
+		binding.Pinned = true
+		if err := s.rebindSession(ctx, binding, account); err != nil {
+			return pinned, skipped, err
+		}
+		pinned++
+	}
+	return pinned, skipped, nil
+}
+
+// MigrateSessions 将匹配的会话迁移到目标账户（如下线账户前保留会话粘性）
+// 与目标账户平台不同的会话不迁移，返回迁移数量和跳过数量
+func (s *CacheService) MigrateSessions(ctx context.Context, filter *SessionFilter, toAccountID uint) (int64, int64, error) {
+	account, err := s.accountRepo.GetByID(toAccountID)
+	if err != nil {
+		return 0, 0, errors.New("目标账户不存在")
+	}
+	sessions, err := s.matchSessions(ctx, filter)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var migrated, skipped int64
+	for _, binding := range sessions {
+		if binding.AccountID == account.ID {
+			continue
+		}
+		if binding.Platform != "" && binding.Platform != account.Platform {
+			skipped++
+			continue
+		}
+		if err := s.rebindSession(ctx, binding, account); err != nil {
+			return migrated, skipped, err
+		}
+		migrated++
+	}
+	return migrated, skipped, nil
+}
+
+// ExpireSessions 使匹配的会话立即过期，返回移除数量
+func (s *CacheService) ExpireSessions(ctx context.Context, filter *SessionFilter) (int64, error) {
+	sessions, err := s.matchSessions(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	var expired int64
+	for _, binding := range sessions {
+		if err := s.sessionCache.RemoveSessionBinding(ctx, binding.SessionID); err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
 // ==================== 临时不可用标记 ====================
 
 // MarkAccountUnavailable 标记账户临时不可用
@@ -122,14 +259,14 @@ func (s *CacheService) GetAccountConcurrencyLimit(accountID uint) int {
 	return s.sessionCache.GetAccountConcurrencyLimit(accountID)
 }
 
-// AcquireConcurrency 获取并发槽位
-func (s *CacheService) AcquireConcurrency(ctx context.Context, accountID uint) (bool, int64, error) {
-	return s.sessionCache.AcquireConcurrency(ctx, accountID)
+// AcquireConcurrency 获取并发槽位，成功时返回租约 ID
+func (s *CacheService) AcquireConcurrency(ctx context.Context, accountID uint, meta cache.LeaseMeta) (string, bool, int64, error) {
+	return s.sessionCache.AcquireConcurrency(ctx, accountID, meta)
 }
 
-// ReleaseConcurrency 释放并发槽位
-func (s *CacheService) ReleaseConcurrency(ctx context.Context, accountID uint) error {
-	return s.sessionCache.ReleaseConcurrency(ctx, accountID)
+// ReleaseConcurrency 按租约 ID 释放并发槽位
+func (s *CacheService) ReleaseConcurrency(ctx context.Context, accountID uint, leaseID string) error {
+	return s.sessionCache.ReleaseConcurrency(ctx, accountID, leaseID)
 }
 
 // GetAccountConcurrency 获取账户当前并发数
@@ -142,6 +279,11 @@ func (s *CacheService) ResetAccountConcurrency(ctx context.Context, accountID ui
 	return s.sessionCache.ResetAccountConcurrency(ctx, accountID)
 }
 
+// ListAccountLeases 列出账户的有效并发租约
+func (s *CacheService) ListAccountLeases(ctx context.Context, accountID uint) ([]cache.LeaseInfo, error) {
+	return s.sessionCache.ListAccountLeases(ctx, accountID)
+}
+
 // ==================== 用户并发控制 ====================
 
 // GetUserConcurrency 获取用户当前并发数
@@ -154,6 +296,23 @@ func (s *CacheService) ResetUserConcurrency(ctx context.Context, userID uint) er
 	return s.sessionCache.ResetUserConcurrency(ctx, userID)
 }
 
+// ListUserLeases 列出用户的有效并发租约
+func (s *CacheService) ListUserLeases(ctx context.Context, userID uint) ([]cache.LeaseInfo, error) {
+	return s.sessionCache.ListUserLeases(ctx, userID)
+}
+
+// ==================== 账户熔断管理 ====================
+
+// GetCircuitBreakers 获取账户熔断器状态及最近的状态变更
+func (s *CacheService) GetCircuitBreakers() *scheduler.BreakerStats {
+	return scheduler.GetScheduler().GetBreakerStats()
+}
+
+// ResetCircuitBreaker 重置账户熔断器（手动恢复调度）
+func (s *CacheService) ResetCircuitBreaker(accountID uint) {
+	scheduler.GetScheduler().ResetBreaker(accountID)
+}
+
 // ==================== 缓存管理统计 ====================
 
 // CacheStats 缓存统计信息
@@ -169,22 +328,24 @@ type CacheStats struct {
 
 // GetCacheStats 获取缓存统计
 func (s *CacheService) GetCacheStats(ctx context.Context) (*CacheStats, error) {
-	stats := s.memoryCache.Stats()
-
-	sessionCount := int64(0)
-	if v, ok := stats["session_count"].(int); ok {
-		sessionCount = int64(v)
+	stats, err := s.sessionCache.Stats(ctx)
+	if err != nil {
+		return nil, err
 	}
-	unavailableCount := int64(0)
-	if v, ok := stats["unavailable_count"].(int); ok {
-		unavailableCount = int64(v)
+
+	sessionCount := int64(stats.SessionCount)
+	unavailableCount := int64(stats.UnavailableCount)
+
+	memoryUsedHuman := "N/A (内存缓存)"
+	if s.sessionCache.BackendName() != cache.BackendMemory {
+		memoryUsedHuman = "N/A (" + s.sessionCache.BackendName() + ")"
 	}
 
 	return &CacheStats{
 		SessionCount:     sessionCount,
 		UnavailableCount: unavailableCount,
 		TotalKeyCount:    sessionCount + unavailableCount,
-		MemoryUsedHuman:  "N/A (内存缓存)",
+		MemoryUsedHuman:  memoryUsedHuman,
 	}, nil
 }
 
@@ -215,18 +376,31 @@ func (s *CacheService) ClearCache(ctx context.Context, cacheType ClearCacheType)
 
 	switch cacheType {
 	case ClearCacheAll:
-		cleared := s.memoryCache.ClearAll()
-		result.DeletedCount = int64(cleared["sessions"] + cleared["unavailable"])
+		sessions, err := s.sessionCache.ClearAllSessions(ctx)
+		if err != nil {
+			return nil, err
+		}
+		unavailable, err := s.sessionCache.ClearAllUnavailable(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result.DeletedCount = sessions + unavailable
 		return result, nil
 
 	case ClearCacheSessions:
-		count := s.memoryCache.Sessions.ClearAll()
-		result.DeletedCount = int64(count)
+		count, err := s.sessionCache.ClearAllSessions(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result.DeletedCount = count
 		return result, nil
 
 	case ClearCacheUnavailable:
-		count := s.memoryCache.Unavailable.ClearAll()
-		result.DeletedCount = int64(count)
+		count, err := s.sessionCache.ClearAllUnavailable(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result.DeletedCount = count
 		return result, nil
 
 	case ClearCacheUsage, ClearCacheCost:
@@ -249,8 +423,11 @@ func (s *CacheService) ClearCache(ctx context.Context, cacheType ClearCacheType)
 // ClearUserCache 清理指定用户的缓存
 func (s *CacheService) ClearUserCache(ctx context.Context, userID uint) (*ClearCacheResult, error) {
 	result := &ClearCacheResult{Type: "user"}
-	count := s.memoryCache.Sessions.ClearByUser(userID)
-	result.DeletedCount = int64(count)
+	count, err := s.sessionCache.ClearUserSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	result.DeletedCount = count
 	return result, nil
 }
 
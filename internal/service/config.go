@@ -15,6 +15,7 @@ import (
 	"cli-proxy/internal/model"
 	"cli-proxy/internal/repository"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -386,3 +387,287 @@ func (s *ConfigService) GetEmailDailyLimit() int {
 	}
 	return val
 }
+
+// ========== 并发排队配置便捷方法 ==========
+
+// GetConcurrencyQueueEnabled 获取并发已满时是否排队等待
+func (s *ConfigService) GetConcurrencyQueueEnabled() bool {
+	return s.GetBool(model.ConfigConcurrencyQueueEnabled)
+}
+
+// GetConcurrencyQueueMaxWait 获取最大排队等待时间
+func (s *ConfigService) GetConcurrencyQueueMaxWait() time.Duration {
+	val := s.GetInt(model.ConfigConcurrencyQueueMaxWait)
+	if val <= 0 {
+		return 10 * time.Second // 默认 10 秒
+	}
+	return time.Duration(val) * time.Second
+}
+
+// GetConcurrencyQueueMaxDepth 获取每个用户/账户的最大排队数
+func (s *ConfigService) GetConcurrencyQueueMaxDepth() int {
+	val := s.GetInt(model.ConfigConcurrencyQueueMaxDepth)
+	if val <= 0 {
+		return 20 // 默认 20 个
+	}
+	return val
+}
+
+// ========== 请求重试配置便捷方法 ==========
+
+// getNonNegativeInt 获取非负整数配置，未配置或无效时返回默认值（0 为有效值）
+func (s *ConfigService) getNonNegativeInt(key string, defaultVal int) int {
+	val, err := strconv.Atoi(s.GetString(key))
+	if err != nil || val < 0 {
+		return defaultVal
+	}
+	return val
+}
+
+// GetRetryMaxRetries 获取最大重试次数
+func (s *ConfigService) GetRetryMaxRetries() int {
+	return s.getNonNegativeInt(model.ConfigRetryMaxRetries, 5)
+}
+
+// GetRetryDelay 获取重试初始退避时间
+func (s *ConfigService) GetRetryDelay() time.Duration {
+	val := s.GetInt(model.ConfigRetryDelay)
+	if val <= 0 {
+		return time.Second // 默认 1 秒
+	}
+	return time.Duration(val) * time.Millisecond
+}
+
+// GetRetryBackoff 获取重试退避系数
+func (s *ConfigService) GetRetryBackoff() float64 {
+	val := s.GetFloat(model.ConfigRetryBackoff)
+	if val < 1 {
+		return 1.5 // 默认 1.5
+	}
+	return val
+}
+
+// GetRetryMaxDelay 获取单次退避上限
+func (s *ConfigService) GetRetryMaxDelay() time.Duration {
+	val := s.GetInt(model.ConfigRetryMaxDelay)
+	if val <= 0 {
+		return 10 * time.Second // 默认 10 秒
+	}
+	return time.Duration(val) * time.Millisecond
+}
+
+// GetRetryJitter 获取退避随机抖动比例（0-1）
+func (s *ConfigService) GetRetryJitter() float64 {
+	val, err := strconv.ParseFloat(s.GetString(model.ConfigRetryJitter), 64)
+	if err != nil || val < 0 {
+		return 0.2 // 默认 0.2
+	}
+	return min(val, 1)
+}
+
+// GetRetryTotalTimeout 获取请求总时长上限（0 不限制）
+func (s *ConfigService) GetRetryTotalTimeout() time.Duration {
+	return time.Duration(s.getNonNegativeInt(model.ConfigRetryTotalTimeout, 600)) * time.Second
+}
+
+// GetRetryFirstByteTimeout 获取流式请求首字节超时（0 不限制）
+func (s *ConfigService) GetRetryFirstByteTimeout() time.Duration {
+	return time.Duration(s.getNonNegativeInt(model.ConfigRetryFirstByteTimeout, 60)) * time.Second
+}
+
+// GetRetryNonStreamFirstByteTimeout 获取非流式请求首字节超时（0 不限制）
+func (s *ConfigService) GetRetryNonStreamFirstByteTimeout() time.Duration {
+	return time.Duration(s.getNonNegativeInt(model.ConfigRetryNonStreamFirstByteTimeout, 300)) * time.Second
+}
+
+// ========== 账户熔断配置便捷方法 ==========
+
+// GetCircuitBreakerEnabled 获取是否启用账户熔断
+func (s *ConfigService) GetCircuitBreakerEnabled() bool {
+	return s.GetBool(model.ConfigCircuitBreakerEnabled)
+}
+
+// GetCircuitBreakerWindow 获取熔断错误率统计窗口
+func (s *ConfigService) GetCircuitBreakerWindow() time.Duration {
+	val := s.GetInt(model.ConfigCircuitBreakerWindow)
+	if val <= 0 {
+		return time.Minute // 默认 60 秒
+	}
+	return time.Duration(val) * time.Second
+}
+
+// GetCircuitBreakerMinRequests 获取窗口内最少请求数
+func (s *ConfigService) GetCircuitBreakerMinRequests() int {
+	val := s.GetInt(model.ConfigCircuitBreakerMinRequests)
+	if val <= 0 {
+		return 10 // 默认 10 次
+	}
+	return val
+}
+
+// GetCircuitBreakerErrorRate 获取熔断错误率阈值
+func (s *ConfigService) GetCircuitBreakerErrorRate() float64 {
+	val := s.GetFloat(model.ConfigCircuitBreakerErrorRate)
+	if val <= 0 || val > 1 {
+		return 0.5 // 默认 50%
+	}
+	return val
+}
+
+// GetCircuitBreakerOpenDuration 获取熔断持续时间
+func (s *ConfigService) GetCircuitBreakerOpenDuration() time.Duration {
+	val := s.GetInt(model.ConfigCircuitBreakerOpenDuration)
+	if val <= 0 {
+		return 30 * time.Second // 默认 30 秒
+	}
+	return time.Duration(val) * time.Second
+}
+
+// GetCircuitBreakerHalfOpenProbes 获取半开探测请求数
+func (s *ConfigService) GetCircuitBreakerHalfOpenProbes() int {
+	val := s.GetInt(model.ConfigCircuitBreakerHalfOpenProbes)
+	if val <= 0 {
+		return 2 // 默认 2 个
+	}
+	return val
+}
+
+// ========== 账户选择策略配置便捷方法 ==========
+
+// GetSchedulerStrategy 获取默认账户选择策略
+func (s *ConfigService) GetSchedulerStrategy() string {
+	val := strings.TrimSpace(s.GetString(model.ConfigSchedulerStrategy))
+	if val == "" {
+		return "weighted_random"
+	}
+	return val
+}
+
+// GetSchedulerStrategyPlatforms 获取按平台指定的选择策略（platform -> 策略名）
+func (s *ConfigService) GetSchedulerStrategyPlatforms() map[string]string {
+	return parseKeyValueList(s.GetString(model.ConfigSchedulerStrategyPlatforms))
+}
+
+// GetSchedulerStrategyGroups 获取按账户分组指定的选择策略（分组ID -> 策略名），无效的分组ID忽略
+func (s *ConfigService) GetSchedulerStrategyGroups() map[uint]string {
+	result := make(map[uint]string)
+	for key, value := range parseKeyValueList(s.GetString(model.ConfigSchedulerStrategyGroups)) {
+		id, err := strconv.ParseUint(key, 10, 32)
+		if err != nil || id == 0 {
+			continue
+		}
+		result[uint(id)] = value
+	}
+	return result
+}
+
+// parseKeyValueList 解析 "k1=v1,k2=v2" 格式的配置
+func parseKeyValueList(value string) map[string]string {
+	result := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(part, "=")
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		if !ok || key == "" || val == "" {
+			continue
+		}
+		result[key] = val
+	}
+	return result
+}
+
+// ========== 请求对冲配置便捷方法 ==========
+
+// GetHedgeEnabled 获取是否启用请求对冲
+func (s *ConfigService) GetHedgeEnabled() bool {
+	return s.GetBool(model.ConfigHedgeEnabled)
+}
+
+// GetHedgeModels 获取对所有 API Key 启用对冲的模型前缀
+func (s *ConfigService) GetHedgeModels() []string {
+	var models []string
+	for _, part := range strings.Split(s.GetString(model.ConfigHedgeModels), ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			models = append(models, part)
+		}
+	}
+	return models
+}
+
+// GetHedgePercentile 获取触发对冲的耗时百分位
+func (s *ConfigService) GetHedgePercentile() float64 {
+	val := s.GetInt(model.ConfigHedgePercentile)
+	if val <= 0 || val >= 100 {
+		return 95 // 默认 P95
+	}
+	return float64(val)
+}
+
+// GetHedgeMinDelay 获取对冲最小触发延迟
+func (s *ConfigService) GetHedgeMinDelay() time.Duration {
+	return time.Duration(s.getNonNegativeInt(model.ConfigHedgeMinDelay, 1000)) * time.Millisecond
+}
+
+// GetPromptAffinityEnabled 获取是否启用提示缓存亲和路由
+func (s *ConfigService) GetPromptAffinityEnabled() bool {
+	return s.GetBool(model.ConfigPromptAffinityEnabled)
+}
+
+// GetPromptAffinityTTL 获取提示缓存亲和记录有效期
+func (s *ConfigService) GetPromptAffinityTTL() time.Duration {
+	return time.Duration(s.getNonNegativeInt(model.ConfigPromptAffinityTTL, 300)) * time.Second
+}
+
+// ========== 请求/响应体记录策略便捷方法 ==========
+
+// 请求/响应体记录模式
+const (
+	BodyCaptureOff     = "off"     // 不记录
+	BodyCaptureErrors  = "errors"  // 仅记录失败请求
+	BodyCaptureSampled = "sampled" // 失败请求全部记录，成功请求按比例采样
+)
+
+// GetBodyCaptureMode 获取请求/响应体记录模式
+func (s *ConfigService) GetBodyCaptureMode() string {
+	switch val := strings.TrimSpace(s.GetString(model.ConfigBodyCaptureMode)); val {
+	case BodyCaptureOff, BodyCaptureErrors, BodyCaptureSampled:
+		return val
+	default:
+		return BodyCaptureSampled
+	}
+}
+
+// GetBodyCaptureSampleRate 获取成功请求采样百分比（0-100）
+func (s *ConfigService) GetBodyCaptureSampleRate() float64 {
+	val, err := strconv.ParseFloat(s.GetString(model.ConfigBodyCaptureSampleRate), 64)
+	if err != nil || val < 0 {
+		return 100
+	}
+	if val > 100 {
+		return 100
+	}
+	return val
+}
+
+// GetBodyCaptureMaxSize 获取单个请求/响应体最大记录字节数
+func (s *ConfigService) GetBodyCaptureMaxSize() int {
+	val := s.GetInt(model.ConfigBodyCaptureMaxSize)
+	if val <= 0 {
+		return 65536 // 默认 64KB
+	}
+	return val
+}
+
+// GetBodyCaptureRedactPatterns 获取脱敏正则配置（每行一个）
+func (s *ConfigService) GetBodyCaptureRedactPatterns() string {
+	return s.GetString(model.ConfigBodyCaptureRedactPatterns)
+}
+
+// GetBodyCaptureRedactPaths 获取脱敏 JSONPath 配置（每行一个）
+func (s *ConfigService) GetBodyCaptureRedactPaths() string {
+	return s.GetString(model.ConfigBodyCaptureRedactPaths)
+}
+
+// GetBodyCaptureCompress 获取是否压缩存储请求/响应体
+func (s *ConfigService) GetBodyCaptureCompress() bool {
+	return s.GetBool(model.ConfigBodyCaptureCompress)
+}
@@ -237,6 +237,7 @@ func (s *AccountHealthCheckService) handleRateLimitedAccount(ctx context.Context
 			if err := s.accountRepo.RecoverAccount(account.ID); err != nil {
 				s.log.Error("[%s] 恢复账号失败: %v", account.Name, err)
 			} else {
+				observeHealthTransition(account.Status, model.AccountStatusValid)
 				s.log.Info("[%s] 限流账号已恢复正常", account.Name)
 				scheduler.GetScheduler().Refresh()
 			}
@@ -287,6 +288,8 @@ func (s *AccountHealthCheckService) handleTokenExpiredAccount(ctx context.Contex
 
 			if err := s.accountRepo.RecoverAccount(account.ID); err != nil {
 				s.log.Error("[%s] 恢复账号失败: %v", account.Name, err)
+			} else {
+				observeHealthTransition(account.Status, model.AccountStatusValid)
 			}
 			scheduler.GetScheduler().Refresh()
 			return
@@ -298,7 +301,9 @@ func (s *AccountHealthCheckService) handleTokenExpiredAccount(ctx context.Contex
 		// 检查是否账号被封
 		if IsAccountBannedError(err) {
 			s.log.Warn("[%s] Token 刷新失败，账号疑似被封: %v", account.Name, err)
-			s.accountRepo.MarkAsSuspended(account.ID, err.Error())
+			if s.accountRepo.MarkAsSuspended(account.ID, err.Error()) == nil {
+				observeHealthTransition(account.Status, model.AccountStatusSuspended)
+			}
 			return
 		}
 
@@ -321,6 +326,7 @@ func (s *AccountHealthCheckService) handleSuspendedAccount(ctx context.Context,
 			if err := s.accountRepo.RecoverAccount(account.ID); err != nil {
 				s.log.Error("[%s] 恢复账号失败: %v", account.Name, err)
 			} else {
+				observeHealthTransition(account.Status, model.AccountStatusValid)
 				s.log.Info("[%s] 疑似封号账号已恢复正常", account.Name)
 				scheduler.GetScheduler().Refresh()
 			}
@@ -341,6 +347,7 @@ func (s *AccountHealthCheckService) handleSuspendedAccount(ctx context.Context,
 			if err := s.accountRepo.MarkAsBanned(account.ID, errMsg); err != nil {
 				s.log.Error("[%s] 标记封号失败: %v", account.Name, err)
 			} else {
+				observeHealthTransition(account.Status, model.AccountStatusBanned)
 				s.log.Warn("[%s] 连续 %d 次检测失败，确认封号", account.Name, count)
 				scheduler.GetScheduler().Refresh()
 			}
@@ -367,6 +374,7 @@ func (s *AccountHealthCheckService) handleBannedAccount(ctx context.Context, acc
 			if err := s.accountRepo.RecoverAccount(account.ID); err != nil {
 				s.log.Error("[%s] 恢复账号失败: %v", account.Name, err)
 			} else {
+				observeHealthTransition(account.Status, model.AccountStatusValid)
 				s.log.Info("[%s] 封号账号意外恢复正常！", account.Name)
 				scheduler.GetScheduler().Refresh()
 			}
@@ -409,6 +417,7 @@ func (s *AccountHealthCheckService) TriggerSingleCheck(accountID uint) (bool, st
 			if err := s.accountRepo.RecoverAccount(accountID); err != nil {
 				s.log.Error("[%s] 恢复账号失败: %v", account.Name, err)
 			} else {
+				observeHealthTransition(account.Status, model.AccountStatusValid)
 				s.log.Info("[%s] 手动检测通过，账号已恢复", account.Name)
 				scheduler.GetScheduler().Refresh()
 			}
@@ -435,6 +444,7 @@ func (s *AccountHealthCheckService) updateAccountStatusByError(account *model.Ac
 			if err := s.accountRepo.MarkAsTokenExpired(account.ID, errMsg); err != nil {
 				s.log.Error("[%s] 标记 Token 过期失败: %v", account.Name, err)
 			} else {
+				observeHealthTransition(account.Status, model.AccountStatusTokenExpired)
 				s.log.Warn("[%s] 检测失败，标记为 Token 过期: %s", account.Name, truncateMsg(errMsg, 100))
 				scheduler.GetScheduler().Refresh()
 			}
@@ -447,6 +457,7 @@ func (s *AccountHealthCheckService) updateAccountStatusByError(account *model.Ac
 			if err := s.accountRepo.MarkAsSuspended(account.ID, errMsg); err != nil {
 				s.log.Error("[%s] 标记疑似封号失败: %v", account.Name, err)
 			} else {
+				observeHealthTransition(account.Status, model.AccountStatusSuspended)
 				s.log.Warn("[%s] 检测失败，标记为疑似封号: %s", account.Name, truncateMsg(errMsg, 100))
 				scheduler.GetScheduler().Refresh()
 			}
@@ -459,6 +470,7 @@ func (s *AccountHealthCheckService) updateAccountStatusByError(account *model.Ac
 			if err := s.accountRepo.MarkAsRateLimited(account.ID, &resetAt, errMsg); err != nil {
 				s.log.Error("[%s] 标记限流失败: %v", account.Name, err)
 			} else {
+				observeHealthTransition(account.Status, model.AccountStatusRateLimited)
 				s.log.Warn("[%s] 检测失败，标记为限流: %s", account.Name, truncateMsg(errMsg, 100))
 				scheduler.GetScheduler().Refresh()
 			}
@@ -469,6 +481,7 @@ func (s *AccountHealthCheckService) updateAccountStatusByError(account *model.Ac
 			if err := s.accountRepo.MarkAsInvalid(account.ID, errMsg); err != nil {
 				s.log.Error("[%s] 标记无效失败: %v", account.Name, err)
 			} else {
+				observeHealthTransition(account.Status, model.AccountStatusInvalid)
 				s.log.Warn("[%s] 检测失败，标记为无效: %s", account.Name, truncateMsg(errMsg, 100))
 				scheduler.GetScheduler().Refresh()
 			}
@@ -487,6 +500,7 @@ func (s *AccountHealthCheckService) ForceRecover(accountID uint) error {
 		return fmt.Errorf("恢复账号失败: %v", err)
 	}
 
+	observeHealthTransition(account.Status, model.AccountStatusValid)
 	s.log.Info("[%s] 账号已强制恢复", account.Name)
 	scheduler.GetScheduler().Refresh()
 	return nil
@@ -513,7 +527,9 @@ func (s *AccountHealthCheckService) RefreshToken(accountID uint) error {
 
 		// 如果账号状态不是 valid，恢复它
 		if account.Status != model.AccountStatusValid {
-			s.accountRepo.RecoverAccount(accountID)
+			if s.accountRepo.RecoverAccount(accountID) == nil {
+				observeHealthTransition(account.Status, model.AccountStatusValid)
+			}
 		}
 
 		scheduler.GetScheduler().Refresh()
@@ -589,6 +605,7 @@ func (s *AccountHealthCheckService) doNormalCheck() {
 					if err := s.accountRepo.MarkAsSuspended(acc.ID, errMsg); err != nil {
 						s.log.Error("[%s] 标记疑似封号失败: %v", acc.Name, err)
 					} else {
+						observeHealthTransition(acc.Status, model.AccountStatusSuspended)
 						s.log.Warn("[%s] 连续错误达到阈值 %d，标记为疑似封号", acc.Name, threshold)
 						scheduler.GetScheduler().Refresh()
 					}
@@ -722,7 +739,8 @@ func (s *AccountHealthCheckService) clearCooldown(accountID uint) {
 
 // tryReauthorizeWithSessionKey 尝试用 SessionKey 重新授权获取新的 OAuth Token
 // 返回: (是否成功, 错误)
-func (s *AccountHealthCheckService) tryReauthorizeWithSessionKey(ctx context.Context, account *model.Account) (bool, error) {
+func (s *AccountHealthCheckService) tryReauthorizeWithSessionKey(ctx context.Context, account *model.Account) (ok bool, err error) {
+	defer func() { scheduler.ObserveTokenRefresh(account.Type, err) }()
 	oauthService := GetOAuthAuthService()
 
 	tokenResult, err := oauthService.ReauthorizeWithSessionKey(ctx, account)
@@ -0,0 +1,22 @@
+/*
+ * 文件作用：服务层 Prometheus 指标
+ * 负责功能：
+ *   - 健康检查引起的账号状态变更计数
+ * 重要程度：⭐⭐ 辅助（可观测性）
+ * 依赖模块：metrics
+ */
+package service
+
+import "cli-proxy/pkg/metrics"
+
+var healthCheckTransitionsTotal = metrics.NewCounterVec("cliproxy_health_check_transitions_total",
+	"Account status transitions made by the health checker.",
+	"from", "to")
+
+// observeHealthTransition 记录一次健康检查引起的账号状态变更
+func observeHealthTransition(from, to string) {
+	if from == to {
+		return
+	}
+	healthCheckTransitionsTotal.Inc(from, to)
+}
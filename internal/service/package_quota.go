@@ -0,0 +1,262 @@
+/*
+ * 文件作用：套餐额度准入服务，在请求转发前检查并预留套餐额度
+ * 负责功能：
+ *   - 请求前套餐有效性检查（过期/耗尽/禁用）
+ *   - 订阅套餐周期用量惰性重置（条件 UPDATE，不覆盖并发写入的用量）
+ *   - 日/周/月/总额度检查
+ *   - 预估费用预留（共享缓存后端，多实例间一致），防止并发请求超额
+ *   - 按实际费用结算预留
+ * 重要程度：⭐⭐⭐⭐ 重要（计费准入）
+ * 依赖模块：repository, model, cache
+ */
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"cli-proxy/internal/cache"
+	"cli-proxy/internal/model"
+	"cli-proxy/internal/repository"
+	"cli-proxy/pkg/logger"
+)
+
+// 套餐准入错误
+var (
+	ErrPackageNotFound    = errors.New("package not found")
+	ErrPackageDisabled    = errors.New("package is not active")
+	ErrPackageExpired     = errors.New("package has expired")
+	ErrPackageExhausted   = errors.New("package quota exhausted")
+	ErrPackageDailyQuota  = errors.New("package daily quota exceeded")
+	ErrPackageWeeklyQuota = errors.New("package weekly quota exceeded")
+	ErrPackageMonthQuota  = errors.New("package monthly quota exceeded")
+)
+
+const (
+	// 未知模型定价或无法估算时的输出 token 预估
+	defaultEstimatedOutputTokens = 1024
+	// 预估输出 token 上限，避免 max_tokens 很大时预留过多
+	maxEstimatedOutputTokens = 4096
+	// 预留有效期：进程异常退出未结算的预留在此之后自动回收
+	packageReservationTTL = 15 * time.Minute
+)
+
+// packageUsageStore 套餐用量存储（由 UserPackageRepository 实现）
+type packageUsageStore interface {
+	GetByID(id uint) (*model.UserPackage, error)
+	ResetPeriodUsage(id uint, now time.Time) error
+	IncrementUsage(id uint, pkgType string, amount float64) error
+}
+
+// PackageQuotaService 套餐额度准入服务
+type PackageQuotaService struct {
+	userPackageRepo packageUsageStore
+	pricingService  *PricingService
+	counters        cache.Counters // 进行中请求的预留费用（多实例共享）
+	log             *logger.Logger
+}
+
+var (
+	packageQuotaService     *PackageQuotaService
+	packageQuotaServiceOnce sync.Once
+)
+
+// GetPackageQuotaService 获取套餐额度准入服务单例
+func GetPackageQuotaService() *PackageQuotaService {
+	packageQuotaServiceOnce.Do(func() {
+		packageQuotaService = &PackageQuotaService{
+			userPackageRepo: repository.NewUserPackageRepository(),
+			pricingService:  NewPricingService(),
+			counters:        cache.GetSessionCache().Counters(),
+			log:             logger.GetLogger("package_quota"),
+		}
+	})
+	return packageQuotaService
+}
+
+// PackageReservation 套餐额度预留
+// 请求成功后调用 Commit 按实际费用结算，失败时调用 Release 释放预留
+type PackageReservation struct {
+	svc         *PackageQuotaService
+	PackageID   uint
+	PackageType string
+	Amount      float64 // 预留金额
+
+	reservationID string // 共享后端中的预留 ID（为空表示未写入后端）
+	once          sync.Once
+}
+
+// EstimateCost 预估请求费用（已应用倍率）
+// inputBytes: 请求体大小，按 4 字节/token 估算输入
+// maxTokens: 请求中的最大输出 token（0 表示未指定）
+func (s *PackageQuotaService) EstimateCost(ctx context.Context, modelName string, inputBytes, maxTokens int, priceRate float64) float64 {
+	outputTokens := maxTokens
+	if outputTokens <= 0 {
+		outputTokens = defaultEstimatedOutputTokens
+	}
+	if outputTokens > maxEstimatedOutputTokens {
+		outputTokens = maxEstimatedOutputTokens
+	}
+
+	usage := &TokenUsage{
+		InputTokens:  int(float64(inputBytes/4) * priceRate),
+		OutputTokens: int(float64(outputTokens) * priceRate),
+	}
+	cost, err := s.pricingService.CalculateCost(ctx, modelName, usage, 1.0) // 倍率已应用到token，这里用1.0
+	if err != nil {
+		return 0
+	}
+	return cost.TotalCost
+}
+
+// Admit 检查套餐是否可用并预留预估费用
+func (s *PackageQuotaService) Admit(ctx context.Context, packageID uint, estimate float64) (*PackageReservation, error) {
+	up, err := s.userPackageRepo.GetByID(packageID)
+	if err != nil || up == nil {
+		return nil, ErrPackageNotFound
+	}
+
+	// 惰性重置周期用量（条件更新只重置周期列，不覆盖其他请求同时写入的用量）
+	if up.ResetPeriodUsageIfNeeded() {
+		if err := s.userPackageRepo.ResetPeriodUsage(packageID, time.Now()); err != nil {
+			s.log.Warn("重置套餐周期用量失败: packageID=%d, error=%v", packageID, err)
+		}
+	}
+
+	if up.ExpireTime != nil && time.Now().After(*up.ExpireTime) {
+		return nil, ErrPackageExpired
+	}
+	if up.Status == "expired" {
+		return nil, ErrPackageExpired
+	}
+	if up.Status == "exhausted" {
+		return nil, ErrPackageExhausted
+	}
+	if !up.IsValid() {
+		if up.Status != "active" {
+			return nil, ErrPackageDisabled
+		}
+		return nil, ErrPackageExhausted
+	}
+
+	headroom, limited := packageHeadroom(up)
+	if limited && estimate > headroom {
+		return nil, packageQuotaError(up, estimate)
+	}
+	limit := -1.0
+	if limited {
+		limit = headroom
+	}
+
+	reservation := &PackageReservation{
+		svc:         s,
+		PackageID:   packageID,
+		PackageType: up.Type,
+		Amount:      estimate,
+	}
+
+	// 已预留金额也计入，防止并发请求（包括其他实例上的请求）同时通过检查
+	reservationID := newCounterID()
+	ok, reserved, err := s.counters.ReserveAmount(ctx, packageReservationKey(packageID), reservationID, estimate, limit, packageReservationTTL)
+	if err != nil {
+		// 缓存后端不可用时只按已结算用量检查，不阻断请求
+		s.log.Warn("预留套餐额度失败: packageID=%d, error=%v", packageID, err)
+		if !up.CanUse(estimate) {
+			return nil, packageQuotaError(up, estimate)
+		}
+		return reservation, nil
+	}
+	if !ok {
+		return nil, packageQuotaError(up, reserved+estimate)
+	}
+
+	reservation.reservationID = reservationID
+	return reservation, nil
+}
+
+// GetReserved 获取套餐当前预留金额
+func (s *PackageQuotaService) GetReserved(ctx context.Context, packageID uint) (float64, error) {
+	return s.counters.ReservedAmount(ctx, packageReservationKey(packageID))
+}
+
+// unreserve 释放预留金额
+func (r *PackageReservation) unreserve() {
+	if r.reservationID == "" {
+		return
+	}
+	// 请求的 ctx 可能已取消，释放使用独立的 ctx
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.svc.counters.ReleaseAmount(ctx, packageReservationKey(r.PackageID), r.reservationID); err != nil {
+		r.svc.log.Warn("释放套餐预留失败: packageID=%d, error=%v", r.PackageID, err)
+	}
+}
+
+// Commit 按实际费用结算：释放预留并增加套餐使用量
+// 订阅套餐按结算时所在周期累加，准入后跨过日/周/月边界的请求计入新周期
+func (r *PackageReservation) Commit(actualCost float64) error {
+	var err error
+	r.once.Do(func() {
+		// 先写入实际用量再释放预留，避免两者之间出现额度空窗
+		err = r.svc.userPackageRepo.IncrementUsage(r.PackageID, r.PackageType, actualCost)
+		r.unreserve()
+	})
+	return err
+}
+
+// Release 释放预留（请求失败或未产生费用）
+func (r *PackageReservation) Release() {
+	r.once.Do(r.unreserve)
+}
+
+// packageHeadroom 套餐剩余可预留额度（订阅取已配置周期中剩余最少的），limited 为 false 表示不限额
+func packageHeadroom(up *model.UserPackage) (headroom float64, limited bool) {
+	if up.Type == "quota" {
+		return up.QuotaTotal - up.QuotaUsed, true
+	}
+	for _, p := range []struct{ quota, used float64 }{
+		{up.DailyQuota, up.DailyUsed},
+		{up.WeeklyQuota, up.WeeklyUsed},
+		{up.MonthlyQuota, up.MonthlyUsed},
+	} {
+		if p.quota <= 0 {
+			continue
+		}
+		if remaining := p.quota - p.used; !limited || remaining < headroom {
+			headroom, limited = remaining, true
+		}
+	}
+	return headroom, limited
+}
+
+// packageReservationKey 套餐预留在共享后端中的 key
+func packageReservationKey(packageID uint) string {
+	return "package:" + strconv.FormatUint(uint64(packageID), 10)
+}
+
+// newCounterID 生成共享计数中的记录 ID（套餐预留 / 频率窗口命中）
+func newCounterID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// packageQuotaError 根据超限的周期返回对应错误
+func packageQuotaError(up *model.UserPackage, amount float64) error {
+	if up.Type == "subscription" {
+		switch {
+		case up.DailyQuota > 0 && up.DailyUsed+amount > up.DailyQuota:
+			return ErrPackageDailyQuota
+		case up.WeeklyQuota > 0 && up.WeeklyUsed+amount > up.WeeklyQuota:
+			return ErrPackageWeeklyQuota
+		case up.MonthlyQuota > 0 && up.MonthlyUsed+amount > up.MonthlyQuota:
+			return ErrPackageMonthQuota
+		}
+	}
+	return ErrPackageExhausted
+}
@@ -0,0 +1,265 @@
+/*
+ * 文件作用：套餐额度准入服务测试
+ * 负责功能：
+ *   - Admit 状态/额度检查与对应错误
+ *   - 预留计入后续准入、Release/Commit 释放预留、Commit 只结算一次
+ *   - 并发准入与多实例共享预留不超额
+ *   - 跨周期惰性重置
+ * 重要程度：⭐⭐ 辅助（测试）
+ * 依赖模块：cache, model, logger
+ */
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cli-proxy/internal/cache"
+	"cli-proxy/internal/model"
+	"cli-proxy/pkg/logger"
+)
+
+// fakePackageStore 内存套餐用量存储，语义与 UserPackageRepository 的条件更新一致
+type fakePackageStore struct {
+	mu       sync.Mutex
+	packages map[uint]*model.UserPackage
+	resets   int
+}
+
+func (f *fakePackageStore) GetByID(id uint) (*model.UserPackage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	up, ok := f.packages[id]
+	if !ok {
+		return nil, errors.New("record not found")
+	}
+	copied := *up
+	return &copied, nil
+}
+
+func (f *fakePackageStore) ResetPeriodUsage(id uint, now time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.resets++
+	f.addPeriodUsageLocked(id, 0, now)
+	return nil
+}
+
+func (f *fakePackageStore) IncrementUsage(id uint, pkgType string, amount float64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if pkgType == "subscription" {
+		f.addPeriodUsageLocked(id, amount, time.Now())
+	} else {
+		f.packages[id].QuotaUsed += amount
+	}
+	return nil
+}
+
+func (f *fakePackageStore) addPeriodUsageLocked(id uint, amount float64, now time.Time) {
+	up := f.packages[id]
+	day, week, month := model.UsagePeriods(now)
+	if up.LastResetDay != day {
+		up.DailyUsed, up.LastResetDay = 0, day
+	}
+	if up.LastResetWeek != week {
+		up.WeeklyUsed, up.LastResetWeek = 0, week
+	}
+	if up.LastResetMonth != month {
+		up.MonthlyUsed, up.LastResetMonth = 0, month
+	}
+	up.DailyUsed += amount
+	up.WeeklyUsed += amount
+	up.MonthlyUsed += amount
+}
+
+func (f *fakePackageStore) get(id uint) model.UserPackage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return *f.packages[id]
+}
+
+// newTestPackageQuotaService 构造使用内存存储的准入服务：
+// 套餐 1 额度型 10/7，套餐 2 订阅日限额 5，套餐 3 已过期，套餐 4 已禁用，套餐 5 订阅不限额，
+// 套餐 6 订阅昨日已用满日限额（周期未重置）
+func newTestPackageQuotaService(t *testing.T, counters cache.Counters) (*PackageQuotaService, *fakePackageStore) {
+	t.Helper()
+	if err := logger.Init(t.TempDir(), logger.LevelError); err != nil {
+		t.Fatalf("logger.Init: %v", err)
+	}
+
+	now := time.Now()
+	day, week, month := model.UsagePeriods(now)
+	yesterday, _, _ := model.UsagePeriods(now.AddDate(0, 0, -1))
+	expired := now.Add(-time.Hour)
+	subscription := func(id uint, daily float64) *model.UserPackage {
+		return &model.UserPackage{ID: id, Type: "subscription", Status: "active", DailyQuota: daily,
+			LastResetDay: day, LastResetWeek: week, LastResetMonth: month}
+	}
+
+	store := &fakePackageStore{packages: map[uint]*model.UserPackage{
+		1: {ID: 1, Type: "quota", Status: "active", QuotaTotal: 10, QuotaUsed: 7},
+		2: subscription(2, 5),
+		3: {ID: 3, Type: "quota", Status: "active", QuotaTotal: 10, ExpireTime: &expired},
+		4: {ID: 4, Type: "quota", Status: "disabled", QuotaTotal: 10},
+		5: subscription(5, 0),
+		6: subscription(6, 5),
+	}}
+	store.packages[6].DailyUsed = 5
+	store.packages[6].LastResetDay = yesterday
+
+	return &PackageQuotaService{
+		userPackageRepo: store,
+		counters:        counters,
+		log:             logger.GetLogger("package_quota"),
+	}, store
+}
+
+func TestPackageQuotaAdmit(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name      string
+		packageID uint
+		estimates []float64 // 依次准入且不释放
+		wantErr   error     // 最后一次准入的结果
+	}{
+		{name: "not found", packageID: 99, estimates: []float64{1}, wantErr: ErrPackageNotFound},
+		{name: "expired", packageID: 3, estimates: []float64{1}, wantErr: ErrPackageExpired},
+		{name: "disabled", packageID: 4, estimates: []float64{1}, wantErr: ErrPackageDisabled},
+		{name: "quota within headroom", packageID: 1, estimates: []float64{1.5, 1.5}},
+		{name: "quota reservations exceed headroom", packageID: 1, estimates: []float64{2, 2}, wantErr: ErrPackageExhausted},
+		{name: "single estimate exceeds headroom", packageID: 1, estimates: []float64{3.5}, wantErr: ErrPackageExhausted},
+		{name: "daily quota exceeded", packageID: 2, estimates: []float64{3, 3}, wantErr: ErrPackageDailyQuota},
+		{name: "unlimited subscription", packageID: 5, estimates: []float64{100, 100}},
+		{name: "stale period is reset", packageID: 6, estimates: []float64{4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, _ := newTestPackageQuotaService(t, cache.NewMemoryCounters())
+			var err error
+			for i, estimate := range tt.estimates {
+				_, err = svc.Admit(ctx, tt.packageID, estimate)
+				if i < len(tt.estimates)-1 && err != nil {
+					t.Fatalf("admit %d: %v", i, err)
+				}
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Admit error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPackageQuotaCommitAndRelease(t *testing.T) {
+	ctx := context.Background()
+	svc, store := newTestPackageQuotaService(t, cache.NewMemoryCounters())
+
+	first, err := svc.Admit(ctx, 1, 2)
+	if err != nil {
+		t.Fatalf("admit first: %v", err)
+	}
+	second, err := svc.Admit(ctx, 1, 1)
+	if err != nil {
+		t.Fatalf("admit second: %v", err)
+	}
+	if reserved, _ := svc.GetReserved(ctx, 1); reserved != 3 {
+		t.Errorf("reserved = %v, want 3", reserved)
+	}
+
+	// Release 释放预留且不计用量，重复调用无副作用
+	first.Release()
+	first.Release()
+	if reserved, _ := svc.GetReserved(ctx, 1); reserved != 1 {
+		t.Errorf("reserved after release = %v, want 1", reserved)
+	}
+
+	// Commit 按实际费用结算并释放预留，只结算一次
+	if err := second.Commit(1.25); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if err := second.Commit(1.25); err != nil {
+		t.Fatalf("second commit: %v", err)
+	}
+	second.Release()
+	if got := store.get(1).QuotaUsed; got != 8.25 {
+		t.Errorf("QuotaUsed = %v, want 8.25", got)
+	}
+	if reserved, _ := svc.GetReserved(ctx, 1); reserved != 0 {
+		t.Errorf("reserved after commit = %v, want 0", reserved)
+	}
+
+	// 剩余 1.75：结算后的用量计入后续准入
+	if _, err := svc.Admit(ctx, 1, 2); !errors.Is(err, ErrPackageExhausted) {
+		t.Errorf("admit over remaining = %v, want %v", err, ErrPackageExhausted)
+	}
+	if _, err := svc.Admit(ctx, 1, 1.75); err != nil {
+		t.Errorf("admit remaining: %v", err)
+	}
+}
+
+func TestPackageQuotaPeriodRollover(t *testing.T) {
+	ctx := context.Background()
+	svc, store := newTestPackageQuotaService(t, cache.NewMemoryCounters())
+
+	r, err := svc.Admit(ctx, 6, 4)
+	if err != nil {
+		t.Fatalf("admit: %v", err)
+	}
+	if store.resets != 1 {
+		t.Errorf("ResetPeriodUsage calls = %d, want 1", store.resets)
+	}
+	if err := r.Commit(2); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	day, _, _ := model.UsagePeriods(time.Now())
+	if up := store.get(6); up.DailyUsed != 2 || up.LastResetDay != day {
+		t.Errorf("after commit DailyUsed=%v LastResetDay=%q, want 2 %q", up.DailyUsed, up.LastResetDay, day)
+	}
+
+	// 已在当前周期内，不再重置
+	if _, err := svc.Admit(ctx, 6, 1); err != nil {
+		t.Fatalf("admit in current period: %v", err)
+	}
+	if store.resets != 1 {
+		t.Errorf("ResetPeriodUsage calls = %d, want 1", store.resets)
+	}
+}
+
+func TestPackageQuotaConcurrentAdmit(t *testing.T) {
+	ctx := context.Background()
+	counters := cache.NewMemoryCounters()
+
+	// 两个服务实例共用同一计数后端，模拟多副本部署
+	replicaA, _ := newTestPackageQuotaService(t, counters)
+	replicaB, _ := newTestPackageQuotaService(t, counters)
+
+	var admitted atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		svc := replicaA
+		if i%2 == 1 {
+			svc = replicaB
+		}
+		go func() {
+			defer wg.Done()
+			// 套餐 2 日限额 5，每次预留 1
+			if _, err := svc.Admit(ctx, 2, 1); err == nil {
+				admitted.Add(1)
+			} else if !errors.Is(err, ErrPackageDailyQuota) {
+				t.Errorf("Admit error = %v, want %v", err, ErrPackageDailyQuota)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := admitted.Load(); got != 5 {
+		t.Errorf("admitted = %d, want 5", got)
+	}
+}
@@ -6,6 +6,7 @@
  *   - 缓存Token特殊定价
  *   - 费率倍率应用
  *   - 费用明细分解
+ *   - 提示缓存命中率与节省统计
  * 重要程度：⭐⭐⭐⭐ 重要（计费核心）
  * 依赖模块：repository, model
  */
@@ -13,6 +14,9 @@ package service
 
 import (
 	"context"
+	"sort"
+	"strings"
+	"time"
 
 	"cli-proxy/internal/model"
 	"cli-proxy/internal/repository"
@@ -75,6 +79,45 @@ func (s *PricingService) IsModelEnabled(ctx context.Context, modelName string) (
 	return aiModel.Enabled, true, nil
 }
 
+// GetFallbackModels 获取模型的回退链（按名称或别名精确匹配），未配置时返回 nil
+func (s *PricingService) GetFallbackModels(ctx context.Context, modelName string) ([]string, error) {
+	var models []model.AIModel
+	err := s.db.WithContext(ctx).
+		Where("enabled = ? AND fallback_models IS NOT NULL AND fallback_models <> ''", true).
+		Find(&models).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range models {
+		if m.Name == modelName || containsModelName(m.Aliases, modelName) {
+			return splitModelNames(m.FallbackModels), nil
+		}
+	}
+	return nil, nil
+}
+
+// containsModelName 逗号分隔的模型名列表中是否包含指定模型
+func containsModelName(list, modelName string) bool {
+	for _, name := range splitModelNames(list) {
+		if name == modelName {
+			return true
+		}
+	}
+	return false
+}
+
+// splitModelNames 分割逗号分隔的模型名列表（去除空白和空项）
+func splitModelNames(list string) []string {
+	var result []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
 // CalculateCost 计算请求费用
 // modelName: 模型名称
 // usage: Token 使用量
@@ -127,6 +170,70 @@ func (s *PricingService) CalculateCostWithModel(aiModel *model.AIModel, usage *T
 	}
 }
 
+// GetCacheHitStats 按账户/API Key/模型统计提示缓存命中率和节省费用
+// 节省费用按模型原价计算：缓存读取按输入价计费的差额，扣除缓存创建相对输入价的溢价
+func (s *PricingService) GetCacheHitStats(ctx context.Context, groupBy string, startTime, endTime time.Time) ([]model.CacheHitStats, error) {
+	rows, err := repository.NewRequestLogRepository().GetCacheUsage(groupBy, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	models, err := s.GetAllModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// 模型名/别名 -> 定价（同一模型名只查找一次）
+	pricing := make(map[string]*model.AIModel)
+	findPricing := func(modelName string) *model.AIModel {
+		if m, ok := pricing[modelName]; ok {
+			return m
+		}
+		var found *model.AIModel
+		for i := range models {
+			if models[i].Name == modelName || containsModelName(models[i].Aliases, modelName) {
+				found = &models[i]
+				break
+			}
+		}
+		pricing[modelName] = found
+		return found
+	}
+
+	statsByKey := make(map[string]*model.CacheHitStats)
+	var result []*model.CacheHitStats
+	for _, row := range rows {
+		stats, ok := statsByKey[row.GroupKey]
+		if !ok {
+			stats = &model.CacheHitStats{Key: row.GroupKey, Name: row.GroupName}
+			statsByKey[row.GroupKey] = stats
+			result = append(result, stats)
+		}
+		stats.RequestCount += row.RequestCount
+		stats.InputTokens += row.InputTokens
+		stats.CacheCreationInputTokens += row.CacheCreationInputTokens
+		stats.CacheReadInputTokens += row.CacheReadInputTokens
+
+		// 价格单位是 $/1M tokens
+		if m := findPricing(row.Model); m != nil {
+			readTokens := float64(row.CacheReadInputTokens)
+			createTokens := float64(row.CacheCreationInputTokens)
+			stats.CacheReadCost += readTokens * m.CacheReadPrice / 1000000
+			stats.CacheCreateCost += createTokens * m.CacheCreatePrice / 1000000
+			stats.Savings += (readTokens*(m.InputPrice-m.CacheReadPrice) - createTokens*(m.CacheCreatePrice-m.InputPrice)) / 1000000
+		}
+	}
+
+	list := make([]model.CacheHitStats, 0, len(result))
+	for _, stats := range result {
+		if total := stats.InputTokens + stats.CacheCreationInputTokens + stats.CacheReadInputTokens; total > 0 {
+			stats.HitRate = float64(stats.CacheReadInputTokens) / float64(total)
+		}
+		list = append(list, *stats)
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Savings > list[j].Savings })
+	return list, nil
+}
+
 // GetAllModels 获取所有模型定价
 func (s *PricingService) GetAllModels(ctx context.Context) ([]model.AIModel, error) {
 	var models []model.AIModel
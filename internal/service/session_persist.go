@@ -0,0 +1,170 @@
+/*
+ * 文件作用：会话绑定持久化服务，内存后端下定期将会话粘性绑定快照写入数据库
+ * 负责功能：
+ *   - 启动时从快照恢复会话绑定（部署/重启后保持上游提示缓存命中）
+ *   - 定时快照、关闭前最终快照
+ * 重要程度：⭐⭐⭐ 一般（Redis 后端自身持久化，无需快照）
+ * 依赖模块：cache, repository, config, model, logger
+ */
+package service
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"cli-proxy/internal/cache"
+	"cli-proxy/internal/config"
+	"cli-proxy/internal/model"
+	"cli-proxy/internal/repository"
+	"cli-proxy/pkg/logger"
+)
+
+// SessionPersistService 会话绑定持久化服务
+type SessionPersistService struct {
+	repo *repository.SessionBindingRepository
+	log  *logger.Logger
+
+	mu       sync.Mutex
+	running  bool
+	stopChan chan struct{}
+	done     chan struct{}
+}
+
+var (
+	sessionPersistService *SessionPersistService
+	sessionPersistOnce    sync.Once
+)
+
+// GetSessionPersistService 获取会话绑定持久化服务单例
+func GetSessionPersistService() *SessionPersistService {
+	sessionPersistOnce.Do(func() {
+		sessionPersistService = &SessionPersistService{
+			repo: repository.NewSessionBindingRepository(),
+			log:  logger.GetLogger("session_persist"),
+		}
+	})
+	return sessionPersistService
+}
+
+// Enabled 是否需要快照（仅内存后端且未关闭快照）
+func (s *SessionPersistService) Enabled() bool {
+	return cache.GetSessionCache().BackendName() == cache.BackendMemory &&
+		config.Cfg.Cache.GetSessionPersistInterval() > 0
+}
+
+// Restore 从快照恢复未过期的会话绑定，返回恢复数量
+// 恢复的绑定按当前会话 TTL 重新计时，固定会话保持固定
+func (s *SessionPersistService) Restore(ctx context.Context) (int, error) {
+	records, err := s.repo.ListActive(time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	sessionCache := cache.GetSessionCache()
+	restored := 0
+	for _, r := range records {
+		binding := &cache.SessionBinding{
+			SessionID:  r.SessionID,
+			AccountID:  r.AccountID,
+			Platform:   r.Platform,
+			Model:      r.Model,
+			UserID:     r.UserID,
+			APIKeyID:   r.APIKeyID,
+			ClientIP:   r.ClientIP,
+			UserAgent:  r.UserAgent,
+			BoundAt:    r.BoundAt,
+			LastUsedAt: r.LastUsedAt,
+			Pinned:     r.Pinned,
+		}
+		if err := sessionCache.SetSessionBinding(ctx, binding); err != nil {
+			return restored, err
+		}
+		restored++
+	}
+	return restored, nil
+}
+
+// Start 启动定时快照
+func (s *SessionPersistService) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return
+	}
+	s.running = true
+	s.stopChan = make(chan struct{})
+	s.done = make(chan struct{})
+
+	interval := time.Duration(config.Cfg.Cache.GetSessionPersistInterval()) * time.Second
+	go s.loop(interval, s.stopChan, s.done)
+	s.log.Info("会话绑定快照已启动 | 间隔: %v", interval)
+}
+
+// Stop 停止定时快照并写入最终快照
+func (s *SessionPersistService) Stop() {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.running = false
+	close(s.stopChan)
+	done := s.done
+	s.mu.Unlock()
+
+	<-done
+	if count, err := s.Snapshot(context.Background()); err != nil {
+		s.log.Error("写入会话绑定快照失败: %v", err)
+	} else {
+		s.log.Info("会话绑定快照已写入 | 数量: %d", count)
+	}
+}
+
+func (s *SessionPersistService) loop(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := s.Snapshot(context.Background()); err != nil {
+				s.log.Error("写入会话绑定快照失败: %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Snapshot 将当前全部会话绑定写入快照，返回写入数量
+func (s *SessionPersistService) Snapshot(ctx context.Context) (int, error) {
+	bindings, _, err := cache.GetSessionCache().ListAllSessions(ctx, 0, math.MaxInt32)
+	if err != nil {
+		return 0, err
+	}
+
+	records := make([]model.SessionBindingRecord, 0, len(bindings))
+	for _, b := range bindings {
+		records = append(records, model.SessionBindingRecord{
+			SessionID:  b.SessionID,
+			AccountID:  b.AccountID,
+			Platform:   b.Platform,
+			Model:      b.Model,
+			UserID:     b.UserID,
+			APIKeyID:   b.APIKeyID,
+			ClientIP:   b.ClientIP,
+			UserAgent:  b.UserAgent,
+			BoundAt:    b.BoundAt,
+			LastUsedAt: b.LastUsedAt,
+			ExpireAt:   b.ExpireAt,
+			Pinned:     b.Pinned,
+		})
+	}
+	if err := s.repo.ReplaceAll(records); err != nil {
+		return 0, err
+	}
+	return len(records), nil
+}
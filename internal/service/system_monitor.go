@@ -3,12 +3,15 @@
  * 负责功能：
  *   - 系统资源统计（CPU/内存/磁盘）
  *   - Redis缓存统计
+ *   - 并发排队统计
+ *   - 账户熔断器状态
+ *   - 请求对冲统计
  *   - MySQL连接统计
  *   - 账号/用户数量统计
  *   - 今日使用量统计
  *   - 完整监控数据聚合
  * 重要程度：⭐⭐⭐ 一般（运维监控）
- * 依赖模块：cache, repository, gopsutil
+ * 依赖模块：cache, scheduler, repository, gopsutil
  */
 package service
 
@@ -20,6 +23,7 @@ import (
 
 	"cli-proxy/internal/cache"
 	"cli-proxy/internal/model"
+	"cli-proxy/internal/proxy/scheduler"
 	"cli-proxy/internal/repository"
 
 	"github.com/shirou/gopsutil/v3/cpu"
@@ -35,7 +39,7 @@ type SystemMonitorService struct {
 	userRepo       *repository.UserRepository
 	dailyUsageRepo *repository.DailyUsageRepository
 	requestLogRepo *repository.RequestLogRepository
-	memoryCache    *cache.MemoryCache
+	sessionCache   *cache.SessionCache
 }
 
 // NewSystemMonitorService 创建系统监控服务
@@ -46,7 +50,7 @@ func NewSystemMonitorService() *SystemMonitorService {
 		userRepo:       repository.NewUserRepository(),
 		dailyUsageRepo: repository.NewDailyUsageRepository(),
 		requestLogRepo: repository.NewRequestLogRepository(),
-		memoryCache:    cache.GetMemoryCache(),
+		sessionCache:   cache.GetSessionCache(),
 	}
 }
 
@@ -71,11 +75,12 @@ type SystemStats struct {
 
 // MemoryCacheStats 内存缓存统计（替代原 Redis 统计）
 type MemoryCacheStats struct {
-	SessionCount            int  `json:"session_count"`             // 会话数量
-	UnavailableCount        int  `json:"unavailable_count"`         // 不可用账户数量
-	AccountConcurrencyCount int  `json:"account_concurrency_count"` // 账户并发数
-	UserConcurrencyCount    int  `json:"user_concurrency_count"`    // 用户并发数
-	Connected               bool `json:"connected"`                 // 是否可用（内存缓存始终可用）
+	SessionCount            int    `json:"session_count"`             // 会话数量
+	UnavailableCount        int    `json:"unavailable_count"`         // 不可用账户数量
+	AccountConcurrencyCount int    `json:"account_concurrency_count"` // 账户并发数
+	UserConcurrencyCount    int    `json:"user_concurrency_count"`    // 用户并发数
+	Connected               bool   `json:"connected"`                 // 是否可用（内存缓存始终可用）
+	Backend                 string `json:"backend"`                   // 后端类型：memory / redis
 }
 
 // MySQLStats MySQL 统计
@@ -126,14 +131,18 @@ type TotalUsageStats struct {
 
 // MonitorData 完整监控数据
 type MonitorData struct {
-	System     SystemStats      `json:"system"`
-	Cache      MemoryCacheStats `json:"cache"` // 替代原 Redis
-	MySQL      MySQLStats       `json:"mysql"`
-	Accounts   AccountStats     `json:"accounts"`
-	Users      UserStats        `json:"users"`
-	TodayUsage TodayUsageStats  `json:"today_usage"`
-	TotalUsage TotalUsageStats  `json:"total_usage"` // 总使用统计
-	UpdatedAt  time.Time        `json:"updated_at"`
+	System     SystemStats                    `json:"system"`
+	Cache      MemoryCacheStats               `json:"cache"`           // 替代原 Redis
+	Queue      *cache.QueueStats              `json:"queue"`           // 并发排队
+	Breakers   *scheduler.BreakerStats        `json:"breakers"`        // 账户熔断
+	Hedge      *scheduler.HedgeStats          `json:"hedge"`           // 请求对冲
+	Prefix     *scheduler.PrefixAffinityStats `json:"prefix_affinity"` // 提示前缀亲和
+	MySQL      MySQLStats                     `json:"mysql"`
+	Accounts   AccountStats                   `json:"accounts"`
+	Users      UserStats                      `json:"users"`
+	TodayUsage TodayUsageStats                `json:"today_usage"`
+	TotalUsage TotalUsageStats                `json:"total_usage"` // 总使用统计
+	UpdatedAt  time.Time                      `json:"updated_at"`
 }
 
 // GetMonitorData 获取完整监控数据
@@ -145,6 +154,10 @@ func (s *SystemMonitorService) GetMonitorData(ctx context.Context) (*MonitorData
 	// 并行获取各项数据
 	data.System = s.GetSystemStats()
 	data.Cache = s.GetCacheStats()
+	data.Queue = s.GetQueueStats()
+	data.Breakers = s.GetBreakerStats()
+	data.Hedge = s.GetHedgeStats()
+	data.Prefix = s.GetPrefixAffinityStats()
 	data.MySQL = s.GetMySQLStats()
 	data.Accounts = s.GetAccountStats()
 	data.Users = s.GetUserStats()
@@ -184,31 +197,51 @@ func (s *SystemMonitorService) GetSystemStats() SystemStats {
 	return stats
 }
 
-// GetCacheStats 获取内存缓存统计（替代原 GetRedisStats）
+// GetCacheStats 获取缓存后端统计（替代原 GetRedisStats）
 func (s *SystemMonitorService) GetCacheStats() MemoryCacheStats {
 	stats := MemoryCacheStats{
 		Connected: true, // 内存缓存始终可用
 	}
 
-	if s.memoryCache != nil {
-		cacheStats := s.memoryCache.Stats()
-		if v, ok := cacheStats["session_count"].(int); ok {
-			stats.SessionCount = v
-		}
-		if v, ok := cacheStats["unavailable_count"].(int); ok {
-			stats.UnavailableCount = v
-		}
-		if v, ok := cacheStats["account_concurrency_count"].(int); ok {
-			stats.AccountConcurrencyCount = v
-		}
-		if v, ok := cacheStats["user_concurrency_count"].(int); ok {
-			stats.UserConcurrencyCount = v
+	if s.sessionCache != nil {
+		stats.Backend = s.sessionCache.BackendName()
+		cacheStats, err := s.sessionCache.Stats(context.Background())
+		if err != nil {
+			stats.Connected = false
+			return stats
 		}
+		stats.SessionCount = cacheStats.SessionCount
+		stats.UnavailableCount = cacheStats.UnavailableCount
+		stats.AccountConcurrencyCount = cacheStats.AccountConcurrencyCount
+		stats.UserConcurrencyCount = cacheStats.UserConcurrencyCount
 	}
 
 	return stats
 }
 
+// GetQueueStats 获取并发排队统计（队列深度、等待时长）
+func (s *SystemMonitorService) GetQueueStats() *cache.QueueStats {
+	if s.sessionCache == nil {
+		return nil
+	}
+	return s.sessionCache.QueueStats()
+}
+
+// GetBreakerStats 获取账户熔断器状态及最近的状态变更
+func (s *SystemMonitorService) GetBreakerStats() *scheduler.BreakerStats {
+	return scheduler.GetScheduler().GetBreakerStats()
+}
+
+// GetHedgeStats 获取非流式请求对冲统计（触发/获胜次数）
+func (s *SystemMonitorService) GetHedgeStats() *scheduler.HedgeStats {
+	return scheduler.GetScheduler().GetHedgeStats()
+}
+
+// GetPrefixAffinityStats 获取提示前缀亲和统计（记录数/命中/未命中）
+func (s *SystemMonitorService) GetPrefixAffinityStats() *scheduler.PrefixAffinityStats {
+	return scheduler.GetScheduler().GetPrefixAffinityStats()
+}
+
 // GetMySQLStats 获取 MySQL 统计
 func (s *SystemMonitorService) GetMySQLStats() MySQLStats {
 	stats := MySQLStats{}
@@ -78,11 +78,12 @@ type AdminCreateUserRequest struct {
 }
 
 type UpdateUserRequest struct {
-	Email          string   `json:"email" binding:"omitempty,email"`
-	Status         string   `json:"status" binding:"omitempty,oneof=active disabled"`
-	Role           string   `json:"role" binding:"omitempty,oneof=admin user"`
-	PriceRate      *float64 `json:"price_rate"`      // 使用指针以区分是否传入
-	MaxConcurrency *int     `json:"max_concurrency"` // 使用指针以区分是否传入
+	Email           string   `json:"email" binding:"omitempty,email"`
+	Status          string   `json:"status" binding:"omitempty,oneof=active disabled"`
+	Role            string   `json:"role" binding:"omitempty,oneof=admin user"`
+	PriceRate       *float64 `json:"price_rate"`        // 使用指针以区分是否传入
+	MaxConcurrency  *int     `json:"max_concurrency"`   // 使用指针以区分是否传入
+	AccountGroupIDs *string  `json:"account_group_ids"` // 绑定的账户分组ID（逗号分隔，空字符串表示解除绑定）
 }
 
 type ChangePasswordRequest struct {
@@ -241,6 +242,13 @@ func (s *UserService) Update(id uint, req *UpdateUserRequest) (*model.User, erro
 	if req.MaxConcurrency != nil {
 		user.MaxConcurrency = *req.MaxConcurrency
 	}
+	if req.AccountGroupIDs != nil {
+		groupIDs, err := NewAccountService().NormalizeAccountGroupIDs(*req.AccountGroupIDs)
+		if err != nil {
+			return nil, err
+		}
+		user.AccountGroupIDs = groupIDs
+	}
 
 	if err := s.repo.Update(user); err != nil {
 		return nil, err
@@ -0,0 +1,281 @@
+/*
+ * 文件作用：凭证信封加密，使用 AES-GCM 加密上游账户凭证等敏感字段
+ * 负责功能：
+ *   - 信封加密：每个值使用随机数据密钥加密，数据密钥再由主密钥加密
+ *   - 多主密钥解密（轮换期间旧密钥仍可解密）
+ *   - 密钥轮换：仅用新主密钥重新包装数据密钥，密文本身不变
+ *   - 主密钥解析（base64 / hex，32 字节）与全局密钥环
+ * 重要程度：⭐⭐⭐⭐ 重要（凭证存储安全）
+ * 依赖模块：无
+ */
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// 密文格式：enc:v1:<主密钥ID>:<base64(包装后的数据密钥)>:<base64(nonce+密文)>
+const (
+	prefix   = "enc:v1:"
+	keySize  = 32 // AES-256
+	keyIDLen = 8
+)
+
+var (
+	ErrNoKey        = errors.New("credential encryption key not configured")
+	ErrUnknownKey   = errors.New("credential encrypted with unknown master key")
+	ErrInvalidValue = errors.New("invalid encrypted credential")
+)
+
+// masterKey 主密钥
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+func newMasterKey(key []byte) (*masterKey, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", keySize, len(key))
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	return &masterKey{id: hex.EncodeToString(sum[:])[:keyIDLen], aead: aead}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Keyring 密钥环：当前主密钥用于加密，全部主密钥均可用于解密
+type Keyring struct {
+	primary *masterKey
+	keys    map[string]*masterKey
+}
+
+// NewKeyring 创建密钥环，primary 为当前主密钥，previous 为轮换前的旧主密钥
+func NewKeyring(primary []byte, previous ...[]byte) (*Keyring, error) {
+	pk, err := newMasterKey(primary)
+	if err != nil {
+		return nil, err
+	}
+	k := &Keyring{primary: pk, keys: map[string]*masterKey{pk.id: pk}}
+	for _, key := range previous {
+		mk, err := newMasterKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("previous key: %w", err)
+		}
+		if _, exists := k.keys[mk.id]; !exists {
+			k.keys[mk.id] = mk
+		}
+	}
+	return k, nil
+}
+
+// PrimaryKeyID 当前主密钥ID（用于日志和判断是否需要轮换）
+func (k *Keyring) PrimaryKeyID() string {
+	return k.primary.id
+}
+
+// Encrypt 加密，空串原样返回
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := seal(k.primary.aead, dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(aead, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return prefix + k.primary.id + ":" + base64.StdEncoding.EncodeToString(wrapped) + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt 解密，未加密的值（迁移前的明文）原样返回
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	_, dataKey, sealed, err := k.open(value)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := unseal(aead, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Rewrap 用当前主密钥重新包装数据密钥，已是当前主密钥时返回 changed=false
+// 明文值会被加密（用于迁移）
+func (k *Keyring) Rewrap(value string) (result string, changed bool, err error) {
+	if value == "" {
+		return value, false, nil
+	}
+	if !IsEncrypted(value) {
+		result, err = k.Encrypt(value)
+		return result, err == nil, err
+	}
+	keyID, dataKey, sealed, err := k.open(value)
+	if err != nil {
+		return "", false, err
+	}
+	if keyID == k.primary.id {
+		return value, false, nil
+	}
+	wrapped, err := seal(k.primary.aead, dataKey)
+	if err != nil {
+		return "", false, err
+	}
+	return prefix + k.primary.id + ":" + base64.StdEncoding.EncodeToString(wrapped) + ":" + base64.StdEncoding.EncodeToString(sealed), true, nil
+}
+
+// open 解析密文并解包数据密钥
+func (k *Keyring) open(value string) (keyID string, dataKey, sealed []byte, err error) {
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, ErrInvalidValue
+	}
+	mk, ok := k.keys[parts[0]]
+	if !ok {
+		return "", nil, nil, fmt.Errorf("%w: %s", ErrUnknownKey, parts[0])
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, ErrInvalidValue
+	}
+	if sealed, err = base64.StdEncoding.DecodeString(parts[2]); err != nil {
+		return "", nil, nil, ErrInvalidValue
+	}
+	if dataKey, err = unseal(mk.aead, wrapped); err != nil {
+		return "", nil, nil, err
+	}
+	return mk.id, dataKey, sealed, nil
+}
+
+// seal 加密并在前面拼接随机 nonce
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func unseal(aead cipher.AEAD, data []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, ErrInvalidValue
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrInvalidValue
+	}
+	return plaintext, nil
+}
+
+// IsEncrypted 值是否为加密格式
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// ParseKey 解析主密钥（base64 或 hex 编码的 32 字节）
+func ParseKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if key, err := base64.StdEncoding.DecodeString(encoded); err == nil && len(key) == keySize {
+		return key, nil
+	}
+	if key, err := hex.DecodeString(encoded); err == nil && len(key) == keySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("master key must be %d bytes encoded as base64 or hex", keySize)
+}
+
+// LoadKey 读取主密钥：优先使用 value，为空时读取 file；两者都为空时返回 nil
+func LoadKey(value, file string) ([]byte, error) {
+	if value == "" && file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read key file: %w", err)
+		}
+		value = string(data)
+	}
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	return ParseKey(value)
+}
+
+// GenerateKey 生成随机主密钥（base64 编码）
+func GenerateKey() (string, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// ==================== 全局密钥环 ====================
+
+var defaultKeyring atomic.Pointer[Keyring]
+
+// SetDefault 设置全局密钥环，nil 表示关闭加密
+func SetDefault(k *Keyring) {
+	defaultKeyring.Store(k)
+}
+
+// Default 获取全局密钥环（未配置时为 nil）
+func Default() *Keyring {
+	return defaultKeyring.Load()
+}
+
+// Encrypt 使用全局密钥环加密，未配置密钥时原样返回（不加密）
+func Encrypt(plaintext string) (string, error) {
+	k := Default()
+	if k == nil {
+		return plaintext, nil
+	}
+	return k.Encrypt(plaintext)
+}
+
+// Decrypt 使用全局密钥环解密，明文原样返回；密文但未配置密钥时返回 ErrNoKey
+func Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	k := Default()
+	if k == nil {
+		return "", ErrNoKey
+	}
+	return k.Decrypt(value)
+}
@@ -0,0 +1,269 @@
+/*
+ * 文件作用：凭证信封加密测试
+ * 负责功能：
+ *   - 加解密往返、明文直通、全局密钥环
+ *   - 密钥轮换（Rewrap 只重新包装数据密钥）
+ *   - 篡改检测（nonce / 密文 / 包装密钥）与未知主密钥
+ *   - 主密钥解析
+ * 重要程度：⭐⭐ 辅助（测试）
+ * 依赖模块：无
+ */
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testKey 生成确定性的 32 字节主密钥
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, keySize)
+}
+
+func testKeyring(t *testing.T, primary []byte, previous ...[]byte) *Keyring {
+	t.Helper()
+	k, err := NewKeyring(primary, previous...)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	return k
+}
+
+// splitValue 拆分密文为 主密钥ID / 包装数据密钥 / nonce+密文
+func splitValue(t *testing.T, value string) (keyID string, wrapped, sealed []byte) {
+	t.Helper()
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		t.Fatalf("unexpected encrypted value %q", value)
+	}
+	wrapped, _ = base64.StdEncoding.DecodeString(parts[1])
+	sealed, _ = base64.StdEncoding.DecodeString(parts[2])
+	return parts[0], wrapped, sealed
+}
+
+func joinValue(keyID string, wrapped, sealed []byte) string {
+	return prefix + keyID + ":" + base64.StdEncoding.EncodeToString(wrapped) + ":" + base64.StdEncoding.EncodeToString(sealed)
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	k := testKeyring(t, testKey(1))
+
+	tests := []struct {
+		name      string
+		plaintext string
+	}{
+		{name: "api key", plaintext: "sk-ant-REDACTED"},
+		{name: "oauth json", plaintext: `{"access_token":"ya29.a0","refresh_token":"1//0g","expiry":"2026-01-01T00:00:00Z"}`},
+		{name: "unicode", plaintext: "密码：p@ss:word"},
+		{name: "empty", plaintext: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted, err := k.Encrypt(tt.plaintext)
+			if err != nil {
+				t.Fatalf("Encrypt: %v", err)
+			}
+			if tt.plaintext == "" {
+				if encrypted != "" {
+					t.Fatalf("Encrypt(\"\") = %q, want empty", encrypted)
+				}
+				return
+			}
+			if !IsEncrypted(encrypted) || strings.Contains(encrypted, tt.plaintext) {
+				t.Fatalf("Encrypt = %q, want opaque %q-prefixed value", encrypted, prefix)
+			}
+			if keyID, _, _ := splitValue(t, encrypted); keyID != k.PrimaryKeyID() {
+				t.Errorf("key id = %q, want %q", keyID, k.PrimaryKeyID())
+			}
+
+			// 每次加密使用新的数据密钥和 nonce
+			again, _ := k.Encrypt(tt.plaintext)
+			if again == encrypted {
+				t.Error("two encryptions of the same value are identical")
+			}
+
+			for _, value := range []string{encrypted, again} {
+				got, err := k.Decrypt(value)
+				if err != nil || got != tt.plaintext {
+					t.Errorf("Decrypt = (%q, %v), want %q", got, err, tt.plaintext)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyringPlaintextPassthrough(t *testing.T) {
+	k := testKeyring(t, testKey(1))
+	for _, value := range []string{"", "sk-legacy-plaintext", "enc:v2:not-this-format"} {
+		if got, err := k.Decrypt(value); err != nil || got != value {
+			t.Errorf("Decrypt(%q) = (%q, %v), want unchanged", value, got, err)
+		}
+	}
+}
+
+func TestKeyringRewrap(t *testing.T) {
+	oldKey, newKey := testKey(1), testKey(2)
+	old := testKeyring(t, oldKey)
+	rotated := testKeyring(t, newKey, oldKey)
+	newOnly := testKeyring(t, newKey)
+
+	encrypted, err := old.Encrypt("sk-rotate-me")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	// 轮换期间旧密文仍可解密
+	if got, err := rotated.Decrypt(encrypted); err != nil || got != "sk-rotate-me" {
+		t.Fatalf("rotated Decrypt = (%q, %v)", got, err)
+	}
+
+	rewrapped, changed, err := rotated.Rewrap(encrypted)
+	if err != nil || !changed {
+		t.Fatalf("Rewrap = (changed=%v, %v), want changed", changed, err)
+	}
+	oldID, _, oldSealed := splitValue(t, encrypted)
+	newID, _, newSealed := splitValue(t, rewrapped)
+	if newID != rotated.PrimaryKeyID() || newID == oldID {
+		t.Errorf("rewrapped key id = %q, want %q", newID, rotated.PrimaryKeyID())
+	}
+	if !bytes.Equal(oldSealed, newSealed) {
+		t.Error("Rewrap changed the sealed payload, want only the data key rewrapped")
+	}
+
+	// 去掉旧主密钥后仍可解密，旧密钥环则无法解密
+	if got, err := newOnly.Decrypt(rewrapped); err != nil || got != "sk-rotate-me" {
+		t.Errorf("new-only Decrypt = (%q, %v)", got, err)
+	}
+	if _, err := old.Decrypt(rewrapped); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("old Decrypt error = %v, want %v", err, ErrUnknownKey)
+	}
+
+	tests := []struct {
+		name        string
+		value       string
+		wantChanged bool
+	}{
+		{name: "already primary", value: rewrapped, wantChanged: false},
+		{name: "empty", value: "", wantChanged: false},
+		{name: "plaintext is encrypted", value: "sk-legacy", wantChanged: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed, err := rotated.Rewrap(tt.value)
+			if err != nil || changed != tt.wantChanged {
+				t.Fatalf("Rewrap(%q) = (changed=%v, %v), want changed=%v", tt.value, changed, err, tt.wantChanged)
+			}
+			if !changed && got != tt.value {
+				t.Errorf("Rewrap(%q) = %q, want unchanged", tt.value, got)
+			}
+			if plaintext, err := rotated.Decrypt(got); err != nil || (tt.value != "" && !IsEncrypted(tt.value) && plaintext != tt.value) {
+				t.Errorf("Decrypt(Rewrap(%q)) = (%q, %v)", tt.value, plaintext, err)
+			}
+		})
+	}
+}
+
+func TestKeyringTamperDetection(t *testing.T) {
+	k := testKeyring(t, testKey(1))
+	encrypted, err := k.Encrypt("sk-tamper")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	keyID, wrapped, sealed := splitValue(t, encrypted)
+
+	flip := func(b []byte, i int) []byte {
+		c := append([]byte(nil), b...)
+		c[i] ^= 0x01
+		return c
+	}
+
+	// Rewrap 只解包数据密钥，密文部分的篡改留给 Decrypt 检测
+	tests := []struct {
+		name          string
+		value         string
+		wantErr       error
+		wantRewrapErr bool
+	}{
+		{name: "nonce", value: joinValue(keyID, wrapped, flip(sealed, 0)), wantErr: ErrInvalidValue},
+		{name: "ciphertext", value: joinValue(keyID, wrapped, flip(sealed, len(sealed)-1)), wantErr: ErrInvalidValue},
+		{name: "truncated ciphertext", value: joinValue(keyID, wrapped, sealed[:4]), wantErr: ErrInvalidValue},
+		{name: "wrapped key nonce", value: joinValue(keyID, flip(wrapped, 0), sealed), wantErr: ErrInvalidValue, wantRewrapErr: true},
+		{name: "wrapped key", value: joinValue(keyID, flip(wrapped, len(wrapped)-1), sealed), wantErr: ErrInvalidValue, wantRewrapErr: true},
+		{name: "bad base64", value: prefix + keyID + ":!!!:" + base64.StdEncoding.EncodeToString(sealed), wantErr: ErrInvalidValue, wantRewrapErr: true},
+		{name: "missing part", value: prefix + keyID + ":" + base64.StdEncoding.EncodeToString(wrapped), wantErr: ErrInvalidValue, wantRewrapErr: true},
+		{name: "unknown key id", value: joinValue("deadbeef", wrapped, sealed), wantErr: ErrUnknownKey, wantRewrapErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := k.Decrypt(tt.value); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Decrypt = (%q, %v), want %v", got, err, tt.wantErr)
+			}
+			if _, _, err := k.Rewrap(tt.value); tt.wantRewrapErr && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Rewrap error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDefaultKeyring(t *testing.T) {
+	t.Cleanup(func() { SetDefault(nil) })
+
+	// 未配置密钥：明文直通，密文无法解密
+	SetDefault(nil)
+	if got, err := Encrypt("sk-plain"); err != nil || got != "sk-plain" {
+		t.Errorf("Encrypt without key = (%q, %v), want passthrough", got, err)
+	}
+	if got, err := Decrypt("sk-plain"); err != nil || got != "sk-plain" {
+		t.Errorf("Decrypt plaintext without key = (%q, %v), want passthrough", got, err)
+	}
+	encrypted, _ := testKeyring(t, testKey(1)).Encrypt("sk-secret")
+	if _, err := Decrypt(encrypted); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Decrypt without key error = %v, want %v", err, ErrNoKey)
+	}
+
+	SetDefault(testKeyring(t, testKey(1)))
+	if got, err := Decrypt(encrypted); err != nil || got != "sk-secret" {
+		t.Errorf("Decrypt = (%q, %v), want sk-secret", got, err)
+	}
+	value, err := Encrypt("sk-new")
+	if err != nil || !IsEncrypted(value) {
+		t.Fatalf("Encrypt = (%q, %v), want encrypted", value, err)
+	}
+	if got, _ := Decrypt(value); got != "sk-new" {
+		t.Errorf("Decrypt(Encrypt) = %q, want sk-new", got)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	key := testKey(7)
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr bool
+	}{
+		{name: "base64", encoded: base64.StdEncoding.EncodeToString(key)},
+		{name: "hex", encoded: hex.EncodeToString(key)},
+		{name: "surrounding whitespace", encoded: "  " + base64.StdEncoding.EncodeToString(key) + "\n"},
+		{name: "short", encoded: base64.StdEncoding.EncodeToString(key[:16]), wantErr: true},
+		{name: "garbage", encoded: "not-a-key", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseKey(tt.encoded)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKey error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !bytes.Equal(got, key) {
+				t.Errorf("ParseKey = %x, want %x", got, key)
+			}
+		})
+	}
+
+	if _, err := NewKeyring(key[:16]); err == nil {
+		t.Error("NewKeyring accepted a 16-byte key")
+	}
+}
@@ -0,0 +1,256 @@
+/*
+ * 文件作用：JSONPath 子集，用于定位并替换 JSON 文档中的字段（如日志脱敏）
+ * 负责功能：
+ *   - 表达式解析：$、.key、['key']、[*] / .*、[n]、..key、[?(@.key)]、[?(@.key=='value')]
+ *   - 在 json.Unmarshal 得到的文档（map/slice）上原地替换匹配的值
+ * 重要程度：⭐⭐ 辅助（工具库）
+ * 依赖模块：无
+ */
+package jsonpath
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// stepKind 路径步骤类型
+type stepKind int
+
+const (
+	stepChild     stepKind = iota // .key / ['key']
+	stepWildcard                  // .* / [*]
+	stepIndex                     // [n]
+	stepRecursive                 // ..key（任意深度的同名字段）
+	stepFilter                    // [?(@.key)] / [?(@.key=='value')]
+)
+
+type step struct {
+	kind  stepKind
+	key   string
+	index int
+	// 过滤条件
+	filterKey   string
+	filterValue *string // nil 表示只要求字段存在
+}
+
+// Path 编译后的 JSONPath
+type Path struct {
+	raw   string
+	steps []step
+}
+
+// String 返回原始表达式
+func (p *Path) String() string {
+	return p.raw
+}
+
+// Compile 编译 JSONPath 表达式（必须以 $ 开头）
+func Compile(expr string) (*Path, error) {
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("jsonpath %q: must start with $", expr)
+	}
+	p := &Path{raw: expr}
+	rest := expr[1:]
+	for rest != "" {
+		var s step
+		var err error
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			rest = rest[2:]
+			s.kind = stepRecursive
+			s.key, rest = readName(rest)
+			if s.key == "" {
+				err = errors.New("missing field name after ..")
+			}
+		case rest[0] == '.':
+			rest = rest[1:]
+			s.key, rest = readName(rest)
+			switch s.key {
+			case "":
+				err = errors.New("missing field name after .")
+			case "*":
+				s.kind = stepWildcard
+			default:
+				s.kind = stepChild
+			}
+		case rest[0] == '[':
+			end := closingBracket(rest)
+			if end < 0 {
+				err = errors.New("unterminated [")
+				break
+			}
+			s, err = parseBracket(rest[1:end])
+			rest = rest[end+1:]
+		default:
+			err = fmt.Errorf("unexpected %q", rest[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("jsonpath %q: %w", expr, err)
+		}
+		p.steps = append(p.steps, s)
+	}
+	if len(p.steps) == 0 {
+		return nil, fmt.Errorf("jsonpath %q: empty path", expr)
+	}
+	return p, nil
+}
+
+// readName 读取 . 之后的字段名（到下一个 . 或 [ 为止）
+func readName(s string) (name, rest string) {
+	end := strings.IndexAny(s, ".[")
+	if end < 0 {
+		return s, ""
+	}
+	return s[:end], s[end:]
+}
+
+// closingBracket 查找与开头 [ 匹配的 ]（跳过引号内的字符）
+func closingBracket(s string) int {
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+func parseBracket(content string) (step, error) {
+	content = strings.TrimSpace(content)
+	switch {
+	case content == "*":
+		return step{kind: stepWildcard}, nil
+	case strings.HasPrefix(content, "?"):
+		return parseFilter(content[1:])
+	case isQuoted(content):
+		return step{kind: stepChild, key: content[1 : len(content)-1]}, nil
+	}
+	index, err := strconv.Atoi(content)
+	if err != nil || index < 0 {
+		return step{}, fmt.Errorf("invalid subscript [%s]", content)
+	}
+	return step{kind: stepIndex, index: index}, nil
+}
+
+// parseFilter 解析 (@.key) 或 (@.key=='value')
+func parseFilter(expr string) (step, error) {
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, "(") || !strings.HasSuffix(expr, ")") {
+		return step{}, fmt.Errorf("invalid filter %q", expr)
+	}
+	expr = strings.TrimSpace(expr[1 : len(expr)-1])
+	if !strings.HasPrefix(expr, "@.") {
+		return step{}, fmt.Errorf("filter %q must reference @.field", expr)
+	}
+	expr = expr[2:]
+
+	s := step{kind: stepFilter}
+	key, value, hasValue := strings.Cut(expr, "==")
+	s.filterKey = strings.TrimSpace(key)
+	if s.filterKey == "" {
+		return step{}, errors.New("filter missing field name")
+	}
+	if hasValue {
+		value = strings.TrimSpace(value)
+		if !isQuoted(value) {
+			return step{}, fmt.Errorf("filter value %s must be a quoted string", value)
+		}
+		value = value[1 : len(value)-1]
+		s.filterValue = &value
+	}
+	return s, nil
+}
+
+func isQuoted(s string) bool {
+	return len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0]
+}
+
+// Replace 将文档中所有匹配位置的值替换为 value，返回新的根节点和替换次数
+// 文档应为 json.Unmarshal 到 interface{} 的结果，map/slice 原地修改
+func (p *Path) Replace(doc interface{}, value interface{}) (interface{}, int) {
+	count := 0
+	root := replace(doc, p.steps, value, &count)
+	return root, count
+}
+
+// replace 在 node 上应用剩余步骤，返回替换后的 node
+func replace(node interface{}, steps []step, value interface{}, count *int) interface{} {
+	if len(steps) == 0 {
+		*count++
+		return value
+	}
+	s, rest := steps[0], steps[1:]
+
+	switch s.kind {
+	case stepChild:
+		if obj, ok := node.(map[string]interface{}); ok {
+			if child, exists := obj[s.key]; exists {
+				obj[s.key] = replace(child, rest, value, count)
+			}
+		}
+	case stepIndex:
+		if arr, ok := node.([]interface{}); ok && s.index < len(arr) {
+			arr[s.index] = replace(arr[s.index], rest, value, count)
+		}
+	case stepWildcard:
+		eachChild(node, func(child interface{}) interface{} {
+			return replace(child, rest, value, count)
+		})
+	case stepFilter:
+		eachChild(node, func(child interface{}) interface{} {
+			if matchFilter(child, s) {
+				return replace(child, rest, value, count)
+			}
+			return child
+		})
+	case stepRecursive:
+		// 先处理当前节点的同名字段，再递归所有子节点
+		child := step{kind: stepChild, key: s.key}
+		node = replace(node, append([]step{child}, rest...), value, count)
+		eachChild(node, func(c interface{}) interface{} {
+			return replace(c, steps, value, count)
+		})
+	}
+	return node
+}
+
+// eachChild 遍历对象的值或数组的元素，并用 fn 的返回值替换
+func eachChild(node interface{}, fn func(interface{}) interface{}) {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = fn(child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = fn(child)
+		}
+	}
+}
+
+func matchFilter(node interface{}, s step) bool {
+	obj, ok := node.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	field, exists := obj[s.filterKey]
+	if !exists {
+		return false
+	}
+	if s.filterValue == nil {
+		return true
+	}
+	str, ok := field.(string)
+	return ok && str == *s.filterValue
+}
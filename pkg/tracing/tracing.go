@@ -0,0 +1,490 @@
+/*
+ * 文件作用：轻量分布式追踪库，兼容 W3C Trace Context 与 OpenTelemetry OTLP 导出
+ * 负责功能：
+ *   - Span 创建、属性/事件/错误记录（通过 context 传递父子关系）
+ *   - traceparent 请求头解析与生成（与客户端互相传递）
+ *   - 按比例采样（存在上游父 Span 时沿用其采样标记）
+ *   - 批量异步导出（队列满时丢弃，不阻塞请求）
+ * 重要程度：⭐⭐⭐ 一般（可观测性基础设施）
+ * 依赖模块：logger
+ */
+package tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cli-proxy/pkg/logger"
+)
+
+// TraceparentHeader W3C Trace Context 请求头
+const TraceparentHeader = "traceparent"
+
+// 批量导出参数
+const (
+	queueSize     = 2048
+	maxBatchSize  = 512
+	flushInterval = 5 * time.Second
+	exportTimeout = 10 * time.Second
+)
+
+// ==================== SpanContext ====================
+
+// TraceID 追踪ID（16 字节）
+type TraceID [16]byte
+
+// SpanID Span ID（8 字节）
+type SpanID [8]byte
+
+// String 十六进制表示
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// String 十六进制表示
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanContext Span 的传播上下文
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid 追踪ID和 Span ID 均非零时有效
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent 生成 traceparent 请求头值
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent 解析 traceparent 请求头（version-traceid-spanid-flags）
+func ParseTraceparent(value string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	// 版本 00 必须恰好四段
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&0x01 == 0x01
+	return sc, sc.IsValid()
+}
+
+// ==================== Span ====================
+
+// SpanKind Span 类型（取值与 OTLP 一致）
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// Attribute Span 属性
+type Attribute struct {
+	Key   string
+	Value interface{} // string / int64 / float64 / bool
+}
+
+// String 字符串属性
+func String(key, value string) Attribute { return Attribute{Key: key, Value: value} }
+
+// Int 整数属性
+func Int(key string, value int) Attribute { return Attribute{Key: key, Value: int64(value)} }
+
+// Int64 整数属性
+func Int64(key string, value int64) Attribute { return Attribute{Key: key, Value: value} }
+
+// Uint 无符号整数属性（ID 类字段）
+func Uint(key string, value uint) Attribute { return Attribute{Key: key, Value: int64(value)} }
+
+// Float64 浮点属性
+func Float64(key string, value float64) Attribute { return Attribute{Key: key, Value: value} }
+
+// Bool 布尔属性
+func Bool(key string, value bool) Attribute { return Attribute{Key: key, Value: value} }
+
+// Event Span 内的时间点事件
+type Event struct {
+	Name       string
+	Time       time.Time
+	Attributes []Attribute
+}
+
+// SpanData 已结束 Span 的快照（导出用）
+type SpanData struct {
+	Name         string
+	Kind         SpanKind
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID
+	StartTime    time.Time
+	EndTime      time.Time
+	Attributes   []Attribute
+	Events       []Event
+	Error        string // 非空表示 Span 以错误结束
+}
+
+// Span 一个追踪片段，nil Span 的所有方法均为空操作
+type Span struct {
+	mu        sync.Mutex
+	data      SpanData
+	sc        SpanContext
+	recording bool
+	ended     bool
+	provider  *provider
+}
+
+// SpanContext 获取 Span 的传播上下文
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetName 修改 Span 名称（如路由在处理后才确定）
+func (s *Span) SetName(name string) {
+	if s == nil || !s.recording {
+		return
+	}
+	s.mu.Lock()
+	s.data.Name = name
+	s.mu.Unlock()
+}
+
+// SetAttributes 设置属性
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil || !s.recording {
+		return
+	}
+	s.mu.Lock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+	s.mu.Unlock()
+}
+
+// AddEvent 记录事件
+func (s *Span) AddEvent(name string, attrs ...Attribute) {
+	if s == nil || !s.recording {
+		return
+	}
+	s.mu.Lock()
+	s.data.Events = append(s.data.Events, Event{Name: name, Time: time.Now(), Attributes: attrs})
+	s.mu.Unlock()
+}
+
+// RecordError 记录错误并将 Span 标记为失败，err 为 nil 时忽略
+func (s *Span) RecordError(err error) {
+	if s == nil || !s.recording || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Error = err.Error()
+	s.mu.Unlock()
+}
+
+// End 结束 Span 并提交导出，重复调用无效
+func (s *Span) End() {
+	if s == nil || !s.recording {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.EndTime = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	s.provider.enqueue(&data)
+}
+
+// ==================== Context ====================
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// ContextWithSpan 将 Span 放入 context
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext 获取 context 中的当前 Span（没有时返回 nil）
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteParent 将客户端传入的父 Span 上下文放入 context
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// StartOption Span 创建选项
+type StartOption func(*Span)
+
+// WithKind 设置 Span 类型
+func WithKind(kind SpanKind) StartOption {
+	return func(s *Span) { s.data.Kind = kind }
+}
+
+// WithAttributes 创建时设置属性
+func WithAttributes(attrs ...Attribute) StartOption {
+	return func(s *Span) { s.data.Attributes = append(s.data.Attributes, attrs...) }
+}
+
+// Start 创建子 Span 并返回携带它的 context；追踪未启用时返回原 context 和 nil Span
+func Start(ctx context.Context, name string, opts ...StartOption) (context.Context, *Span) {
+	p := current.Load()
+	if p == nil {
+		return ctx, nil
+	}
+
+	var parent SpanContext
+	if span := SpanFromContext(ctx); span != nil {
+		parent = span.sc
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		parent = remote
+	}
+
+	sc := SpanContext{SpanID: newSpanID()}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+	} else {
+		sc.TraceID = newTraceID()
+		sc.Sampled = p.sample(sc.TraceID)
+	}
+
+	span := &Span{sc: sc, recording: sc.Sampled, provider: p}
+	if span.recording {
+		span.data = SpanData{
+			Name:         name,
+			Kind:         SpanKindInternal,
+			TraceID:      sc.TraceID,
+			SpanID:       sc.SpanID,
+			ParentSpanID: parent.SpanID,
+			StartTime:    time.Now(),
+		}
+		for _, opt := range opts {
+			opt(span)
+		}
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for id == (TraceID{}) {
+		binary.BigEndian.PutUint64(id[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(id[8:], rand.Uint64())
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for id == (SpanID{}) {
+		binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	}
+	return id
+}
+
+// ==================== Provider ====================
+
+// Exporter Span 导出器
+type Exporter interface {
+	Export(ctx context.Context, spans []*SpanData) error
+}
+
+// Options 追踪初始化选项
+type Options struct {
+	ServiceName string
+	SampleRatio float64 // 新建追踪的采样比例（0~1）
+	Exporter    Exporter
+}
+
+// provider 全局追踪提供者：采样与批量导出
+type provider struct {
+	serviceName string
+	threshold   uint64 // 追踪ID高 64 位小于该值时采样
+	exporter    Exporter
+	queue       chan *SpanData
+	dropped     atomic.Int64
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+var current atomic.Pointer[provider]
+
+// Init 初始化追踪并启动后台导出，重复调用时替换并关闭旧实例
+func Init(opts Options) error {
+	if opts.Exporter == nil {
+		return errors.New("tracing: exporter is required")
+	}
+	ratio := math.Max(0, math.Min(1, opts.SampleRatio))
+	p := &provider{
+		serviceName: opts.ServiceName,
+		exporter:    opts.Exporter,
+		queue:       make(chan *SpanData, queueSize),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if ratio >= 1 {
+		p.threshold = math.MaxUint64
+	} else {
+		p.threshold = uint64(ratio * math.MaxUint64)
+	}
+	go p.run()
+
+	if old := current.Swap(p); old != nil {
+		old.shutdown(context.Background())
+	}
+	return nil
+}
+
+// Enabled 追踪是否已启用
+func Enabled() bool {
+	return current.Load() != nil
+}
+
+// Shutdown 停止追踪并导出剩余 Span
+func Shutdown(ctx context.Context) {
+	if p := current.Swap(nil); p != nil {
+		p.shutdown(ctx)
+	}
+}
+
+func (p *provider) sample(traceID TraceID) bool {
+	if p.threshold == math.MaxUint64 {
+		return true
+	}
+	return binary.BigEndian.Uint64(traceID[:8]) < p.threshold
+}
+
+// enqueue 提交已结束的 Span，队列满时丢弃
+func (p *provider) enqueue(data *SpanData) {
+	select {
+	case p.queue <- data:
+	default:
+		p.dropped.Add(1)
+	}
+}
+
+func (p *provider) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*SpanData, 0, maxBatchSize)
+	for {
+		select {
+		case data := <-p.queue:
+			batch = append(batch, data)
+			if len(batch) >= maxBatchSize {
+				batch = p.flush(batch)
+			}
+		case <-ticker.C:
+			batch = p.flush(batch)
+		case <-p.stop:
+			for {
+				select {
+				case data := <-p.queue:
+					batch = append(batch, data)
+				default:
+					p.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush 导出一批 Span，返回清空后的切片
+func (p *provider) flush(batch []*SpanData) []*SpanData {
+	if len(batch) == 0 {
+		return batch
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	if err := p.exporter.Export(ctx, batch); err != nil {
+		logger.GetLogger("tracing").Warn("导出 Span 失败 | 数量: %d | 错误: %v", len(batch), err)
+	}
+	if dropped := p.dropped.Swap(0); dropped > 0 {
+		logger.GetLogger("tracing").Warn("导出队列已满，丢弃 Span: %d", dropped)
+	}
+	return make([]*SpanData, 0, maxBatchSize)
+}
+
+func (p *provider) shutdown(ctx context.Context) {
+	close(p.stop)
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+	}
+}
+
+// ==================== 内存导出器 ====================
+
+// InMemoryExporter 内存导出器，保存全部导出的 Span（用于测试和调试）
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []*SpanData
+}
+
+// NewInMemoryExporter 创建内存导出器
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+// Export 保存 Span
+func (e *InMemoryExporter) Export(ctx context.Context, spans []*SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// Spans 获取已导出的 Span 副本
+func (e *InMemoryExporter) Spans() []*SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*SpanData(nil), e.spans...)
+}
+
+// Reset 清空已导出的 Span
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
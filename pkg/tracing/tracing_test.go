@@ -0,0 +1,302 @@
+/*
+ * 文件作用：追踪库测试（使用内存导出器）
+ * 负责功能：
+ *   - traceparent 解析与生成
+ *   - 请求链路 Span 树：认证 → 客户端过滤 → 调度尝试 → 上游 HTTP，及首字节事件
+ *   - 异步用量记录 Span 归属请求追踪
+ *   - 采样标记沿用与未启用时的空操作
+ * 重要程度：⭐⭐ 辅助（测试）
+ * 依赖模块：无
+ */
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// startTestTracing 使用内存导出器启用追踪（全部采样），测试结束时关闭
+func startTestTracing(t *testing.T, ratio float64) *InMemoryExporter {
+	t.Helper()
+	exporter := NewInMemoryExporter()
+	if err := Init(Options{ServiceName: "test", SampleRatio: ratio, Exporter: exporter}); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	t.Cleanup(func() { Shutdown(context.Background()) })
+	return exporter
+}
+
+// exportedSpans 关闭追踪以导出队列中的全部 Span，按名称索引
+func exportedSpans(t *testing.T, exporter *InMemoryExporter) map[string]*SpanData {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	Shutdown(ctx)
+
+	spans := make(map[string]*SpanData)
+	for _, span := range exporter.Spans() {
+		if _, dup := spans[span.Name]; dup {
+			t.Fatalf("duplicate span %q", span.Name)
+		}
+		spans[span.Name] = span
+	}
+	return spans
+}
+
+func attribute(span *SpanData, key string) interface{} {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return nil
+}
+
+func hasEvent(span *SpanData, name string) bool {
+	for _, event := range span.Events {
+		if event.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestParseTraceparent(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const spanID = "00f067aa0ba902b7"
+
+	tests := []struct {
+		name        string
+		value       string
+		wantOK      bool
+		wantSampled bool
+	}{
+		{name: "sampled", value: "00-" + traceID + "-" + spanID + "-01", wantOK: true, wantSampled: true},
+		{name: "not sampled", value: "00-" + traceID + "-" + spanID + "-00", wantOK: true},
+		{name: "surrounding spaces", value: " 00-" + traceID + "-" + spanID + "-01 ", wantOK: true, wantSampled: true},
+		{name: "future version with extra fields", value: "01-" + traceID + "-" + spanID + "-01-extra", wantOK: true, wantSampled: true},
+		{name: "version 00 with extra fields", value: "00-" + traceID + "-" + spanID + "-01-extra"},
+		{name: "forbidden version", value: "ff-" + traceID + "-" + spanID + "-01"},
+		{name: "zero trace id", value: "00-00000000000000000000000000000000-" + spanID + "-01"},
+		{name: "zero span id", value: "00-" + traceID + "-0000000000000000-01"},
+		{name: "short trace id", value: "00-" + traceID[:30] + "-" + spanID + "-01"},
+		{name: "non hex span id", value: "00-" + traceID + "-00f067aa0ba902zz-01"},
+		{name: "missing flags", value: "00-" + traceID + "-" + spanID},
+		{name: "empty", value: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := ParseTraceparent(tt.value)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if sc.TraceID.String() != traceID || sc.SpanID.String() != spanID {
+				t.Errorf("parsed %s/%s, want %s/%s", sc.TraceID, sc.SpanID, traceID, spanID)
+			}
+			if sc.Sampled != tt.wantSampled {
+				t.Errorf("sampled = %v, want %v", sc.Sampled, tt.wantSampled)
+			}
+		})
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	for _, sampled := range []bool{true, false} {
+		sc := SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: sampled}
+		got, ok := ParseTraceparent(sc.Traceparent())
+		if !ok || got != sc {
+			t.Errorf("round trip of %s = %+v, %v; want %+v", sc.Traceparent(), got, ok, sc)
+		}
+	}
+}
+
+// TestRequestSpanTree 按代理请求链路创建 Span（与中间件、调度器、上游客户端使用相同的 API），
+// 验证父子关系、首字节事件、异步用量 Span 与 traceparent 传递
+func TestRequestSpanTree(t *testing.T) {
+	exporter := startTestTracing(t, 1)
+
+	var upstreamTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get(TraceparentHeader)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		io.WriteString(w, "event: message_stop\ndata: {}\n\n")
+	}))
+	defer upstream.Close()
+	client := &http.Client{Transport: NewTransport(nil)}
+
+	// 客户端传入的 traceparent
+	inbound, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := ContextWithRemoteParent(context.Background(), inbound)
+	ctx, server := Start(ctx, "POST /v1/messages", WithKind(SpanKindServer))
+	outbound := server.SpanContext().Traceparent()
+
+	_, auth := Start(ctx, "auth.api_key")
+	auth.End()
+	_, filter := Start(ctx, "client_filter")
+	filter.End()
+
+	attemptCtx, attempt := Start(ctx, "scheduler.attempt", WithAttributes(
+		Uint("account.id", 42),
+		String("account.type", "claude-official"),
+	))
+	req, _ := http.NewRequestWithContext(attemptCtx, http.MethodPost, upstream.URL+"/v1/messages", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("upstream request: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	attempt.End()
+	server.End()
+
+	// 异步用量记录：请求已结束，沿用请求追踪
+	usageCtx := context.WithoutCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, usage := Start(usageCtx, "usage.record")
+		usage.End()
+	}()
+	<-done
+
+	spans := exportedSpans(t, exporter)
+	parents := map[string]string{
+		"POST /v1/messages": "",
+		"auth.api_key":      "POST /v1/messages",
+		"client_filter":     "POST /v1/messages",
+		"scheduler.attempt": "POST /v1/messages",
+		"HTTP POST":         "scheduler.attempt",
+		"usage.record":      "POST /v1/messages",
+	}
+	for name, parentName := range parents {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("span %q not exported (got %d spans)", name, len(spans))
+		}
+		if span.TraceID != inbound.TraceID {
+			t.Errorf("span %q trace = %s, want inbound trace %s", name, span.TraceID, inbound.TraceID)
+		}
+		wantParent := inbound.SpanID
+		if parentName != "" {
+			wantParent = spans[parentName].SpanID
+		}
+		if span.ParentSpanID != wantParent {
+			t.Errorf("span %q parent = %s, want %s (%q)", name, span.ParentSpanID, wantParent, parentName)
+		}
+	}
+
+	if got := spans["POST /v1/messages"].Kind; got != SpanKindServer {
+		t.Errorf("server span kind = %d, want %d", got, SpanKindServer)
+	}
+	attemptSpan := spans["scheduler.attempt"]
+	if got := attribute(attemptSpan, "account.id"); got != int64(42) {
+		t.Errorf("account.id = %v, want 42", got)
+	}
+	if got := attribute(attemptSpan, "account.type"); got != "claude-official" {
+		t.Errorf("account.type = %v, want claude-official", got)
+	}
+
+	upstreamSpan := spans["HTTP POST"]
+	if upstreamSpan.Kind != SpanKindClient {
+		t.Errorf("upstream span kind = %d, want %d", upstreamSpan.Kind, SpanKindClient)
+	}
+	if got := attribute(upstreamSpan, "http.response.status_code"); got != int64(http.StatusOK) {
+		t.Errorf("http.response.status_code = %v, want 200", got)
+	}
+	if !hasEvent(upstreamSpan, "first_byte") || attribute(upstreamSpan, "http.first_byte_ms") == nil {
+		t.Errorf("upstream span events = %+v, want first_byte", upstreamSpan.Events)
+	}
+	if upstreamSpan.EndTime.Before(upstreamSpan.StartTime) || upstreamSpan.Error != "" {
+		t.Errorf("upstream span end = %v, error = %q", upstreamSpan.EndTime, upstreamSpan.Error)
+	}
+
+	// 返回给客户端的 traceparent 指向服务端 Span；不向上游转发
+	wantOutbound := SpanContext{TraceID: inbound.TraceID, SpanID: spans["POST /v1/messages"].SpanID, Sampled: true}
+	if outbound != wantOutbound.Traceparent() {
+		t.Errorf("outbound traceparent = %s, want %s", outbound, wantOutbound.Traceparent())
+	}
+	if upstreamTraceparent != "" {
+		t.Errorf("traceparent %q was forwarded upstream", upstreamTraceparent)
+	}
+}
+
+func TestUpstreamErrorStatus(t *testing.T) {
+	exporter := startTestTracing(t, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer upstream.Close()
+
+	ctx, parent := Start(context.Background(), "scheduler.attempt")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, upstream.URL, nil)
+	resp, err := (&http.Client{Transport: NewTransport(nil)}).Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+	parent.End()
+
+	span := exportedSpans(t, exporter)["HTTP GET"]
+	if span == nil || span.Error != "upstream returned HTTP 429" {
+		t.Fatalf("upstream span = %+v, want error for HTTP 429", span)
+	}
+}
+
+func TestSamplingDecision(t *testing.T) {
+	tests := []struct {
+		name      string
+		ratio     float64
+		inbound   string
+		wantSpans int
+	}{
+		{name: "ratio one records new traces", ratio: 1, wantSpans: 2},
+		{name: "ratio zero drops new traces", ratio: 0, wantSpans: 0},
+		{name: "sampled parent overrides ratio", ratio: 0, inbound: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantSpans: 2},
+		{name: "unsampled parent overrides ratio", ratio: 1, inbound: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", wantSpans: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter := startTestTracing(t, tt.ratio)
+			ctx := context.Background()
+			if parent, ok := ParseTraceparent(tt.inbound); ok {
+				ctx = ContextWithRemoteParent(ctx, parent)
+			}
+			ctx, server := Start(ctx, "server")
+			_, child := Start(ctx, "child")
+			child.End()
+			server.End()
+
+			// 未采样时仍需生成可传递的 traceparent
+			if !server.SpanContext().IsValid() {
+				t.Error("span context is invalid")
+			}
+			if got := len(exportedSpans(t, exporter)); got != tt.wantSpans {
+				t.Errorf("exported %d spans, want %d", got, tt.wantSpans)
+			}
+		})
+	}
+}
+
+func TestDisabledTracingIsNoop(t *testing.T) {
+	Shutdown(context.Background())
+	ctx, span := Start(context.Background(), "noop")
+	if span != nil || SpanFromContext(ctx) != nil {
+		t.Fatal("Start returned a span while tracing is disabled")
+	}
+	// nil Span 的方法均为空操作
+	span.SetAttributes(String("k", "v"))
+	span.AddEvent("event")
+	span.RecordError(io.EOF)
+	span.End()
+}
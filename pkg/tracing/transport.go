@@ -0,0 +1,106 @@
+/*
+ * 文件作用：HTTP 客户端追踪，为上游请求创建客户端 Span
+ * 负责功能：
+ *   - 包装 http.RoundTripper，记录上游地址、状态码和错误
+ *   - 记录响应头到达与响应体首字节时间（流式首字节）
+ *   - 响应体读完或关闭时结束 Span（覆盖完整流式传输）
+ * 重要程度：⭐⭐⭐ 一般（可观测性基础设施）
+ * 依赖模块：无
+ */
+package tracing
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// transport 追踪包装的 RoundTripper
+// 只在请求 context 已处于追踪中时创建 Span；traceparent 不会发送给上游
+type transport struct {
+	base http.RoundTripper
+}
+
+// NewTransport 包装 RoundTripper，base 为 nil 时使用 http.DefaultTransport
+func NewTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{base: base}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if SpanFromContext(req.Context()) == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	ctx, span := Start(req.Context(), "HTTP "+req.Method,
+		WithKind(SpanKindClient),
+		WithAttributes(
+			String("http.request.method", req.Method),
+			String("server.address", req.URL.Host),
+			String("url.path", req.URL.Path),
+		),
+	)
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		span.RecordError(err)
+		span.End()
+		return nil, err
+	}
+
+	span.SetAttributes(
+		Int("http.response.status_code", resp.StatusCode),
+		Int64("http.response_header_ms", time.Since(start).Milliseconds()),
+	)
+	if resp.StatusCode >= 400 {
+		span.RecordError(fmt.Errorf("upstream returned HTTP %d", resp.StatusCode))
+	}
+	if resp.Body == nil {
+		span.End()
+		return resp, nil
+	}
+	resp.Body = &tracedBody{ReadCloser: resp.Body, span: span, start: start}
+	return resp, nil
+}
+
+// tracedBody 记录首字节时间，读到 EOF 或关闭时结束 Span
+type tracedBody struct {
+	io.ReadCloser
+	span      *Span
+	start     time.Time
+	firstByte sync.Once
+	endOnce   sync.Once
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.firstByte.Do(func() {
+			b.span.SetAttributes(Int64("http.first_byte_ms", time.Since(b.start).Milliseconds()))
+			b.span.AddEvent("first_byte")
+		})
+	}
+	if err != nil {
+		b.end(err)
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.end(nil)
+	return err
+}
+
+func (b *tracedBody) end(err error) {
+	b.endOnce.Do(func() {
+		if err != nil && err != io.EOF {
+			b.span.RecordError(err)
+		}
+		b.span.End()
+	})
+}